@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"user-check/configuration"
+	"user-check/extauthz"
+	"user-check/grpcapi"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// StartExtAuthz serve the Envoy ext_authz gRPC API on EXTAUTHZ_PORT until ctx is done.
+// It uses the API certificate when TLS is on.
+func StartExtAuthz(ctx context.Context) {
+	defer concurrency.GlobalWaitGroup.Done()
+
+	conf := configuration.AppConfig()
+	log := logger.SugaredLogger().With("package", "api", "action", "serve ext_authz")
+
+	// the caller, correlation id and rate limit of the gRPC api
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(grpcapi.UnaryInterceptor)}
+	if conf.Tls {
+		tlsConfig, err := serverTLSConfig("ext_authz", conf)
+		if err != nil {
+			log.Fatalf("Unable to set up ext_authz TLS: %s", err.Error())
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := grpc.NewServer(opts...)
+	authv3.RegisterAuthorizationServer(srv, extauthz.NewServer(extauthz.LdapChecker))
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", conf.ExtAuthzPort))
+	if err != nil {
+		log.Fatalf("Unable to listen for ext_authz: %s", err.Error())
+	}
+	go func() {
+		log.Infof("Envoy ext_authz listening on port %d, tls:%t", conf.ExtAuthzPort, conf.Tls)
+		if err := srv.Serve(lis); err != nil {
+			log.Fatalf("Unrecoverable ext_authz server failure: %s", err.Error())
+		}
+	}()
+
+	<-ctx.Done()
+	log.Infof("Stopping the ext_authz server")
+	srv.GracefulStop()
+}
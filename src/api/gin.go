@@ -10,6 +10,8 @@ import (
 	"user-check/api/handlers"
 	"user-check/api/middleware"
 	"user-check/configuration"
+	"user-check/metrics"
+	"user-check/policy"
 	"user-check/utils/go-stats/concurrency"
 	"user-check/utils/logger"
 	"time"
@@ -33,43 +35,7 @@ func StartGin(ctx context.Context) {
 	}()
 	
 
-	// Set up gin
-	log.Debugf("Setting up Gin")
-	if !conf.GinLogger {
-		gin.SetMode(gin.ReleaseMode)
-	}
-	router := gin.New()
-
-	// Set up the middleware
-	if conf.GinLogger {
-		log.Warnf("Gin's logger is active! Logs will be unstructured!")
-		router.Use(gin.Logger())
-	}
-	router.Use(gin.Recovery())
-	router.Use(middleware.CorrelationId())
-
-	// Set up the groups
-	userAPI := router.Group("/api/v1")
-	{
-
-		// check user exists in ldap
-		userAPI.GET("/usercheck/:isid", handlers.UserCheck)
-		// count users in ldap
-		userAPI.GET("/usercount", handlers.UserGroupCount)
-		// health check endpoint
-		userAPI.GET("status", handlers.Status)
-
-	}
-
-	// Activate swagger if configured
-	if conf.UseSwagger {
-		log.Infof("Swagger is active, enabling endpoints")
-		url := ginSwagger.URL("/swagger/doc.json") // The url pointing to API definition
-		router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, url))
-	}
-
-
-
+	router := NewRouter()
 
 	// Set up the listener
 	httpSrv := &http.Server{
@@ -79,10 +45,19 @@ func StartGin(ctx context.Context) {
 
 	// Start the HTTPS Server
 	if conf.Tls {
+		tlsConfig, err := serverTLSConfig("api", conf)
+		if err != nil {
+			log.Fatalf("Unable to set up API TLS: %s", err.Error())
+		}
+		httpSrv.TLSConfig = tlsConfig
+		if tlsConfig.ClientCAs != nil {
+			log.Infof("API mTLS is active, verifying client certificates against %s", conf.ApiClientCaFile)
+		}
 		go func() {
 			log.Infof("API TLS is active, enabling secure communication on port %d", conf.HttpPort)
 			log.Debugf("crt file: %s and key file:%s", conf.ApiCertCrtFile, conf.ApiCertKeyFile)
-			if err := httpSrv.ListenAndServeTLS(conf.ApiCertCrtFile, conf.ApiCertKeyFile); err != nil {
+			// certificates are served by the reloader set in TLSConfig
+			if err := httpSrv.ListenAndServeTLS("", ""); err != nil {
 				if err != http.ErrServerClosed {
 					log.Fatalf("Unrecoverable HTTPS Server failure: %s", err.Error())
 				}
@@ -114,3 +89,106 @@ func StartGin(ctx context.Context) {
 		log.Infof("HTTP Server was shutdown successfully")
 	}
 }
+
+// NewRouter set up gin with the middlewares and every route of the api
+func NewRouter() *gin.Engine {
+	conf := configuration.AppConfig()
+	log := logger.SugaredLogger()
+
+	// Set up gin
+	log.Debugf("Setting up Gin")
+	if !conf.GinLogger {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	router := gin.New()
+	// X-Forwarded-For is only believed from the configured proxies, ClientIP is the peer address otherwise
+	if err := router.SetTrustedProxies(conf.TrustedProxyList()); err != nil {
+		log.Errorf("invalid trusted proxies: %s", err)
+	}
+
+	// Set up the middleware
+	if conf.GinLogger {
+		log.Warnf("Gin's logger is active! Logs will be unstructured!")
+		router.Use(gin.Logger())
+	}
+	router.Use(gin.Recovery())
+	router.Use(middleware.CorrelationId())
+	router.Use(middleware.ApiVersion())
+	router.Use(middleware.CallerId())
+
+	// Set up the groups
+	userAPI := router.Group("/api/v1")
+	userAPI.Use(middleware.RateLimit(), middleware.Negotiate())
+	{
+
+		// check user exists in ldap
+		userAPI.GET("/usercheck/:isid", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointUserCheck), handlers.UserCheck)
+		// count users in ldap
+		userAPI.GET("/usercount", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointUserCount), handlers.UserGroupCount)
+		// members of the group, as json, CSV, NDJSON or text
+		userAPI.GET("/members", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointMembers), handlers.Members)
+		// user profile, limited to the allowed attributes
+		userAPI.GET("/users/:isid", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointUsers), handlers.UserProfile)
+		// isid of the user with a mail, UPN, employee ID or proxy address
+		userAPI.GET("/resolve", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointResolve), handlers.Resolve)
+		// verify a password then check the group
+		userAPI.POST("/authenticate", middleware.SelectDirectory(), middleware.Authorize(policy.EndpointAuthenticate), handlers.Authenticate)
+		// same, in a named directory profile, * for all of them
+		userAPI.GET("/directories/:directory/usercheck/:isid", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointUserCheck), handlers.UserCheck)
+		userAPI.GET("/directories/:directory/usercount", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointUserCount), handlers.UserGroupCount)
+		userAPI.GET("/directories/:directory/members", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointMembers), handlers.Members)
+		// health check endpoint
+		userAPI.GET("status", handlers.Status)
+		// readiness, fails while every ldap server circuit breaker is open
+		userAPI.GET("ready", handlers.Ready)
+		// re-read the configuration, same as SIGHUP
+		userAPI.POST("/admin/reload", middleware.Authorize(policy.EndpointAdmin), handlers.Reload)
+		// post membership change events again
+		userAPI.POST("/admin/webhooks/replay", middleware.Authorize(policy.EndpointAdmin), handlers.WebhookReplay)
+
+	}
+
+	// answers in the formats kubernetes and OPA expect, whatever they accept
+	machineAPI := router.Group("/api/v1")
+	machineAPI.Use(middleware.RateLimit())
+	{
+		// kubernetes authorization webhook
+		machineAPI.POST("/k8s/subjectaccessreview", middleware.SelectDirectory(), middleware.Authorize(policy.EndpointK8s), handlers.SubjectAccessReview)
+		// OPA: group list of a user for http.send, group members bundle
+		machineAPI.GET("/opa/groups/:isid", middleware.SelectDirectory(), middleware.Authorize(policy.EndpointOpa), handlers.OpaUserGroups)
+		machineAPI.GET("/opa/bundle.tar.gz", middleware.Authorize(policy.EndpointOpa), handlers.OpaBundle)
+	}
+
+	// v2: typed models, failures as RFC 7807 problem details
+	apiV2 := router.Group("/api/v2")
+	apiV2.Use(middleware.RateLimit(), middleware.Negotiate())
+	{
+		apiV2.GET("/usercheck/:isid", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointUserCheck), handlers.UserCheckV2)
+		apiV2.GET("/usercount", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointUserCount), handlers.UserGroupCountV2)
+		apiV2.GET("/members", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointMembers), handlers.Members)
+		// these answer typed models already, the data alone is sent on v2
+		apiV2.GET("/users/:isid", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointUsers), handlers.UserProfile)
+		apiV2.GET("/resolve", middleware.Cacheable(), middleware.SelectDirectory(), middleware.Authorize(policy.EndpointResolve), handlers.Resolve)
+		apiV2.POST("/authenticate", middleware.SelectDirectory(), middleware.Authorize(policy.EndpointAuthenticate), handlers.Authenticate)
+		apiV2.GET("/status", handlers.StatusV2)
+		apiV2.GET("/ready", handlers.ReadyV2)
+	}
+
+	// forward auth subrequests of reverse proxies, bare status answers
+	forwardAuth := []gin.HandlerFunc{middleware.RateLimit(), middleware.SelectDirectory(), middleware.ForwardGroups(),
+		middleware.Authorize(policy.EndpointForward), handlers.ForwardAuth}
+	router.GET("/auth/forward", forwardAuth...)
+	router.HEAD("/auth/forward", forwardAuth...)
+
+	// prometheus metrics
+	router.GET("/metrics", metrics.Handler())
+
+	// Activate swagger if configured
+	if conf.UseSwagger {
+		log.Infof("Swagger is active, enabling endpoints")
+		url := ginSwagger.URL("/swagger/doc.json") // The url pointing to API definition
+		router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, url))
+	}
+
+	return router
+}
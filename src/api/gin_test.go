@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+	"user-check/configuration"
+	"user-check/model"
+	"user-check/opa"
+	"user-check/policy"
+	"user-check/ratelimit"
+	"user-check/utils/logger"
+)
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+// serve answer a request of the router
+func serve(method, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestApiV2(t *testing.T) {
+	Convey(`Feature: the v2 api`, t, func() {
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+
+		Convey("Answers are the typed models alone", func() {
+			rec := serve(http.MethodGet, "/api/v2/ready", nil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var ready model.Readiness
+			So(json.Unmarshal(rec.Body.Bytes(), &ready), ShouldBeNil)
+			So(ready.Ready, ShouldBeTrue)
+			So(ready.CheckedAt.IsZero(), ShouldBeFalse)
+		})
+		Convey("Failures are problem details", func() {
+			rec := serve(http.MethodGet, "/api/v2/usercheck/bordeanu?by=nickname", map[string]string{"X-Correlation-Id": "req-42"})
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, model.ProblemContentType)
+			var problem model.Problem
+			So(json.Unmarshal(rec.Body.Bytes(), &problem), ShouldBeNil)
+			So(problem, ShouldResemble, model.Problem{
+				Type:          "about:blank",
+				Title:         "Bad Request",
+				Status:        http.StatusBadRequest,
+				Instance:      "/api/v2/usercheck/bordeanu",
+				CorrelationId: "req-42",
+			})
+
+			rec = serve(http.MethodGet, "/api/v2/usercount?directory=nowhere", nil)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, model.ProblemContentType)
+		})
+		Convey("Middleware failures are problem details too", func() {
+			policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+				"hr-portal": {Groups: []string{"hr.*"}, Endpoints: []string{"usercheck"}},
+			}})
+			defer policy.Set(nil)
+			rec := serve(http.MethodGet, "/api/v2/users/bordeanu", map[string]string{"X-Caller-Id": "hr-portal"})
+			So(rec.Code, ShouldEqual, http.StatusForbidden)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, model.ProblemContentType)
+		})
+		Convey("Callers accepting no answer format are refused before anything is done", func() {
+			rec := serve(http.MethodGet, "/api/v1/usercheck/bordeanu?format=xml", nil)
+			So(rec.Code, ShouldEqual, http.StatusNotAcceptable)
+			rec = serve(http.MethodGet, "/api/v2/members", map[string]string{"Accept": "application/xml"})
+			So(rec.Code, ShouldEqual, http.StatusNotAcceptable)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, model.ProblemContentType)
+
+			rec = serve(http.MethodGet, "/api/v1/ready", map[string]string{"Accept": "text/csv, application/json;q=0.1"})
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "application/json")
+			// kubernetes and OPA answers are not negotiated
+			rec = serve(http.MethodGet, "/api/v1/opa/bundle.tar.gz", map[string]string{"Accept": "application/gzip"})
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Only successful lookups carry validators", func() {
+			rec := serve(http.MethodGet, "/api/v1/usercount?directory=nowhere", nil)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+			So(rec.Header().Get("ETag"), ShouldBeEmpty)
+			rec = serve(http.MethodGet, "/api/v1/ready", nil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("ETag"), ShouldBeEmpty)
+			So(rec.Header().Get("Cache-Control"), ShouldBeEmpty)
+		})
+		Convey("v1 answers are unchanged", func() {
+			rec := serve(http.MethodGet, "/api/v1/usercheck/bordeanu?by=nickname", nil)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "application/json")
+			var failure model.JSONFailureResult
+			So(json.Unmarshal(rec.Body.Bytes(), &failure), ShouldBeNil)
+			So(failure.Code, ShouldEqual, http.StatusBadRequest)
+			So(failure.Id, ShouldNotBeEmpty)
+		})
+	})
+}
+
+func TestClientIp(t *testing.T) {
+	Convey(`Feature: the client ip is only taken from trusted proxies`, t, func() {
+		os.Setenv("RATE_LIMIT_RPS", "1")
+		defer os.Unsetenv("RATE_LIMIT_RPS")
+		defer ratelimit.Configure(&configuration.Configuration{})
+		// every request takes the last token of its bucket
+		ready := func(router http.Handler, forwardedFor string) int {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/ready", nil)
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		Convey("X-Forwarded-For is ignored by default", func() {
+			conf, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			ratelimit.Configure(conf)
+			router := NewRouter()
+			So(ready(router, "198.51.100.1"), ShouldEqual, http.StatusOK)
+			So(ready(router, "198.51.100.2"), ShouldEqual, http.StatusTooManyRequests)
+			// building the router again keeps the buckets
+			So(ready(NewRouter(), "198.51.100.3"), ShouldEqual, http.StatusTooManyRequests)
+		})
+		Convey("Callers named by the caller header share the bucket of their client ip", func() {
+			os.Setenv("CALLER_ID_HEADER_TRUSTED", "true")
+			defer os.Unsetenv("CALLER_ID_HEADER_TRUSTED")
+			conf, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			ratelimit.Configure(conf)
+			router := NewRouter()
+			for i, caller := range []string{"hr-portal", "someone-else"} {
+				req := httptest.NewRequest(http.MethodGet, "/api/v1/ready", nil)
+				req.Header.Set("X-Caller-Id", caller)
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				So(rec.Code == http.StatusOK, ShouldEqual, i == 0)
+			}
+		})
+		Convey("X-Forwarded-For set by a trusted proxy gives the client ip", func() {
+			// httptest requests come from 192.0.2.1
+			os.Setenv("TRUSTED_PROXIES", "192.0.2.0/24")
+			defer os.Unsetenv("TRUSTED_PROXIES")
+			conf, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			ratelimit.Configure(conf)
+			router := NewRouter()
+			So(ready(router, "198.51.100.1"), ShouldEqual, http.StatusOK)
+			So(ready(router, "198.51.100.2"), ShouldEqual, http.StatusOK)
+			So(ready(router, "198.51.100.2"), ShouldEqual, http.StatusTooManyRequests)
+		})
+	})
+}
+
+func TestCallerHeader(t *testing.T) {
+	Convey(`Feature: the caller header is only believed when trusted`, t, func() {
+		policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+			"hr-portal": {Groups: []string{"group.users"}, Endpoints: []string{"usercount"}},
+		}})
+		defer policy.Set(nil)
+		hrPortal := map[string]string{"X-Caller-Id": "hr-portal"}
+
+		Convey("From anyone it is ignored by default", func() {
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(serve(http.MethodGet, "/api/v1/usercount", hrPortal).Code, ShouldEqual, http.StatusForbidden)
+		})
+		Convey("From a trusted proxy it names the caller", func() {
+			os.Setenv("TRUSTED_PROXIES", "192.0.2.1")
+			defer os.Unsetenv("TRUSTED_PROXIES")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(serve(http.MethodGet, "/api/v1/usercount", hrPortal).Code, ShouldNotEqual, http.StatusForbidden)
+		})
+		Convey("Trusting it from anyone takes an explicit setting", func() {
+			os.Setenv("CALLER_ID_HEADER_TRUSTED", "true")
+			defer os.Unsetenv("CALLER_ID_HEADER_TRUSTED")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(serve(http.MethodGet, "/api/v1/usercount", hrPortal).Code, ShouldNotEqual, http.StatusForbidden)
+		})
+		Convey("Dry runs still enforce the admin endpoints", func() {
+			os.Setenv("POLICY_DRY_RUN", "true")
+			defer os.Unsetenv("POLICY_DRY_RUN")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(serve(http.MethodPost, "/api/v1/admin/reload", nil).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(http.MethodGet, "/api/v1/usercount", nil).Code, ShouldNotEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestForwardGroups(t *testing.T) {
+	Convey(`Feature: forward auth groups only come from sources the proxy controls`, t, func() {
+		// the proxy may only require the app groups, requiring the configured group is refused
+		policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+			policy.AnyCaller: {Groups: []string{"app-*"}, Endpoints: []string{"forward"}},
+		}})
+		defer policy.Set(nil)
+		user := map[string]string{"X-Forwarded-User": "bordeanu"}
+		withHeader := map[string]string{"X-Forwarded-User": "bordeanu", "X-Required-Groups": "app-users"}
+
+		Convey("By default the query and the groups header are ignored", func() {
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(serve(http.MethodGet, "/auth/forward?group=app-users", user).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(http.MethodGet, "/auth/forward", withHeader).Code, ShouldEqual, http.StatusForbidden)
+		})
+		Convey("The query is read when enabled", func() {
+			os.Setenv("FORWARD_GROUPS_QUERY", "true")
+			defer os.Unsetenv("FORWARD_GROUPS_QUERY")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(serve(http.MethodGet, "/auth/forward?groups=app-users", user).Code, ShouldNotEqual, http.StatusForbidden)
+			So(serve(http.MethodGet, "/auth/forward", withHeader).Code, ShouldEqual, http.StatusForbidden)
+		})
+		Convey("The groups header is read when configured", func() {
+			os.Setenv("FORWARD_GROUPS_HEADER", "X-Required-Groups")
+			defer os.Unsetenv("FORWARD_GROUPS_HEADER")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(serve(http.MethodGet, "/auth/forward", withHeader).Code, ShouldNotEqual, http.StatusForbidden)
+			So(serve(http.MethodGet, "/auth/forward?group=app-users", user).Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestOpaBundleDirectories(t *testing.T) {
+	Convey(`Feature: the OPA bundle is only served to callers allowed every directory in it`, t, func() {
+		os.Setenv("OPA_BUNDLE_REFRESH", "60")
+		os.Setenv("CALLER_ID_HEADER_TRUSTED", "true")
+		defer os.Unsetenv("OPA_BUNDLE_REFRESH")
+		defer os.Unsetenv("CALLER_ID_HEADER_TRUSTED")
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+
+		b, err := opa.NewBundle(opa.Snapshot{Directories: map[string]opa.DirectoryGroups{
+			configuration.DefaultDirectory: {Groups: map[string][]string{"group.users": {"bordeanu"}}},
+			"forest-b":                     {Groups: map[string][]string{"group.users": {"martih"}}},
+		}}, time.Now())
+		So(err, ShouldBeNil)
+		opa.Publish(b)
+		defer opa.Publish(nil)
+
+		policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+			"default-only": {Endpoints: []string{"opa"}, Directories: []string{configuration.DefaultDirectory}},
+			"opa":          {Endpoints: []string{"opa"}},
+		}})
+		defer policy.Set(nil)
+
+		rec := serve(http.MethodGet, "/api/v1/opa/bundle.tar.gz", map[string]string{"X-Caller-Id": "default-only"})
+		So(rec.Code, ShouldEqual, http.StatusForbidden)
+		So(rec.Body.String(), ShouldNotContainSubstring, "martih")
+		rec = serve(http.MethodGet, "/api/v1/opa/bundle.tar.gz", map[string]string{"X-Caller-Id": "opa"})
+		So(rec.Code, ShouldEqual, http.StatusOK)
+	})
+}
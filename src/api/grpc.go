@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"user-check/configuration"
+	"user-check/grpcapi"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// StartGrpc serve the gRPC api on GRPC_PORT until ctx is done.
+// It uses the API certificate, and client certificates, when TLS is on.
+func StartGrpc(ctx context.Context) {
+	defer concurrency.GlobalWaitGroup.Done()
+
+	conf := configuration.AppConfig()
+	log := logger.SugaredLogger().With("package", "api", "action", "serve grpc")
+
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(grpcapi.UnaryInterceptor)}
+	if conf.Tls {
+		tlsConfig, err := serverTLSConfig("grpc", conf)
+		if err != nil {
+			log.Fatalf("Unable to set up gRPC TLS: %s", err.Error())
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := grpc.NewServer(opts...)
+	grpcapi.Register(ctx, srv)
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", conf.GrpcPort))
+	if err != nil {
+		log.Fatalf("Unable to listen for gRPC: %s", err.Error())
+	}
+	go func() {
+		log.Infof("gRPC api listening on port %d, tls:%t, reflection:%t", conf.GrpcPort, conf.Tls, conf.GrpcReflection)
+		if err := srv.Serve(lis); err != nil {
+			log.Fatalf("Unrecoverable gRPC server failure: %s", err.Error())
+		}
+	}()
+
+	<-ctx.Done()
+	log.Infof("Stopping the gRPC server")
+	srv.GracefulStop()
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"user-check/api/response"
+	"user-check/configuration"
+	"user-check/utils"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+	"user-check/webhook"
+)
+
+// Reload godoc
+// @Summary Reload configuration
+// @Description Re-read the configuration file and environment, the current configuration is kept when the new one is invalid
+// @Produce json
+// @Success 200 {string} string "list of changed settings"
+// @Failure 400 {string} string "configuration problems"
+// @Router /v1/admin/reload [post]
+func Reload(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+	log.Infof("Reload configuration, requested by %s", c.GetString(configuration.CallerIdKey))
+
+	type reloadResult struct {
+		Changed  []string `json:"changed,omitempty"`
+		Problems []string `json:"problems,omitempty"`
+	}
+
+	changes, err := configuration.Reload()
+	if err != nil {
+		problems := []string{err.Error()}
+		var validationErr *configuration.ValidationError
+		if errors.As(err, &validationErr) {
+			problems = validationErr.Problems
+		}
+		response.FailureResponse(c, reloadResult{Problems: problems}, utils.HttpError{Code: 400, Err: err})
+		return
+	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), reloadResult{Changed: changes})
+}
+
+// WebhookReplay godoc
+// @Summary Replay membership change events
+// @Description Post the membership change events after sequence since again, to the url or to every webhook url.
+// @Description Events older than the WEBHOOK_RETENTION last ones are gone, the replay then starts at the oldest one.
+// @Produce json
+// @Param since query int true "sequence of the last event not to post again, 0 for every event kept"
+// @Param url query string false "webhook url, every one when left out"
+// @Success 200 {object} model.WebhookReplay
+// @Failure 400 {object} model.JSONFailureResult "invalid since or url"
+// @Failure 404 {object} model.JSONFailureResult "webhooks disabled"
+// @Router /v1/admin/webhooks/replay [post]
+func WebhookReplay(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	n := webhook.Current()
+	if n == nil {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 404, Err: fmt.Errorf("webhooks are disabled")})
+		return
+	}
+	since, err := strconv.ParseInt(c.Query("since"), 10, 64)
+	if err != nil {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: fmt.Errorf("since: %w", err)})
+		return
+	}
+	result, err := n.Replay(c.Query("url"), since)
+	if errors.Is(err, webhook.ErrUnknownUrl) || errors.Is(err, webhook.ErrSince) {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: err})
+		return
+	}
+	if err != nil {
+		log.Errorf("unable to replay webhook events: %v", err)
+		response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: err})
+		return
+	}
+	for _, replay := range result.Urls {
+		log.Infof("Replay of %d webhook events after %d to %s, requested by %s", replay.Events, replay.Since, replay.Url,
+			c.GetString(configuration.CallerIdKey))
+	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), result)
+}
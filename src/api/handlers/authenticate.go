@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"user-check/api/middleware"
+	"user-check/api/response"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/ldapcheck"
+	"user-check/metrics"
+	"user-check/model"
+	"user-check/policy"
+	"user-check/ratelimit"
+	"user-check/utils"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// authBackoff holds the *ratelimit.Backoff of failed authentications, per user and per client ip
+var authBackoff atomic.Value
+
+func init() {
+	configuration.OnReload(func(old, next *configuration.Configuration) (func(), error) {
+		if !configuration.Changed(old, next, "AuthMaxFailures", "AuthBackoffSec", "AuthBackoffMaxSec") {
+			return nil, nil
+		}
+		return func() {
+			authBackoff.Store(newAuthBackoff(next))
+		}, nil
+	})
+}
+
+// newAuthBackoff build the backoff from the configuration, past failures are forgotten
+func newAuthBackoff(conf *configuration.Configuration) *ratelimit.Backoff {
+	return ratelimit.NewBackoff(int(conf.AuthMaxFailures), time.Duration(conf.AuthBackoffSec)*time.Second,
+		time.Duration(conf.AuthBackoffMaxSec)*time.Second)
+}
+
+// currentAuthBackoff return the backoff in force, built on first use
+func currentAuthBackoff() *ratelimit.Backoff {
+	if b, ok := authBackoff.Load().(*ratelimit.Backoff); ok {
+		return b
+	}
+	b := newAuthBackoff(configuration.AppConfig())
+	authBackoff.Store(b)
+	return b
+}
+
+// Authenticate godoc
+// @Summary Authenticate
+// @Description This will verify the password of the user by binding as the user, then check the user is part of the group.
+// @Description Wrong credentials and unknown users are answered with 401, the decision is in data either way.
+// @Description Repeated failures for the same user or from the same client ip are refused with 429 for a time
+// @Description doubling with every failure. The password is never logged.
+// @Accept json
+// @Description On v2 the result is answered alone and failures as problem details.
+// @Produce json
+// @Param credentials body model.Authenticate true "User isid and password"
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Success 200 {object} model.AuthenticateResult "authenticated, authorized tells whether the user is a member"
+// @Failure 401 {object} model.JSONFailureResult "wrong credentials"
+// @Failure 429 {object} model.JSONFailureResult "too many failures, see Retry-After"
+// @Router /v1/authenticate [post]
+// @Router /v2/authenticate [post]
+func Authenticate(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	var body model.Authenticate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: fmt.Errorf("invalid request body")})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: err})
+		return
+	}
+	log.Debugf("Payload: user isid:%v", body.Isid)
+
+	directories := middleware.SelectedDirectories(c)
+	var groups []string
+	for _, directory := range directories {
+		groups = append(groups, middleware.DirectoryGroups(directory)...)
+	}
+	record := middleware.AuditRecord(c, policy.EndpointAuthenticate, groups)
+	record.Isid = body.Isid
+	defer middleware.LogAudit(record)
+
+	backoff := currentAuthBackoff()
+	keys := []string{"user:" + strings.ToLower(body.Isid), "ip:" + c.ClientIP()}
+	for i, key := range keys {
+		if ok, wait := backoff.Allow(key); !ok {
+			for _, reserved := range keys[:i] {
+				backoff.Release(reserved)
+			}
+			log.Warnf("authentication refused, backing off %s for %s", key, wait)
+			metrics.AuthAttempts.WithLabelValues("backoff").Inc()
+			record.Decision = audit.DecisionDenied
+			record.Error = "too many failed authentications"
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			response.FailureResponse(c, nil, utils.HttpError{Code: 429, Err: fmt.Errorf("too many failed authentications, retry in %s", wait.Round(time.Second))})
+			return
+		}
+	}
+	// attempts ending on an error neither succeeded nor failed, they give their reservation back
+	settled := false
+	defer func() {
+		if !settled {
+			for _, key := range keys {
+				backoff.Release(key)
+			}
+		}
+	}()
+
+	ctx := c.Request.Context()
+	for _, directory := range directories {
+		userLdapProvider, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			metrics.AuthAttempts.WithLabelValues("error").Inc()
+			response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: err})
+			return
+		}
+		users, err := userLdapProvider.FindUsers(ctx, ldapcheck.ByIsid, body.Isid)
+		if err == nil && len(users.Entries) == 1 {
+			err = userLdapProvider.Authenticate(ctx, users.Entries[0], body.Password)
+		} else if err == nil {
+			// unknown here, or ambiguous which can't be told apart from a wrong password
+			continue
+		}
+		if errors.Is(err, ldapcheck.ErrInvalidCredentials) {
+			continue
+		}
+		if err != nil {
+			log.Errorf("authentication in directory %s failed: %v", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			metrics.AuthAttempts.WithLabelValues("error").Inc()
+			ldapFailure(c, err)
+			return
+		}
+
+		// the password is right, the user failures start over but not the client ip ones
+		backoff.Success(keys[0])
+		backoff.Release(keys[1])
+		settled = true
+		metrics.AuthAttempts.WithLabelValues("success").Inc()
+		user := users.Entries[0]
+		account := ldapcheck.AccountStateOf(user, time.Now())
+		member, nested, err := userLdapProvider.MemberVia(ctx, user)
+		record.Directory = directory
+		record.AccountState = account.State
+		if err != nil {
+			log.Errorf("group membership lookup in directory %s failed: %v", directory, err)
+			record.Error = err.Error()
+			ldapFailure(c, err)
+			return
+		}
+		authorized := member && (account.Active() || !configuration.AppConfig().InactiveNotMember)
+		if authorized {
+			record.Decision = audit.DecisionMember
+			record.Membership = audit.MembershipOf(nested)
+		} else {
+			record.Decision = audit.DecisionNotMember
+		}
+		log.Infof("user %s authenticated in directory %s, authorized:%t", body.Isid, directory, authorized)
+		response.SuccessResponse(c, c.MustGet("correlation_id").(string), model.AuthenticateResult{
+			Authenticated:  true,
+			Authorized:     authorized,
+			Directory:      directory,
+			AccountState:   account.State,
+			AccountReasons: account.Reasons,
+		})
+		return
+	}
+
+	for _, key := range keys {
+		if lockout := backoff.Failure(key); lockout > 0 {
+			log.Warnf("authentication failures for %s, backing off for %s", key, lockout)
+		}
+	}
+	settled = true
+	log.Infof("authentication of user %s refused", body.Isid)
+	metrics.AuthAttempts.WithLabelValues("invalid").Inc()
+	record.Decision = audit.DecisionUnauthenticated
+	record.Error = ldapcheck.ErrInvalidCredentials.Error()
+	response.FailureResponse(c, model.AuthenticateResult{}, utils.HttpError{Code: 401, Err: ldapcheck.ErrInvalidCredentials})
+}
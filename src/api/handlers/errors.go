@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"user-check/api/response"
+	"user-check/ldapcheck"
+	"user-check/utils"
+)
+
+// ldapFailure answer a failed ldap operation, overload and open breaker errors are reported as 503 so clients back off
+// and identifiers matching several users as 409
+func ldapFailure(c *gin.Context, err error) {
+	if errors.Is(err, ldapcheck.ErrAmbiguous) {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 409, Err: err})
+		return
+	}
+	if errors.Is(err, ldapcheck.ErrQueueTimeout) || errors.Is(err, ldapcheck.ErrCircuitOpen) {
+		c.Header("Retry-After", "1")
+		response.FailureResponse(c, nil, utils.HttpError{Code: 503, Err: err})
+		return
+	}
+	response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: err})
+}
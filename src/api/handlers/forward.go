@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"user-check/api/middleware"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/ldapcheck"
+	"user-check/policy"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// forwardDecision is a cached forward auth answer
+type forwardDecision struct {
+	status int
+	user   string
+	groups []string
+	nested bool
+	at     time.Time
+}
+
+// forwardSweepInterval expired decisions are dropped this often
+const forwardSweepInterval = time.Minute
+
+var (
+	forwardMu        sync.Mutex
+	forwardDecisions = map[string]forwardDecision{}
+	forwardSweep     = time.Now()
+)
+
+func init() {
+	// decisions may depend on any setting, e.g. the directories or INACTIVE_NOT_MEMBER
+	configuration.OnReload(func(old, next *configuration.Configuration) (func(), error) {
+		return clearForwardDecisions, nil
+	})
+}
+
+// clearForwardDecisions forget every cached forward auth decision
+func clearForwardDecisions() {
+	forwardMu.Lock()
+	defer forwardMu.Unlock()
+	forwardDecisions = map[string]forwardDecision{}
+}
+
+// cachedForwardDecision return the decision cached under key when younger than ttl
+func cachedForwardDecision(key string, ttl time.Duration) (forwardDecision, bool) {
+	forwardMu.Lock()
+	defer forwardMu.Unlock()
+	d, ok := forwardDecisions[key]
+	if !ok || time.Since(d.at) > ttl {
+		return forwardDecision{}, false
+	}
+	return d, true
+}
+
+// cacheForwardDecision remember d under key, dropping the expired decisions now and then
+func cacheForwardDecision(key string, d forwardDecision, ttl time.Duration) {
+	forwardMu.Lock()
+	defer forwardMu.Unlock()
+	forwardDecisions[key] = d
+	if time.Since(forwardSweep) < forwardSweepInterval {
+		return
+	}
+	for k, cached := range forwardDecisions {
+		if time.Since(cached.at) > ttl {
+			delete(forwardDecisions, k)
+		}
+	}
+	forwardSweep = time.Now()
+}
+
+// ForwardAuth answer the forward auth subrequests of nginx auth_request, Traefik forwardAuth and Caddy forward_auth.
+// It is served outside the api base path so it is not in the swagger docs. The user must be a member of one of the
+// required groups. Answers have no body: 200 allowed, 401 without user header, 403 unknown user or not a member.
+func ForwardAuth(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+	conf := configuration.AppConfig()
+
+	user := strings.TrimSpace(c.GetHeader(conf.ForwardUserHeader))
+	if user == "" {
+		log.Debugf("forward auth without %s header", conf.ForwardUserHeader)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	directories := middleware.SelectedDirectories(c)
+	var groups []string
+	for _, directory := range directories {
+		groups = append(groups, middleware.RequestGroups(c, directory)...)
+	}
+	record := middleware.AuditRecord(c, policy.EndpointForward, groups)
+	record.Isid = user
+	defer middleware.LogAudit(record)
+
+	sorted := append([]string{}, groups...)
+	sort.Strings(sorted)
+	key := strings.Join(directories, ",") + "/" + strings.ToLower(user) + "/" + strings.Join(sorted, ",")
+	ttl := time.Duration(conf.ForwardCacheTtlSec) * time.Second
+
+	d, cached := cachedForwardDecision(key, ttl)
+	if cached {
+		record.Source = ldapcheck.SourceCache
+	} else {
+		var ok bool
+		if d, ok = forwardDecide(c, directories, user, record); !ok {
+			return
+		}
+		if ttl > 0 {
+			cacheForwardDecision(key, d, ttl)
+		}
+	}
+
+	if d.status == http.StatusOK {
+		record.Decision = audit.DecisionMember
+		record.Membership = audit.MembershipOf(d.nested)
+		c.Header("X-Auth-User", d.user)
+		c.Header("X-Auth-Groups", strings.Join(d.groups, ","))
+	} else {
+		record.Decision = audit.DecisionNotMember
+	}
+	log.Debugf("forward auth of %s answered %d, cached:%t", user, d.status, cached)
+	c.AbortWithStatus(d.status)
+}
+
+// forwardDecide look the user up and check the required groups in every selected directory. A failure is
+// answered right away, bodiless, and reported with ok false.
+func forwardDecide(c *gin.Context, directories []string, user string, record *audit.Record) (forwardDecision, bool) {
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+	d := forwardDecision{status: http.StatusForbidden, at: time.Now()}
+
+	decision, err := ldapcheck.MemberOfAny(c.Request.Context(), directories, user, func(directory string) []string {
+		return middleware.RequestGroups(c, directory)
+	}, configuration.AppConfig().InactiveNotMember)
+	record.Directory = decision.Directory
+	if err != nil {
+		log.Errorf("forward auth in directory %s failed: %v", decision.Directory, err)
+		record.Error = err.Error()
+		if errors.Is(err, ldapcheck.ErrProvider) {
+			c.AbortWithStatus(http.StatusInternalServerError)
+		} else {
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+		}
+		return d, false
+	}
+	record.Source = decision.Source
+	record.AccountState = decision.Account.State
+	if decision.Allowed() {
+		d.status = http.StatusOK
+		d.user = decision.User
+		d.groups = decision.Groups
+		d.nested = decision.Nested
+	}
+	return d, true
+}
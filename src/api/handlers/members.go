@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-check/api/middleware"
+	"user-check/api/response"
+	"user-check/audit"
+	"user-check/ldapcheck"
+	"user-check/model"
+	"user-check/policy"
+	"user-check/utils"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// Members godoc
+// @Summary Members
+// @Description This will list the user members of the group, sorted by isid, with their account state.
+// @Description With directory=* the members of the group of every directory are listed, directory by directory.
+// @Description The whole listing is read before it is answered, as json, CSV or NDJSON flushed row by row,
+// @Description or text with one isid per line, by Accept or format=.
+// @Produce json
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce plain
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Param active_only query bool false "Leave disabled, locked and expired accounts out"
+// @Param format query string false "Answer format instead of Accept: json, csv, ndjson or text"
+// @Success 200 {array} model.Member
+// @Failure 406 {object} model.JSONFailureResult "no acceptable format"
+// @Router /v1/members [get]
+// @Router /v1/directories/{directory}/members [get]
+// @Router /v2/members [get]
+func Members(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	record := middleware.AuditRecord(c, policy.EndpointMembers, selectedGroups(c))
+	defer middleware.LogAudit(record)
+
+	ctx := c.Request.Context()
+	activeOnly := c.Query("active_only") == "true"
+
+	members := []model.Member{}
+	for _, directory := range middleware.SelectedDirectories(c) {
+		userLdapProvider, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: err})
+			return
+		}
+		group, err := userLdapProvider.QueryUserGroupLdap(ctx)
+		if err != nil {
+			log.Errorf("ldap query user group in directory %s failed: %v", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			ldapFailure(c, err)
+			return
+		}
+		accounts, err := userLdapProvider.MemberAccounts(ctx, group, activeOnly)
+		if err != nil {
+			log.Errorf("reading member accounts in directory %s failed: %v", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			ldapFailure(c, err)
+			return
+		}
+		for _, account := range accounts {
+			members = append(members, model.Member{Isid: account.Login, Directory: directory, AccountState: account.Account.State})
+		}
+	}
+	log.Infof("listing %d members", len(members))
+	if !middleware.FanOut(c) {
+		record.Directory = middleware.SelectedDirectories(c)[0]
+	}
+	record.Decision = audit.DecisionFound
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), members)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"user-check/api/middleware"
+	"user-check/api/response"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/ldapcheck"
+	"user-check/model"
+	"user-check/opa"
+	"user-check/policy"
+	"user-check/utils"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// OpaUserGroups godoc
+// @Summary OpaUserGroups
+// @Description This will return every group of the user, direct and nested, as a bare JSON object for OPA http.send.
+// @Description Unknown users are answered with found false and no groups, so policies need no error handling.
+// @Description Cache-Control allows caching the answer for CACHE_TTL seconds, honoured by http.send with cache enabled.
+// @Produce json
+// @Param isid path string true "User isid"
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Success 200 {object} model.OpaUserGroups
+// @Router /v1/opa/groups/{isid} [get]
+func OpaUserGroups(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	isid := c.Param("isid")
+	log.Debugf("Payload: user isid:%v", isid)
+
+	record := middleware.AuditRecord(c, policy.EndpointOpa, nil)
+	defer middleware.LogAudit(record)
+
+	conf := configuration.AppConfig()
+	ctx := c.Request.Context()
+	result := model.OpaUserGroups{User: isid, Groups: []string{}}
+	for _, directory := range middleware.SelectedDirectories(c) {
+		record.Directory = directory
+		userLdapProvider, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Error = err.Error()
+			response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: err})
+			return
+		}
+		entry, groups, err := userGroups(c, userLdapProvider, ldapcheck.ByIsid, isid, true)
+		if err != nil {
+			log.Errorf("group lookup in directory %s failed: %v", directory, err)
+			record.Error = err.Error()
+			ldapFailure(c, err)
+			return
+		}
+		if entry == nil {
+			continue
+		}
+
+		account := ldapcheck.AccountStateOf(entry, time.Now())
+		sort.Strings(groups)
+		result.Found = true
+		result.Directory = directory
+		result.Active = account.Active()
+		result.AccountState = account.State
+		result.Groups = groups
+		record.Source = userLdapProvider.Source
+		record.AccountState = account.State
+		break
+	}
+
+	record.Decision = audit.DecisionNotFound
+	if result.Found {
+		record.Decision = audit.DecisionFound
+	}
+	if conf.CacheTtlSec > 0 {
+		c.Header("Cache-Control", "private, max-age="+strconv.Itoa(int(conf.CacheTtlSec)))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// OpaBundle godoc
+// @Summary OpaBundle
+// @Description This will return the OPA bundle of the group members: a tarball with data.json, read by policies at
+// @Description data.usercheck.directories[directory].groups[group] (member isids) and .users[isid] (groups).
+// @Description The bundle is rebuilt every OPA_BUNDLE_REFRESH seconds; a request with the ETag in If-None-Match
+// @Description gets a 304 while it is unchanged. The caller policy must grant the opa endpoint in every directory.
+// @Produce application/gzip
+// @Param If-None-Match header string false "ETag of the bundle OPA has"
+// @Success 200 {file} file "bundle tarball"
+// @Success 304 {string} string "bundle unchanged"
+// @Failure 403 {object} model.JSONFailureResult "caller not allowed every directory of the bundle"
+// @Failure 404 {object} model.JSONFailureResult "bundle export disabled"
+// @Failure 503 {object} model.JSONFailureResult "first bundle not built yet, see Retry-After"
+// @Router /v1/opa/bundle.tar.gz [get]
+func OpaBundle(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	if configuration.AppConfig().OpaBundleRefreshSec <= 0 {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 404, Err: fmt.Errorf("OPA bundle export is disabled")})
+		return
+	}
+	b := opa.Current()
+	if b == nil {
+		c.Header("Retry-After", "5")
+		response.FailureResponse(c, nil, utils.HttpError{Code: 503, Err: fmt.Errorf("OPA bundle not built yet")})
+		return
+	}
+
+	// the bundle holds the members of every directory, the caller must be allowed all of them
+	if p := policy.Current(); p != nil {
+		caller := c.GetString(configuration.CallerIdKey)
+		for _, directory := range b.Directories {
+			err := p.AuthorizeIn(caller, policy.EndpointOpa, directory, nil)
+			if err == nil {
+				continue
+			}
+			if p.DryRun || configuration.AppConfig().PolicyDryRun {
+				log.Warnf("dry run, would deny request: %s", err)
+				continue
+			}
+			log.Warnf("request denied: %s", err)
+			response.FailureResponse(c, nil, utils.HttpError{Code: 403, Err: err})
+			return
+		}
+	}
+
+	c.Header("ETag", b.ETag)
+	c.Header("Last-Modified", b.BuiltAt.UTC().Format(http.TimeFormat))
+	if utils.ETagMatch(c.GetHeader("If-None-Match"), b.ETag) {
+		log.Debugf("bundle revision %s unchanged", b.Revision)
+		c.Status(http.StatusNotModified)
+		return
+	}
+	log.Infof("serving bundle revision %s", b.Revision)
+	c.Data(http.StatusOK, "application/gzip", b.Archive)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"user-check/api/middleware"
+	"user-check/api/response"
+	"user-check/audit"
+	"user-check/ldapcheck"
+	"user-check/model"
+	"user-check/policy"
+	"user-check/utils"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// Resolve godoc
+// @Summary Resolve
+// @Description This will resolve a mail, userPrincipalName, employeeID or proxyAddresses value to the isid of the account.
+// @Description A single match is answered with 200, several with 409 and none with 404, every match is listed.
+// @Description With directory=* every directory is searched, matches in several directories are ambiguous.
+// @Description On v2 the result is answered alone and failures as problem details, not_found and ambiguous ones with the result as data.
+// @Produce json
+// @Param by query string true "Identifier type: mail, upn, employee_id or proxy_address"
+// @Param value query string true "Identifier value, proxy addresses without a prefix are smtp addresses"
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Success 200 {object} model.ResolveResult
+// @Failure 404 {object} model.JSONFailureResult "no such user, data is the not_found result"
+// @Failure 409 {object} model.JSONFailureResult "several users, data is the ambiguous result"
+// @Router /v1/resolve [get]
+// @Router /v2/resolve [get]
+func Resolve(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	by, value := c.Query("by"), c.Query("value")
+	log.Debugf("Payload: user %s:%v", by, value)
+
+	record := middleware.AuditRecord(c, policy.EndpointResolve, nil)
+	record.Isid = value
+	defer middleware.LogAudit(record)
+
+	if err := ldapcheck.ValidIdentifierType(by); err != nil || value == "" {
+		if err == nil {
+			err = fmt.Errorf("value is a required parameter")
+		}
+		record.Error = err.Error()
+		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: err})
+		return
+	}
+
+	ctx := c.Request.Context()
+	result := model.ResolveResult{Matches: []model.ResolvedUser{}}
+	for _, directory := range middleware.SelectedDirectories(c) {
+		userLdapProvider, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: err})
+			return
+		}
+		sr, err := userLdapProvider.FindUsers(ctx, by, value)
+		if err != nil {
+			log.Errorf("resolving %s in directory %s failed: %v", by, directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			ldapFailure(c, err)
+			return
+		}
+		record.Source = userLdapProvider.Source
+		for _, entry := range sr.Entries {
+			result.Matches = append(result.Matches, model.ResolvedUser{
+				Isid:              entry.GetAttributeValue(userLdapProvider.Schema.LoginAttribute),
+				Directory:         directory,
+				DN:                entry.DN,
+				Mail:              entry.GetAttributeValue("mail"),
+				UserPrincipalName: entry.GetAttributeValue("userPrincipalName"),
+			})
+		}
+	}
+
+	switch len(result.Matches) {
+	case 0:
+		log.Infof("no user has %s %s", by, value)
+		result.Status = model.ResolveNotFound
+		record.Decision = audit.DecisionNotFound
+		response.FailureResponse(c, result, utils.HttpError{Code: 404, Err: fmt.Errorf("no user has %s %s", by, value)})
+	case 1:
+		result.Status = model.ResolveFound
+		result.Isid = result.Matches[0].Isid
+		result.Directory = result.Matches[0].Directory
+		record.Decision = audit.DecisionFound
+		record.Directory = result.Directory
+		response.SuccessResponse(c, c.MustGet("correlation_id").(string), result)
+	default:
+		log.Infof("%d users have %s %s", len(result.Matches), by, value)
+		result.Status = model.ResolveAmbiguous
+		record.Decision = audit.DecisionFound
+		record.Error = ldapcheck.ErrAmbiguous.Error()
+		response.FailureResponse(c, result, utils.HttpError{Code: 409, Err: fmt.Errorf("%w: %s %s", ldapcheck.ErrAmbiguous, by, value)})
+	}
+}
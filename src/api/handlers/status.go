@@ -3,12 +3,17 @@ package handlers
 import (
 	"github.com/gin-gonic/gin"
 	"user-check/api/response"
+	"user-check/breaker"
+	"user-check/certs"
 	"user-check/configuration"
 	"user-check/ldapcheck"
 	"user-check/utils"
 	"user-check/utils/go-stats/concurrency"
 	"user-check/utils/logger"
+	"net/http"
 	"os"
+	"time"
+	"user-check/model"
 )
 
 // Status godoc
@@ -32,8 +37,11 @@ func Status(c *gin.Context) {
 	)
 
 	type status struct {
-		LdapStatus string
-		ProcessPid int64
+		LdapStatus        string
+		ProcessPid        int64
+		CertificateExpiry map[string]time.Time
+		Breakers          map[string]string
+		Directories       map[string]string `json:",omitempty"`
 	}
 
 	ctx := c.Request.Context()
@@ -44,8 +52,7 @@ func Status(c *gin.Context) {
 		return // return here because we don't want to continue if we failed to initialize ldap provider
 	}
 
-	_, err = userLdapProvider.FuncDialLdap(ctx)
-	if err != nil {
+	if err = userLdapProvider.Ping(ctx); err != nil {
 		log.Errorf("seems ldap dialing not working: %v", err)
 		ldapstatus = configuration.LdapDown
 	} else {
@@ -53,9 +60,121 @@ func Status(c *gin.Context) {
 		ldapstatus = configuration.LdapUp
 	}
 
+	// LdapStatus is the default directory, the named ones are reported apart
+	var directories map[string]string
+	for _, directory := range configuration.AppConfig().DirectoryNames()[1:] {
+		if directories == nil {
+			directories = map[string]string{}
+		}
+		directories[directory] = directoryStatus(c, directory)
+	}
+
 	response.SuccessResponse(c, c.MustGet("correlation_id").(string), status{
 		LdapStatus: ldapstatus,
 		ProcessPid: int64(os.Getpid()),
+		CertificateExpiry: certs.Expiries(),
+		Breakers: breakerStates(),
+		Directories: directories,
 	})
 
 }
+
+// directoryStatus ping a named directory, up or down
+func directoryStatus(c *gin.Context, directory string) string {
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+	ctx := c.Request.Context()
+
+	provider, err := ldapcheck.NewForDirectory(ctx, directory)
+	if err != nil {
+		log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+		return configuration.LdapDown
+	}
+	if err = provider.Ping(ctx); err != nil {
+		log.Errorf("seems ldap dialing directory %s not working: %v", directory, err)
+		return configuration.LdapDown
+	}
+	return configuration.LdapUp
+}
+
+// Ready godoc
+// @Summary Readiness Endpoint
+// @Description Ready as long as at least one ldap server circuit breaker lets requests through
+// @Produce json
+// @Success 200 {string} string "circuit breaker states"
+// @Failure 503 {string} string "circuit breaker states"
+// @Router /v1/ready [get]
+func Ready(c *gin.Context) {
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	type readiness struct {
+		Ready    bool
+		Breakers map[string]string
+	}
+
+	ready := breaker.Ready()
+	res := readiness{Ready: ready, Breakers: breakerStates()}
+	if !ready {
+		log.Warnf("not ready, every ldap server circuit breaker is open")
+		c.JSON(http.StatusServiceUnavailable, model.JSONSuccessResult{
+			Code:    http.StatusServiceUnavailable,
+			Id:      c.MustGet("correlation_id").(string),
+			Data:    res,
+			Message: "Not ready",
+		})
+		return
+	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), res)
+}
+
+// StatusV2 godoc
+// @Summary HealthCheck Endpoint v2
+// @Description This return API status: readiness, every directory up or down, the circuit breakers and certificate expiries
+// @Produce json
+// @Success 200 {object} model.ServiceStatus
+// @Router /v2/status [get]
+func StatusV2(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+	log.Info("Return API status")
+
+	directories := map[string]string{}
+	for _, directory := range configuration.AppConfig().DirectoryNames() {
+		directories[directory] = directoryStatus(c, directory)
+	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), model.ServiceStatus{
+		Pid:               os.Getpid(),
+		Ready:             breaker.Ready(),
+		Directories:       directories,
+		Breakers:          breakerStates(),
+		CertificateExpiry: certs.Expiries(),
+		CheckedAt:         time.Now().UTC(),
+	})
+}
+
+// ReadyV2 godoc
+// @Summary Readiness Endpoint v2
+// @Description Ready as long as at least one ldap server circuit breaker lets requests through
+// @Produce json
+// @Success 200 {object} model.Readiness
+// @Failure 503 {object} model.Readiness
+// @Router /v2/ready [get]
+func ReadyV2(c *gin.Context) {
+	res := model.Readiness{Ready: breaker.Ready(), Breakers: breakerStates(), CheckedAt: time.Now().UTC()}
+	if !res.Ready {
+		logger.SugaredLogger().WithContextCorrelationId(c).Warnf("not ready, every ldap server circuit breaker is open")
+		c.JSON(http.StatusServiceUnavailable, res)
+		return
+	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), res)
+}
+
+// breakerStates return the circuit breaker state of every ldap server
+func breakerStates() map[string]string {
+	res := map[string]string{}
+	for name, state := range breaker.States() {
+		res[name] = state.String()
+	}
+	return res
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"net/http"
+	"strings"
+	"time"
+	"user-check/api/middleware"
+	"user-check/api/response"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/ldapcheck"
+	"user-check/model"
+	"user-check/policy"
+	"user-check/rbac"
+	"user-check/utils"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// SubjectAccessReview godoc
+// @Summary SubjectAccessReview
+// @Description Kubernetes authorization webhook. The apiserver posts an authorization.k8s.io/v1 SubjectAccessReview,
+// @Description it is answered with its status: allowed when a rule of K8S_AUTHZ_FILE grants the verb on the resource
+// @Description to a directory group of the user. Unmatched requests get no opinion, unless the rules deny them.
+// @Description Directory failures are reported in status.evaluationError, the apiserver then treats the request as not allowed.
+// @Accept json
+// @Produce json
+// @Param review body model.SubjectAccessReview true "SubjectAccessReview sent by the apiserver"
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Success 200 {object} model.SubjectAccessReview "the review with its status set"
+// @Failure 400 {object} model.JSONFailureResult "not a SubjectAccessReview"
+// @Router /v1/k8s/subjectaccessreview [post]
+func SubjectAccessReview(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	var review model.SubjectAccessReview
+	if err := c.ShouldBindJSON(&review); err != nil {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: fmt.Errorf("invalid request body")})
+		return
+	}
+	if err := review.Validate(); err != nil {
+		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: err})
+		return
+	}
+
+	conf := configuration.AppConfig()
+	user := strings.TrimPrefix(review.Spec.User, conf.K8sAuthzUserPrefix)
+	log.Debugf("Payload: user:%v", user)
+
+	record := middleware.AuditRecord(c, policy.EndpointK8s, nil)
+	record.Isid = user
+	defer middleware.LogAudit(record)
+
+	answer := func(status model.SubjectAccessReviewStatus) {
+		review.Status = status
+		c.JSON(http.StatusOK, review)
+	}
+
+	rules := rbac.Current()
+	if rules == nil {
+		record.Error = "no kubernetes rules configured"
+		answer(model.SubjectAccessReviewStatus{EvaluationError: record.Error})
+		return
+	}
+	if user == "" || (conf.K8sAuthzUserPrefix != "" && user == review.Spec.User) {
+		// users of other authenticators, e.g. service accounts, are left to the other authorizers
+		record.Decision = audit.DecisionNotFound
+		answer(model.SubjectAccessReviewStatus{Reason: "not a directory user"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	req := reviewRequest(review.Spec)
+	for _, directory := range middleware.SelectedDirectories(c) {
+		record.Directory = directory
+		userLdapProvider, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Error = err.Error()
+			answer(model.SubjectAccessReviewStatus{EvaluationError: "error while initializing ldap provider"})
+			return
+		}
+		entry, groups, err := userGroups(c, userLdapProvider, conf.K8sAuthzUserBy, user, rules.Nested)
+		if err != nil {
+			log.Errorf("group lookup of %s in directory %s failed: %v", user, directory, err)
+			record.Error = err.Error()
+			answer(model.SubjectAccessReviewStatus{EvaluationError: "directory lookup failed"})
+			return
+		}
+		if entry == nil {
+			continue
+		}
+		record.Source = userLdapProvider.Source
+		account := ldapcheck.AccountStateOf(entry, time.Now())
+		record.AccountState = account.State
+		if !account.Active() && conf.InactiveNotMember {
+			groups = nil
+		}
+
+		decision := rules.Decide(req, groups)
+		log.Infof("kubernetes request of %s in directory %s: %s", user, directory, decision.Reason)
+		switch {
+		case decision.Allowed:
+			record.Decision = audit.DecisionMember
+			record.Groups = []string{decision.Group}
+		case decision.Denied:
+			record.Decision = audit.DecisionDenied
+		default:
+			record.Decision = audit.DecisionNotMember
+		}
+		answer(model.SubjectAccessReviewStatus{Allowed: decision.Allowed, Denied: decision.Denied, Reason: decision.Reason})
+		return
+	}
+
+	log.Infof("no info in Ldap found for user:%s", user)
+	record.Decision = audit.DecisionNotFound
+	answer(model.SubjectAccessReviewStatus{Reason: "user not found in the directory"})
+}
+
+// reviewRequest convert the attributes of a review to what the rules match
+func reviewRequest(spec model.SubjectAccessReviewSpec) rbac.Request {
+	if r := spec.ResourceAttributes; r != nil {
+		return rbac.Request{Verb: r.Verb, Namespace: r.Namespace, ApiGroup: r.Group, Resource: r.Resource, Subresource: r.Subresource}
+	}
+	return rbac.Request{Verb: spec.NonResourceAttributes.Verb, Path: spec.NonResourceAttributes.Path}
+}
+
+// userGroups look the user up by the identifier type and list the names of its groups, nested ones too when asked.
+// The entry is nil when there is no such user.
+func userGroups(c *gin.Context, p *ldapcheck.Provider, by, user string, nested bool) (*ldap.Entry, []string, error) {
+	ctx := c.Request.Context()
+	users, err := p.FindUsers(ctx, by, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case len(users.Entries) == 0:
+		return nil, nil, nil
+	case len(users.Entries) > 1:
+		return nil, nil, fmt.Errorf("%w: %d users have %s %s", ldapcheck.ErrAmbiguous, len(users.Entries), by, user)
+	}
+	entry := users.Entries[0]
+	direct, err := p.DirectGroups(ctx, entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	all := direct
+	if nested {
+		inherited, err := p.NestedGroups(ctx, entry, direct)
+		if err != nil {
+			return nil, nil, err
+		}
+		all = append(all, inherited...)
+	}
+	names := make([]string, 0, len(all))
+	for _, g := range all {
+		names = append(names, g.Name)
+	}
+	return entry, names, nil
+}
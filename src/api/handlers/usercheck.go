@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"time"
+	"user-check/api/middleware"
 	"user-check/api/response"
+	"user-check/audit"
+	"user-check/configuration"
 	"user-check/ldapcheck"
+	"user-check/model"
+	"user-check/policy"
 	"user-check/utils"
 	"user-check/utils/go-stats/concurrency"
 	"user-check/utils/logger"
@@ -11,66 +18,166 @@ import (
 
 // UserCheck godoc
 // @Summary UserCheck
-// @Description This will validate if user is part of the group
+// @Description This will validate if user is part of the group.
+// @Description With directory=* every directory is searched and the one that matched is reported.
+// @Description Disabled, locked and expired accounts are answered as non-members when INACTIVE_NOT_MEMBER is set.
+// @Description With by= the user is identified by mail, upn, employee_id or proxy_address instead, an identifier
+// @Description matching several users is answered with 409.
 // @Produce json
-// @Param isid path string true "User isid"
+// @Param isid path string true "User isid, or the identifier of type by"
+// @Param by query string false "Identifier type: isid (default), mail, upn, employee_id or proxy_address"
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Param verbose query bool false "Answer with the account state and directory"
 // @Success 200 {string} string "true or false"
+// @Success 200 {object} model.UserCheckResult "verbose or fanned out answer"
 // @Router /v1/usercheck/{isid} [get]
+// @Router /v1/directories/{directory}/usercheck/{isid} [get]
 func UserCheck(c *gin.Context) {
 	concurrency.GlobalWaitGroup.Add(1)
 	defer concurrency.GlobalWaitGroup.Done()
 
-	log := logger.SugaredLogger().WithContextCorrelationId(c)
+	isid := c.Param("isid")
+	by := c.DefaultQuery("by", ldapcheck.ByIsid)
+
+	record := middleware.AuditRecord(c, policy.EndpointUserCheck, selectedGroups(c))
+	defer middleware.LogAudit(record)
+
+	res, member, ok := checkUser(c, record, by, isid)
+	if !ok {
+		return
+	}
+	status := "false"
+	if member {
+		status = "true"
+	}
 
-	// let's do a map, we love maps :D
-	isidmap := map[string]interface{}{
-		"isid": c.Param("isid"),
+	if middleware.FanOut(c) || c.Query("verbose") == "true" {
+		result := model.UserCheckResult{
+			Status:         status,
+			Directory:      res.Directory,
+			AccountState:   res.Account.State,
+			AccountReasons: res.Account.Reasons,
+		}
+		if by != ldapcheck.ByIsid {
+			result.Isid = res.Isid
+		}
+		response.SuccessResponse(c, c.MustGet("correlation_id").(string), result)
+		return
 	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), status)
 
-	var (
-		userLdapProvider *ldapcheck.Provider
-		err              error
-		status           string
-	)
+}
 
-	log.Debugf("Payload: user isid:%v", isidmap["isid"].(string))
+// UserCheckV2 godoc
+// @Summary UserCheck v2
+// @Description This will validate if user is part of the group, answered with a typed membership: a boolean member,
+// @Description the groups matched, the account state, where the answer came from and when.
+// @Description Disabled, locked and expired accounts are not members when INACTIVE_NOT_MEMBER is set.
+// @Produce json
+// @Param isid path string true "User isid, or the identifier of type by"
+// @Param by query string false "Identifier type: isid (default), mail, upn, employee_id or proxy_address"
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Success 200 {object} model.Membership
+// @Failure default {object} model.Problem
+// @Router /v2/usercheck/{isid} [get]
+func UserCheckV2(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
 
-	ctx := c.Request.Context()
+	isid := c.Param("isid")
+	by := c.DefaultQuery("by", ldapcheck.ByIsid)
 
-	if userLdapProvider, err = ldapcheck.New(ctx); err != nil {
-		log.Errorf("Error while initializing Ldap Provider: %s", err)
-		response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: err})
-		return // return here because we don't want to continue if we failed to initialize ldap provider
-	}
+	record := middleware.AuditRecord(c, policy.EndpointUserCheck, selectedGroups(c))
+	defer middleware.LogAudit(record)
 
-	newusersearch, err := userLdapProvider.CheckUserLdap(ctx, isidmap)
-	if err != nil {
-		log.Errorf("check user exists failed: %v", err)
-		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: err})
+	res, member, ok := checkUser(c, record, by, isid)
+	if !ok {
 		return
-	} else {
-		log.Debugf("check user exists returned:%s", *newusersearch)
 	}
 
-	if len(newusersearch.Entries) == 0 {
-		log.Infof("no info in Ldap found for isid:%s", isidmap["isid"].(string))
-		status = "false"
+	result := model.Membership{
+		User:          isid,
+		Found:         res.Found,
+		Member:        member,
+		MatchedGroups: []string{},
+		Directory:     res.Directory,
+		Source:        res.Source,
+		CheckedAt:     time.Now().UTC(),
+	}
+	if res.Found {
+		result.Isid = res.Isid
+		result.AccountState = res.Account.State
+		result.AccountReasons = res.Account.Reasons
+	}
+	if member {
+		result.MatchedGroups = []string{res.Group}
+	}
+	if !res.AnsweredAt.IsZero() {
+		answeredAt := res.AnsweredAt.UTC()
+		result.AnsweredAt = &answeredAt
+	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), result)
+}
+
+// selectedGroups return the groups of every directory the request selected
+func selectedGroups(c *gin.Context) []string {
+	var groups []string
+	for _, directory := range middleware.SelectedDirectories(c) {
+		groups = append(groups, middleware.DirectoryGroups(directory)...)
+	}
+	return groups
+}
+
+// checkUser look the user up in the directories the request selected and tell whether it is a member of the
+// group, inactive accounts are not when INACTIVE_NOT_MEMBER is set. Failures are answered and ok is false.
+func checkUser(c *gin.Context, record *audit.Record, by, isid string) (res ldapcheck.Membership, member bool, ok bool) {
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+	log.Debugf("Payload: user %s:%v", by, isid)
+
+	ctx := c.Request.Context()
+
+	if err := ldapcheck.ValidIdentifierType(by); err != nil {
+		record.Error = err.Error()
+		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: err})
+		return res, false, false
+	}
+	directories := middleware.SelectedDirectories(c)
+	if len(directories) == 1 {
+		res = ldapcheck.LookupUser(ctx, directories[0], by, isid)
 	} else {
-		log.Infof("there is info in Ldap for isid:%s", isidmap["isid"].(string))
-
-		for _, entry := range newusersearch.Entries {
-			// print just email
-			log.Debugf("%s: %v\n", entry.DN, entry.GetAttributeValue("mail"))
-			//log.Debugf("%s: %v\n", entry.DN, entry.GetAttributeValue("mail"), entry.GetAttributeValues("memberOf"))
-
-			if userLdapProvider.IsUserInGroup(ctx, entry.GetAttributeValues("memberOf")) {
-				status = "true"
-			} else {
-				status = "false"
-			}
+		res = ldapcheck.LookupUserIn(ctx, directories, by, isid)
+	}
+	if res.Err != nil {
+		record.Directory = res.Directory
+		record.Error = res.Err.Error()
+		if errors.Is(res.Err, ldapcheck.ErrProvider) {
+			response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: res.Err})
+			return res, false, false // return here because we don't want to continue if we failed to initialize ldap provider
 		}
+		ldapFailure(c, res.Err)
+		return res, false, false
 	}
 
-	response.SuccessResponse(c, c.MustGet("correlation_id").(string), status)
+	record.Source = res.Source
+	record.Directory = res.Directory
+	if res.Found {
+		record.AccountState = res.Account.State
+		c.Header("X-Account-State", res.Account.State)
+	}
+	if !res.Found {
+		log.Infof("no info in Ldap found for isid:%s", isid)
+	} else if res.Member && !res.Account.Active() && configuration.AppConfig().InactiveNotMember {
+		log.Infof("there is info in Ldap for isid:%s in directory %s, account is %s", isid, res.Directory, res.Account.State)
+	} else {
+		log.Infof("there is info in Ldap for isid:%s in directory %s", isid, res.Directory)
+		member = res.Member
+	}
 
+	if member {
+		record.Decision = audit.DecisionMember
+		record.Membership = audit.MembershipOf(res.Nested)
+	} else {
+		record.Decision = audit.DecisionNotMember
+	}
+	return res, member, true
 }
@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
+	"sync"
+	"time"
+	"user-check/api/middleware"
 	"user-check/api/response"
+	"user-check/configuration"
 	"user-check/ldapcheck"
+	"user-check/model"
 	"user-check/utils"
 	"user-check/utils/go-stats/concurrency"
 	"user-check/utils/logger"
@@ -11,39 +17,157 @@ import (
 
 // UserGroupCount godoc
 // @Summary UserGroupCount
-// @Description This will return number of users in the group
+// @Description This will return number of users in the group.
+// @Description With directory=* the group of every directory is counted at once, with a breakdown per directory
+// @Description and the directories that could not be counted under errors.
+// @Description With breakdown=true every member account is read to count active and inactive members.
 // @Produce json
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Param breakdown query bool false "Count active and inactive (disabled, locked, expired) members apart"
 // @Success 200 {string} string "success or failure"
+// @Success 200 {object} model.MemberCount "breakdown answer"
 // @Router /v1/usercount [get]
+// @Router /v1/directories/{directory}/usercount [get]
 func UserGroupCount(c *gin.Context) {
 	concurrency.GlobalWaitGroup.Add(1)
 	defer concurrency.GlobalWaitGroup.Done()
 
-	log := logger.SugaredLogger().WithContextCorrelationId(c)
+	breakdown := c.Query("breakdown") == "true"
+	result, ok := countMembers(c, breakdown)
+	if !ok {
+		return
+	}
 
-	var (
-		userLdapProvider *ldapcheck.Provider
-		err              error
-		result           int
-	)
+	if breakdown && !middleware.FanOut(c) {
+		response.SuccessResponse(c, c.MustGet("correlation_id").(string), result.Breakdown[middleware.SelectedDirectories(c)[0]])
+		return
+	}
+	if middleware.FanOut(c) {
+		response.SuccessResponse(c, c.MustGet("correlation_id").(string), result)
+		return
+	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), result.Total)
+}
 
+// UserGroupCountV2 godoc
+// @Summary UserGroupCount v2
+// @Description This will return the number of members of the group of every directory selected, with the group name.
+// @Description With breakdown=true every member account is read to count active and inactive members.
+// @Produce json
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Param breakdown query bool false "Count active and inactive (disabled, locked, expired) members apart"
+// @Success 200 {object} model.GroupCount
+// @Failure default {object} model.Problem
+// @Router /v2/usercount [get]
+func UserGroupCountV2(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	breakdown := c.Query("breakdown") == "true"
+	counts, ok := countMembers(c, breakdown)
+	if !ok {
+		return
+	}
+
+	result := model.GroupCount{
+		Total:       counts.Total,
+		Directories: map[string]model.DirectoryGroupCount{},
+		Errors:      counts.Errors,
+		CountedAt:   time.Now().UTC(),
+	}
+	for directory, total := range counts.Directories {
+		count := model.DirectoryGroupCount{Total: total}
+		if d, ok := configuration.AppConfig().Directory(directory); ok {
+			count.Group = d.OncoGroup
+		}
+		if states, ok := counts.Breakdown[directory]; ok {
+			count.Active, count.Inactive = &states.Active, &states.Inactive
+		}
+		result.Directories[directory] = count
+	}
+	response.SuccessResponse(c, c.MustGet("correlation_id").(string), result)
+}
+
+// directoryCount is the member count of the group of one directory, or why it could not be counted
+type directoryCount struct {
+	count  model.MemberCount
+	err    error
+	status int
+}
+
+// countMembers count the members of the group of every directory the request selected at once, active and
+// inactive ones apart with breakdown. A fanned out count reports the directories that failed and answers
+// with the others, failures are answered and ok is false when nothing could be counted.
+func countMembers(c *gin.Context, breakdown bool) (result model.DirectoryCount, ok bool) {
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
 	log.Infof("Count users in specific group")
 	ctx := c.Request.Context()
 
-	if userLdapProvider, err = ldapcheck.New(ctx); err != nil {
-		log.Errorf("Error while initializing Ldap Provider: %s", err)
-		response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: err})
-		return
+	directories := middleware.SelectedDirectories(c)
+	counts := make([]directoryCount, len(directories))
+	var wg sync.WaitGroup
+	for i, directory := range directories {
+		wg.Add(1)
+		go func(i int, directory string) {
+			defer wg.Done()
+			counts[i] = countDirectory(ctx, log, directory, breakdown)
+		}(i, directory)
+	}
+	wg.Wait()
+
+	result.Directories = map[string]int{}
+	if breakdown {
+		result.Breakdown = map[string]model.MemberCount{}
+	}
+	var failed *directoryCount
+	for i, directory := range directories {
+		if counts[i].err != nil {
+			if failed == nil {
+				failed = &counts[i]
+			}
+			if result.Errors == nil {
+				result.Errors = map[string]string{}
+			}
+			result.Errors[directory] = counts[i].err.Error()
+			continue
+		}
+		result.Directories[directory] = counts[i].count.Total
+		result.Total += counts[i].count.Total
+		if breakdown {
+			result.Breakdown[directory] = counts[i].count
+		}
+	}
+	if failed != nil && (!middleware.FanOut(c) || len(result.Errors) == len(directories)) {
+		if failed.status == 500 {
+			response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: failed.err})
+		} else {
+			ldapFailure(c, failed.err)
+		}
+		return result, false
+	}
+	return result, true
+}
+
+// countDirectory count the members of the group of directory, active and inactive ones apart with breakdown
+func countDirectory(ctx context.Context, log *logger.CSugaredLogger, directory string, breakdown bool) directoryCount {
+	userLdapProvider, err := ldapcheck.NewForDirectory(ctx, directory)
+	if err != nil {
+		log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+		return directoryCount{err: err, status: 500}
 	}
 
 	secgroupusercount, err := userLdapProvider.QueryUserGroupLdap(ctx)
 	if err != nil {
-		log.Errorf("ldap query user group failed: %v", err)
-		response.FailureResponse(c, nil, utils.HttpError{Code: 400, Err: err})
-		return
-	} else {
-		result = ldapcheck.LdapCountObjects(ctx, secgroupusercount)
-		response.SuccessResponse(c, c.MustGet("correlation_id").(string), result)
+		log.Errorf("ldap query user group in directory %s failed: %v", directory, err)
+		return directoryCount{err: err}
 	}
-}
+	count := model.MemberCount{Total: userLdapProvider.CountMembers(ctx, secgroupusercount)}
 
+	if breakdown {
+		if count.Active, count.Inactive, err = userLdapProvider.MemberStates(ctx, secgroupusercount); err != nil {
+			log.Errorf("reading member accounts in directory %s failed: %v", directory, err)
+			return directoryCount{err: err}
+		}
+	}
+	return directoryCount{count: count}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"strings"
+	"time"
+	"user-check/api/middleware"
+	"user-check/api/response"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/ldapcheck"
+	"user-check/model"
+	"user-check/policy"
+	"user-check/utils"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// profileFields are the ldap attributes with a field of their own in the profile
+var profileFields = map[string]func(p *model.UserProfile, value string){
+	"displayname": func(p *model.UserProfile, value string) { p.DisplayName = value },
+	"mail":        func(p *model.UserProfile, value string) { p.Mail = value },
+	"givenname":   func(p *model.UserProfile, value string) { p.GivenName = value },
+	"sn":          func(p *model.UserProfile, value string) { p.Surname = value },
+	"department":  func(p *model.UserProfile, value string) { p.Department = value },
+	"title":       func(p *model.UserProfile, value string) { p.Title = value },
+	"manager":     func(p *model.UserProfile, value string) { p.Manager = value },
+}
+
+// UserProfile godoc
+// @Summary UserProfile
+// @Description This will return the profile of the user: names, mail, department, title, manager, account state and groups.
+// @Description Only the attributes of the PROFILE_ATTRIBUTES allow-list are returned, groups only when it lists "groups".
+// @Description With directory=* the first directory, in configuration order, where the user exists answers.
+// @Description On v2 the profile is answered alone and failures as problem details.
+// @Produce json
+// @Param isid path string true "User isid"
+// @Param directory query string false "Directory profile, default when omitted, * for every directory"
+// @Param nested query bool false "Also list the groups the user is a member of through nested groups"
+// @Success 200 {object} model.UserProfile
+// @Failure 404 {object} model.JSONFailureResult "no such user"
+// @Router /v1/users/{isid} [get]
+// @Router /v2/users/{isid} [get]
+func UserProfile(c *gin.Context) {
+	concurrency.GlobalWaitGroup.Add(1)
+	defer concurrency.GlobalWaitGroup.Done()
+
+	log := logger.SugaredLogger().WithContextCorrelationId(c)
+
+	isid := c.Param("isid")
+	log.Debugf("Payload: user isid:%v", isid)
+
+	record := middleware.AuditRecord(c, policy.EndpointUsers, nil)
+	defer middleware.LogAudit(record)
+
+	ctx := c.Request.Context()
+	attributes := configuration.AppConfig().ProfileAttributeList()
+	nested := c.Query("nested") == "true"
+
+	var failure error
+	for _, directory := range middleware.SelectedDirectories(c) {
+		userLdapProvider, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			response.FailureResponse(c, nil, utils.HttpError{Code: 500, Err: err})
+			return
+		}
+
+		entry, err := userLdapProvider.UserEntry(ctx, isid, attributes)
+		if err != nil {
+			log.Errorf("user profile lookup in directory %s failed: %v", directory, err)
+			if failure == nil {
+				failure = err
+				record.Directory = directory
+			}
+			continue
+		}
+		if entry == nil {
+			continue
+		}
+
+		profile, err := userProfile(c, userLdapProvider, entry, attributes, nested)
+		record.Directory = directory
+		record.Source = userLdapProvider.Source
+		if err != nil {
+			log.Errorf("reading groups in directory %s failed: %v", directory, err)
+			record.Error = err.Error()
+			ldapFailure(c, err)
+			return
+		}
+		profile.Isid = isid
+		record.Decision = audit.DecisionFound
+		record.AccountState = profile.AccountState
+		response.SuccessResponse(c, c.MustGet("correlation_id").(string), profile)
+		return
+	}
+
+	// a failed directory might have been the one
+	if failure != nil {
+		record.Error = failure.Error()
+		ldapFailure(c, failure)
+		return
+	}
+	log.Infof("no info in Ldap found for isid:%s", isid)
+	record.Decision = audit.DecisionNotFound
+	response.FailureResponse(c, nil, utils.HttpError{Code: 404, Err: fmt.Errorf("user %s not found", isid)})
+}
+
+// userProfile build the profile of entry, keeping only the allowed attributes
+func userProfile(c *gin.Context, p *ldapcheck.Provider, entry *ldap.Entry, attributes []string, nested bool) (model.UserProfile, error) {
+	ctx := c.Request.Context()
+	account := ldapcheck.AccountStateOf(entry, time.Now())
+	profile := model.UserProfile{
+		Directory:      p.Directory,
+		DN:             entry.DN,
+		AccountState:   account.State,
+		AccountReasons: account.Reasons,
+	}
+
+	withGroups := false
+	for _, attribute := range attributes {
+		if attribute == ldapcheck.ProfileGroups {
+			withGroups = true
+			continue
+		}
+		values := entry.GetEqualFoldAttributeValues(attribute)
+		if len(values) == 0 {
+			continue
+		}
+		if set, ok := profileFields[strings.ToLower(attribute)]; ok {
+			set(&profile, values[0])
+			continue
+		}
+		if profile.Attributes == nil {
+			profile.Attributes = map[string][]string{}
+		}
+		profile.Attributes[attribute] = values
+	}
+	if !withGroups {
+		return profile, nil
+	}
+
+	direct, err := p.DirectGroups(ctx, entry)
+	if err != nil {
+		return profile, err
+	}
+	profile.Groups = groupRefs(direct)
+	if nested {
+		inherited, err := p.NestedGroups(ctx, entry, direct)
+		if err != nil {
+			return profile, err
+		}
+		profile.NestedGroups = groupRefs(inherited)
+	}
+	return profile, nil
+}
+
+// groupRefs convert ldap groups to their api model
+func groupRefs(groups []ldapcheck.Group) []model.GroupRef {
+	refs := make([]model.GroupRef, 0, len(groups))
+	for _, g := range groups {
+		refs = append(refs, model.GroupRef{Name: g.Name, DN: g.DN})
+	}
+	return refs
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"strings"
+	"user-check/configuration"
+)
+
+// ApiVersion mark requests of the v2 api, so that the middlewares and handlers before and after routing answer
+// them with bare models and problem details
+func ApiVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/api/v2/") {
+			c.Set(configuration.ApiVersionKey, configuration.ApiV2)
+		}
+		c.Next()
+	}
+}
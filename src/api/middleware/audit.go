@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"time"
+	"user-check/audit"
+	"user-check/configuration"
+)
+
+// AuditRecord start an audit record for the current request, pre-filled with the caller and correlation id.
+// The decision defaults to error until the handler knows better.
+func AuditRecord(c *gin.Context, endpoint string, groups []string) *audit.Record {
+	return &audit.Record{
+		Time:          time.Now().UTC(),
+		CorrelationId: c.GetString(configuration.CorrelationIdKey),
+		Caller:        c.GetString(configuration.CallerIdKey),
+		CallerAuth:    c.GetString(configuration.CallerAuthKey),
+		Endpoint:      endpoint,
+		Isid:          c.Param("isid"),
+		Groups:        groups,
+		Decision:      audit.DecisionError,
+	}
+}
+
+// LogAudit write rec to the audit stream, measuring the latency from the record creation
+func LogAudit(rec *audit.Record) {
+	rec.LatencyMs = float64(time.Since(rec.Time).Microseconds()) / 1000
+	audit.Log(*rec)
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"user-check/api/response"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/policy"
+	"user-check/utils"
+	"user-check/utils/logger"
+)
+
+// Authorize enforce the caller policy for endpoint before the handler touches ldap
+func Authorize(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := logger.SugaredLogger().WithContextCorrelationId(c).With("package", "middleware", "action", "authorize caller")
+		p := policy.Current()
+		if p == nil {
+			if endpoint == policy.EndpointAdmin && !configuration.AppConfig().Development {
+				log.Warnf("request denied: admin endpoints need a caller policy")
+				response.FailureResponse(c, nil, utils.HttpError{Code: 403, Err: fmt.Errorf("admin endpoints need a caller policy")})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		caller := c.GetString(configuration.CallerIdKey)
+		groups := requestedGroups(c, endpoint)
+
+		var err error
+		if endpoint == policy.EndpointAdmin {
+			err = p.Authorize(caller, endpoint, nil)
+		} else {
+			// a fanned out request goes on with the directories the caller may query
+			var allowed []string
+			for _, directory := range SelectedDirectories(c) {
+				var dirGroups []string
+				if policy.GroupScoped(endpoint) {
+					dirGroups = RequestGroups(c, directory)
+				}
+				if dirErr := p.AuthorizeIn(caller, endpoint, directory, dirGroups); dirErr != nil {
+					if err == nil {
+						err = dirErr
+					}
+					continue
+				}
+				allowed = append(allowed, directory)
+			}
+			if err != nil && FanOut(c) && len(allowed) > 0 {
+				log.Debugf("fan out limited to directories %v: %s", allowed, err)
+				c.Set(configuration.DirectoriesKey, allowed)
+				err = nil
+			}
+		}
+		if err == nil {
+			c.Next()
+			return
+		}
+		// dry runs try out the lookup rules, admin endpoints stay enforced
+		if endpoint != policy.EndpointAdmin && (p.DryRun || configuration.AppConfig().PolicyDryRun) {
+			log.Warnf("dry run, would deny request: %s", err)
+			c.Next()
+			return
+		}
+		log.Warnf("request denied: %s", err)
+		if c.Param("isid") != "" {
+			rec := AuditRecord(c, endpoint, groups)
+			rec.Decision = audit.DecisionDenied
+			rec.Error = err.Error()
+			LogAudit(rec)
+		}
+		response.FailureResponse(c, nil, utils.HttpError{Code: 403, Err: err})
+		c.Abort()
+	}
+}
+
+// requestedGroups return the groups the request is going to query
+func requestedGroups(c *gin.Context, endpoint string) []string {
+	if !policy.GroupScoped(endpoint) {
+		return nil
+	}
+	var groups []string
+	for _, directory := range SelectedDirectories(c) {
+		groups = append(groups, RequestGroups(c, directory)...)
+	}
+	return groups
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-check/configuration"
+)
+
+// Cacheable mark the answers of a route as cacheable: they carry an ETag, a Last-Modified and a Cache-Control
+// following the server side cache, and conditional requests of unchanged answers get a 304
+func Cacheable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(configuration.CacheableKey, true)
+		c.Next()
+	}
+}
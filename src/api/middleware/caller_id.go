@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-check/api/response"
+	"user-check/configuration"
+	"user-check/identity"
+	"user-check/utils"
+	"user-check/utils/logger"
+)
+
+// CallerId resolve the identity of the caller and store it in the gin context.
+// A verified client certificate wins over the caller header, which is only believed from trusted proxies
+// unless CALLER_ID_HEADER_TRUSTED is set.
+func CallerId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conf := configuration.AppConfig()
+
+		if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+			log := logger.SugaredLogger().WithContextCorrelationId(c).With("package", "middleware", "action", "identify caller")
+			cert := c.Request.TLS.VerifiedChains[0][0]
+			caller, err := identity.FromCertificate(cert)
+			if err != nil {
+				log.Warnf("rejecting client certificate: %s", err)
+				response.FailureResponse(c, nil, utils.HttpError{Code: 403, Err: err})
+				c.Abort()
+				return
+			}
+			log.Debugf("caller %s identified by client certificate %s", caller, cert.Subject)
+			c.Set(configuration.CallerIdKey, caller)
+			c.Set(configuration.CallerAuthKey, configuration.CallerAuthMtls)
+			c.Next()
+			return
+		}
+
+		caller := c.GetHeader(conf.CallerIdHeader)
+		if caller != "" && !conf.CallerHeaderTrusted(c.RemoteIP()) {
+			logger.SugaredLogger().WithContextCorrelationId(c).With("package", "middleware", "action", "identify caller").
+				Debugf("ignoring the caller header of %s, not a trusted proxy", c.RemoteIP())
+			caller = ""
+		}
+		if caller == "" {
+			c.Set(configuration.CallerIdKey, configuration.AnonymousCaller)
+			c.Set(configuration.CallerAuthKey, configuration.CallerAuthNone)
+		} else {
+			c.Set(configuration.CallerIdKey, caller)
+			c.Set(configuration.CallerAuthKey, configuration.CallerAuthHeader)
+		}
+		c.Next()
+	}
+}
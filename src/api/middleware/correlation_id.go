@@ -2,13 +2,15 @@ package middleware
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"user-check/utils"
 )
 
+// CorrelationId keep the correlation id the caller sent in X-Correlation-Id, or make one up, and send it back
 func CorrelationId() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		correlationId := uuid.New()
-		c.Set("correlation_id", correlationId.String())
+		correlationId := utils.CorrelationId(c.GetHeader(utils.CorrelationIdHeader))
+		c.Set("correlation_id", correlationId)
+		c.Header(utils.CorrelationIdHeader, correlationId)
 		c.Next()
 	}
 }
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"user-check/api/response"
+	"user-check/configuration"
+	"user-check/utils"
+)
+
+// SelectDirectory resolve the directories the request is for, from the :directory path parameter
+// or the directory query parameter. Without either the default directory is used, "*" selects every directory.
+func SelectDirectory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conf := configuration.AppConfig()
+		name := c.Param("directory")
+		if name == "" {
+			name = c.DefaultQuery("directory", configuration.DefaultDirectory)
+		}
+
+		if name == configuration.AllDirectories {
+			c.Set(configuration.DirectoriesKey, conf.DirectoryNames())
+			c.Set(configuration.FanOutKey, true)
+			c.Next()
+			return
+		}
+		if _, ok := conf.Directory(name); !ok {
+			response.FailureResponse(c, nil, utils.HttpError{Code: 404, Err: fmt.Errorf("unknown directory %s", name)})
+			c.Abort()
+			return
+		}
+		c.Set(configuration.DirectoriesKey, []string{name})
+		c.Next()
+	}
+}
+
+// SelectedDirectories return the directories the request is going to query
+func SelectedDirectories(c *gin.Context) []string {
+	if dirs, ok := c.Get(configuration.DirectoriesKey); ok {
+		return dirs.([]string)
+	}
+	return []string{configuration.DefaultDirectory}
+}
+
+// FanOut tell whether the request asked for every directory
+func FanOut(c *gin.Context) bool {
+	return c.GetBool(configuration.FanOutKey)
+}
+
+// DirectoryGroups return the groups a request queries in directory
+func DirectoryGroups(directory string) []string {
+	d, _ := configuration.AppConfig().Directory(directory)
+	return []string{d.OncoGroup}
+}
+
+// RequestGroups return the groups a request checks in directory: the ones it asked for, set under
+// configuration.GroupsKey, or else the configured group of the directory
+func RequestGroups(c *gin.Context, directory string) []string {
+	if groups, ok := c.Get(configuration.GroupsKey); ok {
+		return groups.([]string)
+	}
+	return DirectoryGroups(directory)
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"strings"
+	"user-check/configuration"
+)
+
+// ForwardGroups read the groups a forward auth subrequest requires. Without any the configured group is checked.
+// Only the sources the proxy is configured to control are read: the group (repeatable) and groups (comma
+// separated) query parameters with FORWARD_GROUPS_QUERY, the groups header when FORWARD_GROUPS_HEADER names one.
+func ForwardGroups() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conf := configuration.AppConfig()
+		var values []string
+		if conf.ForwardGroupsQuery {
+			values = append(c.QueryArray("group"), c.Query("groups"))
+		}
+		if conf.ForwardGroupsHeader != "" {
+			values = append(values, c.GetHeader(conf.ForwardGroupsHeader))
+		}
+
+		var groups []string
+		seen := map[string]bool{}
+		for _, value := range values {
+			for _, group := range strings.Split(value, ",") {
+				group = strings.TrimSpace(group)
+				if group != "" && !seen[group] {
+					seen[group] = true
+					groups = append(groups, group)
+				}
+			}
+		}
+		if len(groups) > 0 {
+			c.Set(configuration.GroupsKey, groups)
+		}
+		c.Next()
+	}
+}
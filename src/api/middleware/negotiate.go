@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"user-check/api/response"
+	"user-check/configuration"
+	"user-check/utils"
+)
+
+// Negotiate find the answer formats the caller accepts, from the format query parameter or the Accept header,
+// for SuccessResponse to pick from. Callers accepting none of them are answered with 406 before anything is done.
+func Negotiate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accepted, err := response.Acceptable(c)
+		if err != nil {
+			response.FailureResponse(c, nil, utils.HttpError{Code: http.StatusNotAcceptable, Err: err})
+			c.Abort()
+			return
+		}
+		c.Set(configuration.FormatsKey, accepted)
+		c.Next()
+	}
+}
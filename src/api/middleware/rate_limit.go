@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"strconv"
+	"user-check/api/response"
+	"user-check/configuration"
+	"user-check/metrics"
+	"user-check/ratelimit"
+	"user-check/utils"
+	"user-check/utils/logger"
+)
+
+// RateLimit refuse with 429 callers exceeding their token bucket.
+// Callers identified by client certificate get a bucket each, the others are limited per client ip.
+// The buckets are the ones of the limiter in force, shared by every route and by the gRPC api.
+func RateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter := ratelimit.Current()
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		// a caller named by a header could pick any bucket, only certificates get their own
+		keyType, key := "ip", c.ClientIP()
+		if c.GetString(configuration.CallerAuthKey) == configuration.CallerAuthMtls {
+			keyType, key = "caller", c.GetString(configuration.CallerIdKey)
+		}
+
+		ok, wait := limiter.Allow(keyType + ":" + key)
+		if ok {
+			c.Next()
+			return
+		}
+
+		log := logger.SugaredLogger().WithContextCorrelationId(c).With("package", "middleware", "action", "rate limit")
+		log.Warnf("rate limit exceeded for %s %s", keyType, key)
+		metrics.RateLimited.WithLabelValues(keyType).Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		response.FailureResponse(c, nil, utils.HttpError{Code: 429, Err: fmt.Errorf("rate limit exceeded for %s %s", keyType, key)})
+		c.Abort()
+	}
+}
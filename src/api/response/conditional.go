@@ -0,0 +1,102 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"user-check/configuration"
+	"user-check/policy"
+	"user-check/utils"
+)
+
+// Stamped is data stamped with when and how it was produced, which is left out of its ETag
+type Stamped interface {
+	Unstamped() interface{}
+}
+
+// maxVersions answers whose ETag is remembered, all of them are forgotten when there are more
+const maxVersions = 10000
+
+// version is since when an answer has had its ETag
+type version struct {
+	etag  string
+	since time.Time
+}
+
+var (
+	versionsMu sync.Mutex
+	versions   = map[string]version{}
+)
+
+// ETag return the weak ETag of the answer of data: a hash of the data, the answer formats and the api version
+func ETag(c *gin.Context, data interface{}) string {
+	if s, ok := data.(Stamped); ok {
+		data = s.Unstamped()
+	}
+	encoded, _ := json.Marshal(data)
+	hash := sha256.New()
+	hash.Write([]byte(c.GetString(configuration.ApiVersionKey) + "\n" + strings.Join(acceptedFormats(c), ",") + "\n"))
+	hash.Write(encoded)
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// lastModified return since when the answer to the request has had the etag, now when it changed
+func lastModified(c *gin.Context, etag string) time.Time {
+	key := c.GetString(configuration.CallerIdKey) + " " + c.Request.URL.Path + "?" + c.Request.URL.Query().Encode()
+
+	versionsMu.Lock()
+	defer versionsMu.Unlock()
+	if v, ok := versions[key]; ok && v.etag == etag {
+		return v.since
+	}
+	if len(versions) >= maxVersions {
+		versions = map[string]version{}
+	}
+	since := time.Now().UTC().Truncate(time.Second)
+	versions[key] = version{etag: etag, since: since}
+	return since
+}
+
+// cacheControl return the Cache-Control of cacheable answers: as long as the server side cache keeps them,
+// only for the caller when answers depend on the caller policy, revalidated every time without a cache
+func cacheControl() string {
+	ttl := configuration.AppConfig().CacheTtlSec
+	if ttl <= 0 {
+		return "no-cache"
+	}
+	if policy.Current() != nil {
+		return "private, max-age=" + strconv.Itoa(int(ttl))
+	}
+	return "max-age=" + strconv.Itoa(int(ttl))
+}
+
+// notModified set the validators and caching headers of a cacheable answer and tell whether the conditional
+// request of the caller is satisfied, answering 304 when it is
+func notModified(c *gin.Context, data interface{}) bool {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+	etag := ETag(c, data)
+	modified := lastModified(c, etag)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", modified.Format(http.TimeFormat))
+	c.Header("Cache-Control", cacheControl())
+	c.Header("Vary", "Accept")
+
+	// If-Modified-Since is only looked at without If-None-Match
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		if !utils.ETagMatch(ifNoneMatch, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err != nil || modified.After(since) {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,83 @@
+package response
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+	"user-check/configuration"
+	"user-check/model"
+	"user-check/policy"
+)
+
+func TestConditional(t *testing.T) {
+	Convey(`Feature: validators and conditional requests`, t, func() {
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+		// answer a cacheable GET of target with the request headers
+		answer := func(target string, header map[string]string, data interface{}) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			c := ginTestContext(rec, target, header["Accept"])
+			for name, value := range header {
+				c.Request.Header.Set(name, value)
+			}
+			accepted, err := Acceptable(c)
+			So(err, ShouldBeNil)
+			c.Set(configuration.FormatsKey, accepted)
+			c.Set(configuration.CorrelationIdKey, "req-42")
+			c.Set(configuration.CacheableKey, true)
+			SuccessResponse(c, "req-42", data)
+			c.Writer.WriteHeaderNow()
+			return rec
+		}
+
+		Convey("Answers carry an ETag, a Last-Modified and a Cache-Control", func() {
+			rec := answer("/usercount?directory=a", nil, 497)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("ETag"), ShouldStartWith, `W/"`)
+			So(rec.Header().Get("Last-Modified"), ShouldNotBeEmpty)
+			So(rec.Header().Get("Cache-Control"), ShouldEqual, "no-cache")
+			So(rec.Header().Get("Vary"), ShouldEqual, "Accept")
+		})
+		Convey("Cache-Control follows the server side cache and the caller policy", func() {
+			os.Setenv("CACHE_TTL", "60")
+			defer os.Unsetenv("CACHE_TTL")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(answer("/usercount?directory=b", nil, 497).Header().Get("Cache-Control"), ShouldEqual, "max-age=60")
+			policy.Set(&policy.Policy{})
+			defer policy.Set(nil)
+			So(answer("/usercount?directory=b", nil, 497).Header().Get("Cache-Control"), ShouldEqual, "private, max-age=60")
+		})
+		Convey("A matching If-None-Match is answered with 304", func() {
+			etag := answer("/usercount?directory=c", nil, 497).Header().Get("ETag")
+			rec := answer("/usercount?directory=c", map[string]string{"If-None-Match": etag}, 497)
+			So(rec.Code, ShouldEqual, http.StatusNotModified)
+			So(rec.Body.Len(), ShouldEqual, 0)
+			So(rec.Header().Get("ETag"), ShouldEqual, etag)
+
+			rec = answer("/usercount?directory=c", map[string]string{"If-None-Match": etag}, 498)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("ETag"), ShouldNotEqual, etag)
+			// another format is another representation
+			rec = answer("/usercount?directory=c", map[string]string{"If-None-Match": etag, "Accept": "text/plain"}, 497)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("If-Modified-Since is answered with 304 until the answer changes", func() {
+			modified := answer("/usercount?directory=d", nil, 497).Header().Get("Last-Modified")
+			rec := answer("/usercount?directory=d", map[string]string{"If-Modified-Since": modified}, 497)
+			So(rec.Code, ShouldEqual, http.StatusNotModified)
+			earlier := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+			rec = answer("/usercount?directory=d", map[string]string{"If-Modified-Since": earlier}, 497)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("When and how an answer was produced is not part of its ETag", func() {
+			first := model.GroupCount{Total: 42, CountedAt: time.Now()}
+			second := model.GroupCount{Total: 42, CountedAt: time.Now().Add(time.Minute)}
+			etag := answer("/v2/usercount", nil, first).Header().Get("ETag")
+			So(answer("/v2/usercount", nil, second).Header().Get("ETag"), ShouldEqual, etag)
+		})
+	})
+}
@@ -0,0 +1,263 @@
+package response
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"user-check/configuration"
+)
+
+// Answer formats
+const (
+	FormatJson   = "json"
+	FormatText   = "text"
+	FormatCsv    = "csv"
+	FormatNdjson = "ndjson"
+)
+
+// formats are the answer formats, by preference when the caller has none
+var formats = []string{FormatJson, FormatText, FormatCsv, FormatNdjson}
+
+// mediaTypes are the media types of the answer formats
+var mediaTypes = map[string]string{
+	FormatJson:   "application/json",
+	FormatText:   "text/plain",
+	FormatCsv:    "text/csv",
+	FormatNdjson: "application/x-ndjson",
+}
+
+// mediaRanges are the answer formats of the media ranges of an Accept header
+var mediaRanges = map[string][]string{
+	"*/*":                      formats,
+	"application/*":            {FormatJson, FormatNdjson},
+	"text/*":                   {FormatText, FormatCsv},
+	"application/json":         {FormatJson},
+	"application/problem+json": {FormatJson},
+	"text/plain":               {FormatText},
+	"text/csv":                 {FormatCsv},
+	"application/x-ndjson":     {FormatNdjson},
+	"application/jsonl":        {FormatNdjson},
+}
+
+// Acceptable return the answer formats the request accepts, by preference: the format query parameter, else
+// the Accept header. An empty list means no format is acceptable.
+func Acceptable(c *gin.Context) ([]string, error) {
+	if format := c.Query("format"); format != "" {
+		if _, ok := mediaTypes[format]; !ok {
+			return nil, fmt.Errorf("unknown format %s, one of %s", format, strings.Join(formats, ", "))
+		}
+		return []string{format}, nil
+	}
+	accept := c.GetHeader("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return []string{FormatJson}, nil
+	}
+
+	type ranked struct {
+		format string
+		q      float64
+	}
+	var ranks []ranked
+	refused := map[string]bool{}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			if name, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		for _, format := range mediaRanges[mediaType] {
+			if q <= 0 {
+				// an explicit refusal beats wildcards
+				if !strings.Contains(mediaType, "*") {
+					refused[format] = true
+				}
+				continue
+			}
+			ranks = append(ranks, ranked{format: format, q: q})
+		}
+	}
+	sort.SliceStable(ranks, func(i, j int) bool { return ranks[i].q > ranks[j].q })
+
+	var acceptable []string
+	seen := map[string]bool{}
+	for _, r := range ranks {
+		if seen[r.format] || refused[r.format] {
+			continue
+		}
+		seen[r.format] = true
+		acceptable = append(acceptable, r.format)
+	}
+	if len(acceptable) == 0 {
+		return nil, fmt.Errorf("none of %s is acceptable", strings.Join(formats, ", "))
+	}
+	return acceptable, nil
+}
+
+// acceptedFormats return the formats the Negotiate middleware found acceptable, json without it
+func acceptedFormats(c *gin.Context) []string {
+	if accepted, ok := c.Get(configuration.FormatsKey); ok {
+		return accepted.([]string)
+	}
+	return []string{FormatJson}
+}
+
+// render answer data in the first accepted format it can be written in, false when there is none
+func render(c *gin.Context, id string, data interface{}) bool {
+	for _, format := range acceptedFormats(c) {
+		switch format {
+		case FormatJson:
+			renderJson(c, id, data)
+			return true
+		case FormatText:
+			if lines, ok := textLines(data); ok {
+				c.Data(http.StatusOK, mediaTypes[FormatText]+"; charset=utf-8", []byte(strings.Join(lines, "\n")+"\n"))
+				return true
+			}
+		case FormatCsv, FormatNdjson:
+			if rows, ok := structSlice(data); ok {
+				stream(c, format, rows)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// textLines return the text form of data: scalars and fmt.Stringers on a line, a list of them one per line
+func textLines(data interface{}) ([]string, bool) {
+	if line, ok := textLine(data); ok {
+		return []string{line}, true
+	}
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice {
+		return nil, false
+	}
+	lines := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		line, ok := textLine(v.Index(i).Interface())
+		if !ok {
+			return nil, false
+		}
+		lines = append(lines, line)
+	}
+	return lines, true
+}
+
+// textLine return the text form of a scalar or a fmt.Stringer
+func textLine(data interface{}) (string, bool) {
+	if s, ok := data.(fmt.Stringer); ok {
+		return s.String(), true
+	}
+	switch reflect.ValueOf(data).Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprint(data), true
+	}
+	return "", false
+}
+
+// structSlice return data as a slice of structs, the rows of a CSV or NDJSON answer
+func structSlice(data interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	return v, true
+}
+
+// stream write rows as CSV, with a header of the json field names, or NDJSON, flushing every row so that long
+// listings reach the caller while they are written
+func stream(c *gin.Context, format string, rows reflect.Value) {
+	c.Header("Content-Type", mediaTypes[format]+"; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	columns := jsonFields(rows.Type().Elem())
+	if format == FormatNdjson {
+		encoder := json.NewEncoder(c.Writer)
+		for i := 0; i < rows.Len(); i++ {
+			if err := encoder.Encode(rows.Index(i).Interface()); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		return
+	}
+
+	w := csv.NewWriter(c.Writer)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+	_ = w.Write(header)
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		record := make([]string, len(columns))
+		for j, column := range columns {
+			record[j] = csvValue(row.Field(column.index))
+		}
+		if err := w.Write(record); err != nil {
+			return
+		}
+		w.Flush()
+		c.Writer.Flush()
+	}
+}
+
+// field is a column of a CSV answer
+type field struct {
+	name  string
+	index int
+}
+
+// jsonFields return the exported fields of a struct type by their json name
+func jsonFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, field{name: name, index: i})
+	}
+	return fields
+}
+
+// csvValue return the text of a CSV cell: lists are joined with ;, times in RFC 3339
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	if v.Kind() == reflect.Slice {
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = csvValue(v.Index(i))
+		}
+		return strings.Join(values, ";")
+	}
+	return fmt.Sprint(v.Interface())
+}
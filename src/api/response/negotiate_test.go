@@ -0,0 +1,123 @@
+package response
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-check/configuration"
+	"user-check/model"
+	"user-check/utils/logger"
+)
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+// ginTestContext return a gin context of a GET of target with the Accept header, answering to rec
+func ginTestContext(rec *httptest.ResponseRecorder, target, accept string) *gin.Context {
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		c.Request.Header.Set("Accept", accept)
+	}
+	return c
+}
+
+// negotiated return the formats a request of target with the Accept header accepts
+func negotiated(target, accept string) ([]string, error) {
+	return Acceptable(ginTestContext(httptest.NewRecorder(), target, accept))
+}
+
+func TestAcceptable(t *testing.T) {
+	Convey(`Feature: answer format negotiation`, t, func() {
+		Convey("The format query parameter wins over Accept", func() {
+			accepted, err := negotiated("/?format=csv", "application/json")
+			So(err, ShouldBeNil)
+			So(accepted, ShouldResemble, []string{FormatCsv})
+			_, err = negotiated("/?format=xml", "")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("No Accept means json", func() {
+			accepted, err := negotiated("/", "")
+			So(err, ShouldBeNil)
+			So(accepted, ShouldResemble, []string{FormatJson})
+		})
+		Convey("Accept is ranked by quality, wildcards included", func() {
+			accepted, err := negotiated("/", "application/json;q=0.5, text/csv")
+			So(err, ShouldBeNil)
+			So(accepted, ShouldResemble, []string{FormatCsv, FormatJson})
+			accepted, err = negotiated("/", "text/*;q=0.8, application/json;q=0, */*;q=0.1")
+			So(err, ShouldBeNil)
+			So(accepted, ShouldResemble, []string{FormatText, FormatCsv, FormatNdjson})
+		})
+		Convey("Nothing acceptable is an error", func() {
+			_, err := negotiated("/", "application/xml, text/html")
+			So(err, ShouldNotBeNil)
+			_, err = negotiated("/", "text/plain;q=0")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRender(t *testing.T) {
+	Convey(`Feature: answers in the negotiated format`, t, func() {
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+		answer := func(target, accept string, data interface{}) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			c := ginTestContext(rec, target, accept)
+			accepted, err := Acceptable(c)
+			So(err, ShouldBeNil)
+			c.Set(configuration.FormatsKey, accepted)
+			c.Set(configuration.CorrelationIdKey, "req-42")
+			SuccessResponse(c, "req-42", data)
+			return rec
+		}
+		members := []model.Member{
+			{Isid: "bordeanu", Directory: "default", AccountState: "active"},
+			{Isid: "martih", Directory: "default", AccountState: "disabled"},
+		}
+
+		Convey("Scalars and Stringers are plain text", func() {
+			rec := answer("/?format=text", "", "true")
+			So(rec.Body.String(), ShouldEqual, "true\n")
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+			So(answer("/", "text/plain", 497).Body.String(), ShouldEqual, "497\n")
+			So(answer("/", "text/plain", model.DirectoryCount{Total: 42}).Body.String(), ShouldEqual, "42\n")
+			So(answer("/", "text/plain", members).Body.String(), ShouldEqual, "bordeanu\nmartih\n")
+		})
+		Convey("Lists of structs are CSV and NDJSON", func() {
+			rec := answer("/", "text/csv", members)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/csv")
+			So(rec.Body.String(), ShouldEqual, "isid,directory,account_state\nbordeanu,default,active\nmartih,default,disabled\n")
+
+			rec = answer("/?format=ndjson", "", members)
+			So(rec.Body.String(), ShouldEqual, `{"isid":"bordeanu","directory":"default","account_state":"active"}`+"\n"+
+				`{"isid":"martih","directory":"default","account_state":"disabled"}`+"\n")
+		})
+		Convey("CSV cells of lists, pointers and times", func() {
+			type row struct {
+				Groups []string   `json:"groups"`
+				Active *int       `json:"active,omitempty"`
+				At     time.Time  `json:"at"`
+				Seen   *time.Time `json:"-"`
+			}
+			at := time.Date(2023, 1, 16, 10, 0, 0, 0, time.UTC)
+			rec := answer("/?format=csv", "", []row{{Groups: []string{"a", "b"}, At: at}})
+			So(rec.Body.String(), ShouldEqual, "groups,active,at\na;b,,2023-01-16T10:00:00Z\n")
+		})
+		Convey("The next acceptable format is used when data has no such form", func() {
+			rec := answer("/", "text/csv, application/json;q=0.5", "true")
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "application/json")
+		})
+		Convey("Data with no acceptable form is answered with 406", func() {
+			rec := answer("/?format=csv", "", "true")
+			So(rec.Code, ShouldEqual, http.StatusNotAcceptable)
+		})
+	})
+}
@@ -5,13 +5,31 @@ import (
 	"github.com/gin-gonic/gin"
 	"math"
 	"net/http"
+	"strings"
 	"user-check/configuration"
 	"user-check/model"
 	"user-check/utils"
 )
 
-// SuccessResponse return success response
+// SuccessResponse return success response in the format the caller accepts, the data alone on the v2 api.
+// Data that cannot be written in any accepted format is answered with 406. Cacheable answers carry validators
+// and are answered with 304 when the caller has them already.
 func SuccessResponse(c *gin.Context, id string, data interface{}) {
+	if c.GetBool(configuration.CacheableKey) && notModified(c, data) {
+		return
+	}
+	if !render(c, id, data) {
+		FailureResponse(c, nil, utils.HttpError{Code: http.StatusNotAcceptable,
+			Err: fmt.Errorf("the answer cannot be written as %s", strings.Join(acceptedFormats(c), " or "))})
+	}
+}
+
+// renderJson write the json success response
+func renderJson(c *gin.Context, id string, data interface{}) {
+	if V2(c) {
+		c.JSON(http.StatusOK, data)
+		return
+	}
 	c.JSON(http.StatusOK, model.JSONSuccessResult{
 		Code:          http.StatusOK,
 		Id:            id,
@@ -20,11 +38,15 @@ func SuccessResponse(c *gin.Context, id string, data interface{}) {
 	})
 }
 
-// FailureResponse return failure response
+// FailureResponse return failure response, problem details on the v2 api
 func FailureResponse(c *gin.Context, data interface{}, err utils.HttpError) {
 	if err.Err == nil {
 		err = utils.HttpError{Code: int(math.Max(float64(err.Code), 500)), Err: fmt.Errorf("FailureResponse was called with a nil error (%s)", err.Message)}
 	}
+	if V2(c) {
+		problem(c, data, err)
+		return
+	}
 	var errorString, stackString string
 	conf := configuration.AppConfig()
 	if conf.Development {
@@ -39,3 +61,26 @@ func FailureResponse(c *gin.Context, data interface{}, err utils.HttpError) {
 		Id: c.MustGet("correlation_id").(string),
 	})
 }
+
+// V2 tell whether the request is one of the v2 api
+func V2(c *gin.Context) bool {
+	return c.GetString(configuration.ApiVersionKey) == configuration.ApiV2
+}
+
+// problem answer err as RFC 7807 problem details, data as an extension member
+func problem(c *gin.Context, data interface{}, err utils.HttpError) {
+	var detail string
+	if configuration.AppConfig().Development {
+		detail = err.Error()
+	}
+	c.Header("Content-Type", model.ProblemContentType)
+	c.JSON(err.Code, model.Problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(err.Code),
+		Status:        err.Code,
+		Detail:        detail,
+		Instance:      c.Request.URL.Path,
+		CorrelationId: c.GetString(configuration.CorrelationIdKey),
+		Data:          data,
+	})
+}
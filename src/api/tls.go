@@ -0,0 +1,41 @@
+package api
+
+import (
+	"crypto/tls"
+	"user-check/certs"
+	"user-check/configuration"
+)
+
+// serverTLSConfig build the listener TLS config.
+// The key pair and the client CA bundle are served from reloaders so rotated files are picked up without restart.
+func serverTLSConfig(name string, conf *configuration.Configuration) (*tls.Config, error) {
+	keyPair, err := certs.NewKeyPair(name, conf.ApiCertCrtFile, conf.ApiCertKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: keyPair.GetCertificate,
+	}
+	if conf.ApiClientCaFile == "" {
+		return tlsConfig, nil
+	}
+
+	clientCAs, err := certs.CAPool("api_client_ca", conf.ApiClientCaFile)
+	if err != nil {
+		return nil, err
+	}
+	if conf.ApiClientOptional {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	} else {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	tlsConfig.ClientCAs = clientCAs.CertPool()
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		perConn := tlsConfig.Clone()
+		perConn.GetConfigForClient = nil
+		perConn.ClientCAs = clientCAs.CertPool()
+		return perConn, nil
+	}
+	return tlsConfig, nil
+}
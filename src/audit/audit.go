@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"user-check/utils/logger"
+)
+
+// Decisions recorded in the audit stream
+const (
+	DecisionMember    = "member"
+	DecisionNotMember = "not_member"
+	DecisionDenied    = "denied"
+	DecisionError     = "error"
+	// DecisionFound and DecisionNotFound record lookups that don't check a group, e.g. user profiles
+	DecisionFound    = "found"
+	DecisionNotFound = "not_found"
+	// DecisionUnauthenticated records credentials that were refused
+	DecisionUnauthenticated = "unauthenticated"
+
+	MembershipDirect = "direct"
+	MembershipNested = "nested"
+)
+
+// MembershipOf return how a member is in the group: MembershipNested when only through other groups
+func MembershipOf(nested bool) string {
+	if nested {
+		return MembershipNested
+	}
+	return MembershipDirect
+}
+
+// How isids are written to the audit stream
+const (
+	IsidPlain  = "plain"
+	IsidHash   = "hash"
+	IsidRedact = "redact"
+)
+
+// Record is a single membership decision
+type Record struct {
+	Time          time.Time `json:"ts"`
+	CorrelationId string    `json:"correlation_id"`
+	Caller        string    `json:"caller"`
+	CallerAuth    string    `json:"caller_auth,omitempty"`
+	Endpoint      string    `json:"endpoint"`
+	Isid          string    `json:"isid"`
+	Directory     string    `json:"directory,omitempty"`
+	Groups        []string  `json:"groups"`
+	Decision      string    `json:"decision"`
+	Membership    string    `json:"membership,omitempty"`
+	AccountState  string    `json:"account_state,omitempty"`
+	Source        string    `json:"source,omitempty"`
+	LatencyMs     float64   `json:"latency_ms"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Options configure the audit stream
+type Options struct {
+	// Output "stdout" or a file path, empty disables auditing
+	Output     string
+	MaxSizeMb  int
+	MaxBackups int
+	MaxAgeDays int
+	// IsidMode one of IsidPlain, IsidHash or IsidRedact
+	IsidMode string
+	// HashSalt prepended to isids before hashing
+	HashSalt string
+}
+
+var (
+	mu      sync.Mutex
+	out     io.Writer
+	encoder *json.Encoder
+	options Options
+)
+
+// Init open the audit stream
+func Init(opts Options) error {
+	switch opts.IsidMode {
+	case "", IsidPlain, IsidHash, IsidRedact:
+	default:
+		return fmt.Errorf("unknown audit isid mode %q, expected %s, %s or %s", opts.IsidMode, IsidPlain, IsidHash, IsidRedact)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if closer, ok := out.(io.Closer); ok && out != os.Stdout {
+		closer.Close()
+	}
+	options = opts
+	switch opts.Output {
+	case "":
+		out, encoder = nil, nil
+		return nil
+	case "stdout":
+		out = os.Stdout
+	default:
+		out = &lumberjack.Logger{
+			Filename:   opts.Output,
+			MaxSize:    opts.MaxSizeMb,
+			MaxBackups: opts.MaxBackups,
+			MaxAge:     opts.MaxAgeDays,
+			Compress:   true,
+		}
+	}
+	encoder = json.NewEncoder(out)
+	return nil
+}
+
+// Log write rec to the audit stream as a JSON line
+func Log(rec Record) {
+	mu.Lock()
+	defer mu.Unlock()
+	if encoder == nil {
+		return
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	rec.Isid = maskIsid(rec.Isid)
+	if rec.Groups == nil {
+		rec.Groups = []string{}
+	}
+	if err := encoder.Encode(rec); err != nil {
+		logger.SugaredLogger().With("package", "audit", "action", "write audit record").Errorf("unable to write audit record: %s", err)
+	}
+}
+
+// maskIsid apply the configured isid mode, options must be guarded by mu
+func maskIsid(isid string) string {
+	if isid == "" {
+		return isid
+	}
+	switch options.IsidMode {
+	case IsidHash:
+		sum := sha256.Sum256([]byte(options.HashSalt + isid))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	case IsidRedact:
+		return "[redacted]"
+	}
+	return isid
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readRecords read back the JSON lines written to file
+func readRecords(t *testing.T, file string) []map[string]interface{} {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var res []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rec := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, rec)
+	}
+	return res
+}
+
+func TestMembershipOf(t *testing.T) {
+	Convey(`Feature: members through other groups are audited as nested`, t, func() {
+		So(MembershipOf(false), ShouldEqual, MembershipDirect)
+		So(MembershipOf(true), ShouldEqual, MembershipNested)
+	})
+}
+
+func TestLog(t *testing.T) {
+	Convey(`Feature: audit stream of membership decisions`, t, func() {
+		file := filepath.Join(t.TempDir(), "audit.log")
+		rec := Record{
+			CorrelationId: "705e4dcb-3ecd-24f3-3a35-3e926e4bded5",
+			Caller:        "hr-portal",
+			Endpoint:      "usercheck",
+			Isid:          "bordeanu",
+			Groups:        []string{"group.users"},
+			Decision:      DecisionMember,
+			Membership:    MembershipDirect,
+			Source:        "live",
+		}
+		defer Init(Options{})
+
+		Convey("Records are written as JSON lines", func() {
+			So(Init(Options{Output: file, MaxSizeMb: 1}), ShouldBeNil)
+			Log(rec)
+			Log(rec)
+			records := readRecords(t, file)
+			So(records, ShouldHaveLength, 2)
+			So(records[0]["isid"], ShouldEqual, "bordeanu")
+			So(records[0]["decision"], ShouldEqual, DecisionMember)
+			So(records[0]["correlation_id"], ShouldEqual, rec.CorrelationId)
+		})
+		Convey("Isids can be hashed", func() {
+			So(Init(Options{Output: file, IsidMode: IsidHash, HashSalt: "pepper"}), ShouldBeNil)
+			Log(rec)
+			isid := readRecords(t, file)[0]["isid"].(string)
+			So(isid, ShouldStartWith, "sha256:")
+			So(isid, ShouldNotContainSubstring, "bordeanu")
+		})
+		Convey("Isids can be redacted", func() {
+			So(Init(Options{Output: file, IsidMode: IsidRedact}), ShouldBeNil)
+			Log(rec)
+			So(readRecords(t, file)[0]["isid"], ShouldEqual, "[redacted]")
+		})
+		Convey("Unknown isid modes are refused", func() {
+			So(Init(Options{Output: file, IsidMode: "rot13"}), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,193 @@
+package breaker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	"user-check/metrics"
+)
+
+// State of a circuit breaker
+type State int
+
+const (
+	// Closed requests flow, failures are counted
+	Closed State = iota
+	// HalfOpen a single probe request is let through to test the backend
+	HalfOpen
+	// Open requests fail fast until the probe interval elapses
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "unknown"
+}
+
+// ErrOpen the breaker refuses requests
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Breaker trips after a number of consecutive failures
+type Breaker struct {
+	name          string
+	threshold     int
+	probeInterval time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Breaker{}
+)
+
+// For return the breaker named name, creating it with the given settings on first use
+func For(name string, threshold int, probeInterval time.Duration) *Breaker {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if b, ok := registry[name]; ok {
+		return b
+	}
+	if threshold < 1 {
+		threshold = 1
+	}
+	b := &Breaker{name: name, threshold: threshold, probeInterval: probeInterval}
+	registry[name] = b
+	metrics.BreakerState.WithLabelValues(name).Set(float64(Closed))
+	return b
+}
+
+// Configure change the settings of every existing breaker, their state is kept
+func Configure(threshold int, probeInterval time.Duration) {
+	if threshold < 1 {
+		threshold = 1
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, b := range registry {
+		b.mu.Lock()
+		b.threshold = threshold
+		b.probeInterval = probeInterval
+		b.mu.Unlock()
+	}
+}
+
+// Forget drop the breaker named name, for a backend that is no longer used
+func Forget(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, name)
+	metrics.BreakerState.DeleteLabelValues(name)
+}
+
+// States return the state of every breaker by name
+func States() map[string]State {
+	registryMu.Lock()
+	breakers := make([]*Breaker, 0, len(registry))
+	for _, b := range registry {
+		breakers = append(breakers, b)
+	}
+	registryMu.Unlock()
+
+	res := map[string]State{}
+	for _, b := range breakers {
+		res[b.name] = b.State()
+	}
+	return res
+}
+
+// Ready tell whether at least one ldap server can be tried: no breaker yet, or one that is not open
+func Ready() bool {
+	states := States()
+	for _, state := range states {
+		if state != Open {
+			return true
+		}
+	}
+	return len(states) == 0
+}
+
+// Names return the names of all breakers, sorted
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	res := make([]string, 0, len(registry))
+	for name := range registry {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Allow check whether a request may go through. Once the probe interval of an open breaker
+// elapsed a single probe is allowed, its outcome closes or re-opens the breaker.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Closed:
+		return nil
+	case Open:
+		if time.Since(b.openedAt) < b.probeInterval {
+			return ErrOpen
+		}
+		b.transition(HalfOpen)
+		b.probing = true
+		return nil
+	default:
+		if b.probing {
+			return ErrOpen
+		}
+		b.probing = true
+		return nil
+	}
+}
+
+// Success record a successful request
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	if b.state != Closed {
+		b.transition(Closed)
+	}
+}
+
+// Failure record a failed request
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.transition(Open)
+	}
+}
+
+// State return the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// transition change state, b.mu must be held
+func (b *Breaker) transition(to State) {
+	b.state = to
+	metrics.BreakerState.WithLabelValues(b.name).Set(float64(to))
+	metrics.BreakerTransitions.WithLabelValues(b.name, to.String()).Inc()
+}
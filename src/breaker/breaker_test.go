@@ -0,0 +1,39 @@
+package breaker
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	Convey(`Feature: circuit breaker states`, t, func() {
+		name := fmt.Sprintf("ldaps://dc%d.example.com", time.Now().UnixNano())
+		b := For(name, 2, 20*time.Millisecond)
+		So(b.State(), ShouldEqual, Closed)
+
+		b.Failure()
+		So(b.Allow(), ShouldBeNil)
+		b.Failure()
+		So(b.State(), ShouldEqual, Open)
+		So(b.Allow(), ShouldEqual, ErrOpen)
+
+		time.Sleep(30 * time.Millisecond)
+		So(b.Allow(), ShouldBeNil)
+		So(b.State(), ShouldEqual, HalfOpen)
+		// a single probe at a time
+		So(b.Allow(), ShouldEqual, ErrOpen)
+
+		Convey("A failed probe re-opens the breaker", func() {
+			b.Failure()
+			So(b.State(), ShouldEqual, Open)
+			So(b.Allow(), ShouldEqual, ErrOpen)
+		})
+		Convey("A successful probe closes the breaker", func() {
+			b.Success()
+			So(b.State(), ShouldEqual, Closed)
+			So(States()[name], ShouldEqual, Closed)
+		})
+	})
+}
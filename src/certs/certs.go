@@ -0,0 +1,318 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+	"user-check/metrics"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// Reloadable is a certificate loaded from disk which can be re-read
+type Reloadable interface {
+	// Name identify the certificate in logs, status and metrics
+	Name() string
+	// Files the files the certificate is read from
+	Files() []string
+	// Reload re-read the files, keeping the previous certificate on failure
+	Reload() error
+	// NotAfter expiry of the certificate, the earliest one for bundles
+	NotAfter() time.Time
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []Reloadable
+	pools      = map[string]*Pool{}
+	// modTimes of the files of each certificate when last read, a file changed since is reloaded by Watch
+	modTimes = map[watchedFile]time.Time{}
+)
+
+// watchedFile is a file of a certificate, two certificates may be read from the same file
+type watchedFile struct {
+	r    Reloadable
+	file string
+}
+
+// register add r to the watched certificates, its files as they were when it was read being the baseline
+func register(r Reloadable, seen map[string]time.Time) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	track(r, seen)
+}
+
+// track add r to the watched certificates like register, registryMu must be held
+func track(r Reloadable, seen map[string]time.Time) {
+	registry = append(registry, r)
+	for file, modTime := range seen {
+		modTimes[watchedFile{r, file}] = modTime
+	}
+}
+
+// stat return the modification time of each file, the ones that can't be read are left out
+func stat(files []string) map[string]time.Time {
+	res := map[string]time.Time{}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			res[file] = info.ModTime()
+		}
+	}
+	return res
+}
+
+// changed tell whether a file of r was modified since it was last seen, recording the new modification times
+func changed(r Reloadable) bool {
+	res := false
+	for file, modTime := range stat(r.Files()) {
+		registryMu.Lock()
+		if last, ok := modTimes[watchedFile{r, file}]; ok && !modTime.Equal(last) {
+			res = true
+		}
+		modTimes[watchedFile{r, file}] = modTime
+		registryMu.Unlock()
+	}
+	return res
+}
+
+func registered() []Reloadable {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return append([]Reloadable{}, registry...)
+}
+
+// ReloadAll re-read every registered certificate
+func ReloadAll() {
+	log := logger.SugaredLogger().With("package", "certs", "action", "reload certificates")
+	for _, r := range registered() {
+		if err := r.Reload(); err != nil {
+			log.Errorf("keeping previous %s certificate: %s", r.Name(), err)
+		}
+	}
+}
+
+// Expiries return the expiry of every registered certificate by name
+func Expiries() map[string]time.Time {
+	res := map[string]time.Time{}
+	for _, r := range registered() {
+		res[r.Name()] = r.NotAfter()
+	}
+	return res
+}
+
+// Watch reload the registered certificates every time one of their files changes. The files are compared to
+// what they were when the certificate was read, so a change before the first tick or to a certificate registered
+// after Watch was called is not missed.
+func Watch(ctx context.Context, interval time.Duration) {
+	log := logger.SugaredLogger().With("package", "certs", "action", "watch certificate files")
+
+	concurrency.GlobalWaitGroup.Add(1)
+	go func() {
+		defer concurrency.GlobalWaitGroup.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, r := range registered() {
+				if !changed(r) {
+					continue
+				}
+				log.Infof("%s certificate changed on disk, reloading", r.Name())
+				if err := r.Reload(); err != nil {
+					log.Errorf("keeping previous %s certificate: %s", r.Name(), err)
+				}
+			}
+		}
+	}()
+}
+
+// observe record the outcome of a reload in the metrics
+func observe(r Reloadable, err error) {
+	if err != nil {
+		metrics.CertificateReloads.WithLabelValues(r.Name(), "failure").Inc()
+		return
+	}
+	metrics.CertificateReloads.WithLabelValues(r.Name(), "success").Inc()
+	metrics.CertificateExpiry.WithLabelValues(r.Name(), r.Files()[0]).Set(float64(r.NotAfter().Unix()))
+}
+
+// KeyPair a certificate and private key served by a TLS listener
+type KeyPair struct {
+	name     string
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	notAfter time.Time
+}
+
+// NewKeyPair load and register a certificate/key pair
+func NewKeyPair(name, certFile, keyFile string) (*KeyPair, error) {
+	kp := &KeyPair{name: name, certFile: certFile, keyFile: keyFile}
+	seen := stat(kp.Files())
+	if err := kp.Reload(); err != nil {
+		return nil, err
+	}
+	register(kp, seen)
+	return kp, nil
+}
+
+func (kp *KeyPair) Name() string {
+	return kp.name
+}
+
+func (kp *KeyPair) Files() []string {
+	return []string{kp.certFile, kp.keyFile}
+}
+
+func (kp *KeyPair) Reload() (err error) {
+	defer func() { observe(kp, err) }()
+
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading key pair %s/%s: %v", kp.certFile, kp.keyFile, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("error parsing certificate %s: %v", kp.certFile, err)
+	}
+	cert.Leaf = leaf
+
+	kp.mu.Lock()
+	kp.cert = &cert
+	kp.notAfter = leaf.NotAfter
+	kp.mu.Unlock()
+	return nil
+}
+
+func (kp *KeyPair) NotAfter() time.Time {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+	return kp.notAfter
+}
+
+// GetCertificate serve the current certificate, to be used in tls.Config
+func (kp *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+	return kp.cert, nil
+}
+
+// Pool a CA bundle, along with the system roots for servers that may present a publicly trusted certificate
+type Pool struct {
+	name   string
+	file   string
+	system bool
+
+	mu       sync.RWMutex
+	pool     *x509.CertPool
+	notAfter time.Time
+}
+
+// CAPool return the pool loaded from file, loading and registering it on first use
+func CAPool(name, file string) (*Pool, error) {
+	return caPool(name, file, false)
+}
+
+// SystemCAPool return the system roots and the certificates of file, loading and registering it on first use
+func SystemCAPool(name, file string) (*Pool, error) {
+	return caPool(name, file, true)
+}
+
+func caPool(name, file string, system bool) (*Pool, error) {
+	key := file
+	if system {
+		key = "system+" + file
+	}
+	registryMu.Lock()
+	p, ok := pools[key]
+	registryMu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	p = &Pool{name: name, file: file, system: system}
+	seen := stat(p.Files())
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if existing, ok := pools[key]; ok {
+		return existing, nil
+	}
+	pools[key] = p
+	track(p, seen)
+	return p, nil
+}
+
+func (p *Pool) Name() string {
+	return p.name
+}
+
+func (p *Pool) Files() []string {
+	return []string{p.file}
+}
+
+func (p *Pool) Reload() (err error) {
+	defer func() { observe(p, err) }()
+
+	data, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return fmt.Errorf("error reading CA bundle %s: %v", p.file, err)
+	}
+	pool := x509.NewCertPool()
+	if p.system {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return fmt.Errorf("error reading the system roots: %v", err)
+		}
+	}
+	var notAfter time.Time
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("error parsing CA bundle %s: %v", p.file, err)
+		}
+		pool.AddCert(cert)
+		if notAfter.IsZero() || cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	if notAfter.IsZero() {
+		return fmt.Errorf("no certificates found in CA bundle %s", p.file)
+	}
+
+	p.mu.Lock()
+	p.pool = pool
+	p.notAfter = notAfter
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Pool) NotAfter() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.notAfter
+}
+
+// CertPool return the current pool
+func (p *Pool) CertPool() *x509.CertPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pool
+}
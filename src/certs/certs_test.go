@@ -0,0 +1,152 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"user-check/utils/logger"
+)
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+// writeSelfSigned write a self-signed certificate and key expiring at notAfter
+func writeSelfSigned(t *testing.T, certFile, keyFile string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	// make sure the watcher sees a different modification time
+	os.Chtimes(certFile, time.Now(), notAfter)
+	os.Chtimes(keyFile, time.Now(), notAfter)
+}
+
+func TestKeyPairReload(t *testing.T) {
+	Convey(`Feature: rotated certificates are served without restart`, t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "private.key")
+		first := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+		writeSelfSigned(t, certFile, keyFile, first)
+
+		kp, err := NewKeyPair("api-test", certFile, keyFile)
+		So(err, ShouldBeNil)
+		So(kp.NotAfter().Equal(first), ShouldBeTrue)
+		Watch(ctx, 10*time.Millisecond)
+		time.Sleep(30 * time.Millisecond)
+
+		second := first.Add(30 * 24 * time.Hour)
+		writeSelfSigned(t, certFile, keyFile, second)
+		time.Sleep(50 * time.Millisecond)
+
+		cert, _ := kp.GetCertificate(nil)
+		So(cert.Leaf.NotAfter.Equal(second), ShouldBeTrue)
+		So(Expiries()["api-test"].Equal(second), ShouldBeTrue)
+
+		Convey("A broken file keeps the previous certificate", func() {
+			ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+			So(kp.Reload(), ShouldNotBeNil)
+			cert, _ := kp.GetCertificate(nil)
+			So(cert.Leaf.NotAfter.Equal(second), ShouldBeTrue)
+		})
+	})
+}
+
+func TestWatchBaseline(t *testing.T) {
+	Convey(`Feature: a change made right after Watch is called is not missed`, t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "private.key")
+		first := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+		writeSelfSigned(t, certFile, keyFile, first)
+		kp, err := NewKeyPair("baseline-test", certFile, keyFile)
+		So(err, ShouldBeNil)
+
+		Watch(ctx, 50*time.Millisecond)
+		// before the first tick
+		second := first.Add(30 * 24 * time.Hour)
+		writeSelfSigned(t, certFile, keyFile, second)
+		time.Sleep(150 * time.Millisecond)
+
+		cert, _ := kp.GetCertificate(nil)
+		So(cert.Leaf.NotAfter.Equal(second), ShouldBeTrue)
+	})
+}
+
+func TestWatchRegisteredLater(t *testing.T) {
+	Convey(`Feature: a certificate registered after Watch is called is watched from when it was read`, t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		Watch(ctx, 50*time.Millisecond)
+
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "private.key")
+		first := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+		writeSelfSigned(t, certFile, keyFile, first)
+		kp, err := NewKeyPair("registered-later-test", certFile, keyFile)
+		So(err, ShouldBeNil)
+		// before the next tick
+		second := first.Add(30 * 24 * time.Hour)
+		writeSelfSigned(t, certFile, keyFile, second)
+		time.Sleep(150 * time.Millisecond)
+
+		cert, _ := kp.GetCertificate(nil)
+		So(cert.Leaf.NotAfter.Equal(second), ShouldBeTrue)
+	})
+}
+
+func TestCAPool(t *testing.T) {
+	Convey(`Feature: CA bundles are shared and report their earliest expiry`, t, func() {
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "ca.crt")
+		notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+		writeSelfSigned(t, certFile, filepath.Join(dir, "ca.key"), notAfter)
+
+		p, err := CAPool("ldap_ca", certFile)
+		So(err, ShouldBeNil)
+		So(p.NotAfter().Equal(notAfter), ShouldBeTrue)
+		again, _ := CAPool("ldap_ca", certFile)
+		So(again, ShouldEqual, p)
+
+		_, err = CAPool("missing", filepath.Join(dir, "missing.crt"))
+		So(err, ShouldNotBeNil)
+
+		Convey("A pool with the system roots is kept apart", func() {
+			system, err := SystemCAPool("ldap_ca", certFile)
+			So(err, ShouldBeNil)
+			So(system, ShouldNotEqual, p)
+			So(system.NotAfter().Equal(notAfter), ShouldBeTrue)
+		})
+	})
+}
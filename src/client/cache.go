@@ -0,0 +1,72 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// cache keeps answers of the api by url for a ttl, then until the api tells they changed
+type cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]cacheEntry
+}
+
+// cacheEntry is a cached answer and when it expires
+type cacheEntry struct {
+	res     *response
+	expires time.Time
+}
+
+func newCache(ttl time.Duration, size int) *cache {
+	return &cache{ttl: ttl, size: size, entries: map[string]cacheEntry{}}
+}
+
+// get return the answer cached for key and whether it is still fresh. Expired answers are kept for
+// revalidation until room is needed.
+func (c *cache) get(key string) (*response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return e.res, time.Now().Before(e.expires)
+}
+
+// put cache res for key. When full, expired answers are dropped first, then the one expiring soonest.
+func (c *cache) put(key string, res *response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		var (
+			oldest  string
+			expires time.Time
+		)
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+				continue
+			}
+			if oldest == "" || e.expires.Before(expires) {
+				oldest, expires = k, e.expires
+			}
+		}
+		if len(c.entries) >= c.size {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = cacheEntry{res: res, expires: now.Add(c.ttl)}
+}
+
+// Purge drop every cached answer
+func (c *Client) Purge() {
+	if c.cache == nil {
+		return
+	}
+	c.cache.mu.Lock()
+	defer c.cache.mu.Unlock()
+	c.cache.entries = map[string]cacheEntry{}
+}
@@ -0,0 +1,435 @@
+// Package client is the Go client of the user-check REST api. Every endpoint has a typed method; failed
+// requests are retried with backoff on 5xx and 429 answers, the correlation id of the context is sent along,
+// and answers may be cached locally.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"user-check/model"
+)
+
+// Headers the api reads and answers
+const (
+	CorrelationIdHeader = "X-Correlation-Id"
+	DefaultCallerHeader = "X-Caller-Id"
+)
+
+// Retry defaults
+const (
+	DefaultRetries    = 3
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+// Client calls the user-check api. It is safe for concurrent use.
+type Client struct {
+	base         *url.URL
+	http         *http.Client
+	tlsConfig    *tls.Config
+	callerId     string
+	callerHeader string
+	retries      int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	cache        *cache
+}
+
+// Option configures a Client
+type Option func(*Client) error
+
+// New return a client of the api served at baseURL, e.g. https://user-check.example.com
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url %s: %w", baseURL, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base url %s: scheme must be http or https", baseURL)
+	}
+	c := &Client{
+		base:         base,
+		callerHeader: DefaultCallerHeader,
+		retries:      DefaultRetries,
+		backoff:      DefaultBackoff,
+		maxBackoff:   DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.http == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.tlsConfig
+		c.http = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	} else if c.tlsConfig != nil {
+		return nil, fmt.Errorf("TLS options cannot be combined with WithHTTPClient, set the transport TLS config instead")
+	}
+	return c, nil
+}
+
+// WithHTTPClient send the requests with hc instead of a client of the package
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) error {
+		c.http = hc
+		return nil
+	}
+}
+
+// WithTLSConfig use cfg for https connections
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) error {
+		c.tlsConfig = cfg.Clone()
+		return nil
+	}
+}
+
+// WithRootCAs verify the server certificate against the PEM certificates of caFile instead of the system roots
+func WithRootCAs(caFile string) Option {
+	return func(c *Client) error {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in CA file %s", caFile)
+		}
+		c.tls().RootCAs = pool
+		return nil
+	}
+}
+
+// WithClientCertificate present the certificate for mTLS, the api then identifies the caller by it
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(c *Client) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		c.tls().Certificates = []tls.Certificate{cert}
+		return nil
+	}
+}
+
+// WithCallerId identify the caller by header, for apis without mTLS
+func WithCallerId(id string) Option {
+	return func(c *Client) error {
+		c.callerId = id
+		return nil
+	}
+}
+
+// WithCallerHeader send the caller id in header, when the api CALLER_ID_HEADER is not the default
+func WithCallerHeader(header string) Option {
+	return func(c *Client) error {
+		if header == "" {
+			return fmt.Errorf("caller header cannot be empty")
+		}
+		c.callerHeader = header
+		return nil
+	}
+}
+
+// WithRetries retry a failed request up to retries times, waiting backoff then twice as long each time up to
+// maxBackoff. A Retry-After of the api is waited for instead when longer. Zero retries disables retrying.
+func WithRetries(retries int, backoff, maxBackoff time.Duration) Option {
+	return func(c *Client) error {
+		if retries < 0 || backoff <= 0 || maxBackoff < backoff {
+			return fmt.Errorf("invalid retries %d with backoff %s up to %s", retries, backoff, maxBackoff)
+		}
+		c.retries, c.backoff, c.maxBackoff = retries, backoff, maxBackoff
+		return nil
+	}
+}
+
+// WithCache keep successful answers of lookups for ttl, up to size of them
+func WithCache(ttl time.Duration, size int) Option {
+	return func(c *Client) error {
+		if ttl <= 0 || size <= 0 {
+			return fmt.Errorf("invalid cache ttl %s and size %d", ttl, size)
+		}
+		c.cache = newCache(ttl, size)
+		return nil
+	}
+}
+
+// tls return the TLS config the options fill in
+func (c *Client) tls() *tls.Config {
+	if c.tlsConfig == nil {
+		c.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return c.tlsConfig
+}
+
+// correlationIdKey holds the correlation id of a context
+type correlationIdKey struct{}
+
+// WithCorrelationId return a context whose requests carry the correlation id
+func WithCorrelationId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIdKey{}, id)
+}
+
+// CorrelationId return the correlation id requests with ctx carry. Besides WithCorrelationId, the
+// "correlation_id" value services built like this one set, e.g. on their gin context, is sent along.
+func CorrelationId(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationIdKey{}).(string); ok {
+		return id
+	}
+	id, _ := ctx.Value("correlation_id").(string)
+	return id
+}
+
+// Error is a failure answer of the api
+type Error struct {
+	StatusCode    int
+	CorrelationId string
+	// Message is only sent by apis in development mode
+	Message string
+	// RetryAfter is the wait the api asked for, with 429 and 503
+	RetryAfter time.Duration
+	// Data is the data of the failure answer, e.g. the result of a resolve that found nothing
+	Data json.RawMessage
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("user-check api answered %d: %s (correlation id %s)", e.StatusCode, msg, e.CorrelationId)
+}
+
+// StatusCode return the http status of the api failure err, 0 when err is not one
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// request is a call of the api
+type request struct {
+	method string
+	// path is escaped, from the base url on
+	path   string
+	query  url.Values
+	header http.Header
+	body   interface{}
+	// retry tells whether failures may be retried, false for calls that must not be repeated
+	retry bool
+	// cache tells whether the answer may be served from and kept in the cache
+	cache bool
+}
+
+// response is an answer of the api, read in full
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// do send req, retrying failures, and return the answer of the api. Answers of status 400 and above are
+// returned as an *Error along with the response.
+func (c *Client) do(ctx context.Context, req request) (*response, error) {
+	u := *c.base
+	u.RawPath = c.base.EscapedPath() + req.path
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = req.query.Encode()
+	target := u.String()
+
+	// an expired answer with an ETag is revalidated, the api answers 304 while it is unchanged
+	var stale *response
+	if req.cache && c.cache != nil {
+		cached, fresh := c.cache.get(target)
+		if fresh {
+			return cached, nil
+		}
+		if cached != nil && cached.header.Get("ETag") != "" {
+			stale = cached
+			req.header = req.header.Clone()
+			if req.header == nil {
+				req.header = http.Header{}
+			}
+			req.header.Set("If-None-Match", cached.header.Get("ETag"))
+		}
+	}
+
+	var payload []byte
+	if req.body != nil {
+		var err error
+		if payload, err = json.Marshal(req.body); err != nil {
+			return nil, fmt.Errorf("encoding request body: %w", err)
+		}
+	}
+
+	var (
+		res *response
+		err error
+	)
+	for attempt := 0; ; attempt++ {
+		res, err = c.send(ctx, req, target, payload)
+		if attempt >= c.retries || !req.retry || !retryable(res, err) || ctx.Err() != nil {
+			break
+		}
+		wait := c.wait(attempt, res)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stale != nil && res.status == http.StatusNotModified {
+		c.cache.put(target, stale)
+		return stale, nil
+	}
+	if res.status >= 400 {
+		return res, c.failure(res)
+	}
+	if req.cache && c.cache != nil && res.status == http.StatusOK {
+		c.cache.put(target, res)
+	}
+	return res, nil
+}
+
+// send make one attempt at req
+func (c *Client) send(ctx context.Context, req request, target string, payload []byte) (*response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if id := CorrelationId(ctx); id != "" {
+		httpReq.Header.Set(CorrelationIdHeader, id)
+	}
+	if c.callerId != "" {
+		httpReq.Header.Set(c.callerHeader, c.callerId)
+	}
+
+	httpRes, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+	data, err := ioutil.ReadAll(httpRes.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading answer: %w", err)
+	}
+	return &response{status: httpRes.StatusCode, header: httpRes.Header, body: data}, nil
+}
+
+// retryable tells whether an attempt failed in a way worth retrying: no answer, 429 or a server error
+func retryable(res *response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return res.status == http.StatusTooManyRequests ||
+		res.status >= 500 && res.status != http.StatusNotImplemented
+}
+
+// wait return how long to wait before retrying: exponential backoff with jitter, or the Retry-After of the
+// api when longer
+func (c *Client) wait(attempt int, res *response) time.Duration {
+	wait := c.backoff << uint(attempt)
+	if wait <= 0 || wait > c.maxBackoff {
+		wait = c.maxBackoff
+	}
+	wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	if res != nil {
+		if after := retryAfter(res.header); after > wait {
+			wait = after
+		}
+	}
+	return wait
+}
+
+// retryAfter return the Retry-After wait of an answer, in seconds or as a date
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// failure turn a failure answer into an *Error
+func (c *Client) failure(res *response) error {
+	apiErr := &Error{
+		StatusCode:    res.status,
+		CorrelationId: res.header.Get(CorrelationIdHeader),
+		RetryAfter:    retryAfter(res.header),
+	}
+	var result struct {
+		model.JSONFailureResult
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(res.body, &result) == nil {
+		apiErr.Message = result.Error
+		apiErr.Data = result.Data
+		if apiErr.CorrelationId == "" {
+			apiErr.CorrelationId = result.Id
+		}
+	}
+	return apiErr
+}
+
+// decode read the answer of an endpoint. Endpoints answering in a model.JSONSuccessResult envelope are
+// unwrapped into v, the others decoded as they are.
+func decode(res *response, enveloped bool, v interface{}) error {
+	if !enveloped {
+		if err := json.Unmarshal(res.body, v); err != nil {
+			return fmt.Errorf("decoding answer: %w", err)
+		}
+		return nil
+	}
+	var envelope struct {
+		model.JSONSuccessResult
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(res.body, &envelope); err != nil {
+		return fmt.Errorf("decoding answer: %w", err)
+	}
+	if len(envelope.Data) == 0 {
+		return fmt.Errorf("decoding answer: no data")
+	}
+	if err := json.Unmarshal(envelope.Data, v); err != nil {
+		return fmt.Errorf("decoding answer data: %w", err)
+	}
+	return nil
+}
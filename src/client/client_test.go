@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user-check/api"
+	"user-check/configuration"
+	"user-check/model"
+	"user-check/policy"
+	"user-check/utils/logger"
+)
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+// stub serve handler and return a client of it retrying quickly
+func stub(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, append([]Option{WithRetries(3, time.Millisecond, 10*time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRouter(t *testing.T) {
+	Convey(`Feature: the client against the api router`, t, func() {
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+		srv := httptest.NewServer(api.NewRouter())
+		defer srv.Close()
+		c, err := New(srv.URL+"/", WithRetries(1, time.Millisecond, time.Millisecond))
+		So(err, ShouldBeNil)
+		ctx := WithCorrelationId(context.Background(), "req-42")
+
+		Convey("Readiness follows the circuit breakers", func() {
+			ready, err := c.Ready(ctx)
+			So(err, ShouldBeNil)
+			So(ready.Ready, ShouldBeTrue)
+		})
+		Convey("Failures are typed errors carrying the correlation id", func() {
+			_, err := c.IsMember(ctx, "bordeanu", Lookup{By: "nickname"})
+			So(StatusCode(err), ShouldEqual, http.StatusBadRequest)
+			So(err.(*Error).CorrelationId, ShouldEqual, "req-42")
+
+			_, err = c.CheckUser(ctx, "bordeanu", Lookup{Directory: "nowhere"})
+			So(StatusCode(err), ShouldEqual, http.StatusNotFound)
+			_, err = c.Resolve(ctx, "mail", "", "")
+			So(StatusCode(err), ShouldEqual, http.StatusBadRequest)
+			_, err = c.Authenticate(ctx, "bordeanu", "", "")
+			So(StatusCode(err), ShouldEqual, http.StatusBadRequest)
+			_, err = c.SubjectAccessReview(ctx, model.SubjectAccessReview{}, "")
+			So(StatusCode(err), ShouldEqual, http.StatusBadRequest)
+			_, err = c.OpaBundle(ctx, "")
+			So(StatusCode(err), ShouldEqual, http.StatusNotFound)
+		})
+		Convey("The caller is identified by header for the caller policy", func() {
+			policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+				"hr-portal": {Groups: []string{"hr.*"}, Endpoints: []string{"usercheck"}},
+			}})
+			defer policy.Set(nil)
+			hr, err := New(srv.URL, WithCallerId("hr-portal"), WithRetries(0, time.Millisecond, time.Millisecond))
+			So(err, ShouldBeNil)
+
+			_, err = hr.UserProfile(ctx, "bordeanu", "", false)
+			So(StatusCode(err), ShouldEqual, http.StatusForbidden)
+			_, err = hr.Reload(ctx)
+			So(StatusCode(err), ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestRetries(t *testing.T) {
+	Convey(`Feature: retries with backoff`, t, func() {
+		var calls int32
+		ctx := context.Background()
+
+		Convey("5xx and 429 answers are retried until success", func() {
+			c := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				switch atomic.AddInt32(&calls, 1) {
+				case 1:
+					w.WriteHeader(http.StatusServiceUnavailable)
+				case 2:
+					w.WriteHeader(http.StatusTooManyRequests)
+				default:
+					w.Write([]byte(`{"code":200,"data":"true","id":"x"}`))
+				}
+			})
+			member, err := c.IsMember(ctx, "bordeanu", Lookup{})
+			So(err, ShouldBeNil)
+			So(member, ShouldBeTrue)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+		})
+		Convey("Retries give up with the last failure", func() {
+			c := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(http.StatusBadGateway)
+			})
+			_, err := c.CountUsers(ctx, "")
+			So(StatusCode(err), ShouldEqual, http.StatusBadGateway)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 4)
+		})
+		Convey("Client errors and authentications are not retried", func() {
+			c := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if r.Method == http.MethodPost {
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.WriteHeader(http.StatusBadRequest)
+			})
+			_, err := c.IsMember(ctx, "bordeanu", Lookup{})
+			So(StatusCode(err), ShouldEqual, http.StatusBadRequest)
+			_, err = c.Authenticate(ctx, "bordeanu", "secret", "")
+			So(StatusCode(err), ShouldEqual, http.StatusTooManyRequests)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		})
+		Convey("A canceled context stops retrying", func() {
+			c := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})
+			ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			_, err := c.IsMember(ctx, "bordeanu", Lookup{})
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRequests(t *testing.T) {
+	Convey(`Feature: request headers and caching`, t, func() {
+		var calls int32
+		var headers http.Header
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			headers = r.Header
+			w.Write([]byte(`{"code":200,"data":{"status":"true","directory":"default"},"id":"x"}`))
+		}
+
+		Convey("The correlation id and the caller id are sent along", func() {
+			c := stub(t, handler, WithCallerId("hr-portal"), WithCallerHeader("X-Client"))
+			ctx := context.WithValue(context.Background(), "correlation_id", "from-gin")
+			_, err := c.CheckUser(ctx, "bordeanu", Lookup{})
+			So(err, ShouldBeNil)
+			So(headers.Get(CorrelationIdHeader), ShouldEqual, "from-gin")
+			So(headers.Get("X-Client"), ShouldEqual, "hr-portal")
+
+			_, err = c.CheckUser(WithCorrelationId(ctx, "req-42"), "bordeanu", Lookup{})
+			So(err, ShouldBeNil)
+			So(headers.Get(CorrelationIdHeader), ShouldEqual, "req-42")
+		})
+		Convey("Lookups are served from the cache until they expire", func() {
+			c := stub(t, handler, WithCache(50*time.Millisecond, 10))
+			ctx := context.Background()
+			for i := 0; i < 3; i++ {
+				res, err := c.CheckUser(ctx, "bordeanu", Lookup{})
+				So(err, ShouldBeNil)
+				So(res.Status, ShouldEqual, "true")
+			}
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			_, _ = c.CheckUser(ctx, "martih", Lookup{})
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+
+			time.Sleep(60 * time.Millisecond)
+			_, _ = c.CheckUser(ctx, "bordeanu", Lookup{})
+			So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+			c.Purge()
+			_, _ = c.CheckUser(ctx, "bordeanu", Lookup{})
+			So(atomic.LoadInt32(&calls), ShouldEqual, 4)
+		})
+		Convey("Expired lookups with an ETag are revalidated", func() {
+			var revalidated int32
+			c := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `W/"v1"`)
+				if r.Header.Get("If-None-Match") == `W/"v1"` {
+					atomic.AddInt32(&revalidated, 1)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				handler(w, r)
+			}, WithCache(20*time.Millisecond, 10))
+			ctx := context.Background()
+			_, err := c.CheckUser(ctx, "bordeanu", Lookup{})
+			So(err, ShouldBeNil)
+			time.Sleep(30 * time.Millisecond)
+			res, err := c.CheckUser(ctx, "bordeanu", Lookup{})
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, "true")
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			So(atomic.LoadInt32(&revalidated), ShouldEqual, 1)
+			// the revalidated answer is fresh again
+			_, _ = c.CheckUser(ctx, "bordeanu", Lookup{})
+			So(atomic.LoadInt32(&revalidated), ShouldEqual, 1)
+		})
+		Convey("Invalid options are refused", func() {
+			_, err := New("ftp://example.com")
+			So(err, ShouldNotBeNil)
+			_, err = New("http://example.com", WithRetries(-1, time.Second, time.Second))
+			So(err, ShouldNotBeNil)
+			_, err = New("http://example.com", WithRootCAs("/nonexistent"))
+			So(err, ShouldNotBeNil)
+			_, err = New("http://example.com", WithHTTPClient(http.DefaultClient), WithTLSConfig(&tls.Config{}))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,366 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"user-check/model"
+)
+
+// AllDirectories selects every directory profile of the api
+const AllDirectories = "*"
+
+// Lookup tells how a user is looked up
+type Lookup struct {
+	// By is the identifier type: isid when empty, mail, upn, employee_id or proxy_address
+	By string
+	// Directory is the directory profile: the default one when empty, AllDirectories for every one
+	Directory string
+}
+
+// values return the query parameters of the lookup
+func (l Lookup) values() url.Values {
+	q := url.Values{}
+	if l.By != "" {
+		q.Set("by", l.By)
+	}
+	if l.Directory != "" {
+		q.Set("directory", l.Directory)
+	}
+	return q
+}
+
+// directoryQuery return the query selecting directory, none for the default one
+func directoryQuery(directory string) url.Values {
+	return Lookup{Directory: directory}.values()
+}
+
+// Status is the answer of the health check endpoint
+type Status struct {
+	LdapStatus        string
+	ProcessPid        int64
+	CertificateExpiry map[string]time.Time
+	Breakers          map[string]string
+	Directories       map[string]string
+}
+
+// Readiness is the answer of the readiness endpoint
+type Readiness struct {
+	Ready    bool
+	Breakers map[string]string
+}
+
+// Bundle is the OPA bundle of the group members
+type Bundle struct {
+	Archive []byte
+	ETag    string
+}
+
+// IsMember tells whether the user is a member of the group of the directory. Inactive accounts are not
+// members when the api has INACTIVE_NOT_MEMBER set.
+func (c *Client) IsMember(ctx context.Context, isid string, lookup Lookup) (bool, error) {
+	if lookup.Directory == AllDirectories {
+		res, err := c.CheckUser(ctx, isid, lookup)
+		if err != nil {
+			return false, err
+		}
+		return res.Status == "true", nil
+	}
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/usercheck/" + url.PathEscape(isid),
+		query: lookup.values(), retry: true, cache: true})
+	if err != nil {
+		return false, err
+	}
+	var status string
+	if err := decode(res, true, &status); err != nil {
+		return false, err
+	}
+	return status == "true", nil
+}
+
+// CheckUser check the membership of the user along with the directory that answered and the account state
+func (c *Client) CheckUser(ctx context.Context, isid string, lookup Lookup) (*model.UserCheckResult, error) {
+	q := lookup.values()
+	q.Set("verbose", "true")
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/usercheck/" + url.PathEscape(isid),
+		query: q, retry: true, cache: true})
+	if err != nil {
+		return nil, err
+	}
+	var result model.UserCheckResult
+	if err := decode(res, true, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CountUsers count the members of the group of the directory, or of every directory with AllDirectories
+func (c *Client) CountUsers(ctx context.Context, directory string) (*model.DirectoryCount, error) {
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/usercount",
+		query: directoryQuery(directory), retry: true, cache: true})
+	if err != nil {
+		return nil, err
+	}
+	if directory == AllDirectories {
+		var result model.DirectoryCount
+		if err := decode(res, true, &result); err != nil {
+			return nil, err
+		}
+		return &result, nil
+	}
+	var total int
+	if err := decode(res, true, &total); err != nil {
+		return nil, err
+	}
+	return &model.DirectoryCount{Total: total, Directories: map[string]int{directoryName(directory): total}}, nil
+}
+
+// CountBreakdown count the members like CountUsers, reading every member account to count active and
+// inactive members apart
+func (c *Client) CountBreakdown(ctx context.Context, directory string) (*model.DirectoryCount, error) {
+	q := directoryQuery(directory)
+	q.Set("breakdown", "true")
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/usercount", query: q, retry: true, cache: true})
+	if err != nil {
+		return nil, err
+	}
+	if directory == AllDirectories {
+		var result model.DirectoryCount
+		if err := decode(res, true, &result); err != nil {
+			return nil, err
+		}
+		return &result, nil
+	}
+	var count model.MemberCount
+	if err := decode(res, true, &count); err != nil {
+		return nil, err
+	}
+	name := directoryName(directory)
+	return &model.DirectoryCount{
+		Total:       count.Total,
+		Directories: map[string]int{name: count.Total},
+		Breakdown:   map[string]model.MemberCount{name: count},
+	}, nil
+}
+
+// Members list the user members of the group of the directory, or of every directory with AllDirectories,
+// leaving inactive accounts out with activeOnly
+func (c *Client) Members(ctx context.Context, directory string, activeOnly bool) ([]model.Member, error) {
+	q := directoryQuery(directory)
+	if activeOnly {
+		q.Set("active_only", "true")
+	}
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/members", query: q, retry: true, cache: true})
+	if err != nil {
+		return nil, err
+	}
+	var members []model.Member
+	if err := decode(res, true, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// directoryName return the name of the directory profile, the default one when empty
+func directoryName(directory string) string {
+	if directory == "" {
+		return "default"
+	}
+	return directory
+}
+
+// UserProfile return the profile of the user, limited to the attributes the api allows. With nested the
+// groups the user is a member of through nested groups are listed too. Unknown users fail with a 404 Error.
+func (c *Client) UserProfile(ctx context.Context, isid, directory string, nested bool) (*model.UserProfile, error) {
+	q := directoryQuery(directory)
+	if nested {
+		q.Set("nested", "true")
+	}
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/users/" + url.PathEscape(isid),
+		query: q, retry: true, cache: true})
+	if err != nil {
+		return nil, err
+	}
+	var profile model.UserProfile
+	if err := decode(res, true, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// Resolve return the isid of the user with the mail, upn, employee_id or proxy_address value. No match and
+// several matches are not errors, the Status of the result tells them apart.
+func (c *Client) Resolve(ctx context.Context, by, value, directory string) (*model.ResolveResult, error) {
+	q := directoryQuery(directory)
+	q.Set("by", by)
+	q.Set("value", value)
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/resolve", query: q, retry: true, cache: true})
+	var apiErr *Error
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusConflict) &&
+		len(apiErr.Data) > 0 {
+		var result model.ResolveResult
+		if json.Unmarshal(apiErr.Data, &result) == nil && result.Status != "" {
+			return &result, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result model.ResolveResult
+	if err := decode(res, true, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Authenticate verify the password of the user, then whether the user is a member of the group. Wrong
+// credentials are not an error, the result is not authenticated. Failures are not retried: every attempt
+// counts against the api failure backoff.
+func (c *Client) Authenticate(ctx context.Context, isid, password, directory string) (*model.AuthenticateResult, error) {
+	res, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/authenticate", query: directoryQuery(directory),
+		body: model.Authenticate{Isid: isid, Password: password}})
+	if StatusCode(err) == http.StatusUnauthorized {
+		return &model.AuthenticateResult{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result model.AuthenticateResult
+	if err := decode(res, true, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SubjectAccessReview ask the kubernetes authorization webhook for a decision on review, answered with its
+// status set
+func (c *Client) SubjectAccessReview(ctx context.Context, review model.SubjectAccessReview, directory string) (*model.SubjectAccessReview, error) {
+	if review.ApiVersion == "" {
+		review.ApiVersion = model.SubjectAccessReviewApiVersion
+	}
+	if review.Kind == "" {
+		review.Kind = model.SubjectAccessReviewKind
+	}
+	res, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/k8s/subjectaccessreview",
+		query: directoryQuery(directory), body: review, retry: true})
+	if err != nil {
+		return nil, err
+	}
+	var result model.SubjectAccessReview
+	if err := decode(res, false, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// OpaGroups return every group of the user, direct and nested, as OPA reads them. Unknown users are not
+// found, with no groups.
+func (c *Client) OpaGroups(ctx context.Context, isid, directory string) (*model.OpaUserGroups, error) {
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/opa/groups/" + url.PathEscape(isid),
+		query: directoryQuery(directory), retry: true, cache: true})
+	if err != nil {
+		return nil, err
+	}
+	var result model.OpaUserGroups
+	if err := decode(res, false, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// OpaBundle download the OPA bundle of the group members. With the ETag of the bundle the caller has, nil is
+// returned while the bundle is unchanged.
+func (c *Client) OpaBundle(ctx context.Context, etag string) (*Bundle, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/opa/bundle.tar.gz", header: header, retry: true})
+	if err != nil {
+		return nil, err
+	}
+	if res.status == http.StatusNotModified {
+		return nil, nil
+	}
+	return &Bundle{Archive: res.body, ETag: res.header.Get("ETag")}, nil
+}
+
+// Status return the health of the api and of its directories
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/status", retry: true})
+	if err != nil {
+		return nil, err
+	}
+	var status Status
+	if err := decode(res, true, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Ready tells whether the api is ready, i.e. not every ldap server circuit breaker is open. An api that is
+// not ready is not an error.
+func (c *Client) Ready(ctx context.Context) (*Readiness, error) {
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/ready"})
+	if StatusCode(err) == http.StatusServiceUnavailable {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var readiness Readiness
+	if err := decode(res, true, &readiness); err != nil {
+		return nil, err
+	}
+	return &readiness, nil
+}
+
+// Reload make the api re-read its configuration and return the settings that changed. An invalid
+// configuration is kept out, the error lists its problems.
+func (c *Client) Reload(ctx context.Context) ([]string, error) {
+	type reloadResult struct {
+		Changed  []string `json:"changed"`
+		Problems []string `json:"problems"`
+	}
+	res, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/admin/reload"})
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+		var result reloadResult
+		if json.Unmarshal(apiErr.Data, &result) == nil && len(result.Problems) > 0 {
+			return nil, fmt.Errorf("invalid configuration: %s: %w", strings.Join(result.Problems, "; "), err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result reloadResult
+	if err := decode(res, true, &result); err != nil {
+		return nil, err
+	}
+	return result.Changed, nil
+}
+
+// ReplayWebhooks make the api post the membership change events after sequence since again, to the receiver url or
+// to every webhook url when empty. Replays are not retried, a second one would post the events once more.
+func (c *Client) ReplayWebhooks(ctx context.Context, receiver string, since int64) (*model.WebhookReplay, error) {
+	q := url.Values{}
+	q.Set("since", strconv.FormatInt(since, 10))
+	if receiver != "" {
+		q.Set("url", receiver)
+	}
+	res, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/admin/webhooks/replay", query: q})
+	if err != nil {
+		return nil, err
+	}
+	var result model.WebhookReplay
+	if err := decode(res, true, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
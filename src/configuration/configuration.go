@@ -1,6 +1,12 @@
 package configuration
 
 import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"user-check/schema"
+	"user-check/secrets"
 	"user-check/utils"
 )
 
@@ -8,52 +14,425 @@ import (
 type Configuration struct {
 	Swagger CSwagger
 
-	HttpPort          int32
-	CleanupTimeoutSec int32
-	Development       bool
-	Tls               bool
-	GinLogger         bool
-	UseSwagger        bool
-	Initialized       bool
-	NpaUser           string
-	NpaPassword       string
-	LdapServerAddress string
-	LdapGroup         string
-	SearchPeople      string
-	OncoGroup         string
-	LdapCertFile      string
-	ApiCertCrtFile    string
-	ApiCertKeyFile    string
-}
-
-var appConfig Configuration
+	HttpPort           int32
+	CleanupTimeoutSec  int32
+	Development        bool
+	Tls                bool
+	GinLogger          bool
+	UseSwagger         bool
+	Initialized        bool
+	ConfigFile         string
+	NpaUser            string
+	NpaPassword        string
+	NpaPasswordFile    string
+	NpaPasswordCommand string
+	NpaPasswordEnv     string
+	NpaPasswordTtlSec  int32
+	LdapServerAddress  string
+	LdapGroup          string
+	SearchPeople       string
+	LdapGroupBase      string
+	LdapGroupContainer string
+	OncoGroup          string
+	// disabled, locked and expired accounts are answered as non-members
+	InactiveNotMember bool
+	NestedMembership  bool
+	// schema preset and overrides of its attributes
+	LdapSchema             string
+	LdapUserObjectClass    string
+	LdapLoginAttribute     string
+	LdapGroupObjectClass   string
+	LdapGroupNameAttribute string
+	LdapMemberAttribute    string
+	LdapMemberOfAttribute  string
+	LdapCertFile           string
+	ApiCertCrtFile         string
+	ApiCertKeyFile         string
+	ApiClientCaFile        string
+	CertReloadSec          int32
+	LdapTlsVerify          bool
+	ApiClientOptional      bool
+	ClientIdentityFile     string
+	CallerIdHeader         string
+	TrustedProxies         string
+	CallerIdHeaderTrusted  bool
+	PolicyFile             string
+	PolicyDryRun           bool
+	PolicyReloadSec        int32
+	RateLimitRps           int32
+	RateLimitBurst         int32
+	AuthMaxFailures        int32
+	AuthBackoffSec         int32
+	AuthBackoffMaxSec      int32
+	ForwardUserHeader      string
+	ForwardGroupsHeader    string
+	ForwardGroupsQuery     bool
+	ForwardCacheTtlSec     int32
+	ExtAuthzPort           int32
+	ExtAuthzUserHeader     string
+	ExtAuthzJwtClaim       string
+	GrpcPort               int32
+	GrpcReflection         bool
+	K8sAuthzFile           string
+	K8sAuthzUserPrefix     string
+	K8sAuthzUserBy         string
+	LdapMaxConcurrent      int32
+	LdapQueueTimeoutMs     int32
+	LdapTimeoutMs          int32
+	BreakerThreshold       int32
+	BreakerProbeSec        int32
+	CacheTtlSec            int32
+	CacheMaxEntries        int32
+	SnapshotMaxAgeSec      int32
+	AuditLog               string
+	AuditMaxSizeMb         int32
+	AuditMaxBackups        int32
+	AuditMaxAgeDays        int32
+	AuditIsidMode          string
+	AuditHashSalt          string
+	// attributes the user profile endpoint may return, comma separated
+	ProfileAttributes string
+	// OPA bundle: refresh interval, 0 disables it, and the groups published besides the configured ones, comma separated
+	OpaBundleRefreshSec int32
+	OpaBundleGroups     string
+	// membership change webhooks: snapshot interval, 0 disables them, receiver urls and groups watched besides the
+	// configured ones, comma separated, HMAC secret and the directory of the outbox surviving restarts
+	WebhookRefreshSec    int32
+	WebhookUrls          string
+	WebhookGroups        string
+	WebhookSecret        string
+	WebhookSecretFile    string
+	WebhookOutbox        string
+	WebhookRetention     int32
+	WebhookBackoffSec    int32
+	WebhookBackoffMaxSec int32
+	// named directories besides the default one, only set from the configuration file
+	Directories map[string]Directory
+}
+
+var (
+	// current holds the active *Configuration, swapped as a whole on reload
+	current  atomic.Value
+	initOnce sync.Once
+)
 
+// AppConfig return the active configuration.
+// Unless Load was called first it is made of the defaults overridden by env variables.
+// The returned value must not be modified once the service is running, a reload replaces it.
 func AppConfig() *Configuration {
-	if appConfig.Initialized == false {
-		loadEnvironmentVariables()
-		appConfig.Initialized = true
+	if c, ok := current.Load().(*Configuration); ok {
+		return c
+	}
+	initOnce.Do(func() {
+		c := &Configuration{}
+		loadDefaults(c)
+		loadEnvironmentVariables(c)
+		c.Initialized = true
+		current.CompareAndSwap(nil, c)
+	})
+	return current.Load().(*Configuration)
+}
+
+// Load build the configuration from, by increasing precedence, defaults, config file, env variables
+// and command line flags, then validate it. The active configuration is only replaced when valid.
+// The flags are remembered for Reload.
+func Load(flags *Flags) (*Configuration, error) {
+	c, err := build(flags)
+	if err != nil {
+		return nil, err
+	}
+	reloadMu.Lock()
+	loadedFlags = flags
+	reloadMu.Unlock()
+	current.Store(c)
+	return c, nil
+}
+
+// build assemble and validate a configuration without making it active
+func build(flags *Flags) (*Configuration, error) {
+	c := &Configuration{}
+	loadDefaults(c)
+	c.ConfigFile = utils.EnvOrDefault("CONFIG_FILE", "")
+	if flags != nil && flags.ConfigFile != "" {
+		c.ConfigFile = flags.ConfigFile
+	}
+	var directories map[string]fileDirectory
+	if c.ConfigFile != "" {
+		var err error
+		if directories, err = loadFile(c, c.ConfigFile); err != nil {
+			return nil, err
+		}
 	}
-	return &appConfig
+	problems := loadEnvironmentVariables(c)
+	if flags != nil {
+		flags.apply(c)
+	}
+	// directories inherit the top level settings with the environment and flags applied
+	c.Directories = c.directoriesFromFile(directories)
+	if err := c.validate(problems); err != nil {
+		return nil, err
+	}
+	if c.Development {
+		c.UseSwagger = true
+	}
+	c.Initialized = true
+	return c, nil
+}
+
+// loadDefaults set the built-in defaults
+func loadDefaults(c *Configuration) {
+	c.HttpPort = 8080
+	c.CleanupTimeoutSec = 60
+	c.LdapServerAddress = "ldaps://server.com:636"
+	c.NpaUser = "npa@domain.com"
+	c.NpaPassword = DefaultNpaPassword
+	c.NpaPasswordTtlSec = 300
+	c.SearchPeople = "OU=eCore Office,OU=People Accounts,DC=domain,DC=com"
+	c.LdapGroupBase = "CN=Groups,DC=domain,DC=com"
+	c.LdapGroupContainer = "CN=Security,CN=Groups,DC=domain,DC=com"
+	c.OncoGroup = "group.users"
+	c.LdapSchema = schema.ActiveDirectory
+	c.LdapCertFile = DefaultLdapCertFile
+	c.LdapTlsVerify = true
+	c.ApiCertCrtFile = "server.crt"
+	c.ApiCertKeyFile = "private.key"
+	c.CertReloadSec = 60
+	c.CallerIdHeader = "X-Caller-Id"
+	c.PolicyReloadSec = 30
+	c.AuthMaxFailures = 5
+	c.AuthBackoffSec = 1
+	c.AuthBackoffMaxSec = 900
+	c.ForwardUserHeader = "X-Forwarded-User"
+	c.ForwardCacheTtlSec = 60
+	c.K8sAuthzUserBy = "isid"
+	c.GrpcReflection = true
+	c.LdapMaxConcurrent = 20
+	c.LdapQueueTimeoutMs = 2000
+	c.LdapTimeoutMs = 5000
+	c.BreakerThreshold = 5
+	c.BreakerProbeSec = 30
+	c.CacheMaxEntries = 10000
+	c.SnapshotMaxAgeSec = 3600
+	c.AuditMaxSizeMb = 100
+	c.AuditMaxBackups = 10
+	c.AuditMaxAgeDays = 90
+	c.AuditIsidMode = "plain"
+	c.WebhookOutbox = "webhook-outbox"
+	c.WebhookRetention = 10000
+	c.WebhookBackoffSec = 1
+	c.WebhookBackoffMaxSec = 300
+	c.ProfileAttributes = "displayName,mail,givenName,sn,department,title,manager,groups"
 }
 
-// loadEnvironmentVariables load env variables
-func loadEnvironmentVariables() {
-	appConfig.CleanupTimeoutSec = utils.EnvOrDefaultInt32("SHUTDOWN_TIMEOUT", 300)
+// loadEnvironmentVariables load env variables, unset ones keep the current value
+// loadEnvironmentVariables override c with the env variables set, returning the ones whose value is not valid
+func loadEnvironmentVariables(c *Configuration) []string {
+	var problems []string
+	envInt32 := func(name string, def int32) int32 {
+		v, err := utils.EnvOrDefaultInt32(name, def)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		return v
+	}
+	envBool := func(name string, def bool) bool {
+		v, err := utils.EnvOrDefaultBool(name, def)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		return v
+	}
+	c.CleanupTimeoutSec = envInt32("SHUTDOWN_TIMEOUT", c.CleanupTimeoutSec)
 	// ldap server
-	appConfig.LdapServerAddress = utils.EnvOrDefault("LDAP_ADDR", "ldaps://server.com:636")
+	// several servers can be given, separated by commas, they are tried in order
+	c.LdapServerAddress = utils.EnvOrDefault("LDAP_ADDR", c.LdapServerAddress)
 	// NPA account info
 	// user
-	appConfig.NpaUser = utils.EnvOrDefault("NPA_USER", "npa@domain.com")
+	c.NpaUser = utils.EnvOrDefault("NPA_USER", c.NpaUser)
 	// password
 	// yes, i know how to use a vault and store password there, but this prj waay tooo simple
-	appConfig.NpaPassword = utils.EnvOrDefault("NPA_PASSWORD", "passwd")
+	c.NpaPassword = utils.EnvOrDefault("NPA_PASSWORD", c.NpaPassword)
+	// or read it from a file (Kubernetes/Docker secret), re-read when it changes
+	c.NpaPasswordFile = utils.EnvOrDefault("NPA_PASSWORD_FILE", c.NpaPasswordFile)
+	// or from the output of a command, cached for NPA_PASSWORD_COMMAND_TTL seconds
+	c.NpaPasswordCommand = utils.EnvOrDefault("NPA_PASSWORD_COMMAND", c.NpaPasswordCommand)
+	c.NpaPasswordTtlSec = envInt32("NPA_PASSWORD_COMMAND_TTL", c.NpaPasswordTtlSec)
+	// or from the env variable it names, read again on every bind
+	c.NpaPasswordEnv = utils.EnvOrDefault("NPA_PASSWORD_ENV", c.NpaPasswordEnv)
 	// search people
-	appConfig.SearchPeople = "OU=eCore Office,OU=People Accounts,DC=domain,DC=com"
+	c.SearchPeople = utils.EnvOrDefault("LDAP_SEARCH_BASE", c.SearchPeople)
+	// where groups are searched and the container of the security groups
+	c.LdapGroupBase = utils.EnvOrDefault("LDAP_GROUP_BASE", c.LdapGroupBase)
+	c.LdapGroupContainer = utils.EnvOrDefault("LDAP_GROUP_CONTAINER", c.LdapGroupContainer)
 	// onco group
-	appConfig.OncoGroup = utils.EnvOrDefault("USER_GROUP", "group.users")
+	c.OncoGroup = utils.EnvOrDefault("USER_GROUP", c.OncoGroup)
+	// answer disabled, locked or expired accounts as not in the group
+	c.InactiveNotMember = envBool("INACTIVE_NOT_MEMBER", c.InactiveNotMember)
+	// members of the group through other groups are members too
+	c.NestedMembership = envBool("NESTED_MEMBERSHIP", c.NestedMembership)
+	// how users and groups are stored: ad, openldap, openldap-memberof, openldap-posix or freeipa,
+	// single attributes of the preset can be overridden
+	c.LdapSchema = utils.EnvOrDefault("LDAP_SCHEMA", c.LdapSchema)
+	c.LdapUserObjectClass = utils.EnvOrDefault("LDAP_USER_OBJECT_CLASS", c.LdapUserObjectClass)
+	c.LdapLoginAttribute = utils.EnvOrDefault("LDAP_LOGIN_ATTRIBUTE", c.LdapLoginAttribute)
+	c.LdapGroupObjectClass = utils.EnvOrDefault("LDAP_GROUP_OBJECT_CLASS", c.LdapGroupObjectClass)
+	c.LdapGroupNameAttribute = utils.EnvOrDefault("LDAP_GROUP_NAME_ATTRIBUTE", c.LdapGroupNameAttribute)
+	c.LdapMemberAttribute = utils.EnvOrDefault("LDAP_MEMBER_ATTRIBUTE", c.LdapMemberAttribute)
+	c.LdapMemberOfAttribute = utils.EnvOrDefault("LDAP_MEMBEROF_ATTRIBUTE", c.LdapMemberOfAttribute)
 	// LDAP certificate file
-	appConfig.LdapCertFile = utils.EnvOrDefault("LDAP_CERT_FILE", "cert.crt")
+	c.LdapCertFile = utils.EnvOrDefault("LDAP_CERT_FILE", c.LdapCertFile)
+	// verify the ldap server certificate against the system roots and LDAP_CERT_FILE, on by default
+	c.LdapTlsVerify = envBool("LDAP_TLS_VERIFY", c.LdapTlsVerify)
 	// API SSL CRT file
-	appConfig.ApiCertCrtFile = utils.EnvOrDefault("API_CERT_CRT_FILE", "server.crt")
-	appConfig.ApiCertKeyFile = utils.EnvOrDefault("API_CERT_KEY_FILE", "private.key")
+	c.ApiCertCrtFile = utils.EnvOrDefault("API_CERT_CRT_FILE", c.ApiCertCrtFile)
+	c.ApiCertKeyFile = utils.EnvOrDefault("API_CERT_KEY_FILE", c.ApiCertKeyFile)
+	// how often (seconds) certificate files are checked for changes
+	c.CertReloadSec = envInt32("CERT_RELOAD_INTERVAL", c.CertReloadSec)
+	// mTLS, client certificates are verified against this CA bundle when set
+	c.ApiClientCaFile = utils.EnvOrDefault("API_CLIENT_CA_FILE", c.ApiClientCaFile)
+	c.ApiClientOptional = envBool("API_CLIENT_CERT_OPTIONAL", c.ApiClientOptional)
+	// subject/SAN patterns mapped to caller identities
+	c.ClientIdentityFile = utils.EnvOrDefault("CLIENT_IDENTITY_FILE", c.ClientIdentityFile)
+	// header carrying the authenticated caller identity, set by the fronting gateway
+	c.CallerIdHeader = utils.EnvOrDefault("CALLER_ID_HEADER", c.CallerIdHeader)
+	// reverse proxies whose X-Forwarded-For gives the client ip, ips or cidrs, none by default
+	c.TrustedProxies = utils.EnvOrDefault("TRUSTED_PROXIES", c.TrustedProxies)
+	// the caller header is believed from trusted proxies only, unless it is trusted from anyone
+	c.CallerIdHeaderTrusted = envBool("CALLER_ID_HEADER_TRUSTED", c.CallerIdHeaderTrusted)
+	// caller authorization policy, empty means every caller may query every group
+	c.PolicyFile = utils.EnvOrDefault("POLICY_FILE", c.PolicyFile)
+	c.PolicyDryRun = envBool("POLICY_DRY_RUN", c.PolicyDryRun)
+	c.PolicyReloadSec = envInt32("POLICY_RELOAD_INTERVAL", c.PolicyReloadSec)
+	// per certificate caller (or client ip) token bucket, 0 disables rate limiting
+	c.RateLimitRps = envInt32("RATE_LIMIT_RPS", c.RateLimitRps)
+	c.RateLimitBurst = envInt32("RATE_LIMIT_BURST", c.RateLimitBurst)
+	// failed authentications per user and per client ip before backing off, doubling from AUTH_BACKOFF_SEC
+	c.AuthMaxFailures = envInt32("AUTH_MAX_FAILURES", c.AuthMaxFailures)
+	c.AuthBackoffSec = envInt32("AUTH_BACKOFF_SEC", c.AuthBackoffSec)
+	c.AuthBackoffMaxSec = envInt32("AUTH_BACKOFF_MAX_SEC", c.AuthBackoffMaxSec)
+	// forward auth: headers the reverse proxy sets with the user and the required groups, decision cache ttl.
+	// The required groups are only read from the sources enabled here, which the proxy must control.
+	c.ForwardUserHeader = utils.EnvOrDefault("FORWARD_USER_HEADER", c.ForwardUserHeader)
+	c.ForwardGroupsHeader = utils.EnvOrDefault("FORWARD_GROUPS_HEADER", c.ForwardGroupsHeader)
+	c.ForwardGroupsQuery = envBool("FORWARD_GROUPS_QUERY", c.ForwardGroupsQuery)
+	c.ForwardCacheTtlSec = envInt32("FORWARD_CACHE_TTL", c.ForwardCacheTtlSec)
+	// Envoy ext_authz gRPC server, 0 disables it; the principal is a claim of the verified JWT or else the user header, set by Envoy
+	c.ExtAuthzPort = envInt32("EXTAUTHZ_PORT", c.ExtAuthzPort)
+	c.ExtAuthzUserHeader = utils.EnvOrDefault("EXTAUTHZ_USER_HEADER", c.ExtAuthzUserHeader)
+	c.ExtAuthzJwtClaim = utils.EnvOrDefault("EXTAUTHZ_JWT_CLAIM", c.ExtAuthzJwtClaim)
+	// gRPC api, 0 disables it; reflection lets grpcurl and the like list the services
+	c.GrpcPort = envInt32("GRPC_PORT", c.GrpcPort)
+	c.GrpcReflection = envBool("GRPC_REFLECTION", c.GrpcReflection)
+	// kubernetes authorization webhook: rules file, prefix the apiserver puts before user names and the identifier they are
+	c.K8sAuthzFile = utils.EnvOrDefault("K8S_AUTHZ_FILE", c.K8sAuthzFile)
+	c.K8sAuthzUserPrefix = utils.EnvOrDefault("K8S_AUTHZ_USER_PREFIX", c.K8sAuthzUserPrefix)
+	c.K8sAuthzUserBy = utils.EnvOrDefault("K8S_AUTHZ_USER_BY", c.K8sAuthzUserBy)
+	// concurrent ldap operations across all callers, 0 means unlimited
+	c.LdapMaxConcurrent = envInt32("LDAP_MAX_CONCURRENT", c.LdapMaxConcurrent)
+	c.LdapQueueTimeoutMs = envInt32("LDAP_QUEUE_TIMEOUT_MS", c.LdapQueueTimeoutMs)
+	// dial and operation timeout for a single ldap server
+	c.LdapTimeoutMs = envInt32("LDAP_TIMEOUT_MS", c.LdapTimeoutMs)
+	// per server circuit breaker, opens after this many consecutive failures
+	c.BreakerThreshold = envInt32("BREAKER_FAILURE_THRESHOLD", c.BreakerThreshold)
+	// seconds an open breaker waits before letting a probe through
+	c.BreakerProbeSec = envInt32("BREAKER_PROBE_INTERVAL", c.BreakerProbeSec)
+	// answers younger than this are served from cache, 0 disables the cache
+	c.CacheTtlSec = envInt32("CACHE_TTL", c.CacheTtlSec)
+	c.CacheMaxEntries = envInt32("CACHE_MAX_ENTRIES", c.CacheMaxEntries)
+	// while the directory is unavailable answers up to this old are served as snapshot, 0 disables the fallback
+	c.SnapshotMaxAgeSec = envInt32("SNAPSHOT_MAX_AGE", c.SnapshotMaxAgeSec)
+	// audit stream of membership decisions, "stdout" or a file path, empty disables it
+	c.AuditLog = utils.EnvOrDefault("AUDIT_LOG", c.AuditLog)
+	c.AuditMaxSizeMb = envInt32("AUDIT_MAX_SIZE_MB", c.AuditMaxSizeMb)
+	c.AuditMaxBackups = envInt32("AUDIT_MAX_BACKUPS", c.AuditMaxBackups)
+	c.AuditMaxAgeDays = envInt32("AUDIT_MAX_AGE_DAYS", c.AuditMaxAgeDays)
+	// plain, hash or redact
+	c.AuditIsidMode = utils.EnvOrDefault("AUDIT_ISID_MODE", c.AuditIsidMode)
+	c.AuditHashSalt = utils.EnvOrDefault("AUDIT_HASH_SALT", c.AuditHashSalt)
+	// allow-list of the attributes returned by the user profile endpoint, "groups" for the group list
+	c.ProfileAttributes = utils.EnvOrDefault("PROFILE_ATTRIBUTES", c.ProfileAttributes)
+	// OPA bundle of the group members, rebuilt every OPA_BUNDLE_REFRESH seconds, 0 disables it
+	c.OpaBundleRefreshSec = envInt32("OPA_BUNDLE_REFRESH", c.OpaBundleRefreshSec)
+	c.OpaBundleGroups = utils.EnvOrDefault("OPA_BUNDLE_GROUPS", c.OpaBundleGroups)
+	// join and leave events of the watched groups, found every WEBHOOK_REFRESH seconds, 0 disables them,
+	// signed with the secret and posted to every url, retried with a backoff doubling up to WEBHOOK_BACKOFF_MAX_SEC
+	c.WebhookRefreshSec = envInt32("WEBHOOK_REFRESH", c.WebhookRefreshSec)
+	c.WebhookUrls = utils.EnvOrDefault("WEBHOOK_URLS", c.WebhookUrls)
+	c.WebhookGroups = utils.EnvOrDefault("WEBHOOK_GROUPS", c.WebhookGroups)
+	c.WebhookSecret = utils.EnvOrDefault("WEBHOOK_SECRET", c.WebhookSecret)
+	c.WebhookSecretFile = utils.EnvOrDefault("WEBHOOK_SECRET_FILE", c.WebhookSecretFile)
+	// events are kept there until delivered, the last WEBHOOK_RETENTION of them for replays
+	c.WebhookOutbox = utils.EnvOrDefault("WEBHOOK_OUTBOX", c.WebhookOutbox)
+	c.WebhookRetention = envInt32("WEBHOOK_RETENTION", c.WebhookRetention)
+	c.WebhookBackoffSec = envInt32("WEBHOOK_BACKOFF_SEC", c.WebhookBackoffSec)
+	c.WebhookBackoffMaxSec = envInt32("WEBHOOK_BACKOFF_MAX_SEC", c.WebhookBackoffMaxSec)
+	return problems
+}
+
+// NpaPasswordSecret return the provider of the npa password: file, command, env variable or plain value, in this order
+func (c *Configuration) NpaPasswordSecret() secrets.Provider {
+	d, _ := c.Directory(DefaultDirectory)
+	return d.NpaPasswordSecret()
+}
+
+// OpaBundleGroupList return the groups published in the OPA bundle besides the configured group of each directory
+func (c *Configuration) OpaBundleGroupList() []string {
+	return list(c.OpaBundleGroups)
+}
+
+// TrustedProxyList return the ips and cidrs of the reverse proxies trusted with the client ip
+func (c *Configuration) TrustedProxyList() []string {
+	return list(c.TrustedProxies)
+}
+
+// TrustedProxy tell whether ip is one of the trusted proxies
+func (c *Configuration) TrustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range c.TrustedProxyList() {
+		if _, cidr, err := net.ParseCIDR(proxy); err == nil && cidr.Contains(addr) || addr.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CallerHeaderTrusted tell whether the caller header of a request coming from ip names its caller
+func (c *Configuration) CallerHeaderTrusted(ip string) bool {
+	return c.CallerIdHeaderTrusted || c.TrustedProxy(ip)
+}
+
+// WebhookUrlList return the urls membership change events are posted to
+func (c *Configuration) WebhookUrlList() []string {
+	return list(c.WebhookUrls)
+}
+
+// WebhookGroupList return the groups whose membership changes are posted besides the configured group of each directory
+func (c *Configuration) WebhookGroupList() []string {
+	return list(c.WebhookGroups)
+}
+
+// WebhookSecretProvider return the provider of the secret webhook events are signed with: file or plain value
+func (c *Configuration) WebhookSecretProvider() secrets.Provider {
+	return secrets.For(secrets.Spec{File: c.WebhookSecretFile, Value: c.WebhookSecret})
+}
+
+// list split a comma separated setting, leaving blanks out
+func list(setting string) []string {
+	var items []string
+	for _, item := range strings.Split(setting, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ProfileAttributeList return the allow-list of the user profile endpoint
+func (c *Configuration) ProfileAttributeList() []string {
+	return strings.FieldsFunc(c.ProfileAttributes, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
 }
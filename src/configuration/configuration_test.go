@@ -0,0 +1,362 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"user-check/utils/logger"
+)
+
+const testYaml = `
+server:
+  port: 9000
+  shutdown_timeout: 120
+ldap:
+  address: ldaps://dc1.example.com:636,ldaps://dc2.example.com:636
+  bind_password: s3cret
+  user_base_dn: OU=People,DC=example,DC=com
+groups:
+  default: licensed.users
+`
+
+const testToml = `
+[server]
+port = 9001
+
+[ldap]
+schema = "openldap"
+memberof_attribute = "memberOf"
+
+[groups]
+default = "toml.users"
+`
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	Convey(`Feature: flags beat env, env beats file, file beats defaults`, t, func() {
+		file := writeConfig(t, "config.yaml", testYaml)
+		os.Setenv("USER_GROUP", "env.users")
+		os.Setenv("SHUTDOWN_TIMEOUT", "300")
+		defer os.Unsetenv("USER_GROUP")
+		defer os.Unsetenv("SHUTDOWN_TIMEOUT")
+
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags := BindFlags(fs)
+		So(fs.Parse([]string{"--config", file, "--timeout", "30"}), ShouldBeNil)
+
+		c, err := Load(flags)
+		So(err, ShouldBeNil)
+		// file beats defaults
+		So(c.HttpPort, ShouldEqual, 9000)
+		So(c.SearchPeople, ShouldEqual, "OU=People,DC=example,DC=com")
+		// defaults are kept for settings missing from the file
+		So(c.LdapGroupBase, ShouldEqual, "CN=Groups,DC=domain,DC=com")
+		// env beats file
+		So(c.OncoGroup, ShouldEqual, "env.users")
+		// flags beat env
+		So(c.CleanupTimeoutSec, ShouldEqual, 30)
+		So(AppConfig().OncoGroup, ShouldEqual, "env.users")
+	})
+}
+
+func TestLoadToml(t *testing.T) {
+	Convey(`Feature: TOML configuration files`, t, func() {
+		os.Setenv("CONFIG_FILE", writeConfig(t, "config.toml", testToml))
+		defer os.Unsetenv("CONFIG_FILE")
+
+		c, err := Load(nil)
+		So(err, ShouldBeNil)
+		So(c.HttpPort, ShouldEqual, 9001)
+		So(c.OncoGroup, ShouldEqual, "toml.users")
+		So(c.LdapSchema, ShouldEqual, "openldap")
+		So(c.LdapMemberOfAttribute, ShouldEqual, "memberOf")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	Convey(`Feature: every configuration problem is reported at once`, t, func() {
+		file := writeConfig(t, "config.yaml", `
+server:
+  port: 70000
+ldap:
+  address: http://dc1.example.com
+audit:
+  isid_mode: rot13
+`)
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags := BindFlags(fs)
+		So(fs.Parse([]string{"-c", file}), ShouldBeNil)
+
+		_, err := Load(flags)
+		So(err, ShouldHaveSameTypeAs, &ValidationError{})
+		problems := err.(*ValidationError).Problems
+		So(problems, ShouldHaveLength, 3)
+		So(err.Error(), ShouldContainSubstring, "server.port")
+		So(err.Error(), ShouldContainSubstring, "ldap.address")
+		So(err.Error(), ShouldContainSubstring, "audit.isid_mode")
+	})
+	Convey(`Feature: unknown settings are refused`, t, func() {
+		_, err := Load(nil)
+		So(err, ShouldBeNil)
+		os.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", "ldap:\n  adress: ldaps://typo\n"))
+		defer os.Unsetenv("CONFIG_FILE")
+		_, err = Load(nil)
+		So(err, ShouldNotBeNil)
+	})
+	Convey(`Feature: the profile allow-list can't return credentials`, t, func() {
+		os.Setenv("PROFILE_ATTRIBUTES", "mail,unicodePwd,*")
+		defer os.Unsetenv("PROFILE_ATTRIBUTES")
+		_, err := Load(nil)
+		So(err, ShouldHaveSameTypeAs, &ValidationError{})
+		So(err.(*ValidationError).Problems, ShouldHaveLength, 2)
+		So(err.Error(), ShouldContainSubstring, "unicodePwd")
+	})
+	Convey(`Feature: enabled webhooks need urls and a secret`, t, func() {
+		os.Setenv("WEBHOOK_REFRESH", "60")
+		os.Setenv("WEBHOOK_URLS", "https://provisioning.example.com/hooks, ftp://example.com")
+		defer os.Unsetenv("WEBHOOK_REFRESH")
+		defer os.Unsetenv("WEBHOOK_URLS")
+		_, err := Load(nil)
+		So(err, ShouldHaveSameTypeAs, &ValidationError{})
+		So(err.(*ValidationError).Problems, ShouldHaveLength, 2)
+		So(err.Error(), ShouldContainSubstring, "webhook.secret")
+		So(err.Error(), ShouldContainSubstring, "ftp://example.com")
+	})
+	Convey(`Feature: env values that don't parse are reported like file values`, t, func() {
+		os.Setenv("CACHE_TTL", "5m")
+		os.Setenv("INACTIVE_NOT_MEMBER", "yes please")
+		defer os.Unsetenv("CACHE_TTL")
+		defer os.Unsetenv("INACTIVE_NOT_MEMBER")
+		_, err := Load(nil)
+		So(err, ShouldHaveSameTypeAs, &ValidationError{})
+		So(err.(*ValidationError).Problems, ShouldHaveLength, 2)
+		So(err.Error(), ShouldContainSubstring, `CACHE_TTL: "5m"`)
+		So(err.Error(), ShouldContainSubstring, `INACTIVE_NOT_MEMBER: "yes please"`)
+	})
+}
+
+func TestValidateK8sAuthz(t *testing.T) {
+	Convey(`Feature: kubernetes user names are mapped by a known identifier`, t, func() {
+		os.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", "k8s_authz:\n  user_by: sAMAccountName\n"))
+		defer os.Unsetenv("CONFIG_FILE")
+		_, err := Load(nil)
+		So(err, ShouldHaveSameTypeAs, &ValidationError{})
+		So(err.(*ValidationError).Problems, ShouldHaveLength, 1)
+		So(err.Error(), ShouldContainSubstring, "k8s_authz.user_by")
+
+		os.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", "k8s_authz:\n  user_by: upn\n"))
+		_, err = Load(nil)
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestRedacted(t *testing.T) {
+	Convey(`Feature: printed configuration has no secrets`, t, func() {
+		c := &Configuration{}
+		loadDefaults(c)
+		c.NpaPassword = "s3cret"
+		c.WebhookSecret = "hook-s3cret"
+		out, err := c.Redacted()
+		So(err, ShouldBeNil)
+		So(out, ShouldNotContainSubstring, "s3cret")
+		So(out, ShouldContainSubstring, "bind_password: <redacted>")
+	})
+}
+
+const testDirectories = `
+ldap:
+  address: ldaps://dc1.example.com:636
+  bind_user: npa@example.com
+  bind_password: s3cret
+  user_base_dn: OU=People,DC=example,DC=com
+groups:
+  default: licensed.users
+directories:
+  forest-b:
+    address: ldaps://dc1.b.example.com:636
+    bind_user: npa@b.example.com
+    bind_password: b-s3cret
+    user_base_dn: OU=People,DC=b,DC=example,DC=com
+  contractors:
+    address: ldap://ldap.contractors.example.com:389
+    group: contractors
+    tls_verify: false
+    schema: openldap-posix
+`
+
+func TestDirectories(t *testing.T) {
+	Convey(`Feature: named directory profiles`, t, func() {
+		os.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", testDirectories))
+		defer os.Unsetenv("CONFIG_FILE")
+		c, err := Load(nil)
+		So(err, ShouldBeNil)
+
+		So(c.DirectoryNames(), ShouldResemble, []string{DefaultDirectory, "contractors", "forest-b"})
+		Convey(`the default directory is the top level settings`, func() {
+			d, ok := c.Directory(DefaultDirectory)
+			So(ok, ShouldBeTrue)
+			So(d.LdapServerAddress, ShouldEqual, "ldaps://dc1.example.com:636")
+			So(d.OncoGroup, ShouldEqual, "licensed.users")
+		})
+		Convey(`settings left out are taken from the ldap and groups sections`, func() {
+			d, ok := c.Directory("contractors")
+			So(ok, ShouldBeTrue)
+			So(d.OncoGroup, ShouldEqual, "contractors")
+			So(d.NpaUser, ShouldEqual, "npa@example.com")
+			So(d.NpaPassword, ShouldEqual, "s3cret")
+			So(d.SearchPeople, ShouldEqual, "OU=People,DC=example,DC=com")
+			d, _ = c.Directory("forest-b")
+			So(d.NpaPassword, ShouldEqual, "b-s3cret")
+			So(d.OncoGroup, ShouldEqual, "licensed.users")
+		})
+		Convey(`settings left out are inherited with the environment applied`, func() {
+			os.Setenv("NPA_PASSWORD", "env-s3cret")
+			os.Setenv("LDAP_SCHEMA", "freeipa")
+			defer os.Unsetenv("NPA_PASSWORD")
+			defer os.Unsetenv("LDAP_SCHEMA")
+			c, err := Load(nil)
+			So(err, ShouldBeNil)
+			d, _ := c.Directory("forest-b")
+			So(d.NpaPassword, ShouldEqual, "b-s3cret")
+			So(d.Schema, ShouldEqual, "freeipa")
+			d, _ = c.Directory("contractors")
+			So(d.NpaPassword, ShouldEqual, "env-s3cret")
+			So(d.Schema, ShouldEqual, "openldap-posix")
+		})
+		Convey(`the password is read from the env variable the configuration names`, func() {
+			os.Setenv("NPA_PASSWORD_ENV", "TEST_NPA_PASSWORD")
+			os.Setenv("TEST_NPA_PASSWORD", "from-env")
+			defer os.Unsetenv("NPA_PASSWORD_ENV")
+			defer os.Unsetenv("TEST_NPA_PASSWORD")
+			c, err := Load(nil)
+			So(err, ShouldBeNil)
+			password, err := c.NpaPasswordSecret().Secret(context.Background())
+			So(err, ShouldBeNil)
+			So(password, ShouldEqual, "from-env")
+			d, _ := c.Directory("contractors")
+			password, err = d.NpaPasswordSecret().Secret(context.Background())
+			So(err, ShouldBeNil)
+			So(password, ShouldEqual, "from-env")
+			// a directory with a password of its own does not inherit the source
+			d, _ = c.Directory("forest-b")
+			password, _ = d.NpaPasswordSecret().Secret(context.Background())
+			So(password, ShouldEqual, "b-s3cret")
+		})
+		Convey(`directories have their own schema`, func() {
+			d, _ := c.Directory("contractors")
+			s, err := d.LdapSchema()
+			So(err, ShouldBeNil)
+			So(s.MemberAttribute, ShouldEqual, "memberUid")
+			d, _ = c.Directory("forest-b")
+			s, err = d.LdapSchema()
+			So(err, ShouldBeNil)
+			So(s.LoginAttribute, ShouldEqual, "sAMAccountName")
+		})
+		Convey(`unknown directories are not found`, func() {
+			_, ok := c.Directory("forest-c")
+			So(ok, ShouldBeFalse)
+		})
+		Convey(`directory passwords are redacted`, func() {
+			out, err := c.Redacted()
+			So(err, ShouldBeNil)
+			So(out, ShouldNotContainSubstring, "b-s3cret")
+			So(out, ShouldContainSubstring, "forest-b:")
+		})
+		Convey(`directories are validated`, func() {
+			os.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", testDirectories+"  default:\n    address: http://dc1\n"))
+			_, err := Load(nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `directories.default: "default" can't be used as a directory name`)
+			So(err.Error(), ShouldContainSubstring, "directories.default.address")
+		})
+		Convey(`directory schemas are validated`, func() {
+			os.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", testDirectories+"  forest-c:\n    member_attribute: members\n"))
+			_, err := Load(nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `directories.forest-c.schema: member attribute "members"`)
+		})
+	})
+}
+
+func TestReload(t *testing.T) {
+	Convey(`Feature: reload swaps the configuration only when it is valid`, t, func() {
+		file := writeConfig(t, "config.yaml", testYaml)
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags := BindFlags(fs)
+		So(fs.Parse([]string{"-c", file}), ShouldBeNil)
+		_, err := Load(flags)
+		So(err, ShouldBeNil)
+
+		Convey(`a changed group and password are reported, the password redacted`, func() {
+			So(ioutil.WriteFile(file, []byte(`
+server:
+  port: 9100
+ldap:
+  bind_password: n3w
+groups:
+  default: other.users
+`), 0600), ShouldBeNil)
+			changes, err := Reload()
+			So(err, ShouldBeNil)
+			So(changes, ShouldContain, `OncoGroup: "licensed.users" -> "other.users"`)
+			So(changes, ShouldContain, "NpaPassword: <redacted>")
+			So(changes, ShouldContain, `HttpPort: "9000" -> "9100" (restart required)`)
+			So(AppConfig().OncoGroup, ShouldEqual, "other.users")
+			// the listener keeps its port until restart
+			So(AppConfig().HttpPort, ShouldEqual, 9000)
+		})
+
+		Convey(`an invalid file keeps the current configuration`, func() {
+			So(ioutil.WriteFile(file, []byte("ldap:\n  address: http://dc1\n"), 0600), ShouldBeNil)
+			_, err := Reload()
+			So(err, ShouldNotBeNil)
+			So(AppConfig().OncoGroup, ShouldEqual, "licensed.users")
+		})
+
+		Convey(`an env value that doesn't parse keeps the current configuration`, func() {
+			os.Setenv("BREAKER_FAILURE_THRESHOLD", "five")
+			defer os.Unsetenv("BREAKER_FAILURE_THRESHOLD")
+			_, err := Reload()
+			So(err, ShouldHaveSameTypeAs, &ValidationError{})
+			So(err.Error(), ShouldContainSubstring, "BREAKER_FAILURE_THRESHOLD")
+			So(AppConfig().OncoGroup, ShouldEqual, "licensed.users")
+		})
+
+		Convey(`a hook refusing the change keeps the current configuration`, func() {
+			applied := false
+			registered := reloadHooks
+			t.Cleanup(func() {
+				reloadMu.Lock()
+				defer reloadMu.Unlock()
+				reloadHooks = registered
+			})
+			OnReload(func(old, next *Configuration) (func(), error) {
+				if next.OncoGroup == "refused.users" {
+					return nil, fmt.Errorf("refused")
+				}
+				return func() { applied = true }, nil
+			})
+			So(ioutil.WriteFile(file, []byte("groups:\n  default: refused.users\n"), 0600), ShouldBeNil)
+			_, err := Reload()
+			So(err, ShouldNotBeNil)
+			So(applied, ShouldBeFalse)
+			So(AppConfig().OncoGroup, ShouldEqual, "licensed.users")
+		})
+	})
+}
@@ -3,7 +3,30 @@ package configuration
 // Server related constants
 const (
 	CorrelationIdKey = "correlation_id"
+	CallerIdKey = "caller_id"
+	AnonymousCaller = "anonymous"
+	CallerAuthKey = "caller_auth"
+	CallerAuthMtls = "mtls"
+	CallerAuthHeader = "header"
+	CallerAuthNone = "none"
+	// DirectoriesKey holds the directories a request is going to query
+	DirectoriesKey = "directories"
+	// GroupsKey holds the groups a request is going to check when they are not the configured ones
+	GroupsKey = "groups"
+	// FanOutKey is set when the request asked for every directory
+	FanOutKey = "fan_out"
+	// ApiVersionKey holds the api version of the request, v2 answers bare models and problem details
+	ApiVersionKey = "api_version"
+	ApiV2 = "v2"
+	// FormatsKey holds the answer formats the caller accepts, by preference
+	FormatsKey = "formats"
+	// CacheableKey is set when the answer carries an ETag and may be cached
+	CacheableKey = "cacheable"
 	LdapUp = "up"
 	LdapDown = "down"
+	// DefaultNpaPassword placeholder password, refused in production mode
+	DefaultNpaPassword = "passwd"
+	// DefaultLdapCertFile ldap CA bundle trusted along with the system roots when it exists
+	DefaultLdapCertFile = "cert.crt"
 )
 
@@ -0,0 +1,103 @@
+package configuration
+
+import (
+	"sort"
+	"time"
+	"user-check/schema"
+	"user-check/secrets"
+)
+
+const (
+	// DefaultDirectory is the directory described by the top level ldap and groups settings
+	DefaultDirectory = "default"
+	// AllDirectories selects every directory, the request is fanned out
+	AllDirectories = "*"
+)
+
+// Directory is a named directory profile: its own servers, bind account, base DNs and group
+type Directory struct {
+	Name               string
+	LdapServerAddress  string
+	NpaUser            string
+	NpaPassword        string
+	NpaPasswordFile    string
+	NpaPasswordCommand string
+	NpaPasswordEnv     string
+	NpaPasswordTtlSec  int32
+	SearchPeople       string
+	LdapGroupBase      string
+	LdapGroupContainer string
+	OncoGroup          string
+	LdapCertFile       string
+	LdapTlsVerify      bool
+	// Schema is the preset, SchemaOverrides the attributes set explicitly
+	Schema          string
+	SchemaOverrides schema.Schema
+}
+
+// Directory return the directory profile called name
+func (c *Configuration) Directory(name string) (Directory, bool) {
+	if name == DefaultDirectory {
+		return Directory{
+			Name:               DefaultDirectory,
+			LdapServerAddress:  c.LdapServerAddress,
+			NpaUser:            c.NpaUser,
+			NpaPassword:        c.NpaPassword,
+			NpaPasswordFile:    c.NpaPasswordFile,
+			NpaPasswordCommand: c.NpaPasswordCommand,
+			NpaPasswordEnv:     c.NpaPasswordEnv,
+			NpaPasswordTtlSec:  c.NpaPasswordTtlSec,
+			SearchPeople:       c.SearchPeople,
+			LdapGroupBase:      c.LdapGroupBase,
+			LdapGroupContainer: c.LdapGroupContainer,
+			OncoGroup:          c.OncoGroup,
+			LdapCertFile:       c.LdapCertFile,
+			LdapTlsVerify:      c.LdapTlsVerify,
+			Schema:             c.LdapSchema,
+			SchemaOverrides:    c.schemaOverrides(),
+		}, true
+	}
+	d, ok := c.Directories[name]
+	if ok {
+		d.Name = name
+	}
+	return d, ok
+}
+
+// DirectoryNames list the configured directories, the default one first then by name
+func (c *Configuration) DirectoryNames() []string {
+	names := make([]string, 0, len(c.Directories))
+	for name := range c.Directories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultDirectory}, names...)
+}
+
+// NpaPasswordSecret return the provider of the bind password of the directory
+func (d Directory) NpaPasswordSecret() secrets.Provider {
+	return secrets.For(secrets.Spec{
+		File:       d.NpaPasswordFile,
+		Command:    d.NpaPasswordCommand,
+		CommandTtl: time.Duration(d.NpaPasswordTtlSec) * time.Second,
+		Env:        d.NpaPasswordEnv,
+		Value:      d.NpaPassword,
+	})
+}
+
+// schemaOverrides return the schema attributes set at the top level
+func (c *Configuration) schemaOverrides() schema.Schema {
+	return schema.Schema{
+		UserObjectClass:    c.LdapUserObjectClass,
+		LoginAttribute:     c.LdapLoginAttribute,
+		GroupObjectClass:   c.LdapGroupObjectClass,
+		GroupNameAttribute: c.LdapGroupNameAttribute,
+		MemberAttribute:    c.LdapMemberAttribute,
+		MemberOfAttribute:  c.LdapMemberOfAttribute,
+	}
+}
+
+// LdapSchema return the schema of the directory, the preset with the overrides applied
+func (d Directory) LdapSchema() (schema.Schema, error) {
+	return schema.Resolve(d.Schema, d.SchemaOverrides)
+}
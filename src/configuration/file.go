@@ -0,0 +1,559 @@
+package configuration
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"user-check/schema"
+)
+
+// redacted replaces secrets when the configuration is printed
+const redacted = "<redacted>"
+
+// fileConfig is the schema of the configuration file
+type fileConfig struct {
+	Server       fileServer       `yaml:"server" toml:"server"`
+	Ldap         fileLdap         `yaml:"ldap" toml:"ldap"`
+	Groups       fileGroups       `yaml:"groups" toml:"groups"`
+	Tls          fileTls          `yaml:"tls" toml:"tls"`
+	Cache        fileCache        `yaml:"cache" toml:"cache"`
+	Auth         fileAuth         `yaml:"auth" toml:"auth"`
+	RateLimit    fileRateLimit    `yaml:"rate_limit" toml:"rate_limit"`
+	Authenticate fileAuthenticate `yaml:"authenticate" toml:"authenticate"`
+	ForwardAuth  fileForwardAuth  `yaml:"forward_auth" toml:"forward_auth"`
+	ExtAuthz     fileExtAuthz     `yaml:"ext_authz" toml:"ext_authz"`
+	Grpc         fileGrpc         `yaml:"grpc" toml:"grpc"`
+	K8sAuthz     fileK8sAuthz     `yaml:"k8s_authz" toml:"k8s_authz"`
+	Audit        fileAudit        `yaml:"audit" toml:"audit"`
+	Profile      fileProfile      `yaml:"profile" toml:"profile"`
+	Opa          fileOpa          `yaml:"opa" toml:"opa"`
+	Webhook      fileWebhook      `yaml:"webhook" toml:"webhook"`
+	Swagger      CSwagger         `yaml:"swagger" toml:"swagger"`
+	// named directories, settings left out are taken from the ldap and groups sections
+	Directories map[string]fileDirectory `yaml:"directories,omitempty" toml:"directories,omitempty"`
+}
+
+type fileServer struct {
+	Port            int32 `yaml:"port" toml:"port"`
+	ShutdownTimeout int32 `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	Development     bool  `yaml:"development" toml:"development"`
+	Swagger         bool  `yaml:"swagger" toml:"swagger"`
+	GinLogger       bool  `yaml:"gin_logger" toml:"gin_logger"`
+}
+
+type fileLdap struct {
+	Address          string `yaml:"address" toml:"address"`
+	BindUser         string `yaml:"bind_user" toml:"bind_user"`
+	BindPassword     string `yaml:"bind_password" toml:"bind_password"`
+	BindPasswordFile string `yaml:"bind_password_file" toml:"bind_password_file"`
+	BindPasswordCmd  string `yaml:"bind_password_command" toml:"bind_password_command"`
+	BindPasswordTtl  int32  `yaml:"bind_password_command_ttl" toml:"bind_password_command_ttl"`
+	BindPasswordEnv  string `yaml:"bind_password_env" toml:"bind_password_env"`
+	UserBaseDn       string `yaml:"user_base_dn" toml:"user_base_dn"`
+	CaFile           string `yaml:"ca_file" toml:"ca_file"`
+	TlsVerify        bool   `yaml:"tls_verify" toml:"tls_verify"`
+	TimeoutMs        int32  `yaml:"timeout_ms" toml:"timeout_ms"`
+	MaxConcurrent    int32  `yaml:"max_concurrent" toml:"max_concurrent"`
+	QueueTimeoutMs   int32  `yaml:"queue_timeout_ms" toml:"queue_timeout_ms"`
+	BreakerThreshold int32  `yaml:"breaker_failure_threshold" toml:"breaker_failure_threshold"`
+	BreakerProbe     int32  `yaml:"breaker_probe_interval" toml:"breaker_probe_interval"`
+	fileSchema       `yaml:",inline" toml:",inline"`
+}
+
+// fileSchema is the schema preset and its overrides, shared by the ldap section and the directories
+type fileSchema struct {
+	Schema             string `yaml:"schema,omitempty" toml:"schema,omitempty"`
+	UserObjectClass    string `yaml:"user_object_class,omitempty" toml:"user_object_class,omitempty"`
+	LoginAttribute     string `yaml:"login_attribute,omitempty" toml:"login_attribute,omitempty"`
+	GroupObjectClass   string `yaml:"group_object_class,omitempty" toml:"group_object_class,omitempty"`
+	GroupNameAttribute string `yaml:"group_name_attribute,omitempty" toml:"group_name_attribute,omitempty"`
+	MemberAttribute    string `yaml:"member_attribute,omitempty" toml:"member_attribute,omitempty"`
+	MemberOfAttribute  string `yaml:"memberof_attribute,omitempty" toml:"memberof_attribute,omitempty"`
+}
+
+// overrides return the attributes set explicitly
+func (f fileSchema) overrides() schema.Schema {
+	return schema.Schema{
+		UserObjectClass:    f.UserObjectClass,
+		LoginAttribute:     f.LoginAttribute,
+		GroupObjectClass:   f.GroupObjectClass,
+		GroupNameAttribute: f.GroupNameAttribute,
+		MemberAttribute:    f.MemberAttribute,
+		MemberOfAttribute:  f.MemberOfAttribute,
+	}
+}
+
+// schemaToFile map a preset and its overrides to the file schema
+func schemaToFile(preset string, o schema.Schema) fileSchema {
+	return fileSchema{
+		Schema:             preset,
+		UserObjectClass:    o.UserObjectClass,
+		LoginAttribute:     o.LoginAttribute,
+		GroupObjectClass:   o.GroupObjectClass,
+		GroupNameAttribute: o.GroupNameAttribute,
+		MemberAttribute:    o.MemberAttribute,
+		MemberOfAttribute:  o.MemberOfAttribute,
+	}
+}
+
+type fileGroups struct {
+	Default   string `yaml:"default" toml:"default"`
+	BaseDn    string `yaml:"base_dn" toml:"base_dn"`
+	Container string `yaml:"container_dn" toml:"container_dn"`
+	// InactiveNotMember answers disabled, locked and expired accounts as non-members
+	InactiveNotMember bool `yaml:"inactive_not_member" toml:"inactive_not_member"`
+	// NestedMembership makes members through other groups members too
+	NestedMembership bool `yaml:"nested_membership" toml:"nested_membership"`
+}
+
+type fileDirectory struct {
+	Address          string `yaml:"address,omitempty" toml:"address,omitempty"`
+	BindUser         string `yaml:"bind_user,omitempty" toml:"bind_user,omitempty"`
+	BindPassword     string `yaml:"bind_password,omitempty" toml:"bind_password,omitempty"`
+	BindPasswordFile string `yaml:"bind_password_file,omitempty" toml:"bind_password_file,omitempty"`
+	BindPasswordCmd  string `yaml:"bind_password_command,omitempty" toml:"bind_password_command,omitempty"`
+	BindPasswordEnv  string `yaml:"bind_password_env,omitempty" toml:"bind_password_env,omitempty"`
+	UserBaseDn       string `yaml:"user_base_dn,omitempty" toml:"user_base_dn,omitempty"`
+	CaFile           string `yaml:"ca_file,omitempty" toml:"ca_file,omitempty"`
+	TlsVerify        *bool  `yaml:"tls_verify,omitempty" toml:"tls_verify,omitempty"`
+	Group            string `yaml:"group,omitempty" toml:"group,omitempty"`
+	GroupBaseDn      string `yaml:"group_base_dn,omitempty" toml:"group_base_dn,omitempty"`
+	GroupContainer   string `yaml:"group_container_dn,omitempty" toml:"group_container_dn,omitempty"`
+	fileSchema       `yaml:",inline" toml:",inline"`
+}
+
+type fileTls struct {
+	Enabled            bool   `yaml:"enabled" toml:"enabled"`
+	CertFile           string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile            string `yaml:"key_file" toml:"key_file"`
+	ClientCaFile       string `yaml:"client_ca_file" toml:"client_ca_file"`
+	ClientCertOptional bool   `yaml:"client_cert_optional" toml:"client_cert_optional"`
+	ReloadInterval     int32  `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+type fileCache struct {
+	Ttl            int32 `yaml:"ttl" toml:"ttl"`
+	MaxEntries     int32 `yaml:"max_entries" toml:"max_entries"`
+	SnapshotMaxAge int32 `yaml:"snapshot_max_age" toml:"snapshot_max_age"`
+}
+
+type fileAuth struct {
+	CallerHeader         string `yaml:"caller_header" toml:"caller_header"`
+	TrustedProxies       string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	CallerHeaderTrusted  bool   `yaml:"caller_header_trusted" toml:"caller_header_trusted"`
+	IdentityFile         string `yaml:"identity_file" toml:"identity_file"`
+	PolicyFile           string `yaml:"policy_file" toml:"policy_file"`
+	PolicyDryRun         bool   `yaml:"policy_dry_run" toml:"policy_dry_run"`
+	PolicyReloadInterval int32  `yaml:"policy_reload_interval" toml:"policy_reload_interval"`
+}
+
+type fileRateLimit struct {
+	Rps   int32 `yaml:"rps" toml:"rps"`
+	Burst int32 `yaml:"burst" toml:"burst"`
+}
+
+type fileAuthenticate struct {
+	MaxFailures int32 `yaml:"max_failures" toml:"max_failures"`
+	Backoff     int32 `yaml:"backoff" toml:"backoff"`
+	BackoffMax  int32 `yaml:"backoff_max" toml:"backoff_max"`
+}
+
+type fileForwardAuth struct {
+	UserHeader   string `yaml:"user_header" toml:"user_header"`
+	GroupsHeader string `yaml:"groups_header" toml:"groups_header"`
+	GroupsQuery  bool   `yaml:"groups_query" toml:"groups_query"`
+	CacheTtl     int32  `yaml:"cache_ttl" toml:"cache_ttl"`
+}
+
+type fileExtAuthz struct {
+	Port       int32  `yaml:"port" toml:"port"`
+	UserHeader string `yaml:"user_header" toml:"user_header"`
+	JwtClaim   string `yaml:"jwt_claim" toml:"jwt_claim"`
+}
+
+type fileGrpc struct {
+	Port       int32 `yaml:"port" toml:"port"`
+	Reflection bool  `yaml:"reflection" toml:"reflection"`
+}
+
+type fileK8sAuthz struct {
+	RulesFile  string `yaml:"rules_file" toml:"rules_file"`
+	UserPrefix string `yaml:"user_prefix" toml:"user_prefix"`
+	UserBy     string `yaml:"user_by" toml:"user_by"`
+}
+
+type fileProfile struct {
+	Attributes string `yaml:"attributes" toml:"attributes"`
+}
+
+type fileOpa struct {
+	BundleRefresh int32  `yaml:"bundle_refresh" toml:"bundle_refresh"`
+	BundleGroups  string `yaml:"bundle_groups" toml:"bundle_groups"`
+}
+
+type fileWebhook struct {
+	Refresh    int32  `yaml:"refresh" toml:"refresh"`
+	Urls       string `yaml:"urls" toml:"urls"`
+	Groups     string `yaml:"groups" toml:"groups"`
+	Secret     string `yaml:"secret" toml:"secret"`
+	SecretFile string `yaml:"secret_file" toml:"secret_file"`
+	Outbox     string `yaml:"outbox" toml:"outbox"`
+	Retention  int32  `yaml:"retention" toml:"retention"`
+	Backoff    int32  `yaml:"backoff" toml:"backoff"`
+	BackoffMax int32  `yaml:"backoff_max" toml:"backoff_max"`
+}
+
+type fileAudit struct {
+	Output     string `yaml:"output" toml:"output"`
+	MaxSizeMb  int32  `yaml:"max_size_mb" toml:"max_size_mb"`
+	MaxBackups int32  `yaml:"max_backups" toml:"max_backups"`
+	MaxAgeDays int32  `yaml:"max_age_days" toml:"max_age_days"`
+	IsidMode   string `yaml:"isid_mode" toml:"isid_mode"`
+	HashSalt   string `yaml:"hash_salt" toml:"hash_salt"`
+}
+
+// loadFile override c with the settings present in a YAML or TOML file, chosen by extension. The named directories
+// are returned as written, they inherit the top level settings once every source is applied.
+func loadFile(c *Configuration, file string) (map[string]fileDirectory, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening configuration file %s: %v", file, err)
+	}
+
+	// start from the current values so settings missing from the file are kept
+	f := c.toFile()
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&f)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &f)
+	default:
+		return nil, fmt.Errorf("configuration file %s: unsupported format, expected .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing configuration file %s: %v", file, err)
+	}
+	c.fromFile(f)
+	return f.Directories, nil
+}
+
+// toFile map the configuration to the file schema
+func (c *Configuration) toFile() fileConfig {
+	return fileConfig{
+		Server: fileServer{
+			Port:            c.HttpPort,
+			ShutdownTimeout: c.CleanupTimeoutSec,
+			Development:     c.Development,
+			Swagger:         c.UseSwagger,
+			GinLogger:       c.GinLogger,
+		},
+		Ldap: fileLdap{
+			Address:          c.LdapServerAddress,
+			BindUser:         c.NpaUser,
+			BindPassword:     c.NpaPassword,
+			BindPasswordFile: c.NpaPasswordFile,
+			BindPasswordCmd:  c.NpaPasswordCommand,
+			BindPasswordTtl:  c.NpaPasswordTtlSec,
+			BindPasswordEnv:  c.NpaPasswordEnv,
+			UserBaseDn:       c.SearchPeople,
+			CaFile:           c.LdapCertFile,
+			TlsVerify:        c.LdapTlsVerify,
+			TimeoutMs:        c.LdapTimeoutMs,
+			MaxConcurrent:    c.LdapMaxConcurrent,
+			QueueTimeoutMs:   c.LdapQueueTimeoutMs,
+			BreakerThreshold: c.BreakerThreshold,
+			BreakerProbe:     c.BreakerProbeSec,
+			fileSchema:       schemaToFile(c.LdapSchema, c.schemaOverrides()),
+		},
+		Groups: fileGroups{
+			Default:           c.OncoGroup,
+			BaseDn:            c.LdapGroupBase,
+			Container:         c.LdapGroupContainer,
+			InactiveNotMember: c.InactiveNotMember,
+			NestedMembership:  c.NestedMembership,
+		},
+		Tls: fileTls{
+			Enabled:            c.Tls,
+			CertFile:           c.ApiCertCrtFile,
+			KeyFile:            c.ApiCertKeyFile,
+			ClientCaFile:       c.ApiClientCaFile,
+			ClientCertOptional: c.ApiClientOptional,
+			ReloadInterval:     c.CertReloadSec,
+		},
+		Cache: fileCache{
+			Ttl:            c.CacheTtlSec,
+			MaxEntries:     c.CacheMaxEntries,
+			SnapshotMaxAge: c.SnapshotMaxAgeSec,
+		},
+		Auth: fileAuth{
+			CallerHeader:         c.CallerIdHeader,
+			TrustedProxies:       c.TrustedProxies,
+			CallerHeaderTrusted:  c.CallerIdHeaderTrusted,
+			IdentityFile:         c.ClientIdentityFile,
+			PolicyFile:           c.PolicyFile,
+			PolicyDryRun:         c.PolicyDryRun,
+			PolicyReloadInterval: c.PolicyReloadSec,
+		},
+		RateLimit: fileRateLimit{
+			Rps:   c.RateLimitRps,
+			Burst: c.RateLimitBurst,
+		},
+		Authenticate: fileAuthenticate{
+			MaxFailures: c.AuthMaxFailures,
+			Backoff:     c.AuthBackoffSec,
+			BackoffMax:  c.AuthBackoffMaxSec,
+		},
+		ForwardAuth: fileForwardAuth{
+			UserHeader:   c.ForwardUserHeader,
+			GroupsHeader: c.ForwardGroupsHeader,
+			GroupsQuery:  c.ForwardGroupsQuery,
+			CacheTtl:     c.ForwardCacheTtlSec,
+		},
+		ExtAuthz: fileExtAuthz{
+			Port:       c.ExtAuthzPort,
+			UserHeader: c.ExtAuthzUserHeader,
+			JwtClaim:   c.ExtAuthzJwtClaim,
+		},
+		Grpc: fileGrpc{
+			Port:       c.GrpcPort,
+			Reflection: c.GrpcReflection,
+		},
+		K8sAuthz: fileK8sAuthz{
+			RulesFile:  c.K8sAuthzFile,
+			UserPrefix: c.K8sAuthzUserPrefix,
+			UserBy:     c.K8sAuthzUserBy,
+		},
+		Audit: fileAudit{
+			Output:     c.AuditLog,
+			MaxSizeMb:  c.AuditMaxSizeMb,
+			MaxBackups: c.AuditMaxBackups,
+			MaxAgeDays: c.AuditMaxAgeDays,
+			IsidMode:   c.AuditIsidMode,
+			HashSalt:   c.AuditHashSalt,
+		},
+		Profile: fileProfile{
+			Attributes: c.ProfileAttributes,
+		},
+		Opa: fileOpa{
+			BundleRefresh: c.OpaBundleRefreshSec,
+			BundleGroups:  c.OpaBundleGroups,
+		},
+		Webhook: fileWebhook{
+			Refresh:    c.WebhookRefreshSec,
+			Urls:       c.WebhookUrls,
+			Groups:     c.WebhookGroups,
+			Secret:     c.WebhookSecret,
+			SecretFile: c.WebhookSecretFile,
+			Outbox:     c.WebhookOutbox,
+			Retention:  c.WebhookRetention,
+			Backoff:    c.WebhookBackoffSec,
+			BackoffMax: c.WebhookBackoffMaxSec,
+		},
+		Swagger:     c.Swagger,
+		Directories: c.directoriesToFile(),
+	}
+}
+
+// directoriesToFile map the named directories to the file schema
+func (c *Configuration) directoriesToFile() map[string]fileDirectory {
+	if len(c.Directories) == 0 {
+		return nil
+	}
+	dirs := map[string]fileDirectory{}
+	for name, d := range c.Directories {
+		tlsVerify := d.LdapTlsVerify
+		dirs[name] = fileDirectory{
+			Address:          d.LdapServerAddress,
+			BindUser:         d.NpaUser,
+			BindPassword:     d.NpaPassword,
+			BindPasswordFile: d.NpaPasswordFile,
+			BindPasswordCmd:  d.NpaPasswordCommand,
+			BindPasswordEnv:  d.NpaPasswordEnv,
+			UserBaseDn:       d.SearchPeople,
+			CaFile:           d.LdapCertFile,
+			TlsVerify:        &tlsVerify,
+			Group:            d.OncoGroup,
+			GroupBaseDn:      d.LdapGroupBase,
+			GroupContainer:   d.LdapGroupContainer,
+			fileSchema:       schemaToFile(d.Schema, d.SchemaOverrides),
+		}
+	}
+	return dirs
+}
+
+// directoriesFromFile build the named directories, unset settings are taken from the default directory, which
+// must have every source applied already
+func (c *Configuration) directoriesFromFile(files map[string]fileDirectory) map[string]Directory {
+	if len(files) == 0 {
+		return nil
+	}
+	or := func(value, inherited string) string {
+		if value == "" {
+			return inherited
+		}
+		return value
+	}
+	inherited, _ := c.Directory(DefaultDirectory)
+	dirs := map[string]Directory{}
+	for name, fd := range files {
+		d := inherited
+		d.Name = name
+		d.LdapServerAddress = or(fd.Address, d.LdapServerAddress)
+		d.NpaUser = or(fd.BindUser, d.NpaUser)
+		d.SearchPeople = or(fd.UserBaseDn, d.SearchPeople)
+		d.LdapGroupBase = or(fd.GroupBaseDn, d.LdapGroupBase)
+		d.LdapGroupContainer = or(fd.GroupContainer, d.LdapGroupContainer)
+		d.OncoGroup = or(fd.Group, d.OncoGroup)
+		d.LdapCertFile = or(fd.CaFile, d.LdapCertFile)
+		// a directory with a preset of its own does not inherit the overrides of the ldap section
+		if fd.Schema != "" {
+			d.Schema, d.SchemaOverrides = fd.Schema, schema.Schema{}
+		}
+		d.SchemaOverrides = d.SchemaOverrides.Override(fd.overrides())
+		// the password comes as a whole from the directory or from the ldap section
+		if fd.BindPassword != "" || fd.BindPasswordFile != "" || fd.BindPasswordCmd != "" || fd.BindPasswordEnv != "" {
+			d.NpaPassword, d.NpaPasswordFile, d.NpaPasswordCommand = fd.BindPassword, fd.BindPasswordFile, fd.BindPasswordCmd
+			d.NpaPasswordEnv = fd.BindPasswordEnv
+		}
+		if fd.TlsVerify != nil {
+			d.LdapTlsVerify = *fd.TlsVerify
+		}
+		dirs[name] = d
+	}
+	return dirs
+}
+
+// fromFile copy the file schema back into the configuration
+func (c *Configuration) fromFile(f fileConfig) {
+	c.HttpPort = f.Server.Port
+	c.CleanupTimeoutSec = f.Server.ShutdownTimeout
+	c.Development = f.Server.Development
+	c.UseSwagger = f.Server.Swagger
+	c.GinLogger = f.Server.GinLogger
+
+	c.LdapServerAddress = f.Ldap.Address
+	c.NpaUser = f.Ldap.BindUser
+	c.NpaPassword = f.Ldap.BindPassword
+	c.NpaPasswordFile = f.Ldap.BindPasswordFile
+	c.NpaPasswordCommand = f.Ldap.BindPasswordCmd
+	c.NpaPasswordTtlSec = f.Ldap.BindPasswordTtl
+	c.NpaPasswordEnv = f.Ldap.BindPasswordEnv
+	c.SearchPeople = f.Ldap.UserBaseDn
+	c.LdapCertFile = f.Ldap.CaFile
+	c.LdapTlsVerify = f.Ldap.TlsVerify
+	c.LdapTimeoutMs = f.Ldap.TimeoutMs
+	c.LdapMaxConcurrent = f.Ldap.MaxConcurrent
+	c.LdapQueueTimeoutMs = f.Ldap.QueueTimeoutMs
+	c.BreakerThreshold = f.Ldap.BreakerThreshold
+	c.BreakerProbeSec = f.Ldap.BreakerProbe
+	c.LdapSchema = f.Ldap.Schema
+	c.LdapUserObjectClass = f.Ldap.UserObjectClass
+	c.LdapLoginAttribute = f.Ldap.LoginAttribute
+	c.LdapGroupObjectClass = f.Ldap.GroupObjectClass
+	c.LdapGroupNameAttribute = f.Ldap.GroupNameAttribute
+	c.LdapMemberAttribute = f.Ldap.MemberAttribute
+	c.LdapMemberOfAttribute = f.Ldap.MemberOfAttribute
+
+	c.OncoGroup = f.Groups.Default
+	c.LdapGroupBase = f.Groups.BaseDn
+	c.LdapGroupContainer = f.Groups.Container
+	c.InactiveNotMember = f.Groups.InactiveNotMember
+	c.NestedMembership = f.Groups.NestedMembership
+
+	c.Tls = f.Tls.Enabled
+	c.ApiCertCrtFile = f.Tls.CertFile
+	c.ApiCertKeyFile = f.Tls.KeyFile
+	c.ApiClientCaFile = f.Tls.ClientCaFile
+	c.ApiClientOptional = f.Tls.ClientCertOptional
+	c.CertReloadSec = f.Tls.ReloadInterval
+
+	c.CacheTtlSec = f.Cache.Ttl
+	c.CacheMaxEntries = f.Cache.MaxEntries
+	c.SnapshotMaxAgeSec = f.Cache.SnapshotMaxAge
+
+	c.CallerIdHeader = f.Auth.CallerHeader
+	c.TrustedProxies = f.Auth.TrustedProxies
+	c.CallerIdHeaderTrusted = f.Auth.CallerHeaderTrusted
+	c.ClientIdentityFile = f.Auth.IdentityFile
+	c.PolicyFile = f.Auth.PolicyFile
+	c.PolicyDryRun = f.Auth.PolicyDryRun
+	c.PolicyReloadSec = f.Auth.PolicyReloadInterval
+
+	c.RateLimitRps = f.RateLimit.Rps
+	c.RateLimitBurst = f.RateLimit.Burst
+
+	c.AuthMaxFailures = f.Authenticate.MaxFailures
+	c.AuthBackoffSec = f.Authenticate.Backoff
+	c.AuthBackoffMaxSec = f.Authenticate.BackoffMax
+
+	c.ForwardUserHeader = f.ForwardAuth.UserHeader
+	c.ForwardGroupsHeader = f.ForwardAuth.GroupsHeader
+	c.ForwardGroupsQuery = f.ForwardAuth.GroupsQuery
+	c.ForwardCacheTtlSec = f.ForwardAuth.CacheTtl
+
+	c.ExtAuthzPort = f.ExtAuthz.Port
+	c.ExtAuthzUserHeader = f.ExtAuthz.UserHeader
+	c.ExtAuthzJwtClaim = f.ExtAuthz.JwtClaim
+
+	c.GrpcPort = f.Grpc.Port
+	c.GrpcReflection = f.Grpc.Reflection
+
+	c.K8sAuthzFile = f.K8sAuthz.RulesFile
+	c.K8sAuthzUserPrefix = f.K8sAuthz.UserPrefix
+	c.K8sAuthzUserBy = f.K8sAuthz.UserBy
+
+	c.AuditLog = f.Audit.Output
+	c.AuditMaxSizeMb = f.Audit.MaxSizeMb
+	c.AuditMaxBackups = f.Audit.MaxBackups
+	c.AuditMaxAgeDays = f.Audit.MaxAgeDays
+	c.AuditIsidMode = f.Audit.IsidMode
+	c.AuditHashSalt = f.Audit.HashSalt
+
+	c.ProfileAttributes = f.Profile.Attributes
+
+	c.OpaBundleRefreshSec = f.Opa.BundleRefresh
+	c.OpaBundleGroups = f.Opa.BundleGroups
+
+	c.WebhookRefreshSec = f.Webhook.Refresh
+	c.WebhookUrls = f.Webhook.Urls
+	c.WebhookGroups = f.Webhook.Groups
+	c.WebhookSecret = f.Webhook.Secret
+	c.WebhookSecretFile = f.Webhook.SecretFile
+	c.WebhookOutbox = f.Webhook.Outbox
+	c.WebhookRetention = f.Webhook.Retention
+	c.WebhookBackoffSec = f.Webhook.Backoff
+	c.WebhookBackoffMaxSec = f.Webhook.BackoffMax
+
+	c.Swagger = f.Swagger
+}
+
+// Redacted render the effective configuration in the file schema (YAML), with secrets replaced
+func (c *Configuration) Redacted() (string, error) {
+	f := c.toFile()
+	if f.Ldap.BindPassword != "" {
+		f.Ldap.BindPassword = redacted
+	}
+	if f.Audit.HashSalt != "" {
+		f.Audit.HashSalt = redacted
+	}
+	if f.Webhook.Secret != "" {
+		f.Webhook.Secret = redacted
+	}
+	for name, d := range f.Directories {
+		if d.BindPassword != "" {
+			d.BindPassword = redacted
+			f.Directories[name] = d
+		}
+	}
+	out, err := yaml.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
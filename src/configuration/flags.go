@@ -0,0 +1,50 @@
+package configuration
+
+import (
+	"github.com/spf13/pflag"
+)
+
+// Flags command line settings, they win over env variables, config file and defaults
+type Flags struct {
+	ConfigFile  string
+	PrintConfig bool
+
+	fs                *pflag.FlagSet
+	cleanupTimeoutSec int32
+	httpPort          int32
+	useSwagger        bool
+	development       bool
+	tls               bool
+}
+
+// BindFlags register the command line flags on fs
+func BindFlags(fs *pflag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	fs.StringVarP(&f.ConfigFile, "config", "c", "", "YAML or TOML configuration file. Default: $CONFIG_FILE")
+	fs.BoolVar(&f.PrintConfig, "print-config", false, "Print the effective configuration, secrets redacted, and exit")
+	fs.Int32VarP(&f.cleanupTimeoutSec, "timeout", "t", 60, "Time to wait for graceful shutdown on SIGTERM/SIGINT in seconds. Default: 60")
+	fs.Int32VarP(&f.httpPort, "port", "p", 8080, "TCP port for the HTTP listener to bind to. Default: 8080")
+	fs.BoolVarP(&f.useSwagger, "swagger", "s", false, "Activate swagger. Do not use this in Production!")
+	fs.BoolVarP(&f.development, "devel", "d", false, "Start in development mode. Implies --swagger. Do not use this in Production!")
+	fs.BoolVarP(&f.tls, "tls", "l", false, "Active TLS in listener. Implies ssl keys env vars set")
+	return f
+}
+
+// apply override c with the flags given on the command line
+func (f *Flags) apply(c *Configuration) {
+	if f.fs.Changed("timeout") {
+		c.CleanupTimeoutSec = f.cleanupTimeoutSec
+	}
+	if f.fs.Changed("port") {
+		c.HttpPort = f.httpPort
+	}
+	if f.fs.Changed("swagger") {
+		c.UseSwagger = f.useSwagger
+	}
+	if f.fs.Changed("devel") {
+		c.Development = f.development
+	}
+	if f.fs.Changed("tls") {
+		c.Tls = f.tls
+	}
+}
@@ -0,0 +1,168 @@
+package configuration
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"user-check/secrets"
+	"user-check/utils/logger"
+)
+
+// ReloadHook prepare the switch from old to next. It must not change anything yet:
+// an error aborts the reload and the old configuration stays active. The returned
+// func, when not nil, applies the change once every hook has prepared successfully.
+type ReloadHook func(old, next *Configuration) (func(), error)
+
+var (
+	reloadMu    sync.Mutex
+	loadedFlags *Flags
+	reloadHooks []ReloadHook
+)
+
+// secretFields are never printed in a diff
+var secretFields = map[string]bool{
+	"NpaPassword":   true,
+	"AuditHashSalt": true,
+	"WebhookSecret": true,
+}
+
+// restartFields are read once at startup, a change only takes effect after a restart
+var restartFields = map[string]bool{
+	"HttpPort":            true,
+	"Tls":                 true,
+	"GinLogger":           true,
+	"UseSwagger":          true,
+	"Development":         true,
+	"ApiCertCrtFile":      true,
+	"ApiCertKeyFile":      true,
+	"ApiClientCaFile":     true,
+	"ApiClientOptional":   true,
+	"CertReloadSec":       true,
+	"TrustedProxies":      true,
+	"ExtAuthzPort":        true,
+	"OpaBundleRefreshSec": true,
+	"WebhookRefreshSec":   true,
+	"WebhookOutbox":       true,
+	"GrpcPort":            true,
+	"GrpcReflection":      true,
+}
+
+// OnReload register a hook run on every configuration reload, in registration order
+func OnReload(hook ReloadHook) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, hook)
+}
+
+// Reload build the configuration again from the same sources Load used and make it active.
+// An invalid configuration, or one a hook refuses, is rejected and the active one is kept.
+// Returns the list of changed settings.
+func Reload() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	log := logger.SugaredLogger().With("package", "configuration", "action", "reload configuration")
+
+	old := AppConfig()
+	next, err := build(loadedFlags)
+	if err != nil {
+		log.Errorf("keeping current configuration: %v", err)
+		return nil, err
+	}
+	// swagger info is only set up at startup
+	next.Swagger = old.Swagger
+
+	changes := Diff(old, next)
+	// settings read at startup keep reporting what is actually in use
+	nv, ov := reflect.ValueOf(next).Elem(), reflect.ValueOf(old).Elem()
+	for name := range restartFields {
+		nv.FieldByName(name).Set(ov.FieldByName(name))
+	}
+	if len(changes) == 0 {
+		log.Infof("configuration reloaded, nothing changed")
+		return changes, nil
+	}
+
+	var apply []func()
+	for _, hook := range reloadHooks {
+		fn, err := hook(old, next)
+		if err != nil {
+			log.Errorf("keeping current configuration: %v", err)
+			return nil, err
+		}
+		if fn != nil {
+			apply = append(apply, fn)
+		}
+	}
+	current.Store(next)
+	// secret sources may have changed, they are read again from the new configuration
+	secrets.Forget()
+	for _, fn := range apply {
+		fn()
+	}
+	for _, change := range changes {
+		log.Infof("configuration changed: %s", change)
+	}
+	return changes, nil
+}
+
+// Diff list the settings that differ between old and next, secrets are not printed
+func Diff(old, next *Configuration) []string {
+	var changes []string
+	ov, nv := reflect.ValueOf(*old), reflect.ValueOf(*next)
+	for i := 0; i < ov.NumField(); i++ {
+		name := ov.Type().Field(i).Name
+		if name == "Swagger" || name == "Initialized" {
+			continue
+		}
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		if name == "Directories" {
+			changes = append(changes, diffDirectories(old.Directories, next.Directories)...)
+			continue
+		}
+		change := fmt.Sprintf("%s: %q -> %q", name, fmt.Sprint(a), fmt.Sprint(b))
+		if secretFields[name] {
+			change = name + ": <redacted>"
+		}
+		if restartFields[name] {
+			change += " (restart required)"
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// Changed report whether any of the named settings differ between old and next
+func Changed(old, next *Configuration, fields ...string) bool {
+	ov, nv := reflect.ValueOf(*old), reflect.ValueOf(*next)
+	for _, name := range fields {
+		if !reflect.DeepEqual(ov.FieldByName(name).Interface(), nv.FieldByName(name).Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffDirectories list the named directories added, removed or changed, without their settings
+func diffDirectories(old, next map[string]Directory) []string {
+	var changes []string
+	for name, d := range next {
+		o, ok := old[name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("Directories: %s added", name))
+		case !reflect.DeepEqual(o, d):
+			changes = append(changes, fmt.Sprintf("Directories: %s changed", name))
+		}
+	}
+	for name := range old {
+		if _, ok := next[name]; !ok {
+			changes = append(changes, fmt.Sprintf("Directories: %s removed", name))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
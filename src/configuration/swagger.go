@@ -13,8 +13,11 @@ type CSwagger struct {
 	BasePath    string `yaml:"basepath"`
 }
 
-// LoadSwaggerConf load swagger related configs
+// LoadSwaggerConf load swagger related configs from swagger.yaml, unless the config file had a swagger section
 func (c *Configuration) LoadSwaggerConf() {
+	if c.Swagger.Title != "" {
+		return
+	}
 	yamlFile, err := ioutil.ReadFile("swagger.yaml")
 	if err != nil {
 		log.Fatalf("Error opening swagger configuration file swagger.yaml: %v ", err)
@@ -0,0 +1,202 @@
+package configuration
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration, %d problem(s):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// secretAttributes hold credentials, they are never returned whatever the allow-list says
+var secretAttributes = map[string]bool{
+	"userpassword":            true,
+	"unicodepwd":              true,
+	"dbcspwd":                 true,
+	"ntpwdhistory":            true,
+	"lmpwdhistory":            true,
+	"supplementalcredentials": true,
+	"krbprincipalkey":         true,
+	"sambantpassword":         true,
+	"sambalmpassword":         true,
+	"ms-mcs-admpwd":           true,
+}
+
+// validator collect configuration problems
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		v.problems = append(v.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) fileExists(file, setting string) {
+	if file == "" {
+		return
+	}
+	_, err := os.Stat(file)
+	v.check(err == nil, "%s: file %s is not readable: %v", setting, file, err)
+}
+
+// ldapAddress check a comma separated list of ldap urls
+func (v *validator) ldapAddress(address, setting string) {
+	v.check(address != "", "%s: is required", setting)
+	for _, server := range strings.FieldsFunc(address, func(r rune) bool { return r == ',' || r == ' ' }) {
+		u, err := url.Parse(server)
+		v.check(err == nil && (u.Scheme == "ldap" || u.Scheme == "ldaps") && u.Host != "",
+			"%s: %q is not an ldap:// or ldaps:// url", setting, server)
+	}
+}
+
+// schema check the schema preset and overrides of a directory resolve to a usable schema
+func (v *validator) schema(d Directory, section string) {
+	_, err := d.LdapSchema()
+	v.check(err == nil, "%s.schema: %v", section, err)
+}
+
+// Validate check the configuration, reporting all the problems at once
+func (c *Configuration) Validate() error {
+	return c.validate(nil)
+}
+
+// validate check the configuration, reporting problems found while loading it along with its own
+func (c *Configuration) validate(problems []string) error {
+	v := &validator{problems: problems}
+
+	v.check(c.HttpPort > 0 && c.HttpPort < 65536, "server.port: %d is not a valid TCP port", c.HttpPort)
+	v.check(c.CleanupTimeoutSec >= 0, "server.shutdown_timeout: must not be negative")
+
+	v.ldapAddress(c.LdapServerAddress, "ldap.address")
+	v.check(c.NpaUser != "", "ldap.bind_user: is required")
+	v.check(c.NpaPassword != "" || c.NpaPasswordFile != "" || c.NpaPasswordCommand != "" || c.NpaPasswordEnv != "",
+		"ldap.bind_password: is required, unless bind_password_file, bind_password_command or bind_password_env is set")
+	v.check(c.NpaPasswordFile == "" || c.NpaPasswordCommand == "",
+		"ldap.bind_password_file: can't be used together with bind_password_command")
+	v.fileExists(c.NpaPasswordFile, "ldap.bind_password_file")
+	v.check(c.NpaPasswordTtlSec >= 0, "ldap.bind_password_command_ttl: must not be negative")
+	v.check(c.SearchPeople != "", "ldap.user_base_dn: is required")
+	v.check(c.LdapTimeoutMs >= 0, "ldap.timeout_ms: must not be negative")
+	v.check(c.LdapMaxConcurrent >= 0, "ldap.max_concurrent: must not be negative")
+	v.check(c.LdapQueueTimeoutMs >= 0, "ldap.queue_timeout_ms: must not be negative")
+	v.check(c.BreakerThreshold > 0, "ldap.breaker_failure_threshold: must be at least 1")
+	v.check(c.BreakerProbeSec > 0, "ldap.breaker_probe_interval: must be at least 1 second")
+	if c.LdapCertFile != DefaultLdapCertFile {
+		v.fileExists(c.LdapCertFile, "ldap.ca_file")
+	}
+
+	defaultDirectory, _ := c.Directory(DefaultDirectory)
+	v.schema(defaultDirectory, "ldap")
+	v.check(c.OncoGroup != "", "groups.default: is required")
+	v.check(c.LdapGroupBase != "", "groups.base_dn: is required")
+	v.check(c.LdapGroupContainer != "", "groups.container_dn: is required")
+
+	if c.Tls {
+		v.check(c.ApiCertCrtFile != "", "tls.cert_file: is required when tls is enabled")
+		v.check(c.ApiCertKeyFile != "", "tls.key_file: is required when tls is enabled")
+		v.fileExists(c.ApiCertCrtFile, "tls.cert_file")
+		v.fileExists(c.ApiCertKeyFile, "tls.key_file")
+		v.fileExists(c.ApiClientCaFile, "tls.client_ca_file")
+	} else {
+		v.check(c.ApiClientCaFile == "", "tls.client_ca_file: client certificates need tls to be enabled")
+	}
+	v.check(c.CertReloadSec >= 0, "tls.reload_interval: must not be negative")
+
+	v.check(c.CacheTtlSec >= 0, "cache.ttl: must not be negative")
+	v.check(c.CacheMaxEntries >= 0, "cache.max_entries: must not be negative")
+	v.check(c.SnapshotMaxAgeSec >= 0, "cache.snapshot_max_age: must not be negative")
+
+	v.check(c.CallerIdHeader != "", "auth.caller_header: is required")
+	for _, proxy := range c.TrustedProxyList() {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		v.check(cidrErr == nil || net.ParseIP(proxy) != nil, "auth.trusted_proxies: %q is not an ip or a cidr", proxy)
+	}
+	v.fileExists(c.ClientIdentityFile, "auth.identity_file")
+	v.fileExists(c.PolicyFile, "auth.policy_file")
+	v.check(c.PolicyReloadSec >= 0, "auth.policy_reload_interval: must not be negative")
+
+	for _, name := range c.DirectoryNames()[1:] {
+		d := c.Directories[name]
+		section := "directories." + name
+		v.check(name != DefaultDirectory && name != AllDirectories && !strings.ContainsAny(name, "/ "),
+			"%s: %q can't be used as a directory name", section, name)
+		v.ldapAddress(d.LdapServerAddress, section+".address")
+		v.check(d.NpaUser != "", "%s.bind_user: is required", section)
+		v.check(d.NpaPassword != "" || d.NpaPasswordFile != "" || d.NpaPasswordCommand != "" || d.NpaPasswordEnv != "",
+			"%s.bind_password: is required, unless bind_password_file, bind_password_command or bind_password_env is set", section)
+		v.check(d.NpaPasswordFile == "" || d.NpaPasswordCommand == "",
+			"%s.bind_password_file: can't be used together with bind_password_command", section)
+		v.fileExists(d.NpaPasswordFile, section+".bind_password_file")
+		v.check(d.SearchPeople != "", "%s.user_base_dn: is required", section)
+		v.check(d.OncoGroup != "", "%s.group: is required", section)
+		if d.LdapCertFile != DefaultLdapCertFile {
+			v.fileExists(d.LdapCertFile, section+".ca_file")
+		}
+		v.schema(d, section)
+	}
+
+	v.check(c.RateLimitRps >= 0, "rate_limit.rps: must not be negative")
+	v.check(c.RateLimitBurst >= 0, "rate_limit.burst: must not be negative")
+	v.check(c.AuthMaxFailures >= 0, "authenticate.max_failures: must not be negative")
+	v.check(c.AuthBackoffSec > 0, "authenticate.backoff: must be positive")
+	v.check(c.AuthBackoffMaxSec >= c.AuthBackoffSec, "authenticate.backoff_max: must not be below authenticate.backoff")
+	v.check(c.ForwardUserHeader != "", "forward_auth.user_header: is required")
+	v.check(c.ForwardCacheTtlSec >= 0, "forward_auth.cache_ttl: must not be negative")
+	v.check(c.ExtAuthzPort >= 0 && c.ExtAuthzPort < 65536, "ext_authz.port: %d is not a valid TCP port", c.ExtAuthzPort)
+	v.check(c.ExtAuthzPort == 0 || c.ExtAuthzPort != c.HttpPort, "ext_authz.port: %d is the http port", c.ExtAuthzPort)
+	v.check(c.ExtAuthzPort == 0 || c.ExtAuthzUserHeader != "" || c.ExtAuthzJwtClaim != "",
+		"ext_authz: user_header or jwt_claim is required")
+	v.check(c.GrpcPort >= 0 && c.GrpcPort < 65536, "grpc.port: %d is not a valid TCP port", c.GrpcPort)
+	v.check(c.GrpcPort == 0 || c.GrpcPort != c.HttpPort && c.GrpcPort != c.ExtAuthzPort,
+		"grpc.port: %d is already used by the http or ext_authz server", c.GrpcPort)
+	v.fileExists(c.K8sAuthzFile, "k8s_authz.rules_file")
+	v.check(c.OpaBundleRefreshSec >= 0, "opa.bundle_refresh: must not be negative")
+	v.check(c.WebhookRefreshSec >= 0, "webhook.refresh: must not be negative")
+	if c.WebhookRefreshSec > 0 {
+		v.check(len(c.WebhookUrlList()) > 0, "webhook.urls: at least one is required when webhooks are enabled")
+		v.check(c.WebhookSecret != "" || c.WebhookSecretFile != "", "webhook.secret: is required when webhooks are enabled, unless secret_file is set")
+		v.check(c.WebhookOutbox != "", "webhook.outbox: is required when webhooks are enabled")
+	}
+	for _, receiver := range c.WebhookUrlList() {
+		u, err := url.Parse(receiver)
+		v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"webhook.urls: %q is not an http:// or https:// url", receiver)
+	}
+	v.fileExists(c.WebhookSecretFile, "webhook.secret_file")
+	v.check(c.WebhookRetention > 0, "webhook.retention: must be at least 1")
+	v.check(c.WebhookBackoffSec > 0, "webhook.backoff: must be positive")
+	v.check(c.WebhookBackoffMaxSec >= c.WebhookBackoffSec, "webhook.backoff_max: must not be below webhook.backoff")
+	switch c.K8sAuthzUserBy {
+	case "isid", "mail", "upn", "employee_id", "proxy_address":
+	default:
+		v.check(false, "k8s_authz.user_by: %q is not one of isid, mail, upn, employee_id or proxy_address", c.K8sAuthzUserBy)
+	}
+
+	switch c.AuditIsidMode {
+	case "plain", "hash", "redact":
+	default:
+		v.check(false, "audit.isid_mode: %q is not one of plain, hash or redact", c.AuditIsidMode)
+	}
+	v.check(c.AuditIsidMode != "hash" || c.AuditHashSalt != "", "audit.hash_salt: is required when isids are hashed")
+
+	for _, attribute := range c.ProfileAttributeList() {
+		v.check(attribute != "*" && attribute != "+", "profile.attributes: %s would return every attribute, list them explicitly", attribute)
+		v.check(!secretAttributes[strings.ToLower(attribute)], "profile.attributes: %s holds credentials and can't be returned", attribute)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
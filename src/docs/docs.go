@@ -19,16 +19,22 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/v1/status": {
-            "get": {
-                "description": "This return API status",
+        "/v1/admin/reload": {
+            "post": {
+                "description": "Re-read the configuration file and environment, the current configuration is kept when the new one is invalid",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "HealthCheck Endpoint",
+                "summary": "Reload configuration",
                 "responses": {
                     "200": {
-                        "description": "api pid and ldap status",
+                        "description": "list of changed settings",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "configuration problems",
                         "schema": {
                             "type": "string"
                         }
@@ -36,13 +42,371 @@ const docTemplate = `{
                 }
             }
         },
-        "/v1/usercheck/{isid}": {
+        "/v1/admin/webhooks/replay": {
+            "post": {
+                "description": "Post the membership change events after sequence since again, to the url or to every webhook url.\nEvents older than the WEBHOOK_RETENTION last ones are gone, the replay then starts at the oldest one.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Replay membership change events",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "sequence of the last event not to post again, 0 for every event kept",
+                        "name": "since",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "webhook url, every one when left out",
+                        "name": "url",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.WebhookReplay"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid since or url",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    },
+                    "404": {
+                        "description": "webhooks disabled",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/authenticate": {
+            "post": {
+                "description": "This will verify the password of the user by binding as the user, then check the user is part of the group.\nWrong credentials and unknown users are answered with 401, the decision is in data either way.\nRepeated failures for the same user or from the same client ip are refused with 429 for a time\ndoubling with every failure. The password is never logged.\nOn v2 the result is answered alone and failures as problem details.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Authenticate",
+                "parameters": [
+                    {
+                        "description": "User isid and password",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.Authenticate"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "authenticated, authorized tells whether the user is a member",
+                        "schema": {
+                            "$ref": "#/definitions/model.AuthenticateResult"
+                        }
+                    },
+                    "401": {
+                        "description": "wrong credentials",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    },
+                    "429": {
+                        "description": "too many failures, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/directories/{directory}/members": {
+            "get": {
+                "description": "This will list the user members of the group, sorted by isid, with their account state.\nWith directory=* the members of the group of every directory are listed, directory by directory.\nThe whole listing is read before it is answered, as json, CSV or NDJSON flushed row by row,\nor text with one isid per line, by Accept or format=.",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/x-ndjson",
+                    "text/plain"
+                ],
+                "summary": "Members",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Leave disabled, locked and expired accounts out",
+                        "name": "active_only",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Answer format instead of Accept: json, csv, ndjson or text",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Member"
+                            }
+                        }
+                    },
+                    "406": {
+                        "description": "no acceptable format",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/directories/{directory}/usercheck/{isid}": {
             "get": {
-                "description": "This will validate if user is part of the group",
+                "description": "This will validate if user is part of the group.\nWith directory=* every directory is searched and the one that matched is reported.\nDisabled, locked and expired accounts are answered as non-members when INACTIVE_NOT_MEMBER is set.\nWith by= the user is identified by mail, upn, employee_id or proxy_address instead, an identifier\nmatching several users is answered with 409.",
                 "produces": [
                     "application/json"
                 ],
                 "summary": "UserCheck",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User isid, or the identifier of type by",
+                        "name": "isid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Identifier type: isid (default), mail, upn, employee_id or proxy_address",
+                        "name": "by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Answer with the account state and directory",
+                        "name": "verbose",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "verbose or fanned out answer",
+                        "schema": {
+                            "$ref": "#/definitions/model.UserCheckResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/directories/{directory}/usercount": {
+            "get": {
+                "description": "This will return number of users in the group.\nWith directory=* the group of every directory is counted at once, with a breakdown per directory\nand the directories that could not be counted under errors.\nWith breakdown=true every member account is read to count active and inactive members.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UserGroupCount",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count active and inactive (disabled, locked, expired) members apart",
+                        "name": "breakdown",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "breakdown answer",
+                        "schema": {
+                            "$ref": "#/definitions/model.MemberCount"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/k8s/subjectaccessreview": {
+            "post": {
+                "description": "Kubernetes authorization webhook. The apiserver posts an authorization.k8s.io/v1 SubjectAccessReview,\nit is answered with its status: allowed when a rule of K8S_AUTHZ_FILE grants the verb on the resource\nto a directory group of the user. Unmatched requests get no opinion, unless the rules deny them.\nDirectory failures are reported in status.evaluationError, the apiserver then treats the request as not allowed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "SubjectAccessReview",
+                "parameters": [
+                    {
+                        "description": "SubjectAccessReview sent by the apiserver",
+                        "name": "review",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.SubjectAccessReview"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "the review with its status set",
+                        "schema": {
+                            "$ref": "#/definitions/model.SubjectAccessReview"
+                        }
+                    },
+                    "400": {
+                        "description": "not a SubjectAccessReview",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/members": {
+            "get": {
+                "description": "This will list the user members of the group, sorted by isid, with their account state.\nWith directory=* the members of the group of every directory are listed, directory by directory.\nThe whole listing is read before it is answered, as json, CSV or NDJSON flushed row by row,\nor text with one isid per line, by Accept or format=.",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/x-ndjson",
+                    "text/plain"
+                ],
+                "summary": "Members",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Leave disabled, locked and expired accounts out",
+                        "name": "active_only",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Answer format instead of Accept: json, csv, ndjson or text",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Member"
+                            }
+                        }
+                    },
+                    "406": {
+                        "description": "no acceptable format",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/opa/bundle.tar.gz": {
+            "get": {
+                "description": "This will return the OPA bundle of the group members: a tarball with data.json, read by policies at\ndata.usercheck.directories[directory].groups[group] (member isids) and .users[isid] (groups).\nThe bundle is rebuilt every OPA_BUNDLE_REFRESH seconds; a request with the ETag in If-None-Match\ngets a 304 while it is unchanged. The caller policy must grant the opa endpoint in every directory.",
+                "produces": [
+                    "application/gzip"
+                ],
+                "summary": "OpaBundle",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag of the bundle OPA has",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "bundle tarball",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "bundle unchanged",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "caller not allowed every directory of the bundle",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    },
+                    "404": {
+                        "description": "bundle export disabled",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    },
+                    "503": {
+                        "description": "first bundle not built yet, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/opa/groups/{isid}": {
+            "get": {
+                "description": "This will return every group of the user, direct and nested, as a bare JSON object for OPA http.send.\nUnknown users are answered with found false and no groups, so policies need no error handling.\nCache-Control allows caching the answer for CACHE_TTL seconds, honoured by http.send with cache enabled.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "OpaUserGroups",
                 "parameters": [
                     {
                         "type": "string",
@@ -50,11 +414,40 @@ const docTemplate = `{
                         "name": "isid",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "true or false",
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.OpaUserGroups"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/ready": {
+            "get": {
+                "description": "Ready as long as at least one ldap server circuit breaker lets requests through",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Readiness Endpoint",
+                "responses": {
+                    "200": {
+                        "description": "circuit breaker states",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "circuit breaker states",
                         "schema": {
                             "type": "string"
                         }
@@ -62,22 +455,1128 @@ const docTemplate = `{
                 }
             }
         },
-        "/v1/usercount": {
+        "/v1/resolve": {
             "get": {
-                "description": "This will return number of users in the group",
+                "description": "This will resolve a mail, userPrincipalName, employeeID or proxyAddresses value to the isid of the account.\nA single match is answered with 200, several with 409 and none with 404, every match is listed.\nWith directory=* every directory is searched, matches in several directories are ambiguous.\nOn v2 the result is answered alone and failures as problem details, not_found and ambiguous ones with the result as data.",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "UserGroupCount",
+                "summary": "Resolve",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Identifier type: mail, upn, employee_id or proxy_address",
+                        "name": "by",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Identifier value, proxy addresses without a prefix are smtp addresses",
+                        "name": "value",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.ResolveResult"
+                        }
+                    },
+                    "404": {
+                        "description": "no such user, data is the not_found result",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    },
+                    "409": {
+                        "description": "several users, data is the ambiguous result",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/status": {
+            "get": {
+                "description": "This return API status",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "HealthCheck Endpoint",
                 "responses": {
                     "200": {
-                        "description": "success or failure",
+                        "description": "api pid and ldap status",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
             }
+        },
+        "/v1/usercheck/{isid}": {
+            "get": {
+                "description": "This will validate if user is part of the group.\nWith directory=* every directory is searched and the one that matched is reported.\nDisabled, locked and expired accounts are answered as non-members when INACTIVE_NOT_MEMBER is set.\nWith by= the user is identified by mail, upn, employee_id or proxy_address instead, an identifier\nmatching several users is answered with 409.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UserCheck",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User isid, or the identifier of type by",
+                        "name": "isid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Identifier type: isid (default), mail, upn, employee_id or proxy_address",
+                        "name": "by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Answer with the account state and directory",
+                        "name": "verbose",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "verbose or fanned out answer",
+                        "schema": {
+                            "$ref": "#/definitions/model.UserCheckResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/usercount": {
+            "get": {
+                "description": "This will return number of users in the group.\nWith directory=* the group of every directory is counted at once, with a breakdown per directory\nand the directories that could not be counted under errors.\nWith breakdown=true every member account is read to count active and inactive members.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UserGroupCount",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count active and inactive (disabled, locked, expired) members apart",
+                        "name": "breakdown",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "breakdown answer",
+                        "schema": {
+                            "$ref": "#/definitions/model.MemberCount"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/{isid}": {
+            "get": {
+                "description": "This will return the profile of the user: names, mail, department, title, manager, account state and groups.\nOnly the attributes of the PROFILE_ATTRIBUTES allow-list are returned, groups only when it lists \"groups\".\nWith directory=* the first directory, in configuration order, where the user exists answers.\nOn v2 the profile is answered alone and failures as problem details.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UserProfile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User isid",
+                        "name": "isid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also list the groups the user is a member of through nested groups",
+                        "name": "nested",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.UserProfile"
+                        }
+                    },
+                    "404": {
+                        "description": "no such user",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/authenticate": {
+            "post": {
+                "description": "This will verify the password of the user by binding as the user, then check the user is part of the group.\nWrong credentials and unknown users are answered with 401, the decision is in data either way.\nRepeated failures for the same user or from the same client ip are refused with 429 for a time\ndoubling with every failure. The password is never logged.\nOn v2 the result is answered alone and failures as problem details.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Authenticate",
+                "parameters": [
+                    {
+                        "description": "User isid and password",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.Authenticate"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "authenticated, authorized tells whether the user is a member",
+                        "schema": {
+                            "$ref": "#/definitions/model.AuthenticateResult"
+                        }
+                    },
+                    "401": {
+                        "description": "wrong credentials",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    },
+                    "429": {
+                        "description": "too many failures, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/members": {
+            "get": {
+                "description": "This will list the user members of the group, sorted by isid, with their account state.\nWith directory=* the members of the group of every directory are listed, directory by directory.\nThe whole listing is read before it is answered, as json, CSV or NDJSON flushed row by row,\nor text with one isid per line, by Accept or format=.",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/x-ndjson",
+                    "text/plain"
+                ],
+                "summary": "Members",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Leave disabled, locked and expired accounts out",
+                        "name": "active_only",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Answer format instead of Accept: json, csv, ndjson or text",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Member"
+                            }
+                        }
+                    },
+                    "406": {
+                        "description": "no acceptable format",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/ready": {
+            "get": {
+                "description": "Ready as long as at least one ldap server circuit breaker lets requests through",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Readiness Endpoint v2",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Readiness"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.Readiness"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/resolve": {
+            "get": {
+                "description": "This will resolve a mail, userPrincipalName, employeeID or proxyAddresses value to the isid of the account.\nA single match is answered with 200, several with 409 and none with 404, every match is listed.\nWith directory=* every directory is searched, matches in several directories are ambiguous.\nOn v2 the result is answered alone and failures as problem details, not_found and ambiguous ones with the result as data.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Resolve",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Identifier type: mail, upn, employee_id or proxy_address",
+                        "name": "by",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Identifier value, proxy addresses without a prefix are smtp addresses",
+                        "name": "value",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.ResolveResult"
+                        }
+                    },
+                    "404": {
+                        "description": "no such user, data is the not_found result",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    },
+                    "409": {
+                        "description": "several users, data is the ambiguous result",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/status": {
+            "get": {
+                "description": "This return API status: readiness, every directory up or down, the circuit breakers and certificate expiries",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "HealthCheck Endpoint v2",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.ServiceStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/usercheck/{isid}": {
+            "get": {
+                "description": "This will validate if user is part of the group, answered with a typed membership: a boolean member,\nthe groups matched, the account state, where the answer came from and when.\nDisabled, locked and expired accounts are not members when INACTIVE_NOT_MEMBER is set.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UserCheck v2",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User isid, or the identifier of type by",
+                        "name": "isid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Identifier type: isid (default), mail, upn, employee_id or proxy_address",
+                        "name": "by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Membership"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/model.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/usercount": {
+            "get": {
+                "description": "This will return the number of members of the group of every directory selected, with the group name.\nWith breakdown=true every member account is read to count active and inactive members.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UserGroupCount v2",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count active and inactive (disabled, locked, expired) members apart",
+                        "name": "breakdown",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.GroupCount"
+                        }
+                    },
+                    "default": {
+                        "description": "",
+                        "schema": {
+                            "$ref": "#/definitions/model.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/users/{isid}": {
+            "get": {
+                "description": "This will return the profile of the user: names, mail, department, title, manager, account state and groups.\nOnly the attributes of the PROFILE_ATTRIBUTES allow-list are returned, groups only when it lists \"groups\".\nWith directory=* the first directory, in configuration order, where the user exists answers.\nOn v2 the profile is answered alone and failures as problem details.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "UserProfile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User isid",
+                        "name": "isid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Directory profile, default when omitted, * for every directory",
+                        "name": "directory",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also list the groups the user is a member of through nested groups",
+                        "name": "nested",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.UserProfile"
+                        }
+                    },
+                    "404": {
+                        "description": "no such user",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONFailureResult"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "model.Authenticate": {
+            "type": "object",
+            "properties": {
+                "isid": {
+                    "type": "string",
+                    "example": "bordeanu"
+                },
+                "password": {
+                    "type": "string",
+                    "example": "secret"
+                }
+            }
+        },
+        "model.AuthenticateResult": {
+            "type": "object",
+            "properties": {
+                "account_reasons": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "account_state": {
+                    "type": "string",
+                    "example": "active"
+                },
+                "authenticated": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "authorized": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "directory": {
+                    "type": "string",
+                    "example": "default"
+                }
+            }
+        },
+        "model.DirectoryGroupCount": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "integer",
+                    "example": 40
+                },
+                "group": {
+                    "type": "string",
+                    "example": "group.users"
+                },
+                "inactive": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
+        "model.GroupCount": {
+            "type": "object",
+            "properties": {
+                "counted_at": {
+                    "type": "string"
+                },
+                "directories": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/model.DirectoryGroupCount"
+                    }
+                },
+                "errors": {
+                    "description": "Errors are the directories that could not be counted, they are left out of the total",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
+        "model.GroupRef": {
+            "type": "object",
+            "properties": {
+                "dn": {
+                    "type": "string",
+                    "example": "CN=app-users,OU=Groups,DC=example,DC=com"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "app-users"
+                }
+            }
+        },
+        "model.JSONFailureResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer",
+                    "example": 400
+                },
+                "data": {},
+                "error": {
+                    "type": "string",
+                    "example": "There was an error processing the request"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "705e4dcb-3ecd-24f3-3a35-3e926e4bded5"
+                },
+                "stacktrace": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.Member": {
+            "type": "object",
+            "properties": {
+                "account_state": {
+                    "type": "string",
+                    "example": "active"
+                },
+                "directory": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "isid": {
+                    "type": "string",
+                    "example": "bordeanu"
+                }
+            }
+        },
+        "model.MemberCount": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "integer",
+                    "example": 40
+                },
+                "inactive": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
+        "model.Membership": {
+            "type": "object",
+            "properties": {
+                "account_reasons": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "account_state": {
+                    "type": "string",
+                    "example": "active"
+                },
+                "answered_at": {
+                    "type": "string"
+                },
+                "checked_at": {
+                    "type": "string"
+                },
+                "directory": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "found": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "isid": {
+                    "type": "string",
+                    "example": "bordeanu"
+                },
+                "matched_groups": {
+                    "description": "MatchedGroups are the checked groups the user is a member of, none when not a member",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "group.users"
+                    ]
+                },
+                "member": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "source": {
+                    "description": "Source is live, cache or snapshot, AnsweredAt when the directory gave the answer",
+                    "type": "string",
+                    "example": "live"
+                },
+                "user": {
+                    "description": "User is the identifier asked for, Isid the login of the user found",
+                    "type": "string",
+                    "example": "bordeanu"
+                }
+            }
+        },
+        "model.NonResourceAttributes": {
+            "type": "object",
+            "properties": {
+                "path": {
+                    "type": "string",
+                    "example": "/healthz"
+                },
+                "verb": {
+                    "type": "string",
+                    "example": "get"
+                }
+            }
+        },
+        "model.OpaUserGroups": {
+            "type": "object",
+            "properties": {
+                "account_state": {
+                    "type": "string",
+                    "example": "active"
+                },
+                "active": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "directory": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "found": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "user": {
+                    "type": "string",
+                    "example": "bordeanu"
+                }
+            }
+        },
+        "model.Problem": {
+            "type": "object",
+            "properties": {
+                "correlation_id": {
+                    "type": "string",
+                    "example": "705e4dcb-3ecd-24f3-3a35-3e926e4bded5"
+                },
+                "data": {},
+                "detail": {
+                    "description": "Detail is only sent in development mode",
+                    "type": "string",
+                    "example": "user bordeanu not found"
+                },
+                "instance": {
+                    "type": "string",
+                    "example": "/api/v2/users/bordeanu"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 404
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Not Found"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "about:blank"
+                }
+            }
+        },
+        "model.Readiness": {
+            "type": "object",
+            "properties": {
+                "breakers": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "checked_at": {
+                    "type": "string"
+                },
+                "ready": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "model.ResolveResult": {
+            "type": "object",
+            "properties": {
+                "directory": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "isid": {
+                    "type": "string",
+                    "example": "bordeanu"
+                },
+                "matches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.ResolvedUser"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "found"
+                }
+            }
+        },
+        "model.ResolvedUser": {
+            "type": "object",
+            "properties": {
+                "directory": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "dn": {
+                    "type": "string",
+                    "example": "CN=Dan Bordeanu,OU=People,DC=example,DC=com"
+                },
+                "isid": {
+                    "type": "string",
+                    "example": "bordeanu"
+                },
+                "mail": {
+                    "type": "string",
+                    "example": "dan.bordeanu@example.com"
+                },
+                "user_principal_name": {
+                    "type": "string",
+                    "example": "bordeanu@example.com"
+                }
+            }
+        },
+        "model.ResourceAttributes": {
+            "type": "object",
+            "properties": {
+                "group": {
+                    "type": "string",
+                    "example": "apps"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "namespace": {
+                    "type": "string",
+                    "example": "team-a"
+                },
+                "resource": {
+                    "type": "string",
+                    "example": "deployments"
+                },
+                "subresource": {
+                    "type": "string"
+                },
+                "verb": {
+                    "type": "string",
+                    "example": "get"
+                },
+                "version": {
+                    "type": "string",
+                    "example": "v1"
+                }
+            }
+        },
+        "model.ServiceStatus": {
+            "type": "object",
+            "properties": {
+                "breakers": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "certificate_expiry": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "checked_at": {
+                    "type": "string"
+                },
+                "directories": {
+                    "description": "Directories are up or down, by name, the default one included",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "pid": {
+                    "type": "integer",
+                    "example": 4242
+                },
+                "ready": {
+                    "description": "Ready is false when every ldap server circuit breaker is open",
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "model.SubjectAccessReview": {
+            "type": "object",
+            "properties": {
+                "apiVersion": {
+                    "type": "string",
+                    "example": "authorization.k8s.io/v1"
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "SubjectAccessReview"
+                },
+                "spec": {
+                    "$ref": "#/definitions/model.SubjectAccessReviewSpec"
+                },
+                "status": {
+                    "$ref": "#/definitions/model.SubjectAccessReviewStatus"
+                }
+            }
+        },
+        "model.SubjectAccessReviewSpec": {
+            "type": "object",
+            "properties": {
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "nonResourceAttributes": {
+                    "$ref": "#/definitions/model.NonResourceAttributes"
+                },
+                "resourceAttributes": {
+                    "$ref": "#/definitions/model.ResourceAttributes"
+                },
+                "uid": {
+                    "type": "string"
+                },
+                "user": {
+                    "type": "string",
+                    "example": "oidc:bordeanu"
+                }
+            }
+        },
+        "model.SubjectAccessReviewStatus": {
+            "type": "object",
+            "properties": {
+                "allowed": {
+                    "type": "boolean"
+                },
+                "denied": {
+                    "type": "boolean"
+                },
+                "evaluationError": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.UserCheckResult": {
+            "type": "object",
+            "properties": {
+                "account_reasons": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "account_state": {
+                    "type": "string",
+                    "example": "active"
+                },
+                "directory": {
+                    "type": "string",
+                    "example": "contractors"
+                },
+                "isid": {
+                    "description": "Isid is the login of the user when it was identified by another identifier type",
+                    "type": "string",
+                    "example": "bordeanu"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "true"
+                }
+            }
+        },
+        "model.UserProfile": {
+            "type": "object",
+            "properties": {
+                "account_reasons": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "account_state": {
+                    "type": "string",
+                    "example": "active"
+                },
+                "attributes": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
+                },
+                "department": {
+                    "type": "string",
+                    "example": "Engineering"
+                },
+                "directory": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "display_name": {
+                    "type": "string",
+                    "example": "Dan Bordeanu"
+                },
+                "dn": {
+                    "type": "string",
+                    "example": "CN=Dan Bordeanu,OU=People,DC=example,DC=com"
+                },
+                "given_name": {
+                    "type": "string",
+                    "example": "Dan"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.GroupRef"
+                    }
+                },
+                "isid": {
+                    "type": "string",
+                    "example": "bordeanu"
+                },
+                "mail": {
+                    "type": "string",
+                    "example": "dan.bordeanu@example.com"
+                },
+                "manager": {
+                    "type": "string",
+                    "example": "CN=Jane Doe,OU=People,DC=example,DC=com"
+                },
+                "nested_groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.GroupRef"
+                    }
+                },
+                "surname": {
+                    "type": "string",
+                    "example": "Bordeanu"
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Developer"
+                }
+            }
+        },
+        "model.WebhookReplay": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "description": "Events is the number of events posted again, summed over the urls",
+                    "type": "integer",
+                    "example": 2
+                },
+                "since": {
+                    "type": "integer",
+                    "example": 40
+                },
+                "urls": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.WebhookUrlReplay"
+                    }
+                }
+            }
+        },
+        "model.WebhookUrlReplay": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "since": {
+                    "type": "integer",
+                    "example": 40
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://provisioning.example.com/hooks"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,227 @@
+package extauthz
+
+import (
+	"context"
+	"errors"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"strings"
+	"time"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/grpcapi"
+	"user-check/ldapcheck"
+	"user-check/policy"
+	"user-check/utils/logger"
+)
+
+const (
+	// Endpoint is the name ext_authz checks are audited and granted under
+	Endpoint = policy.EndpointExtAuthz
+	// JwtAuthnFilter is the Envoy filter whose metadata holds the verified JWT payloads
+	JwtAuthnFilter = "envoy.filters.http.jwt_authn"
+	// GroupsExtension is the per route context extension listing the required groups
+	GroupsExtension = "groups"
+	// DirectoryExtension is the per route context extension naming the directory, "*" for every directory
+	DirectoryExtension = "directory"
+)
+
+// Checker decide whether user is a member of one of the groups required in each directory
+type Checker func(ctx context.Context, directories []string, user string, groups func(directory string) []string) (ldapcheck.GroupDecision, error)
+
+// LdapChecker check against the directories with ldapcheck
+func LdapChecker(ctx context.Context, directories []string, user string, groups func(directory string) []string) (ldapcheck.GroupDecision, error) {
+	return ldapcheck.MemberOfAny(ctx, directories, user, groups, configuration.AppConfig().InactiveNotMember)
+}
+
+// Server implements the Envoy envoy.service.auth.v3.Authorization service
+type Server struct {
+	authv3.UnimplementedAuthorizationServer
+	check Checker
+}
+
+// NewServer return an authorization server deciding with check
+func NewServer(check Checker) *Server {
+	return &Server{check: check}
+}
+
+// Check answer an Envoy authorization request. The principal is read, when EXTAUTHZ_JWT_CLAIM is set, from the
+// payload the jwt_authn filter verified, or else from the configured header. The required groups come from
+// the groups context extension of the route, or else the configured group. Envoy, the caller of the check,
+// must be granted the ext_authz endpoint for them by the caller policy.
+// Denials and failures are answers, not gRPC errors, so Envoy applies them whatever its failure mode.
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	conf := configuration.AppConfig()
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	headers := httpReq.GetHeaders()
+	extensions := req.GetAttributes().GetContextExtensions()
+
+	caller, callerAuth := grpcapi.Caller(ctx)
+	record := &audit.Record{
+		Time:          time.Now().UTC(),
+		CorrelationId: headers["x-request-id"],
+		Caller:        caller,
+		CallerAuth:    callerAuth,
+		Endpoint:      Endpoint,
+		Decision:      audit.DecisionError,
+	}
+	defer func() {
+		record.LatencyMs = float64(time.Since(record.Time).Microseconds()) / 1000
+		audit.Log(*record)
+	}()
+	log := logger.SugaredLogger().WithCorrelationId(record.CorrelationId).With("package", "extauthz", "action", "check request")
+
+	user := principal(req, conf)
+	if user == "" {
+		log.Debugf("no principal in request to %s", httpReq.GetPath())
+		record.Decision = audit.DecisionDenied
+		record.Error = "no principal"
+		return denied(codes.Unauthenticated, typev3.StatusCode_Unauthorized), nil
+	}
+	record.Isid = user
+
+	directories := []string{configuration.DefaultDirectory}
+	switch name := extensions[DirectoryExtension]; {
+	case name == configuration.AllDirectories:
+		directories = conf.DirectoryNames()
+	case name != "":
+		if _, ok := conf.Directory(name); !ok {
+			log.Errorf("unknown directory %s in route context extensions", name)
+			record.Error = "unknown directory " + name
+			return denied(codes.InvalidArgument, typev3.StatusCode_InternalServerError), nil
+		}
+		directories = []string{name}
+	}
+
+	// only the route configuration names groups, the downstream could send any header
+	required := splitGroups(extensions[GroupsExtension])
+	groups := func(directory string) []string {
+		if len(required) > 0 {
+			return required
+		}
+		d, _ := conf.Directory(directory)
+		return []string{d.OncoGroup}
+	}
+	for _, directory := range directories {
+		record.Groups = append(record.Groups, groups(directory)...)
+	}
+	directories, err := authorize(caller, directories, groups, extensions[DirectoryExtension] == configuration.AllDirectories)
+	if err != nil {
+		log.Warnf("request denied: %s", err)
+		record.Decision = audit.DecisionDenied
+		record.Error = err.Error()
+		return denied(codes.PermissionDenied, typev3.StatusCode_Forbidden), nil
+	}
+
+	decision, err := s.check(ctx, directories, user, groups)
+	record.Directory = decision.Directory
+	if err != nil {
+		log.Errorf("check of %s failed: %v", user, err)
+		record.Error = err.Error()
+		if errors.Is(err, ldapcheck.ErrProvider) {
+			return denied(codes.Internal, typev3.StatusCode_InternalServerError), nil
+		}
+		return denied(codes.Unavailable, typev3.StatusCode_ServiceUnavailable), nil
+	}
+	record.Source = decision.Source
+	record.AccountState = decision.Account.State
+	if !decision.Allowed() {
+		log.Infof("%s is not a member of %v", user, record.Groups)
+		record.Decision = audit.DecisionNotMember
+		return denied(codes.PermissionDenied, typev3.StatusCode_Forbidden), nil
+	}
+
+	log.Infof("%s is a member of %v in directory %s", user, decision.Groups, decision.Directory)
+	record.Decision = audit.DecisionMember
+	record.Membership = audit.MembershipOf(decision.Nested)
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{
+			Headers: []*corev3.HeaderValueOption{
+				header("x-auth-user", decision.User),
+				header("x-auth-groups", strings.Join(decision.Groups, ",")),
+			},
+		}},
+	}, nil
+}
+
+// authorize enforce the caller policy like the Authorize middleware: a check of every directory goes on with the
+// directories the caller may query, others are refused
+func authorize(caller string, directories []string, groups func(directory string) []string, fanOut bool) ([]string, error) {
+	p := policy.Current()
+	if p == nil {
+		return directories, nil
+	}
+	var (
+		allowed []string
+		err     error
+	)
+	for _, directory := range directories {
+		if dirErr := p.AuthorizeIn(caller, Endpoint, directory, groups(directory)); dirErr != nil {
+			if err == nil {
+				err = dirErr
+			}
+			continue
+		}
+		allowed = append(allowed, directory)
+	}
+	switch {
+	case err == nil, fanOut && len(allowed) > 0:
+		return allowed, nil
+	case p.DryRun || configuration.AppConfig().PolicyDryRun:
+		logger.SugaredLogger().With("package", "extauthz", "action", "authorize caller").Warnf("dry run, would deny request: %s", err)
+		return directories, nil
+	}
+	return nil, err
+}
+
+// principal read the user of the request. With a JWT claim configured it is that claim of a payload jwt_authn
+// verified and nothing else, otherwise the user header when one is configured.
+func principal(req *authv3.CheckRequest, conf *configuration.Configuration) string {
+	if conf.ExtAuthzJwtClaim != "" {
+		// jwt_authn stores each verified payload under its payload_in_metadata name
+		payloads := req.GetAttributes().GetMetadataContext().GetFilterMetadata()[JwtAuthnFilter]
+		for _, payload := range payloads.GetFields() {
+			if claim := payload.GetStructValue().GetFields()[conf.ExtAuthzJwtClaim].GetStringValue(); claim != "" {
+				return claim
+			}
+		}
+		return ""
+	}
+	if conf.ExtAuthzUserHeader == "" {
+		return ""
+	}
+	return strings.TrimSpace(req.GetAttributes().GetRequest().GetHttp().GetHeaders()[strings.ToLower(conf.ExtAuthzUserHeader)])
+}
+
+// splitGroups split a comma separated list of groups
+func splitGroups(value string) []string {
+	var groups []string
+	for _, group := range strings.Split(value, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// denied return a denial with the gRPC code and the HTTP status Envoy answers the downstream with
+func denied(code codes.Code, httpStatus typev3.StatusCode) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(code)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: httpStatus},
+		}},
+	}
+}
+
+// header return a header overwriting any value the downstream sent, so it can't be spoofed
+func header(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
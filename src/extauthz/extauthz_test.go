@@ -0,0 +1,198 @@
+package extauthz
+
+import (
+	"context"
+	"errors"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+	"net"
+	"os"
+	"testing"
+	"user-check/configuration"
+	"user-check/grpcapi"
+	"user-check/ldapcheck"
+	"user-check/policy"
+	"user-check/ratelimit"
+	"user-check/utils/logger"
+)
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+// fakeDirectory stands in for ldap: users and the groups they are members of
+type fakeDirectory map[string][]string
+
+func (f fakeDirectory) check(ctx context.Context, directories []string, user string, groups func(directory string) []string) (ldapcheck.GroupDecision, error) {
+	if user == "broken" {
+		return ldapcheck.GroupDecision{Directory: directories[0]}, errors.New("ldap server down")
+	}
+	memberOf, ok := f[user]
+	if !ok {
+		return ldapcheck.GroupDecision{}, nil
+	}
+	decision := ldapcheck.GroupDecision{Found: true, Directory: directories[0], User: user}
+	for _, required := range groups(directories[0]) {
+		for _, group := range memberOf {
+			if group == required {
+				decision.Groups = append(decision.Groups, group)
+			}
+		}
+	}
+	return decision, nil
+}
+
+// client start the server on an in-memory listener and return an Envoy-style client
+func client(t *testing.T, check Checker) authv3.AuthorizationClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(grpcapi.UnaryInterceptor))
+	authv3.RegisterAuthorizationServer(srv, NewServer(check))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return authv3.NewAuthorizationClient(conn)
+}
+
+// checkRequest build the request Envoy sends for an http request with headers
+func checkRequest(headers map[string]string, extensions map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Method: "GET", Path: "/app", Headers: headers,
+		}},
+		ContextExtensions: extensions,
+	}}
+}
+
+func TestCheck(t *testing.T) {
+	Convey(`Feature: Envoy ext_authz checks`, t, func() {
+		os.Setenv("EXTAUTHZ_USER_HEADER", "x-forwarded-user")
+		defer os.Unsetenv("EXTAUTHZ_USER_HEADER")
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+		c := client(t, fakeDirectory{"bordeanu": {"app-users", "group.users"}}.check)
+		ctx := context.Background()
+
+		Convey("A member is allowed with the user and groups headers", func() {
+			res, err := c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"}, nil))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.OK))
+			headers := map[string]string{}
+			for _, h := range res.GetOkResponse().GetHeaders() {
+				headers[h.GetHeader().GetKey()] = h.GetHeader().GetValue()
+				So(h.GetAppendAction(), ShouldEqual, corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD)
+			}
+			So(headers["x-auth-user"], ShouldEqual, "bordeanu")
+			So(headers["x-auth-groups"], ShouldEqual, configuration.AppConfig().OncoGroup)
+		})
+		Convey("The route context extensions name the required groups", func() {
+			res, err := c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"},
+				map[string]string{GroupsExtension: "admins, app-users"}))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.OK))
+
+			res, err = c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"},
+				map[string]string{GroupsExtension: "admins"}))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.PermissionDenied))
+			So(res.GetDeniedResponse().GetStatus().GetCode(), ShouldEqual, typev3.StatusCode_Forbidden)
+		})
+		Convey("Request headers can't name the required groups", func() {
+			c := client(t, fakeDirectory{"martih": {"app-users"}}.check)
+			res, err := c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "martih", "x-required-groups": "app-users"}, nil))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.PermissionDenied))
+		})
+		Convey("The verified JWT claim is the principal, the user header is then ignored", func() {
+			os.Setenv("EXTAUTHZ_JWT_CLAIM", "preferred_username")
+			defer os.Unsetenv("EXTAUTHZ_JWT_CLAIM")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+
+			req := checkRequest(map[string]string{"x-forwarded-user": "someone-else"}, nil)
+			payload, _ := structpb.NewStruct(map[string]interface{}{"sub": "1234", "preferred_username": "bordeanu"})
+			req.Attributes.MetadataContext = &corev3.Metadata{FilterMetadata: map[string]*structpb.Struct{
+				JwtAuthnFilter: {Fields: map[string]*structpb.Value{"jwt_payload": structpb.NewStructValue(payload)}},
+			}}
+			res, err := c.Check(ctx, req)
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.OK))
+
+			// a request jwt_authn let through without a token
+			res, err = c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"}, nil))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.Unauthenticated))
+		})
+		Convey("The user header is only read when configured", func() {
+			os.Unsetenv("EXTAUTHZ_USER_HEADER")
+			os.Setenv("EXTAUTHZ_JWT_CLAIM", "preferred_username")
+			defer os.Unsetenv("EXTAUTHZ_JWT_CLAIM")
+			conf, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			So(conf.ExtAuthzUserHeader, ShouldBeEmpty)
+		})
+		Convey("Envoy must be granted the ext_authz endpoint for the groups", func() {
+			policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+				policy.AnyCaller: {Groups: []string{"app-*"}, Endpoints: []string{"ext_authz"}},
+			}})
+			defer policy.Set(nil)
+			res, err := c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"}, nil))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.PermissionDenied))
+
+			res, err = c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"},
+				map[string]string{GroupsExtension: "app-users"}))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.OK))
+		})
+		Convey("Without principal the request is unauthenticated", func() {
+			res, err := c.Check(ctx, checkRequest(nil, nil))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.Unauthenticated))
+			So(res.GetDeniedResponse().GetStatus().GetCode(), ShouldEqual, typev3.StatusCode_Unauthorized)
+		})
+		Convey("Checks are rate limited like the gRPC api", func() {
+			os.Setenv("RATE_LIMIT_RPS", "1")
+			defer os.Unsetenv("RATE_LIMIT_RPS")
+			conf, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			ratelimit.Configure(conf)
+			defer ratelimit.Configure(&configuration.Configuration{})
+
+			_, err = c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"}, nil))
+			So(err, ShouldBeNil)
+			_, err = c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"}, nil))
+			So(grpcstatus.Code(err), ShouldEqual, codes.ResourceExhausted)
+		})
+		Convey("Unknown users are denied", func() {
+			res, err := c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "nobody"}, nil))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.PermissionDenied))
+		})
+		Convey("Directory failures are answered as unavailable", func() {
+			res, err := c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "broken"}, nil))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.Unavailable))
+			So(res.GetDeniedResponse().GetStatus().GetCode(), ShouldEqual, typev3.StatusCode_ServiceUnavailable)
+		})
+		Convey("Unknown directories are refused", func() {
+			res, err := c.Check(ctx, checkRequest(map[string]string{"x-forwarded-user": "bordeanu"},
+				map[string]string{DirectoryExtension: "nowhere"}))
+			So(err, ShouldBeNil)
+			So(res.GetStatus().GetCode(), ShouldEqual, int32(codes.InvalidArgument))
+		})
+	})
+}
@@ -0,0 +1,172 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/policy"
+	usercheckv1 "user-check/proto/usercheck/v1"
+	"user-check/ratelimit"
+	"user-check/utils/logger"
+)
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+// dial start the gRPC api on an in-memory listener and return a connection to it
+func dial(t *testing.T) *grpc.ClientConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(UnaryInterceptor))
+	Register(ctx, srv)
+	go srv.Serve(lis)
+	t.Cleanup(func() {
+		cancel()
+		srv.Stop()
+	})
+
+	conn, err := grpc.Dial("bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServer(t *testing.T) {
+	Convey(`Feature: the gRPC api`, t, func() {
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+		conn := dial(t)
+		client := usercheckv1.NewUserCheckServiceClient(conn)
+		ctx := context.Background()
+
+		Convey("The correlation id sent is sent back, a new one otherwise", func() {
+			var header metadata.MD
+			_, err := client.CheckMembership(metadata.AppendToOutgoingContext(ctx, "x-correlation-id", "req-42"),
+				&usercheckv1.CheckMembershipRequest{Isid: "bordeanu", By: "nickname"}, grpc.Header(&header))
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+			So(header.Get("x-correlation-id"), ShouldResemble, []string{"req-42"})
+
+			_, _ = client.CheckMembership(metadata.AppendToOutgoingContext(ctx, "x-correlation-id", "not safe"),
+				&usercheckv1.CheckMembershipRequest{Isid: "bordeanu", By: "nickname"}, grpc.Header(&header))
+			So(header.Get("x-correlation-id"), ShouldHaveLength, 1)
+			So(header.Get("x-correlation-id")[0], ShouldNotEqual, "not safe")
+		})
+		Convey("Invalid requests are refused before ldap is asked", func() {
+			_, err := client.CheckMembership(ctx, &usercheckv1.CheckMembershipRequest{})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+			_, err = client.CheckMembership(ctx, &usercheckv1.CheckMembershipRequest{Isid: "bordeanu", Directory: "nowhere"})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+			_, err = client.GetUser(ctx, &usercheckv1.GetUserRequest{})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+			_, err = client.BatchCheck(ctx, &usercheckv1.BatchCheckRequest{Isids: make([]string, MaxBatch+1)})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+		Convey("The caller policy applies as on the REST api", func() {
+			policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+				"hr-portal": {Groups: []string{"hr.*"}, Endpoints: []string{"usercheck"}},
+			}})
+			defer policy.Set(nil)
+			os.Setenv("CALLER_ID_HEADER_TRUSTED", "true")
+			defer os.Unsetenv("CALLER_ID_HEADER_TRUSTED")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			caller := metadata.AppendToOutgoingContext(ctx, "x-caller-id", "hr-portal")
+
+			_, err = client.CheckMembership(caller, &usercheckv1.CheckMembershipRequest{Isid: "bordeanu"})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			_, err = client.ListMembers(caller, &usercheckv1.ListMembersRequest{})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			_, err = client.GetUser(ctx, &usercheckv1.GetUserRequest{Isid: "bordeanu"})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+		})
+		Convey("Calls are rate limited like on the REST api, a batch takes a token per user", func() {
+			os.Setenv("RATE_LIMIT_RPS", "1")
+			os.Setenv("RATE_LIMIT_BURST", "3")
+			defer os.Unsetenv("RATE_LIMIT_RPS")
+			defer os.Unsetenv("RATE_LIMIT_BURST")
+			conf, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			ratelimit.Configure(conf)
+			defer ratelimit.Configure(&configuration.Configuration{})
+
+			_, err = client.BatchCheck(ctx, &usercheckv1.BatchCheckRequest{Isids: []string{"bordeanu", "martih"}, By: "nickname"})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+			var header metadata.MD
+			_, err = client.BatchCheck(ctx, &usercheckv1.BatchCheckRequest{Isids: []string{"bordeanu", "martih"}, By: "nickname"},
+				grpc.Header(&header))
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+			So(header.Get("retry-after"), ShouldNotBeEmpty)
+			_, err = client.CheckMembership(ctx, &usercheckv1.CheckMembershipRequest{Isid: "bordeanu", By: "nickname"})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+		Convey("Member lists and counts are audited like on the REST api", func() {
+			os.Setenv("LDAP_ADDR", "ldap://127.0.0.1:1")
+			defer os.Unsetenv("LDAP_ADDR")
+			_, err := configuration.Load(nil)
+			So(err, ShouldBeNil)
+			file := filepath.Join(t.TempDir(), "audit.log")
+			So(audit.Init(audit.Options{Output: file}), ShouldBeNil)
+			defer audit.Init(audit.Options{})
+
+			_, err = client.ListMembers(ctx, &usercheckv1.ListMembersRequest{})
+			So(status.Code(err), ShouldEqual, codes.Unavailable)
+			_, err = client.CountMembers(ctx, &usercheckv1.CountMembersRequest{})
+			So(status.Code(err), ShouldEqual, codes.Unavailable)
+
+			data, err := ioutil.ReadFile(file)
+			So(err, ShouldBeNil)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			So(lines, ShouldHaveLength, 2)
+			for i, endpoint := range []string{policy.EndpointMembers, policy.EndpointUserCount} {
+				var rec audit.Record
+				So(json.Unmarshal([]byte(lines[i]), &rec), ShouldBeNil)
+				So(rec.Endpoint, ShouldEqual, endpoint)
+				So(rec.Directory, ShouldEqual, configuration.DefaultDirectory)
+				So(rec.Groups, ShouldResemble, []string{configuration.AppConfig().OncoGroup})
+				So(rec.Decision, ShouldEqual, audit.DecisionError)
+				So(rec.Error, ShouldNotBeEmpty)
+			}
+		})
+		Convey("Health needs the health endpoint when a policy is loaded", func() {
+			policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+				"hr-portal": {Groups: []string{"*"}, Endpoints: []string{"usercheck"}},
+			}})
+			defer policy.Set(nil)
+			_, err := client.Health(ctx, &usercheckv1.HealthRequest{})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+
+			policy.Set(&policy.Policy{Callers: map[string]policy.Caller{
+				policy.AnyCaller: {Endpoints: []string{"health"}},
+			}})
+			res, err := client.Health(ctx, &usercheckv1.HealthRequest{})
+			So(err, ShouldBeNil)
+			So(res.GetDirectories(), ShouldContainKey, configuration.DefaultDirectory)
+		})
+		Convey("gRPC health checking follows the circuit breakers", func() {
+			res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+				Service: usercheckv1.UserCheckService_ServiceDesc.ServiceName,
+			})
+			So(err, ShouldBeNil)
+			So(res.GetStatus(), ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+		})
+	})
+}
@@ -0,0 +1,261 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/identity"
+	"user-check/ldapcheck"
+	"user-check/metrics"
+	"user-check/policy"
+	"user-check/ratelimit"
+	"user-check/utils"
+	"user-check/utils/logger"
+)
+
+// callerKey holds the caller of a call in its context
+type callerKey struct{}
+
+// caller is the identity of the client and how it was established
+type caller struct {
+	id   string
+	auth string
+}
+
+// correlationIdMetadata is the metadata carrying the correlation id, both ways
+var correlationIdMetadata = strings.ToLower(utils.CorrelationIdHeader)
+
+// UnaryInterceptor set up every call like the gin middlewares do for a request: the correlation id, kept from
+// the x-correlation-id metadata or made up and sent back, then the caller, by verified client certificate or
+// caller metadata when trusted, and last the rate limit.
+func UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	correlationId := utils.CorrelationId(first(md, correlationIdMetadata))
+	_ = grpc.SetHeader(ctx, metadata.Pairs(correlationIdMetadata, correlationId))
+	ctx = context.WithValue(ctx, configuration.CorrelationIdKey, correlationId)
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "identify caller")
+
+	c := caller{id: configuration.AnonymousCaller, auth: configuration.CallerAuthNone}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			cert := tlsInfo.State.VerifiedChains[0][0]
+			id, err := identity.FromCertificate(cert)
+			if err != nil {
+				log.Warnf("rejecting client certificate: %s", err)
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+			log.Debugf("caller %s identified by client certificate %s", id, cert.Subject)
+			c = caller{id: id, auth: configuration.CallerAuthMtls}
+		}
+	}
+	if c.auth == configuration.CallerAuthNone {
+		conf := configuration.AppConfig()
+		if id := first(md, strings.ToLower(conf.CallerIdHeader)); id != "" && conf.CallerHeaderTrusted(peerIp(ctx)) {
+			c = caller{id: id, auth: configuration.CallerAuthHeader}
+		}
+	}
+	ctx = context.WithValue(ctx, callerKey{}, c)
+	if err := rateLimit(ctx, c, req); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res, err := handler(ctx, req)
+	logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "serve call").
+		Debugf("%s by %s answered %s in %s", info.FullMethod, c.id, status.Code(err), time.Since(start))
+	return res, err
+}
+
+// rateLimit refuse with ResourceExhausted callers exceeding their token bucket, like the RateLimit middleware.
+// A batch takes a token per user, as many as the single calls it stands for.
+func rateLimit(ctx context.Context, c caller, req interface{}) error {
+	limiter := ratelimit.Current()
+	if limiter == nil {
+		return nil
+	}
+	keyType, key := "ip", peerIp(ctx)
+	if c.auth == configuration.CallerAuthMtls {
+		keyType, key = "caller", c.id
+	}
+	cost := 1
+	if batch, ok := req.(interface{ GetIsids() []string }); ok && len(batch.GetIsids()) > 1 {
+		// larger batches are refused as invalid
+		cost = len(batch.GetIsids())
+		if cost > MaxBatch {
+			cost = MaxBatch
+		}
+	}
+	ok, wait := limiter.AllowN(keyType+":"+key, cost)
+	if ok {
+		return nil
+	}
+	logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "rate limit").
+		Warnf("rate limit exceeded for %s %s", keyType, key)
+	metrics.RateLimited.WithLabelValues(keyType).Inc()
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(wait.Seconds())))))
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s %s", keyType, key)
+}
+
+// first return the first value of the metadata key
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
+// peerIp return the ip address the call comes from, empty when unknown
+func peerIp(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// Caller return the identity of the client of a call going through UnaryInterceptor and how it was established
+func Caller(ctx context.Context) (string, string) {
+	c := callerOf(ctx)
+	return c.id, c.auth
+}
+
+// callerOf return the caller the interceptor identified
+func callerOf(ctx context.Context) caller {
+	if c, ok := ctx.Value(callerKey{}).(caller); ok {
+		return c
+	}
+	return caller{id: configuration.AnonymousCaller, auth: configuration.CallerAuthNone}
+}
+
+// correlationIdOf return the correlation id of the call
+func correlationIdOf(ctx context.Context) string {
+	id, _ := ctx.Value(configuration.CorrelationIdKey).(string)
+	return id
+}
+
+// selectDirectories return the directories a call asks for: the default one when empty, every one for "*".
+// The second value tells whether the call fans out.
+func selectDirectories(name string) ([]string, bool, error) {
+	conf := configuration.AppConfig()
+	switch name {
+	case "", configuration.DefaultDirectory:
+		return []string{configuration.DefaultDirectory}, false, nil
+	case configuration.AllDirectories:
+		return conf.DirectoryNames(), true, nil
+	}
+	if _, ok := conf.Directory(name); !ok {
+		return nil, false, status.Errorf(codes.InvalidArgument, "unknown directory %s", name)
+	}
+	return []string{name}, false, nil
+}
+
+// directoryGroups return the groups a call queries in directory
+func directoryGroups(directory string) []string {
+	d, _ := configuration.AppConfig().Directory(directory)
+	return []string{d.OncoGroup}
+}
+
+// selectedGroups return the configured groups of directories, the groups a call reading them is audited with
+func selectedGroups(directories []string) []string {
+	var groups []string
+	for _, directory := range directories {
+		groups = append(groups, directoryGroups(directory)...)
+	}
+	return groups
+}
+
+// authorize enforce the caller policy for endpoint like the Authorize middleware: a fanned out call goes on
+// with the directories the caller may query, others are refused with PermissionDenied.
+func authorize(ctx context.Context, endpoint string, directories []string, fanOut bool) ([]string, error) {
+	p := policy.Current()
+	if p == nil {
+		return directories, nil
+	}
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "authorize caller")
+
+	c := callerOf(ctx)
+	var (
+		allowed []string
+		err     error
+	)
+	for _, directory := range directories {
+		var groups []string
+		if policy.GroupScoped(endpoint) {
+			groups = directoryGroups(directory)
+		}
+		if dirErr := p.AuthorizeIn(c.id, endpoint, directory, groups); dirErr != nil {
+			if err == nil {
+				err = dirErr
+			}
+			continue
+		}
+		allowed = append(allowed, directory)
+	}
+	switch {
+	case err == nil:
+		return allowed, nil
+	case fanOut && len(allowed) > 0:
+		log.Debugf("fan out limited to directories %v: %s", allowed, err)
+		return allowed, nil
+	case p.DryRun || configuration.AppConfig().PolicyDryRun:
+		log.Warnf("dry run, would deny request: %s", err)
+		return directories, nil
+	}
+	log.Warnf("request denied: %s", err)
+	return nil, status.Error(codes.PermissionDenied, err.Error())
+}
+
+// auditRecord start the audit record of a call, the decision defaults to error until the call knows better
+func auditRecord(ctx context.Context, endpoint, isid string, groups []string) *audit.Record {
+	c := callerOf(ctx)
+	return &audit.Record{
+		Time:          time.Now().UTC(),
+		CorrelationId: correlationIdOf(ctx),
+		Caller:        c.id,
+		CallerAuth:    c.auth,
+		Endpoint:      endpoint,
+		Isid:          isid,
+		Groups:        groups,
+		Decision:      audit.DecisionError,
+	}
+}
+
+// logAudit write rec to the audit stream, measuring the latency from the record creation
+func logAudit(rec *audit.Record) {
+	rec.LatencyMs = float64(time.Since(rec.Time).Microseconds()) / 1000
+	audit.Log(*rec)
+}
+
+// ldapError turn a failed directory operation into a gRPC status like ldapFailure does for http. The details
+// of unexpected failures are only sent in development mode.
+func ldapError(err error) error {
+	switch {
+	case errors.Is(err, ldapcheck.ErrAmbiguous):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ldapcheck.ErrQueueTimeout), errors.Is(err, ldapcheck.ErrCircuitOpen):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	code := codes.Internal
+	if !errors.Is(err, ldapcheck.ErrProvider) {
+		code = codes.Unavailable
+	}
+	if !configuration.AppConfig().Development {
+		return status.Error(code, "directory lookup failed")
+	}
+	return status.Error(code, err.Error())
+}
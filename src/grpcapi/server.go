@@ -0,0 +1,382 @@
+package grpcapi
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+	"user-check/audit"
+	"user-check/breaker"
+	"user-check/configuration"
+	"user-check/ldapcheck"
+	"user-check/policy"
+	usercheckv1 "user-check/proto/usercheck/v1"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+const (
+	// MaxBatch is the most users a BatchCheck may check
+	MaxBatch = 100
+	// healthInterval the gRPC health status follows the circuit breakers this often
+	healthInterval = 5 * time.Second
+)
+
+// Server implements the usercheck.v1.UserCheckService on top of ldapcheck, like the gin handlers
+type Server struct {
+	usercheckv1.UnimplementedUserCheckServiceServer
+}
+
+// NewServer return the gRPC service
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Register add the service, gRPC health checking and, when enabled, server reflection to srv.
+// The health status is SERVING while at least one ldap server can be tried, until ctx is done.
+func Register(ctx context.Context, srv *grpc.Server) {
+	usercheckv1.RegisterUserCheckServiceServer(srv, NewServer())
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(srv, healthServer)
+	concurrency.GlobalWaitGroup.Add(1)
+	go func() {
+		defer concurrency.GlobalWaitGroup.Done()
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+		for {
+			serving := healthpb.HealthCheckResponse_SERVING
+			if !breaker.Ready() {
+				serving = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+			healthServer.SetServingStatus("", serving)
+			healthServer.SetServingStatus(usercheckv1.UserCheckService_ServiceDesc.ServiceName, serving)
+			select {
+			case <-ctx.Done():
+				healthServer.Shutdown()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	if configuration.AppConfig().GrpcReflection {
+		reflection.Register(srv)
+	}
+}
+
+// CheckMembership tell whether the user is a member of the configured group, like GET /v1/usercheck
+func (s *Server) CheckMembership(ctx context.Context, req *usercheckv1.CheckMembershipRequest) (*usercheckv1.CheckMembershipResponse, error) {
+	directories, by, err := s.checkArguments(ctx, req.GetBy(), req.GetDirectory(), []string{req.GetIsid()})
+	if err != nil {
+		return nil, err
+	}
+	res, err := check(ctx, directories, by, req.GetIsid())
+	if err != nil {
+		return nil, ldapError(err)
+	}
+	return res, nil
+}
+
+// BatchCheck check several users, each one answered or failed on its own
+func (s *Server) BatchCheck(ctx context.Context, req *usercheckv1.BatchCheckRequest) (*usercheckv1.BatchCheckResponse, error) {
+	if len(req.GetIsids()) > MaxBatch {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d users can be checked at once", MaxBatch)
+	}
+	directories, by, err := s.checkArguments(ctx, req.GetBy(), req.GetDirectory(), req.GetIsids())
+	if err != nil {
+		return nil, err
+	}
+	res := &usercheckv1.BatchCheckResponse{Results: make([]*usercheckv1.BatchCheckResult, 0, len(req.GetIsids()))}
+	for _, isid := range req.GetIsids() {
+		result := &usercheckv1.BatchCheckResult{Identifier: isid}
+		if result.Result, err = check(ctx, directories, by, isid); err != nil {
+			result.Result = nil
+			result.Error = status.Convert(ldapError(err)).Message()
+		}
+		res.Results = append(res.Results, result)
+	}
+	return res, nil
+}
+
+// checkArguments validate the identifiers and type, then pick the directories the caller may check
+func (s *Server) checkArguments(ctx context.Context, by, directory string, identifiers []string) ([]string, string, error) {
+	if by == "" {
+		by = ldapcheck.ByIsid
+	}
+	if err := ldapcheck.ValidIdentifierType(by); err != nil {
+		return nil, by, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, identifier := range identifiers {
+		if strings.TrimSpace(identifier) == "" {
+			return nil, by, status.Error(codes.InvalidArgument, "isid is required")
+		}
+	}
+	directories, fanOut, err := selectDirectories(directory)
+	if err != nil {
+		return nil, by, err
+	}
+	directories, err = authorize(ctx, policy.EndpointUserCheck, directories, fanOut)
+	return directories, by, err
+}
+
+// check look one user up in the directories and audit the answer
+func check(ctx context.Context, directories []string, by, isid string) (*usercheckv1.CheckMembershipResponse, error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "check membership")
+	record := auditRecord(ctx, policy.EndpointUserCheck, isid, selectedGroups(directories))
+	defer logAudit(record)
+
+	var res ldapcheck.Membership
+	if len(directories) == 1 {
+		res = ldapcheck.LookupUser(ctx, directories[0], by, isid)
+	} else {
+		res = ldapcheck.LookupUserIn(ctx, directories, by, isid)
+	}
+	record.Directory = res.Directory
+	if res.Err != nil {
+		record.Error = res.Err.Error()
+		return nil, res.Err
+	}
+
+	record.Source = res.Source
+	record.AccountState = res.Account.State
+	member := res.Member && (res.Account.Active() || !configuration.AppConfig().InactiveNotMember)
+	log.Infof("user %s:%s found:%t member:%t in directory %s", by, isid, res.Found, member, res.Directory)
+	record.Decision = audit.DecisionNotMember
+	if member {
+		record.Decision = audit.DecisionMember
+		record.Membership = audit.MembershipOf(res.Nested)
+	}
+	return &usercheckv1.CheckMembershipResponse{
+		Member:         member,
+		Found:          res.Found,
+		Isid:           res.Isid,
+		Directory:      res.Directory,
+		AccountState:   res.Account.State,
+		AccountReasons: res.Account.Reasons,
+		Source:         res.Source,
+	}, nil
+}
+
+// CountMembers count the members of the configured group, like GET /v1/usercount
+func (s *Server) CountMembers(ctx context.Context, req *usercheckv1.CountMembersRequest) (*usercheckv1.CountMembersResponse, error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "count members")
+	directories, fanOut, err := selectDirectories(req.GetDirectory())
+	if err != nil {
+		return nil, err
+	}
+	if directories, err = authorize(ctx, policy.EndpointUserCount, directories, fanOut); err != nil {
+		return nil, err
+	}
+	record := auditRecord(ctx, policy.EndpointUserCount, "", selectedGroups(directories))
+	defer logAudit(record)
+
+	res := &usercheckv1.CountMembersResponse{Directories: map[string]*usercheckv1.DirectoryCount{}}
+	for _, directory := range directories {
+		p, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			return nil, status.Error(codes.Internal, "error while initializing ldap provider")
+		}
+		group, err := p.QueryUserGroupLdap(ctx)
+		if err != nil {
+			log.Errorf("ldap query user group in directory %s failed: %v", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			return nil, ldapError(err)
+		}
+		count := &usercheckv1.DirectoryCount{Total: int32(p.CountMembers(ctx, group))}
+		if req.GetBreakdown() {
+			active, inactive, err := p.MemberStates(ctx, group)
+			if err != nil {
+				log.Errorf("reading member accounts in directory %s failed: %v", directory, err)
+				record.Directory = directory
+				record.Error = err.Error()
+				return nil, ldapError(err)
+			}
+			count.Active, count.Inactive = int32(active), int32(inactive)
+		}
+		res.Directories[directory] = count
+		res.Total += count.Total
+	}
+	if !fanOut {
+		record.Directory = directories[0]
+	}
+	record.Decision = audit.DecisionFound
+	return res, nil
+}
+
+// ListMembers list the user members of the configured group of each directory
+func (s *Server) ListMembers(ctx context.Context, req *usercheckv1.ListMembersRequest) (*usercheckv1.ListMembersResponse, error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "list members")
+	directories, fanOut, err := selectDirectories(req.GetDirectory())
+	if err != nil {
+		return nil, err
+	}
+	if directories, err = authorize(ctx, policy.EndpointMembers, directories, fanOut); err != nil {
+		return nil, err
+	}
+	record := auditRecord(ctx, policy.EndpointMembers, "", selectedGroups(directories))
+	defer logAudit(record)
+
+	res := &usercheckv1.ListMembersResponse{}
+	for _, directory := range directories {
+		p, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			return nil, status.Error(codes.Internal, "error while initializing ldap provider")
+		}
+		group, err := p.QueryUserGroupLdap(ctx)
+		if err != nil {
+			log.Errorf("ldap query user group in directory %s failed: %v", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			return nil, ldapError(err)
+		}
+		logins, err := p.MemberLogins(ctx, group, req.GetActiveOnly())
+		if err != nil {
+			log.Errorf("reading member accounts in directory %s failed: %v", directory, err)
+			record.Directory = directory
+			record.Error = err.Error()
+			return nil, ldapError(err)
+		}
+		for _, login := range logins {
+			res.Members = append(res.Members, &usercheckv1.Member{Isid: login, Directory: directory})
+		}
+	}
+	if !fanOut {
+		record.Directory = directories[0]
+	}
+	record.Decision = audit.DecisionFound
+	return res, nil
+}
+
+// GetUser return the profile of the user, like GET /v1/users/{isid}
+func (s *Server) GetUser(ctx context.Context, req *usercheckv1.GetUserRequest) (*usercheckv1.GetUserResponse, error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "get user")
+	if strings.TrimSpace(req.GetIsid()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "isid is required")
+	}
+	directories, fanOut, err := selectDirectories(req.GetDirectory())
+	if err != nil {
+		return nil, err
+	}
+	if directories, err = authorize(ctx, policy.EndpointUsers, directories, fanOut); err != nil {
+		return nil, err
+	}
+	record := auditRecord(ctx, policy.EndpointUsers, req.GetIsid(), nil)
+	defer logAudit(record)
+
+	attributes := configuration.AppConfig().ProfileAttributeList()
+	for _, directory := range directories {
+		record.Directory = directory
+		p, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			record.Error = err.Error()
+			return nil, status.Error(codes.Internal, "error while initializing ldap provider")
+		}
+		entry, err := p.UserEntry(ctx, req.GetIsid(), attributes)
+		if err != nil {
+			log.Errorf("user profile lookup in directory %s failed: %v", directory, err)
+			record.Error = err.Error()
+			return nil, ldapError(err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		account := ldapcheck.AccountStateOf(entry, time.Now())
+		res := &usercheckv1.GetUserResponse{
+			Isid:           req.GetIsid(),
+			Directory:      directory,
+			Dn:             entry.DN,
+			AccountState:   account.State,
+			AccountReasons: account.Reasons,
+			Attributes:     map[string]*usercheckv1.AttributeValues{},
+		}
+		withGroups := false
+		for _, attribute := range attributes {
+			if attribute == ldapcheck.ProfileGroups {
+				withGroups = true
+				continue
+			}
+			if values := entry.GetEqualFoldAttributeValues(attribute); len(values) > 0 {
+				res.Attributes[attribute] = &usercheckv1.AttributeValues{Values: values}
+			}
+		}
+		if withGroups {
+			direct, err := p.DirectGroups(ctx, entry)
+			if err != nil {
+				record.Error = err.Error()
+				return nil, ldapError(err)
+			}
+			res.Groups = groups(direct)
+			if req.GetNested() {
+				inherited, err := p.NestedGroups(ctx, entry, direct)
+				if err != nil {
+					record.Error = err.Error()
+					return nil, ldapError(err)
+				}
+				res.NestedGroups = groups(inherited)
+			}
+		}
+		record.Source = p.Source
+		record.AccountState = account.State
+		record.Decision = audit.DecisionFound
+		return res, nil
+	}
+
+	record.Decision = audit.DecisionNotFound
+	return nil, status.Errorf(codes.NotFound, "user %s not found", req.GetIsid())
+}
+
+// groups convert ldap groups to their protobuf message
+func groups(groups []ldapcheck.Group) []*usercheckv1.Group {
+	res := make([]*usercheckv1.Group, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, &usercheckv1.Group{Name: g.Name, Dn: g.DN})
+	}
+	return res
+}
+
+// Health report whether ldap can be tried and bind to every directory the caller may query, like GET /v1/status.
+// The binds count for the circuit breakers like any other ldap operation.
+func (s *Server) Health(ctx context.Context, _ *usercheckv1.HealthRequest) (*usercheckv1.HealthResponse, error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "grpcapi", "action", "health")
+	directories, err := authorize(ctx, policy.EndpointHealth, configuration.AppConfig().DirectoryNames(), true)
+	if err != nil {
+		return nil, err
+	}
+	res := &usercheckv1.HealthResponse{
+		Ready:       breaker.Ready(),
+		Directories: map[string]string{},
+		Breakers:    map[string]string{},
+	}
+	for name, state := range breaker.States() {
+		res.Breakers[name] = state.String()
+	}
+	for _, directory := range directories {
+		res.Directories[directory] = configuration.LdapDown
+		p, err := ldapcheck.NewForDirectory(ctx, directory)
+		if err != nil {
+			log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+			continue
+		}
+		if err = p.Ping(ctx); err != nil {
+			log.Errorf("seems ldap binding directory %s not working: %v", directory, err)
+			continue
+		}
+		res.Directories[directory] = configuration.LdapUp
+	}
+	return res, nil
+}
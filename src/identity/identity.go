@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"crypto/x509"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Rule maps client certificates to a caller identity.
+// Subject is matched against the RFC 2253 subject, SAN against every DNS, email and URI SAN.
+// Patterns use "*" as a wildcard, a rule matches when all its non-empty patterns match.
+type Rule struct {
+	Identity string `yaml:"identity"`
+	Subject  string `yaml:"subject"`
+	SAN      string `yaml:"san"`
+
+	subject *regexp.Regexp
+	san     *regexp.Regexp
+}
+
+// Mapper resolves verified client certificates to caller identities
+type Mapper struct {
+	Rules []*Rule `yaml:"identities"`
+}
+
+var current atomic.Value
+
+// Load read and compile an identity mapping file
+func Load(file string) (*Mapper, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening identity file %s: %v", file, err)
+	}
+	m := &Mapper{}
+	if err = yaml.UnmarshalStrict(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing identity file %s: %v", file, err)
+	}
+	for i, rule := range m.Rules {
+		if rule.Identity == "" {
+			return nil, fmt.Errorf("identity file %s: rule %d has no identity", file, i)
+		}
+		if rule.Subject == "" && rule.SAN == "" {
+			return nil, fmt.Errorf("identity file %s: rule for %s needs a subject or san pattern", file, rule.Identity)
+		}
+		rule.subject = compile(rule.Subject)
+		rule.san = compile(rule.SAN)
+	}
+	return m, nil
+}
+
+// compile turn a wildcard pattern into an anchored regexp
+func compile(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	quoted := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `.*`)
+	return regexp.MustCompile("(?i)^" + quoted + "$")
+}
+
+// Identify return the identity of the first rule matching cert
+func (m *Mapper) Identify(cert *x509.Certificate) (string, bool) {
+	for _, rule := range m.Rules {
+		if rule.matches(cert) {
+			return rule.Identity, true
+		}
+	}
+	return "", false
+}
+
+func (r *Rule) matches(cert *x509.Certificate) bool {
+	if r.subject != nil && !r.subject.MatchString(cert.Subject.String()) {
+		return false
+	}
+	if r.san != nil {
+		for _, san := range SANs(cert) {
+			if r.san.MatchString(san) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// SANs return all the DNS, email and URI subject alternative names of cert
+func SANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// Current return the active mapper, nil when none is configured
+func Current() *Mapper {
+	m, _ := current.Load().(*Mapper)
+	return m
+}
+
+// Set replace the active mapper
+func Set(m *Mapper) {
+	current.Store(m)
+}
+
+// FromCertificate resolve the caller identity of a verified client certificate.
+// Without a mapper the certificate common name is the identity.
+func FromCertificate(cert *x509.Certificate) (string, error) {
+	m := Current()
+	if m == nil {
+		if cert.Subject.CommonName == "" {
+			return "", fmt.Errorf("client certificate %s has no common name", cert.Subject)
+		}
+		return cert.Subject.CommonName, nil
+	}
+	if id, ok := m.Identify(cert); ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("client certificate %s is not mapped to any caller", cert.Subject)
+}
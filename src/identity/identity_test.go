@@ -0,0 +1,53 @@
+package identity
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+const testIdentities = `
+identities:
+  - identity: hr-portal
+    subject: "CN=hr-portal*,O=Example"
+  - identity: mesh-workload
+    san: "spiffe://example.org/ns/*/sa/reports"
+`
+
+func TestIdentify(t *testing.T) {
+	Convey(`Feature: map client certificates to callers`, t, func() {
+		file := filepath.Join(t.TempDir(), "identities.yaml")
+		So(ioutil.WriteFile(file, []byte(testIdentities), 0600), ShouldBeNil)
+		m, err := Load(file)
+		So(err, ShouldBeNil)
+
+		Convey("Subject pattern", func() {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: "hr-portal-prod", Organization: []string{"Example"}}}
+			id, ok := m.Identify(cert)
+			So(ok, ShouldBeTrue)
+			So(id, ShouldEqual, "hr-portal")
+		})
+		Convey("SAN pattern", func() {
+			u, _ := url.Parse("spiffe://example.org/ns/finance/sa/reports")
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: "workload"}, URIs: []*url.URL{u}}
+			id, ok := m.Identify(cert)
+			So(ok, ShouldBeTrue)
+			So(id, ShouldEqual, "mesh-workload")
+		})
+		Convey("Unmapped certificates are refused", func() {
+			Set(m)
+			defer Set(nil)
+			_, err := FromCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Without a mapper the common name is used", func() {
+			id, err := FromCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}})
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "intruder")
+		})
+	})
+}
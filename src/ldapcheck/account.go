@@ -0,0 +1,222 @@
+package ldapcheck
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"user-check/utils/logger"
+)
+
+// Account states, by precedence when several apply
+const (
+	AccountActive          = "active"
+	AccountDisabled        = "disabled"
+	AccountExpired         = "expired"
+	AccountLocked          = "locked"
+	AccountPasswordExpired = "password_expired"
+)
+
+// AccountAttributes are read to tell the account state: Active Directory, OpenLDAP ppolicy and FreeIPA
+var AccountAttributes = []string{"userAccountControl", "msDS-User-Account-Control-Computed", "lockoutTime",
+	"accountExpires", "pwdLastSet", "pwdAccountLockedTime", "nsAccountLock"}
+
+const (
+	// uacAccountDisable and uacLockout are userAccountControl flags, Active Directory only sets the lockout one in
+	// msDS-User-Account-Control-Computed, while the lockout lasts
+	uacAccountDisable = 0x2
+	uacLockout        = 0x10
+	// defaultLockoutDuration is the Active Directory default, a lockoutTime older than this is over when the
+	// server doesn't compute the lockout itself
+	defaultLockoutDuration = 30 * time.Minute
+	// fileTimeUnixEpoch is 1970-01-01 in Windows FILETIME, 100ns intervals since 1601
+	fileTimeUnixEpoch = 116444736000000000
+	// fileTimeNever is the accountExpires value of accounts that never expire, as is 0
+	fileTimeNever = 1<<63 - 1
+	// memberBatchSize members are looked up per search when users don't list their groups
+	memberBatchSize = 100
+)
+
+// AccountState tell whether an account can be used, with every reason it can't
+type AccountState struct {
+	State   string
+	Reasons []string
+}
+
+// Active tell whether nothing prevents the account from being used
+func (s AccountState) Active() bool {
+	return s.State == AccountActive
+}
+
+// AccountStateOf read the account state of a user entry at time now
+func AccountStateOf(entry *ldap.Entry, now time.Time) AccountState {
+	var reasons []string
+	add := func(state string) {
+		for _, r := range reasons {
+			if r == state {
+				return
+			}
+		}
+		reasons = append(reasons, state)
+	}
+
+	if uac, err := strconv.ParseInt(entry.GetAttributeValue("userAccountControl"), 10, 64); err == nil {
+		if uac&uacAccountDisable != 0 {
+			add(AccountDisabled)
+		}
+	}
+	if strings.EqualFold(entry.GetAttributeValue("nsAccountLock"), "true") {
+		add(AccountDisabled)
+	}
+	if expires, err := strconv.ParseInt(entry.GetAttributeValue("accountExpires"), 10, 64); err == nil {
+		if expires != 0 && expires != fileTimeNever && fileTime(expires).Before(now) {
+			add(AccountExpired)
+		}
+	}
+	// lockoutTime stays set once the lockout is over, until the next logon
+	if computed, err := strconv.ParseInt(entry.GetAttributeValue("msDS-User-Account-Control-Computed"), 10, 64); err == nil {
+		if computed&uacLockout != 0 {
+			add(AccountLocked)
+		}
+	} else if lockout, err := strconv.ParseInt(entry.GetAttributeValue("lockoutTime"), 10, 64); err == nil && lockout > 0 {
+		if fileTime(lockout).Add(defaultLockoutDuration).After(now) {
+			add(AccountLocked)
+		}
+	}
+	if entry.GetAttributeValue("pwdAccountLockedTime") != "" {
+		add(AccountLocked)
+	}
+	// 0 means the password must be changed before the account can be used
+	if entry.GetAttributeValue("pwdLastSet") == "0" {
+		add(AccountPasswordExpired)
+	}
+
+	for _, state := range []string{AccountDisabled, AccountExpired, AccountLocked, AccountPasswordExpired} {
+		for _, r := range reasons {
+			if r == state {
+				return AccountState{State: state, Reasons: reasons}
+			}
+		}
+	}
+	return AccountState{State: AccountActive}
+}
+
+// fileTime convert a Windows FILETIME. It goes by seconds, nanoseconds only span 1678 to 2262.
+func fileTime(ft int64) time.Time {
+	if ft < 0 {
+		ft = 0
+	}
+	intervals := ft - fileTimeUnixEpoch
+	return time.Unix(intervals/1e7, intervals%1e7*100)
+}
+
+// MemberStates count the active and inactive members of the group. Members that are not users under the
+// user search base, e.g. nested groups or contacts, are in neither count.
+func (p *Provider) MemberStates(ctx context.Context, group *ldap.SearchResult) (active, inactive int, err error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "go-user-check", "action", "read member account states")
+
+	var users []*ldap.Entry
+	for _, g := range group.Entries {
+		found, err := p.memberEntries(ctx, g)
+		if err != nil {
+			return 0, 0, err
+		}
+		users = append(users, found...)
+	}
+
+	now := time.Now()
+	for _, user := range users {
+		if AccountStateOf(user, now).Active() {
+			active++
+		} else {
+			inactive++
+		}
+	}
+	log.Debugf("group %s has %d active and %d inactive members", p.OncoGroup, active, inactive)
+	return active, inactive, nil
+}
+
+// MemberAccount is a user member of a group and the state of its account
+type MemberAccount struct {
+	Login   string
+	Account AccountState
+}
+
+// MemberAccounts list the user members of the group sorted by login, leaving inactive accounts out when asked
+func (p *Provider) MemberAccounts(ctx context.Context, group *ldap.SearchResult, activeOnly bool) ([]MemberAccount, error) {
+	members := []MemberAccount{}
+	now := time.Now()
+	for _, g := range group.Entries {
+		users, err := p.memberEntries(ctx, g)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			account := AccountStateOf(user, now)
+			if activeOnly && !account.Active() {
+				continue
+			}
+			members = append(members, MemberAccount{Login: user.GetAttributeValue(p.Schema.LoginAttribute), Account: account})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Login < members[j].Login })
+	return members, nil
+}
+
+// MemberLogins list the logins of the user members of the group, sorted, leaving inactive accounts out when asked
+func (p *Provider) MemberLogins(ctx context.Context, group *ldap.SearchResult, activeOnly bool) ([]string, error) {
+	members, err := p.MemberAccounts(ctx, group, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	logins := make([]string, 0, len(members))
+	for _, member := range members {
+		logins = append(logins, member.Login)
+	}
+	return logins, nil
+}
+
+// memberEntries read the account attributes of the members of a group entry
+func (p *Provider) memberEntries(ctx context.Context, group *ldap.Entry) ([]*ldap.Entry, error) {
+	attributes := append([]string{p.Schema.LoginAttribute}, AccountAttributes...)
+	key := "members:" + strings.ToLower(group.DN)
+
+	// one search when users list their groups
+	if p.Schema.HasMemberOf() {
+		filter := "(&(objectClass=" + p.Schema.UserObjectClass + ")(" + p.Schema.MemberOfAttribute + "=" + ldap.EscapeFilter(group.DN) + "))"
+		sr, err := p.search(ctx, key, ldap.NewSearchRequest(p.SearchPeople, 2, 0, 0, 0, false, filter, attributes, nil))
+		if err != nil {
+			return nil, err
+		}
+		return sr.Entries, nil
+	}
+
+	// otherwise the members are looked up in batches, by login for memberUid and by DN (OpenLDAP entryDN) for the others
+	matchAttribute := "entryDN"
+	if !p.Schema.MembersAreDns() {
+		matchAttribute = p.Schema.LoginAttribute
+	}
+	members := group.GetAttributeValues(p.Schema.MemberAttribute)
+	var entries []*ldap.Entry
+	for start := 0; start < len(members); start += memberBatchSize {
+		end := start + memberBatchSize
+		if end > len(members) {
+			end = len(members)
+		}
+		var filter strings.Builder
+		filter.WriteString("(&(objectClass=" + p.Schema.UserObjectClass + ")(|")
+		for _, member := range members[start:end] {
+			filter.WriteString("(" + matchAttribute + "=" + ldap.EscapeFilter(member) + ")")
+		}
+		filter.WriteString("))")
+		sr, err := p.search(ctx, fmt.Sprintf("%s:%d", key, start), ldap.NewSearchRequest(p.SearchPeople, 2, 0, 0, 0, false, filter.String(), attributes, nil))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, sr.Entries...)
+	}
+	return entries, nil
+}
@@ -0,0 +1,87 @@
+package ldapcheck
+
+import (
+	"github.com/go-ldap/ldap/v3"
+	. "github.com/smartystreets/goconvey/convey"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func userEntry(attributes map[string]string) *ldap.Entry {
+	values := map[string][]string{}
+	for name, value := range attributes {
+		values[name] = []string{value}
+	}
+	return ldap.NewEntry("CN=jdoe,OU=People,DC=example,DC=com", values)
+}
+
+func toFileTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/100+fileTimeUnixEpoch, 10)
+}
+
+func TestAccountState(t *testing.T) {
+	Convey(`Feature: account state of AD, OpenLDAP and FreeIPA users`, t, func() {
+		now := time.Now()
+
+		Convey("A normal AD account is active", func() {
+			state := AccountStateOf(userEntry(map[string]string{
+				"userAccountControl": "512", "accountExpires": "9223372036854775807", "lockoutTime": "0", "pwdLastSet": "133000000000000000",
+			}), now)
+			So(state.Active(), ShouldBeTrue)
+			So(state.Reasons, ShouldBeEmpty)
+		})
+		Convey("Disabled beats locked", func() {
+			state := AccountStateOf(userEntry(map[string]string{
+				"userAccountControl": "514", "lockoutTime": toFileTime(now.Add(-time.Minute)),
+			}), now)
+			So(state.State, ShouldEqual, AccountDisabled)
+			So(state.Reasons, ShouldResemble, []string{AccountDisabled, AccountLocked})
+		})
+		Convey("An AD lockout is over once the server no longer computes it", func() {
+			lockoutTime := toFileTime(now.Add(-time.Minute))
+			locked := AccountStateOf(userEntry(map[string]string{
+				"lockoutTime": lockoutTime, "msDS-User-Account-Control-Computed": "16",
+			}), now)
+			So(locked.State, ShouldEqual, AccountLocked)
+			over := AccountStateOf(userEntry(map[string]string{
+				"lockoutTime": lockoutTime, "msDS-User-Account-Control-Computed": "0",
+			}), now)
+			So(over.Active(), ShouldBeTrue)
+		})
+		Convey("Without the computed flags an old lockoutTime is over", func() {
+			old := AccountStateOf(userEntry(map[string]string{"lockoutTime": "133000000000000000"}), now)
+			So(old.Active(), ShouldBeTrue)
+			// userAccountControl never carries a lasting lockout
+			So(AccountStateOf(userEntry(map[string]string{"userAccountControl": "528"}), now).Active(), ShouldBeTrue)
+		})
+		Convey("FILETIMEs far from now don't overflow", func() {
+			So(fileTime(fileTimeNever).Year(), ShouldBeGreaterThan, 30000)
+			So(fileTime(0).Year(), ShouldEqual, 1601)
+			far := AccountStateOf(userEntry(map[string]string{"lockoutTime": "9223372036854775806"}), now)
+			So(far.State, ShouldEqual, AccountLocked)
+			expired := AccountStateOf(userEntry(map[string]string{"accountExpires": "1"}), now)
+			So(expired.State, ShouldEqual, AccountExpired)
+		})
+		Convey("Expiry is compared to now", func() {
+			expired := AccountStateOf(userEntry(map[string]string{"accountExpires": toFileTime(now.Add(-time.Hour))}), now)
+			So(expired.State, ShouldEqual, AccountExpired)
+			future := AccountStateOf(userEntry(map[string]string{"accountExpires": toFileTime(now.Add(time.Hour))}), now)
+			So(future.Active(), ShouldBeTrue)
+			never := AccountStateOf(userEntry(map[string]string{"accountExpires": "0"}), now)
+			So(never.Active(), ShouldBeTrue)
+		})
+		Convey("A password that must be changed", func() {
+			state := AccountStateOf(userEntry(map[string]string{"pwdLastSet": "0"}), now)
+			So(state.State, ShouldEqual, AccountPasswordExpired)
+		})
+		Convey("OpenLDAP ppolicy lock", func() {
+			state := AccountStateOf(userEntry(map[string]string{"pwdAccountLockedTime": "000001010000Z"}), now)
+			So(state.State, ShouldEqual, AccountLocked)
+		})
+		Convey("FreeIPA disabled account", func() {
+			state := AccountStateOf(userEntry(map[string]string{"nsAccountLock": "TRUE"}), now)
+			So(state.State, ShouldEqual, AccountDisabled)
+		})
+	})
+}
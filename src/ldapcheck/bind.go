@@ -0,0 +1,51 @@
+package ldapcheck
+
+import (
+	"context"
+	"errors"
+	"github.com/go-ldap/ldap/v3"
+	"time"
+	"user-check/utils/logger"
+)
+
+// ErrInvalidCredentials the password is wrong, or the account can't bind
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticate bind as the user entry with password. Answers are never cached and the password is never logged.
+// An empty password is refused outright, most servers accept it as an anonymous bind.
+func (p *Provider) Authenticate(ctx context.Context, user *ldap.Entry, password string) error {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "go-user-check", "action", "authenticate user")
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	release, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	l, err := p.FuncDialLdap(ctx)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	b := p.breaker(p.server)
+
+	err = l.Bind(user.DN, password)
+	switch {
+	case err == nil:
+		b.Success()
+		p.answered(SourceLive, time.Now())
+		return nil
+	case isBackendFailure(err):
+		b.Failure()
+		return err
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials):
+		b.Success()
+		log.Debugf("bind as %s refused", user.DN)
+		return ErrInvalidCredentials
+	}
+	b.Success()
+	return err
+}
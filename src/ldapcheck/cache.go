@@ -0,0 +1,67 @@
+package ldapcheck
+
+import (
+	"github.com/go-ldap/ldap/v3"
+	"sync"
+	"time"
+	"user-check/configuration"
+	"user-check/metrics"
+)
+
+// Where an answer came from
+const (
+	// SourceLive answered by the directory
+	SourceLive = "live"
+	// SourceCache served from cache, younger than the cache ttl
+	SourceCache = "cache"
+	// SourceSnapshot last known answer, served because the directory is unavailable
+	SourceSnapshot = "snapshot"
+)
+
+type cacheEntry struct {
+	result *ldap.SearchResult
+	at     time.Time
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   = map[string]cacheEntry{}
+)
+
+// cacheGet return the answer stored under key if it is younger than maxAge
+func cacheGet(key string, maxAge time.Duration) (*ldap.SearchResult, time.Time, bool) {
+	if maxAge <= 0 {
+		return nil, time.Time{}, false
+	}
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	entry, ok := cache[key]
+	if !ok || time.Since(entry.at) > maxAge {
+		return nil, time.Time{}, false
+	}
+	return entry.result, entry.at, true
+}
+
+// cachePut store a live answer, making room by dropping an arbitrary entry when full
+func cachePut(key string, result *ldap.SearchResult) {
+	conf := configuration.AppConfig()
+	if conf.CacheTtlSec <= 0 && conf.SnapshotMaxAgeSec <= 0 {
+		return
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if _, ok := cache[key]; !ok && conf.CacheMaxEntries > 0 && len(cache) >= int(conf.CacheMaxEntries) {
+		for k := range cache {
+			delete(cache, k)
+			break
+		}
+	}
+	cache[key] = cacheEntry{result: result, at: time.Now()}
+}
+
+// answered record where an answer came from
+func (p *Provider) answered(source string, at time.Time) {
+	p.Source = source
+	p.AnsweredAt = at
+	metrics.LdapAnswers.WithLabelValues(source).Inc()
+}
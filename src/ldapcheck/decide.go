@@ -0,0 +1,78 @@
+package ldapcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrProvider marks a directory whose provider could not be set up, a configuration problem rather than an ldap one
+var ErrProvider = errors.New("error while initializing ldap provider")
+
+// GroupDecision tells whether a user is a member of one of the required groups
+type GroupDecision struct {
+	// Found the user exists in Directory
+	Found     bool
+	Directory string
+	// User is the login of the user, Groups the required groups it is a member of
+	User    string
+	Groups  []string
+	Account AccountState
+	Source  string
+	// Nested the user is a member of none of Groups directly, only through other groups
+	Nested bool
+}
+
+// Allowed tell whether the user is a member of at least one required group
+func (d GroupDecision) Allowed() bool {
+	return len(d.Groups) > 0
+}
+
+// MemberOfAny look user up in the directories, in order, and check it against the groups required in each.
+// The first directory where the user is a member of any of them answers. With inactiveNotMember enabled,
+// locked and expired accounts are members of nothing.
+func MemberOfAny(ctx context.Context, directories []string, user string, groups func(directory string) []string,
+	inactiveNotMember bool) (GroupDecision, error) {
+	var decision GroupDecision
+	for _, directory := range directories {
+		p, err := NewForDirectory(ctx, directory)
+		if err != nil {
+			return decision, fmt.Errorf("%w for directory %s: %v", ErrProvider, directory, err)
+		}
+		users, err := p.FindUsers(ctx, ByIsid, user)
+		if err != nil {
+			return GroupDecision{Directory: directory}, err
+		}
+		if len(users.Entries) == 0 {
+			continue
+		}
+		entry := users.Entries[0]
+		decision = GroupDecision{
+			Found:     true,
+			Directory: directory,
+			User:      entry.GetAttributeValue(p.Schema.LoginAttribute),
+			Account:   AccountStateOf(entry, time.Now()),
+			Source:    p.Source,
+		}
+		if !decision.Account.Active() && inactiveNotMember {
+			continue
+		}
+		direct := false
+		for _, group := range groups(directory) {
+			member, nested, err := p.ForGroup(group).MemberVia(ctx, entry)
+			if err != nil {
+				return GroupDecision{Directory: directory}, err
+			}
+			if member {
+				decision.Groups = append(decision.Groups, group)
+				direct = direct || !nested
+			}
+		}
+		decision.Nested = decision.Allowed() && !direct
+		if decision.Allowed() {
+			return decision, nil
+		}
+	}
+	return decision, nil
+}
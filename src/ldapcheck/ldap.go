@@ -6,43 +6,69 @@ import (
 	"crypto/x509"
 	"fmt"
 	"github.com/go-ldap/ldap/v3"
-	"io/ioutil"
+	"net"
+	"user-check/breaker"
+	"user-check/certs"
 	"user-check/configuration"
+	"user-check/schema"
 	"user-check/utils/logger"
 	"strings"
 	"time"
 )
 
 type Provider struct {
+	// Directory is the name of the directory profile queried
+	Directory         string
 	LdapServerAddress string
+	Servers           []string
 	NpaUser           string
 	NpaPassword       string
 	SearchPeople      string
+	GroupBase         string
+	GroupContainer    string
 	OncoGroup         string
 	CertFile          string
+	TlsVerify         bool
+	Timeout           time.Duration
+	// NestedMembership makes members of the group through other groups members too
+	NestedMembership bool
+	// Schema names the object classes and attributes of the directory
+	Schema schema.Schema
+
+	// Source and AnsweredAt describe the last answer: live, cache or snapshot
+	Source     string
+	AnsweredAt time.Time
+
+	// server the current connection was dialled to
+	server string
 }
 
-var (
-	ResultCount int
-)
-
 // New provide context, variables to be used for example
 func New(ctx context.Context) (*Provider, error) {
+	return NewForDirectory(ctx, configuration.DefaultDirectory)
+}
+
+// NewForDirectory return a provider querying the named directory profile
+func NewForDirectory(ctx context.Context, directory string) (*Provider, error) {
 	var (
 		provider *Provider
 	)
 
 	log := logger.SugaredLogger().WithContextCorrelationId(ctx)
-	log.Debug("Instantiating ldap check provider")
+	log.Debugf("Instantiating ldap check provider for directory %s", directory)
 
-	provider = &Provider{}
-	appConfig := configuration.AppConfig()
+	appConfig, ok := configuration.AppConfig().Directory(directory)
+	if !ok {
+		return nil, fmt.Errorf("unknown directory %s", directory)
+	}
+	provider = &Provider{Directory: directory}
 
 	// ldap server address
 	if appConfig.LdapServerAddress == "" {
 		return nil, fmt.Errorf("ldap address is not set")
 	} else {
 		provider.LdapServerAddress = appConfig.LdapServerAddress
+		provider.Servers = servers(appConfig.LdapServerAddress)
 		log.Debugf("LDAP_ADDRESS:%s", provider.LdapServerAddress)
 	}
 
@@ -55,10 +81,13 @@ func New(ctx context.Context) (*Provider, error) {
 	}
 
 	// npa password
-	if appConfig.NpaPassword == "" {
+	password, err := appConfig.NpaPasswordSecret().Secret(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("npa password is not available: %w", err)
+	} else if password == "" {
 		return nil, fmt.Errorf("npa password is not set")
 	} else {
-		provider.NpaPassword = appConfig.NpaPassword
+		provider.NpaPassword = password
 	}
 
 	// search people
@@ -69,83 +98,122 @@ func New(ctx context.Context) (*Provider, error) {
 		log.Debugf("SEARCH_PEOPLE:%s", provider.SearchPeople)
 	}
 
+	// group search base and security group container
+	provider.GroupBase = appConfig.LdapGroupBase
+	provider.GroupContainer = appConfig.LdapGroupContainer
+
 	// group group
-	if appConfig.groupGroup == "" {
+	if appConfig.OncoGroup == "" {
 		return nil, fmt.Errorf("groupgroup is not set")
 	} else {
-		provider.groupGroup = appConfig.groupGroup
-		log.Debugf("group_GROUP:%s", provider.groupGroup)
+		provider.OncoGroup = appConfig.OncoGroup
+		log.Debugf("group_GROUP:%s", provider.OncoGroup)
 	}
 
-	// cert file
-	if appConfig.LdapCertFile == "" {
-		return nil, fmt.Errorf("cert file not set")
-	} else {
-		provider.CertFile = appConfig.LdapCertFile
-		log.Debugf("LDAP_CERT_FILE:%s", provider.CertFile)
+	// cert file, trusted along with the system roots
+	provider.CertFile = appConfig.LdapCertFile
+	log.Debugf("LDAP_CERT_FILE:%s", provider.CertFile)
+	provider.TlsVerify = appConfig.LdapTlsVerify
+	if provider.Schema, err = appConfig.LdapSchema(); err != nil {
+		return nil, fmt.Errorf("invalid ldap schema: %w", err)
 	}
+	provider.Timeout = time.Duration(configuration.AppConfig().LdapTimeoutMs) * time.Millisecond
+	provider.NestedMembership = configuration.AppConfig().NestedMembership
 
 	return provider, nil
 }
 
+// servers split the configured ldap address into the list of servers to try
+func servers(address string) []string {
+	return strings.FieldsFunc(address, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
 // CheckUserLdap check if user is in ldap group
 func (p *Provider) CheckUserLdap(ctx context.Context, isidmap map[string]interface{}) (*ldap.SearchResult, error) {
-	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "group-license", "action", "check user ldap")
-
-	l, err := p.FuncDialLdap(ctx)
-	if err != nil {
-		return &ldap.SearchResult{}, err
-	}
-	err = l.Bind(p.NpaUser, p.NpaPassword)
-	if err != nil {
-		log.Debugf("error binding:%s", err)
-	}
-
-	searchFilter := "(&(objectClass=user)(sAMAccountName=" + isidmap["isid"].(string) + "))"
+	isid := isidmap["isid"].(string)
+	searchFilter := p.Schema.UserFilter(ldap.EscapeFilter(isid))
 	searchRequest := ldap.NewSearchRequest(
 		p.SearchPeople, // The base dn to search
 		2, 0, 0, 0, false,
-		searchFilter, // The filter to apply
-		[]string{"mail", "sn", "givenName", "memberOf"}, // A list attributes to retrieve
+		searchFilter,       // The filter to apply
+		p.userAttributes(), // A list attributes to retrieve
 		nil,
 	)
 
-	sr, err := l.Search(searchRequest)
-	if err != nil {
-		log.Debugf("Failed to search:%v", err)
-		return sr, err
-	}
-
-	// any good developer is closing the connection after we done with it
-	defer l.Close()
-
 	// check how fast is this
 	//defer TimeTaken(ctx, time.Now(), "checkuserldap")
 
-	return sr, nil
+	return p.search(ctx, "user:"+strings.ToLower(isid), searchRequest)
 }
 
-// ReadCertFile read ldap certificate
-func (p *Provider) ReadCertFile(ctx context.Context) []byte {
+// userAttributes are read when checking a user: identifiers, account state and groups
+func (p *Provider) userAttributes() []string {
+	attributes := append([]string{"mail", "sn", "givenName", "userPrincipalName", p.Schema.LoginAttribute}, AccountAttributes...)
+	if p.Schema.HasMemberOf() {
+		attributes = append(attributes, p.Schema.MemberOfAttribute)
+	}
+	return attributes
+}
+
+// CAName return the name the CA bundle of directory is reported under
+func CAName(directory string) string {
+	if directory == configuration.DefaultDirectory {
+		return "ldap_ca"
+	}
+	return "ldap_ca/" + directory
+}
+
+// caPool return the system roots and the ldap CA bundle, reloaded from disk when it changes. Without a readable
+// bundle only the system roots are trusted.
+func (p *Provider) caPool(ctx context.Context) *x509.CertPool {
 	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "group-calculator", "action", "read cert file")
-	res, err := ioutil.ReadFile(p.CertFile)
+	if p.CertFile != "" {
+		pool, err := certs.SystemCAPool(CAName(p.Directory), p.CertFile)
+		if err == nil {
+			return pool.CertPool()
+		}
+		log.Debugf("issue reading the cert file from disk:%s", err)
+	}
+	roots, err := x509.SystemCertPool()
 	if err != nil {
-		log.Debugf("issue readint the cert file from disk:%s", err)
+		log.Debugf("issue reading the system roots:%s", err)
+		return x509.NewCertPool()
 	}
-	return res
+	return roots
 }
 
-// FuncDialLdap dial ldap
+// FuncDialLdap dial the first ldap server whose circuit breaker lets requests through. The dial may take the
+// half-open probe of the breaker: callers must report the outcome of their request to the breaker of p.server.
 func (p *Provider) FuncDialLdap(ctx context.Context) (*ldap.Conn, error) {
-
 	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "group-calculator", "action", "dial ldap")
 
-	combinedCerts := p.ReadCertFile(ctx)
+	lastErr := fmt.Errorf("all ldap servers are unavailable: %w", breaker.ErrOpen)
+	for _, server := range p.Servers {
+		b := p.breaker(server)
+		if err := b.Allow(); err != nil {
+			log.Debugf("skipping ldap server %s: %s", server, err)
+			continue
+		}
+		l, err := p.dialServer(ctx, server)
+		if err != nil {
+			log.Debugf("error dialling up ldap server %s:%s", server, err)
+			b.Failure()
+			lastErr = err
+			continue
+		}
+		p.server = server
+		return l, nil
+	}
+	return nil, lastErr
+}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(combinedCerts)
+// dialServer open a connection to a single ldap server
+func (p *Provider) dialServer(ctx context.Context, server string) (*ldap.Conn, error) {
+	caCertPool := p.caPool(ctx)
 
-	l, err := ldap.DialURL(p.LdapServerAddress, ldap.DialWithTLSConfig(&tls.Config{
+	l, err := ldap.DialURL(server, ldap.DialWithDialer(&net.Dialer{Timeout: p.Timeout}), ldap.DialWithTLSConfig(&tls.Config{
 		Rand:                        nil,
 		Time:                        nil,
 		Certificates:                nil,
@@ -154,12 +222,12 @@ func (p *Provider) FuncDialLdap(ctx context.Context) (*ldap.Conn, error) {
 		GetConfigForClient:          nil,
 		VerifyPeerCertificate:       nil,
 		VerifyConnection:            nil,
-		RootCAs:                     nil,
+		RootCAs:                     caCertPool,
 		NextProtos:                  nil,
 		ServerName:                  "",
 		ClientAuth:                  tls.RequireAndVerifyClientCert,
 		ClientCAs:                   caCertPool,
-		InsecureSkipVerify:          true,
+		InsecureSkipVerify:          !p.TlsVerify,
 		CipherSuites:                nil,
 		SessionTicketsDisabled:      false,
 		ClientSessionCache:          nil,
@@ -170,69 +238,64 @@ func (p *Provider) FuncDialLdap(ctx context.Context) (*ldap.Conn, error) {
 		Renegotiation:               0,
 		KeyLogWriter:                nil,
 	}))
-
 	if err != nil {
-		log.Debugf("error dialling up ldap server:%s", err)
-		return l, err
+		return nil, err
 	}
-
-	return l, err
+	if p.Timeout > 0 {
+		l.SetTimeout(p.Timeout)
+	}
+	return l, nil
 }
 
 // QueryUserGroupLdap count total number of users in ldap group
 func (p *Provider) QueryUserGroupLdap(ctx context.Context) (*ldap.SearchResult, error) {
 	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "group-license", "action", "get users from  security group")
 
-	l, err := p.FuncDialLdap(ctx)
-	if err != nil {
-		return &ldap.SearchResult{}, err
-	}
-
-	err = l.Bind(p.NpaUser, p.NpaPassword)
-	if err != nil {
-		log.Debugf("error binding:%s", err)
-	}
-
-	searchGroupFilter := "(&(objectCategory=group)(cn=" + p.groupGroup + "))"
+	searchGroupFilter := p.Schema.GroupFilter(ldap.EscapeFilter(p.OncoGroup))
 
 	searchRequestGroups := ldap.NewSearchRequest(
-		"CN=Groups,DC=domain,DC=com", // The base dn to search
+		p.GroupBase, // The base dn to search
 		2, 0, 0, 0, false,
-		searchGroupFilter,  // The filter to apply
-		[]string{"member"}, // A list attributes to retrieve
+		searchGroupFilter,                  // The filter to apply
+		[]string{p.Schema.MemberAttribute}, // A list attributes to retrieve
 		nil,
 	)
-	srg, err := l.Search(searchRequestGroups)
+	srg, err := p.search(ctx, "group:"+strings.ToLower(p.OncoGroup), searchRequestGroups)
 	if err != nil {
 		log.Debugf("Failed to search group:%v", err)
 		return srg, err
 	}
 
-	// any good developer is closing the connection after we done with it
-	defer l.Close()
 	//defer TimeTaken(ctx, time.Now(), "ldapquerygroup")
 	log.Debugf("ldap query user group returned:%s", srg)
 	return srg, err
 }
 
-// LdapCountObjects count members in ldap group
-func LdapCountObjects(ctx context.Context, newusercount *ldap.SearchResult) int {
+// CountMembers count the values of the member attribute of the group
+func (p *Provider) CountMembers(ctx context.Context, group *ldap.SearchResult) int {
 	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "go-user-check", "action", "count members in ldap security group")
-	for _, entry := range newusercount.Entries {
-		ResultCount = strings.Count(fmt.Sprint(entry.GetAttributeValues("member")), "CN=")
+	count := 0
+	for _, entry := range group.Entries {
+		count += len(entry.GetAttributeValues(p.Schema.MemberAttribute))
 	}
-	log.Debugf("total numbers of members in security group is: %d", ResultCount)
-	//defer TimeTaken(ctx, time.Now(), "ldapcountobjects")
-	return ResultCount
+	log.Debugf("total numbers of members in security group is: %d", count)
+	return count
+}
+
+// ForGroup return a copy of the provider checking group instead of the configured one
+func (p *Provider) ForGroup(group string) *Provider {
+	q := *p
+	q.OncoGroup = group
+	return &q
 }
 
 // IsUserInGroup check if user is in group
 func (p *Provider) IsUserInGroup(ctx context.Context, list []string) bool {
 	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "go-user-check", "action", "check if user is in the security group")
 	//log.Debug(list)
-	groupgropupbase := "CN=" + p.groupGroup + ",CN=Security,CN=Groups,DC=domain,DC=com"
+	groupgropupbase := p.Schema.GroupNameAttribute + "=" + p.OncoGroup + "," + p.GroupContainer
 	for _, v := range list {
-		if v == groupgropupbase {
+		if strings.EqualFold(v, groupgropupbase) {
 			log.Infof("user is in the security group:%s", groupgropupbase)
 			return true
 		}
@@ -241,6 +304,34 @@ func (p *Provider) IsUserInGroup(ctx context.Context, list []string) bool {
 	return false
 }
 
+// IsMember check whether the user entry is a member of the group. The user memberOf attribute is used when
+// the directory maintains it, the group member list otherwise.
+func (p *Provider) IsMember(ctx context.Context, user *ldap.Entry) (bool, error) {
+	if p.Schema.HasMemberOf() {
+		return p.IsUserInGroup(ctx, user.GetAttributeValues(p.Schema.MemberOfAttribute)), nil
+	}
+
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "go-user-check", "action", "check if user is in the security group")
+	group, err := p.QueryUserGroupLdap(ctx)
+	if err != nil {
+		return false, err
+	}
+	member := user.DN
+	if !p.Schema.MembersAreDns() {
+		member = user.GetAttributeValue(p.Schema.LoginAttribute)
+	}
+	for _, entry := range group.Entries {
+		for _, v := range entry.GetAttributeValues(p.Schema.MemberAttribute) {
+			if strings.EqualFold(v, member) {
+				log.Infof("user is in the security group:%s", p.OncoGroup)
+				return true, nil
+			}
+		}
+	}
+	log.Infof("user is not in the security group:%s", p.OncoGroup)
+	return false, nil
+}
+
 // TimeTaken simple function returning how long it takes to execute a function
 // be sure deffer is disabled in the functions in prod env
 // use this just for internal debugging
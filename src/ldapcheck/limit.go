@@ -0,0 +1,81 @@
+package ldapcheck
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"user-check/configuration"
+	"user-check/metrics"
+)
+
+// ErrQueueTimeout no concurrency slot freed up before the queue timeout
+var ErrQueueTimeout = errors.New("too many concurrent ldap operations, gave up waiting for a free slot")
+
+// limits is the concurrency limit in force, replaced as a whole when the configuration is reloaded.
+// Operations holding a slot of a replaced limit release it there.
+type limits struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+var (
+	limitsMu sync.Mutex
+	active   *limits
+)
+
+// newLimits build the concurrency limit from the configuration
+func newLimits(conf *configuration.Configuration) *limits {
+	metrics.LdapMaxConcurrent.Set(float64(conf.LdapMaxConcurrent))
+	l := &limits{queueTimeout: time.Duration(conf.LdapQueueTimeoutMs) * time.Millisecond}
+	if conf.LdapMaxConcurrent > 0 {
+		l.slots = make(chan struct{}, conf.LdapMaxConcurrent)
+	}
+	return l
+}
+
+// currentLimits return the limit in force, built from the active configuration on first use
+func currentLimits() *limits {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	if active == nil {
+		active = newLimits(configuration.AppConfig())
+	}
+	return active
+}
+
+// acquire wait for a free ldap concurrency slot. The returned func releases it.
+func acquire(ctx context.Context) (func(), error) {
+	l := currentLimits()
+	slots := l.slots
+	if slots == nil {
+		metrics.LdapInFlight.Inc()
+		return metrics.LdapInFlight.Dec, nil
+	}
+
+	release := func() {
+		<-slots
+		metrics.LdapInFlight.Dec()
+	}
+	select {
+	case slots <- struct{}{}:
+		metrics.LdapInFlight.Inc()
+		return release, nil
+	default:
+	}
+
+	metrics.LdapQueued.Inc()
+	defer metrics.LdapQueued.Dec()
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		metrics.LdapInFlight.Inc()
+		return release, nil
+	case <-timer.C:
+		metrics.LdapQueueTimeouts.Inc()
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
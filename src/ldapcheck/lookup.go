@@ -0,0 +1,74 @@
+package ldapcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"strings"
+)
+
+// Identifier types users can be looked up by
+const (
+	ByIsid         = "isid"
+	ByMail         = "mail"
+	ByUpn          = "upn"
+	ByEmployeeId   = "employee_id"
+	ByProxyAddress = "proxy_address"
+)
+
+// ErrAmbiguous the identifier matches more than one user
+var ErrAmbiguous = errors.New("identifier matches several users")
+
+// identifierAttributes are the ldap attributes holding each identifier type but the isid, which is the login attribute
+var identifierAttributes = map[string]string{
+	ByMail:         "mail",
+	ByUpn:          "userPrincipalName",
+	ByEmployeeId:   "employeeID",
+	ByProxyAddress: "proxyAddresses",
+}
+
+// IdentifierTypes list the identifier types users can be looked up by
+func IdentifierTypes() []string {
+	return []string{ByIsid, ByMail, ByUpn, ByEmployeeId, ByProxyAddress}
+}
+
+// ValidIdentifierType check by is a known identifier type
+func ValidIdentifierType(by string) error {
+	for _, t := range IdentifierTypes() {
+		if by == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown identifier type %q, expected one of %s", by, strings.Join(IdentifierTypes(), ", "))
+}
+
+// identifierFilter return the filter matching value as an identifier of type by. Proxy addresses without
+// a prefix are taken as smtp addresses, the match ignores case so primary (SMTP:) addresses are found too.
+func (p *Provider) identifierFilter(by, value string) (string, error) {
+	if err := ValidIdentifierType(by); err != nil {
+		return "", err
+	}
+	attribute := p.Schema.LoginAttribute
+	if by != ByIsid {
+		attribute = identifierAttributes[by]
+	}
+	if by == ByProxyAddress && !strings.Contains(value, ":") {
+		value = "smtp:" + value
+	}
+	return "(&(objectClass=" + p.Schema.UserObjectClass + ")(" + attribute + "=" + ldap.EscapeFilter(value) + "))", nil
+}
+
+// FindUsers search the users whose identifier of type by is value. Every match is returned, callers report
+// several matches with ErrAmbiguous.
+func (p *Provider) FindUsers(ctx context.Context, by, value string) (*ldap.SearchResult, error) {
+	if by == ByIsid {
+		return p.CheckUserLdap(ctx, map[string]interface{}{"isid": value})
+	}
+	filter, err := p.identifierFilter(by, value)
+	if err != nil {
+		return nil, err
+	}
+	searchRequest := ldap.NewSearchRequest(p.SearchPeople, 2, 0, 0, 0, false, filter, p.userAttributes(), nil)
+	return p.search(ctx, by+":"+strings.ToLower(value), searchRequest)
+}
@@ -0,0 +1,35 @@
+package ldapcheck
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"user-check/schema"
+)
+
+func TestIdentifierFilter(t *testing.T) {
+	Convey(`Feature: users are found by mail, UPN, employee ID or proxy address`, t, func() {
+		ad, _ := schema.Preset(schema.ActiveDirectory)
+		p := &Provider{Schema: ad}
+
+		Convey("The isid is the login attribute", func() {
+			filter, err := p.identifierFilter(ByIsid, "bordeanu")
+			So(err, ShouldBeNil)
+			So(filter, ShouldEqual, "(&(objectClass=user)(sAMAccountName=bordeanu))")
+		})
+		Convey("Values are escaped", func() {
+			filter, err := p.identifierFilter(ByMail, "a*b@example.com")
+			So(err, ShouldBeNil)
+			So(filter, ShouldEqual, `(&(objectClass=user)(mail=a\2ab@example.com))`)
+		})
+		Convey("Proxy addresses default to smtp", func() {
+			filter, _ := p.identifierFilter(ByProxyAddress, "dan@example.com")
+			So(filter, ShouldEqual, "(&(objectClass=user)(proxyAddresses=smtp:dan@example.com))")
+			filter, _ = p.identifierFilter(ByProxyAddress, "x500:/o=example/cn=dan")
+			So(filter, ShouldEqual, "(&(objectClass=user)(proxyAddresses=x500:/o=example/cn=dan))")
+		})
+		Convey("Unknown identifier types are refused", func() {
+			_, err := p.identifierFilter("phone", "123")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
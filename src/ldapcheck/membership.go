@@ -0,0 +1,134 @@
+package ldapcheck
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"strings"
+	"sync"
+	"time"
+	"user-check/utils/logger"
+)
+
+// Membership is the answer of a single directory about a user
+type Membership struct {
+	Directory string
+	// Isid is the login of the user found, the identifier may be of another type
+	Isid   string
+	Found  bool
+	Member bool
+	// Nested the user is a member only through other groups
+	Nested  bool
+	Account AccountState
+	// Group is the group checked, Source and AnsweredAt tell where the answer came from and how old it is
+	Group      string
+	Source     string
+	AnsweredAt time.Time
+	Err        error
+}
+
+// LookupUser check whether the user identified by isid, an identifier of type by, exists in directory
+// and is a member of its group
+func LookupUser(ctx context.Context, directory, by, isid string) Membership {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx)
+	res := Membership{Directory: directory}
+
+	userLdapProvider, err := NewForDirectory(ctx, directory)
+	if err != nil {
+		log.Errorf("Error while initializing Ldap Provider for directory %s: %s", directory, err)
+		res.Err = fmt.Errorf("%w: %v", ErrProvider, err)
+		return res
+	}
+
+	newusersearch, err := userLdapProvider.FindUsers(ctx, by, isid)
+	if err != nil {
+		log.Errorf("check user exists in directory %s failed: %v", directory, err)
+		res.Err = err
+		return res
+	}
+	log.Debugf("check user exists in directory %s returned:%s", directory, *newusersearch)
+	if by != ByIsid && len(newusersearch.Entries) > 1 {
+		res.Err = fmt.Errorf("%w: %d users have %s %s", ErrAmbiguous, len(newusersearch.Entries), by, isid)
+		return res
+	}
+
+	res.Group = userLdapProvider.OncoGroup
+	res.Source = userLdapProvider.Source
+	res.AnsweredAt = userLdapProvider.AnsweredAt
+	for _, entry := range newusersearch.Entries {
+		// print just email
+		log.Debugf("%s: %v\n", entry.DN, entry.GetAttributeValue("mail"))
+		res.Found = true
+		res.Isid = entry.GetAttributeValue(userLdapProvider.Schema.LoginAttribute)
+		res.Account = AccountStateOf(entry, time.Now())
+		if res.Member, res.Nested, err = userLdapProvider.MemberVia(ctx, entry); err != nil {
+			log.Errorf("group membership lookup in directory %s failed: %v", directory, err)
+			res.Err = err
+			return res
+		}
+	}
+	return res
+}
+
+// MemberVia check whether the user entry is a member of the group, directly or, with NestedMembership, through
+// the groups it is a member of. nested tells the user is a member only through other groups.
+func (p *Provider) MemberVia(ctx context.Context, user *ldap.Entry) (member, nested bool, err error) {
+	if member, err = p.IsMember(ctx, user); err != nil || member || !p.NestedMembership {
+		return member, false, err
+	}
+	direct, err := p.DirectGroups(ctx, user)
+	if err != nil {
+		return false, false, err
+	}
+	inherited, err := p.NestedGroups(ctx, user, direct)
+	if err != nil {
+		return false, false, err
+	}
+	for _, group := range inherited {
+		if strings.EqualFold(group.Name, p.OncoGroup) {
+			return true, true, nil
+		}
+	}
+	return false, false, nil
+}
+
+// LookupUserIn ask every directory at once. The first directory, in the given order, where the user is a member
+// answers. Otherwise a failed directory might have been the one, so its error is returned, and only when
+// every directory answered the first one where the user exists is reported.
+func LookupUserIn(ctx context.Context, directories []string, by, isid string) Membership {
+	answers := make([]Membership, len(directories))
+	var wg sync.WaitGroup
+	for i, directory := range directories {
+		wg.Add(1)
+		go func(i int, directory string) {
+			defer wg.Done()
+			answers[i] = LookupUser(ctx, directory, by, isid)
+		}(i, directory)
+	}
+	wg.Wait()
+
+	// an active account beats a disabled one that is still in the group
+	for i := range answers {
+		if answers[i].Member && answers[i].Account.Active() {
+			return answers[i]
+		}
+	}
+	var found, failed *Membership
+	for i := range answers {
+		switch {
+		case answers[i].Member:
+			return answers[i]
+		case answers[i].Found && found == nil:
+			found = &answers[i]
+		case answers[i].Err != nil && failed == nil:
+			failed = &answers[i]
+		}
+	}
+	if failed != nil {
+		return *failed
+	}
+	if found != nil {
+		return *found
+	}
+	return Membership{}
+}
@@ -0,0 +1,56 @@
+package ldapcheck
+
+import (
+	"context"
+	"github.com/go-ldap/ldap/v3"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+	"user-check/configuration"
+	"user-check/schema"
+)
+
+func TestMemberVia(t *testing.T) {
+	Convey(`Feature: members through other groups are told apart from direct ones`, t, func() {
+		os.Setenv("CACHE_TTL", "60")
+		defer os.Unsetenv("CACHE_TTL")
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+		ctx := context.Background()
+
+		ad, _ := schema.Preset(schema.ActiveDirectory)
+		p := &Provider{Directory: "member-via-test", OncoGroup: "group.users", GroupContainer: "OU=Groups,DC=example,DC=com", Schema: ad}
+		direct := ldap.NewEntry("CN=direct,DC=example,DC=com", map[string][]string{
+			"memberOf": {"CN=group.users,OU=Groups,DC=example,DC=com"},
+		})
+		nested := ldap.NewEntry("CN=nested,DC=example,DC=com", map[string][]string{
+			"memberOf": {"CN=team,OU=Groups,DC=example,DC=com"},
+		})
+		// the in-chain search of the nested user, answered from the cache
+		cachePut(p.Directory+"/nested:cn=nested,dc=example,dc=com", &ldap.SearchResult{Entries: []*ldap.Entry{
+			ldap.NewEntry("CN=team,OU=Groups,DC=example,DC=com", map[string][]string{"cn": {"team"}}),
+			ldap.NewEntry("CN=group.users,OU=Groups,DC=example,DC=com", map[string][]string{"cn": {"group.users"}}),
+		}})
+
+		Convey("Direct members are members", func() {
+			member, isNested, err := p.MemberVia(ctx, direct)
+			So(err, ShouldBeNil)
+			So(member, ShouldBeTrue)
+			So(isNested, ShouldBeFalse)
+		})
+		Convey("Nested groups are only walked when asked", func() {
+			member, _, err := p.MemberVia(ctx, nested)
+			So(err, ShouldBeNil)
+			So(member, ShouldBeFalse)
+
+			p.NestedMembership = true
+			member, isNested, err := p.MemberVia(ctx, nested)
+			So(err, ShouldBeNil)
+			So(member, ShouldBeTrue)
+			So(isNested, ShouldBeTrue)
+			member, isNested, _ = p.MemberVia(ctx, direct)
+			So(member, ShouldBeTrue)
+			So(isNested, ShouldBeFalse)
+		})
+	})
+}
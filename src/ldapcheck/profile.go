@@ -0,0 +1,145 @@
+package ldapcheck
+
+import (
+	"context"
+	"github.com/go-ldap/ldap/v3"
+	"sort"
+	"strings"
+	"user-check/schema"
+	"user-check/utils/logger"
+)
+
+// ProfileGroups is the allow-list entry granting the group list of a profile, it is not an ldap attribute
+const ProfileGroups = "groups"
+
+// maxNestingDepth bounds the walk up nested groups on directories without the in-chain matching rule
+const maxNestingDepth = 10
+
+// Group is a group a user is a member of
+type Group struct {
+	Name string
+	DN   string
+}
+
+// UserEntry read the entry of isid with the given attributes and the account attributes, nil when there is no such user
+func (p *Provider) UserEntry(ctx context.Context, isid string, attributes []string) (*ldap.Entry, error) {
+	wanted := append([]string{p.Schema.LoginAttribute}, AccountAttributes...)
+	if p.Schema.HasMemberOf() {
+		wanted = append(wanted, p.Schema.MemberOfAttribute)
+	}
+	for _, attribute := range attributes {
+		if attribute != ProfileGroups {
+			wanted = append(wanted, attribute)
+		}
+	}
+	searchRequest := ldap.NewSearchRequest(p.SearchPeople, 2, 0, 0, 0, false,
+		p.Schema.UserFilter(ldap.EscapeFilter(isid)), wanted, nil)
+
+	sr, err := p.search(ctx, "profile:"+strings.ToLower(isid)+":"+strings.Join(attributes, ","), searchRequest)
+	if err != nil || len(sr.Entries) == 0 {
+		return nil, err
+	}
+	return sr.Entries[0], nil
+}
+
+// DirectGroups list the groups the user entry is a direct member of, read from memberOf when the
+// directory maintains it and searched by member otherwise
+func (p *Provider) DirectGroups(ctx context.Context, user *ldap.Entry) ([]Group, error) {
+	if p.Schema.HasMemberOf() {
+		var groups []Group
+		for _, dn := range user.GetAttributeValues(p.Schema.MemberOfAttribute) {
+			groups = append(groups, Group{Name: rdnValue(dn), DN: dn})
+		}
+		return sortGroups(groups), nil
+	}
+	member := user.DN
+	if !p.Schema.MembersAreDns() {
+		member = user.GetAttributeValue(p.Schema.LoginAttribute)
+	}
+	return p.groupsOf(ctx, member)
+}
+
+// NestedGroups list the groups the user is a member of only through other groups. Active Directory resolves
+// them in one search, elsewhere the groups are walked up to maxNestingDepth levels.
+func (p *Provider) NestedGroups(ctx context.Context, user *ldap.Entry, direct []Group) ([]Group, error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "go-user-check", "action", "read nested groups")
+	seen := map[string]bool{}
+	for _, g := range direct {
+		seen[strings.ToLower(g.DN)] = true
+	}
+
+	var nested []Group
+	if p.Schema.InChain {
+		filter := "(&(objectClass=" + p.Schema.GroupObjectClass + ")(" + p.Schema.MemberAttribute + ":" + schema.RuleInChain + ":=" + ldap.EscapeFilter(user.DN) + "))"
+		sr, err := p.search(ctx, "nested:"+strings.ToLower(user.DN), ldap.NewSearchRequest(p.GroupBase, 2, 0, 0, 0, false,
+			filter, []string{p.Schema.GroupNameAttribute}, nil))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range sr.Entries {
+			if !seen[strings.ToLower(entry.DN)] {
+				nested = append(nested, Group{Name: entry.GetAttributeValue(p.Schema.GroupNameAttribute), DN: entry.DN})
+			}
+		}
+		return sortGroups(nested), nil
+	}
+
+	// groups only nest when members are DNs
+	if !p.Schema.MembersAreDns() {
+		return nil, nil
+	}
+	level := direct
+	for depth := 0; len(level) > 0; depth++ {
+		if depth == maxNestingDepth {
+			log.Warnf("stopped walking nested groups of %s after %d levels", user.DN, maxNestingDepth)
+			break
+		}
+		var next []Group
+		for _, g := range level {
+			parents, err := p.groupsOf(ctx, g.DN)
+			if err != nil {
+				return nil, err
+			}
+			for _, parent := range parents {
+				if !seen[strings.ToLower(parent.DN)] {
+					seen[strings.ToLower(parent.DN)] = true
+					next = append(next, parent)
+				}
+			}
+		}
+		nested = append(nested, next...)
+		level = next
+	}
+	return sortGroups(nested), nil
+}
+
+// groupsOf search the groups listing member, a DN or a login depending on the member attribute
+func (p *Provider) groupsOf(ctx context.Context, member string) ([]Group, error) {
+	sr, err := p.search(ctx, "groups:"+strings.ToLower(member), ldap.NewSearchRequest(p.GroupBase, 2, 0, 0, 0, false,
+		p.Schema.MemberFilter(ldap.EscapeFilter(member)), []string{p.Schema.GroupNameAttribute}, nil))
+	if err != nil {
+		return nil, err
+	}
+	var groups []Group
+	for _, entry := range sr.Entries {
+		groups = append(groups, Group{Name: entry.GetAttributeValue(p.Schema.GroupNameAttribute), DN: entry.DN})
+	}
+	return sortGroups(groups), nil
+}
+
+// rdnValue return the value of the first RDN of dn, dn itself when it does not parse
+func rdnValue(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// sortGroups order groups by name for stable answers
+func sortGroups(groups []Group) []Group {
+	sort.Slice(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i].Name) < strings.ToLower(groups[j].Name)
+	})
+	return groups
+}
@@ -0,0 +1,14 @@
+package ldapcheck
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestRdnValue(t *testing.T) {
+	Convey(`Feature: group names of memberOf values`, t, func() {
+		So(rdnValue("CN=app users,OU=Groups,DC=example,DC=com"), ShouldEqual, "app users")
+		So(rdnValue(`cn=a\,b,ou=groups,dc=example,dc=com`), ShouldEqual, "a,b")
+		So(rdnValue("not a dn"), ShouldEqual, "not a dn")
+	})
+}
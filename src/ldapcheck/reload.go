@@ -0,0 +1,54 @@
+package ldapcheck
+
+import (
+	"time"
+	"user-check/breaker"
+	"user-check/configuration"
+)
+
+func init() {
+	configuration.OnReload(reload)
+}
+
+// reload switch the concurrency limit and breaker settings, and drop the cached answers
+// and breakers of a directory that is no longer queried
+func reload(old, next *configuration.Configuration) (func(), error) {
+	return func() {
+		if configuration.Changed(old, next, "LdapMaxConcurrent", "LdapQueueTimeoutMs") {
+			limitsMu.Lock()
+			active = newLimits(next)
+			limitsMu.Unlock()
+		}
+		if configuration.Changed(old, next, "BreakerThreshold", "BreakerProbeSec") {
+			breaker.Configure(int(next.BreakerThreshold), time.Duration(next.BreakerProbeSec)*time.Second)
+		}
+		if configuration.Changed(old, next, "LdapServerAddress", "Directories") {
+			kept := map[string]bool{}
+			for _, server := range allServers(next) {
+				kept[server] = true
+			}
+			for _, server := range allServers(old) {
+				if !kept[server] {
+					breaker.Forget(server)
+				}
+			}
+		}
+		if configuration.Changed(old, next, "LdapServerAddress", "SearchPeople", "LdapGroupBase", "LdapGroupContainer", "NpaUser", "Directories",
+			"LdapSchema", "LdapUserObjectClass", "LdapLoginAttribute", "LdapGroupObjectClass", "LdapGroupNameAttribute",
+			"LdapMemberAttribute", "LdapMemberOfAttribute") {
+			cacheMu.Lock()
+			cache = map[string]cacheEntry{}
+			cacheMu.Unlock()
+		}
+	}, nil
+}
+
+// allServers list the ldap servers of every directory
+func allServers(c *configuration.Configuration) []string {
+	var all []string
+	for _, name := range c.DirectoryNames() {
+		d, _ := c.Directory(name)
+		all = append(all, servers(d.LdapServerAddress)...)
+	}
+	return all
+}
@@ -0,0 +1,139 @@
+package ldapcheck
+
+import (
+	"context"
+	"errors"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"time"
+	"user-check/breaker"
+	"user-check/configuration"
+	"user-check/utils/logger"
+)
+
+// searchPageSize entries are requested per page, below the Active Directory MaxPageSize of 1000
+const searchPageSize = 500
+
+// ErrCircuitOpen every ldap server breaker is open and there is no snapshot to fall back to
+var ErrCircuitOpen = breaker.ErrOpen
+
+// breaker return the circuit breaker of server
+func (p *Provider) breaker(server string) *breaker.Breaker {
+	conf := configuration.AppConfig()
+	return breaker.For(server, int(conf.BreakerThreshold), time.Duration(conf.BreakerProbeSec)*time.Second)
+}
+
+// search run searchRequest as the npa account. Answers are cached under key, fresh ones are
+// served from cache and, while the directory is unavailable, the last known one is served as snapshot.
+func (p *Provider) search(ctx context.Context, key string, searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "group-license", "action", "ldap search")
+	conf := configuration.AppConfig()
+	// the same user or group may exist in several directories
+	key = p.Directory + "/" + key
+
+	if sr, at, ok := cacheGet(key, time.Duration(conf.CacheTtlSec)*time.Second); ok {
+		p.answered(SourceCache, at)
+		return sr, nil
+	}
+
+	sr, err := p.searchLive(ctx, searchRequest)
+	if err == nil {
+		cachePut(key, sr)
+		p.answered(SourceLive, time.Now())
+		return sr, nil
+	}
+	if !isUnavailable(err) {
+		return &ldap.SearchResult{}, err
+	}
+
+	if snapshot, at, ok := cacheGet(key, time.Duration(conf.SnapshotMaxAgeSec)*time.Second); ok {
+		log.Warnf("directory unavailable (%s), answering from snapshot taken at %s", err, at.Format(time.RFC3339))
+		p.answered(SourceSnapshot, at)
+		return snapshot, nil
+	}
+	return &ldap.SearchResult{}, err
+}
+
+// searchLive run searchRequest against the directory, reporting the outcome to the server breaker
+func (p *Provider) searchLive(ctx context.Context, searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "group-license", "action", "ldap search")
+
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	l, err := p.FuncDialLdap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// any good developer is closing the connection after we done with it
+	defer l.Close()
+	b := p.breaker(p.server)
+
+	err = l.Bind(p.NpaUser, p.NpaPassword)
+	if err != nil {
+		log.Debugf("error binding:%s", err)
+		if isBackendFailure(err) {
+			b.Failure()
+			return nil, err
+		}
+	}
+
+	// paged so groups larger than the server size limit are read whole
+	sr, err := l.SearchWithPaging(searchRequest, searchPageSize)
+	if err != nil {
+		log.Debugf("Failed to search:%v", err)
+		if isBackendFailure(err) {
+			b.Failure()
+		} else {
+			b.Success()
+		}
+		return nil, err
+	}
+	b.Success()
+	return sr, nil
+}
+
+// Ping dial the directory and bind as the npa account, reporting the outcome to the server breaker like a search
+// does, so that a health check taking the half-open probe closes or re-opens the breaker. A bind refused for
+// another reason than the server being down, e.g. invalid credentials, fails the check and leaves the breaker as is.
+func (p *Provider) Ping(ctx context.Context) error {
+	log := logger.SugaredLogger().WithContextCorrelationId(ctx).With("package", "group-license", "action", "ldap ping")
+
+	release, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	l, err := p.FuncDialLdap(ctx)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	b := p.breaker(p.server)
+
+	if err = l.Bind(p.NpaUser, p.NpaPassword); err != nil {
+		log.Debugf("error binding:%s", err)
+		if isBackendFailure(err) {
+			b.Failure()
+		}
+		return err
+	}
+	b.Success()
+	return nil
+}
+
+// isBackendFailure tell whether err means the server is down or overloaded, as opposed to a bad request
+func isBackendFailure(err error) bool {
+	return ldap.IsErrorAnyOf(err, ldap.ErrorNetwork, ldap.LDAPResultBusy, ldap.LDAPResultUnavailable,
+		ldap.LDAPResultTimeLimitExceeded)
+}
+
+// isUnavailable tell whether err means the directory could not answer, so a snapshot may be served
+func isUnavailable(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrQueueTimeout) || isBackendFailure(err) ||
+		errors.As(err, new(net.Error))
+}
@@ -0,0 +1,115 @@
+package ldapcheck
+
+import (
+	"context"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"user-check/breaker"
+	"user-check/configuration"
+	"user-check/utils/logger"
+)
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+// fakeLdap serve binds answered with bindResult and empty searches on a local port, returning its ldap url
+func fakeLdap(t *testing.T, bindResult int64) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveLdap(conn, bindResult)
+		}
+	}()
+	return "ldap://" + ln.Addr().String()
+}
+
+// serveLdap answer the requests of a connection until it is closed or unbound
+func serveLdap(conn net.Conn, bindResult int64) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		var tag ber.Tag
+		var result int64
+		switch packet.Children[1].Tag {
+		case ldap.ApplicationBindRequest:
+			tag, result = ldap.ApplicationBindResponse, bindResult
+		case ldap.ApplicationSearchRequest:
+			tag = ldap.ApplicationSearchResultDone
+		default:
+			return
+		}
+		res := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, packet.Children[0].Value, ""))
+		op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+		op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, result, ""))
+		op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		res.AppendChild(op)
+		if _, err = conn.Write(res.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	Convey(`Feature: health checks report to the circuit breaker`, t, func() {
+		address := fakeLdap(t, ldap.LDAPResultSuccess)
+		os.Setenv("LDAP_ADDR", address)
+		defer os.Unsetenv("LDAP_ADDR")
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+		ctx := context.Background()
+		p, err := New(ctx)
+		So(err, ShouldBeNil)
+
+		Convey("A status check taking the half-open probe lets lookups through again", func() {
+			// opened by a failure, probed at once
+			b := breaker.For(address, 1, 0)
+			b.Failure()
+			So(b.State(), ShouldEqual, breaker.Open)
+
+			So(p.Ping(ctx), ShouldBeNil)
+			So(b.State(), ShouldEqual, breaker.Closed)
+			_, err := p.CheckUserLdap(ctx, map[string]interface{}{"isid": "bordeanu"})
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey(`Feature: a health check with a rejected bind fails`, t, func() {
+		address := fakeLdap(t, ldap.LDAPResultInvalidCredentials)
+		os.Setenv("LDAP_ADDR", address)
+		defer os.Unsetenv("LDAP_ADDR")
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+		ctx := context.Background()
+		p, err := New(ctx)
+		So(err, ShouldBeNil)
+
+		Convey("Invalid credentials are reported without opening or closing the breaker", func() {
+			b := breaker.For(address, 1, time.Hour)
+			So(ldap.IsErrorWithCode(p.Ping(ctx), ldap.LDAPResultInvalidCredentials), ShouldBeTrue)
+			So(b.State(), ShouldEqual, breaker.Closed)
+
+			b.Failure()
+			So(p.Ping(ctx), ShouldNotBeNil)
+			So(b.State(), ShouldEqual, breaker.Open)
+		})
+	})
+}
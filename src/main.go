@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/spf13/pflag"
 	"user-check/api"
+	"user-check/audit"
+	"user-check/certs"
 	"user-check/configuration"
 	"user-check/docs"
+	"user-check/identity"
+	"user-check/ldapcheck"
+	"user-check/opa"
+	"user-check/rbac"
 	"user-check/utils/go-stats/concurrency"
 	"user-check/utils/logger"
+	"user-check/webhook"
 	"os"
 	"os/signal"
 	"syscall"
@@ -23,18 +31,27 @@ func main() {
 		cancel context.CancelFunc
 	)
 
-	appConfig := configuration.AppConfig()
-
-	pflag.Int32VarP(&appConfig.CleanupTimeoutSec, "timeout", "t", 60, "Time to wait for graceful shutdown on SIGTERM/SIGINT in seconds. Default: 60")
-	pflag.Int32VarP(&appConfig.HttpPort, "port", "p", 8080, "TCP port for the HTTP listener to bind to. Default: 8080")
-	pflag.BoolVarP(&appConfig.UseSwagger, "swagger", "s", false, "Activate swagger. Do not use this in Production!")
-	pflag.BoolVarP(&appConfig.Development, "devel", "d", false, "Start in development mode. Implies --swagger. Do not use this in Production!")
-	pflag.BoolVarP(&appConfig.Tls, "tls", "l", false, "Active TLS in listener. Implies ssl keys env vars set")
+	flags := configuration.BindFlags(pflag.CommandLine)
 	pflag.Parse()
 
+	appConfig, err := configuration.Load(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if flags.PrintConfig {
+		out, err := appConfig.Redacted()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Print(out)
+		os.Exit(0)
+	}
+
 	ctx = context.Background()
 	ctx, cancel = context.WithCancel(ctx)
-	cSignal := make(chan os.Signal)
+	cSignal := make(chan os.Signal, 1)
 	signal.Notify(cSignal, os.Interrupt, syscall.SIGTERM)
 
 	logger.Init(ctx, appConfig.Development)
@@ -56,10 +73,6 @@ func main() {
 
 	}
 
-	if appConfig.Development {
-		appConfig.UseSwagger = true
-	}
-
 	if appConfig.UseSwagger {
 		appConfig.LoadSwaggerConf()
 		docs.SwaggerInfo.Title = appConfig.Swagger.Title
@@ -69,20 +82,104 @@ func main() {
 	}
 	log.Infof(docs.SwaggerInfo.BasePath)
 
+	// make sure the npa passwords can be read, and are not the placeholder in production
+	for _, name := range appConfig.DirectoryNames() {
+		directory, _ := appConfig.Directory(name)
+		npaPassword, err := directory.NpaPasswordSecret().Secret(ctx)
+		if err != nil {
+			log.Fatalf("Unable to read the npa password of directory %s: %s", name, err)
+		}
+		if npaPassword == configuration.DefaultNpaPassword {
+			if !appConfig.Development {
+				log.Fatalf("The default npa password is still set for directory %s, refusing to start in production mode. Set NPA_PASSWORD, NPA_PASSWORD_FILE, NPA_PASSWORD_COMMAND or NPA_PASSWORD_ENV", name)
+			}
+			log.Warnf("The default npa password is still set for directory %s, this is only allowed in development mode", name)
+		}
+	}
+
+	if err := audit.Init(auditOptions(appConfig)); err != nil {
+		log.Fatalf("Unable to open audit log: %s", err)
+	}
+
+	if appConfig.ClientIdentityFile != "" {
+		mapper, err := identity.Load(appConfig.ClientIdentityFile)
+		if err != nil {
+			log.Fatalf("Unable to load client identities: %s", err)
+		}
+		identity.Set(mapper)
+	}
+
+	if appConfig.K8sAuthzFile != "" {
+		rules, err := rbac.Load(appConfig.K8sAuthzFile)
+		if err != nil {
+			log.Fatalf("Unable to load kubernetes rules: %s", err)
+		}
+		rbac.Set(rules)
+	}
+
+	if err := watchPolicy(ctx, appConfig); err != nil {
+		log.Fatalf("Unable to load caller policy: %s", err)
+	}
+	if appConfig.PolicyFile == "" {
+		log.Warnf("No caller policy configured, every caller may query every group")
+	}
+	registerReloadHooks(ctx)
+
 	go func() {
 		<-cSignal
 		log.Warnf("SIGTERM received, attempting graceful exit.")
 		cancel()
 	}()
 
+	// load the ldap CA bundles up front so their expiry shows up in status and metrics
+	for _, name := range appConfig.DirectoryNames() {
+		directory, _ := appConfig.Directory(name)
+		if directory.LdapCertFile == "" {
+			continue
+		}
+		if _, err := certs.SystemCAPool(ldapcheck.CAName(name), directory.LdapCertFile); err != nil {
+			log.Warnf("Unable to load ldap CA bundle of directory %s: %s", name, err)
+		}
+	}
+	if appConfig.CertReloadSec > 0 {
+		certs.Watch(ctx, time.Second*time.Duration(appConfig.CertReloadSec))
+	}
+	if appConfig.OpaBundleRefreshSec > 0 {
+		opa.Run(ctx, time.Second*time.Duration(appConfig.OpaBundleRefreshSec))
+	}
+	if appConfig.WebhookRefreshSec > 0 {
+		if err := webhook.Run(ctx, time.Second*time.Duration(appConfig.WebhookRefreshSec)); err != nil {
+			log.Fatalf("Unable to open the webhook outbox: %s", err)
+		}
+	}
+	hupSignal := make(chan os.Signal, 1)
+	signal.Notify(hupSignal, syscall.SIGHUP)
+	go func() {
+		for range hupSignal {
+			log.Infof("SIGHUP received, reloading configuration and certificates.")
+			// an invalid configuration is logged and the current one kept
+			_, _ = configuration.Reload()
+			certs.ReloadAll()
+		}
+	}()
+
 	log.Info("Starting webapi handler")
 	concurrency.GlobalWaitGroup.Add(1)
 	go api.StartGin(ctx)
+	if appConfig.ExtAuthzPort > 0 {
+		concurrency.GlobalWaitGroup.Add(1)
+		go api.StartExtAuthz(ctx)
+	}
+	if appConfig.GrpcPort > 0 {
+		concurrency.GlobalWaitGroup.Add(1)
+		go api.StartGrpc(ctx)
+	}
 
 	<-ctx.Done()
 
-	log.Infof("Graceful shutdown initiated. Waiting for %d seconds before forced exit.", appConfig.CleanupTimeoutSec)
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second*time.Duration(appConfig.CleanupTimeoutSec))
+	cleanupTimeoutSec := configuration.AppConfig().CleanupTimeoutSec
+	log.Infof("Graceful shutdown initiated. Waiting for %d seconds before forced exit.", cleanupTimeoutSec)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*time.Duration(cleanupTimeoutSec))
 	go func() {
 		concurrency.GlobalWaitGroup.Wait()
 		log.Infof("Cleanup done.")
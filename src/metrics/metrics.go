@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "usercheck"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// CertificateExpiry expiry of the certificates loaded by the service, as unix timestamp
+	CertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Expiry (NotAfter) of the certificates loaded by the service.",
+	}, []string{"certificate", "file"})
+
+	// CertificateReloads certificate reloads by outcome
+	CertificateReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "certificate_reloads_total",
+		Help:      "Certificate reloads by outcome.",
+	}, []string{"certificate", "result"})
+
+	// RateLimited requests refused by the per caller rate limit
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests refused with 429 by the per caller rate limit, by key type (caller or ip).",
+	}, []string{"key_type"})
+
+	// AuthAttempts credential verifications by result
+	AuthAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authentications_total",
+		Help:      "Credential verifications by result: success, invalid, backoff or error.",
+	}, []string{"result"})
+
+	// RateLimitConfig configured per caller rate limit
+	RateLimitConfig = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rate_limit",
+		Help:      "Configured per caller rate limit, requests per second and burst.",
+	}, []string{"setting"})
+
+	// LdapInFlight ldap operations currently running
+	LdapInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ldap_operations_in_flight",
+		Help:      "Ldap operations currently running.",
+	})
+
+	// LdapQueued ldap operations waiting for a slot
+	LdapQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ldap_operations_queued",
+		Help:      "Ldap operations waiting for a free concurrency slot.",
+	})
+
+	// LdapQueueTimeouts ldap operations given up while queued
+	LdapQueueTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ldap_queue_timeouts_total",
+		Help:      "Ldap operations refused because no concurrency slot freed up in time.",
+	})
+
+	// BreakerState state of the per server circuit breakers
+	BreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ldap_breaker_state",
+		Help:      "State of the per ldap server circuit breaker: 0 closed, 1 half-open, 2 open.",
+	}, []string{"server"})
+
+	// BreakerTransitions circuit breaker state changes
+	BreakerTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ldap_breaker_transitions_total",
+		Help:      "Circuit breaker state changes by server and new state.",
+	}, []string{"server", "state"})
+
+	// LdapAnswers directory answers by source (live, cache or snapshot)
+	LdapAnswers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ldap_answers_total",
+		Help:      "Directory answers by source: live, cache or snapshot.",
+	}, []string{"source"})
+
+	// LdapMaxConcurrent configured ldap concurrency limit
+	LdapMaxConcurrent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ldap_max_concurrent_operations",
+		Help:      "Configured limit of concurrent ldap operations, 0 means unlimited.",
+	})
+
+	// WebhookEvents membership changes found, by type (join or leave)
+	WebhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "Membership change events found, by type: join or leave.",
+	}, []string{"type"})
+
+	// WebhookDeliveries webhook posts by result (delivered or failed)
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook event posts by result: delivered or failed.",
+	}, []string{"result"})
+
+	// WebhookMissed events a url should have got that were no longer kept
+	WebhookMissed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_missed_events_total",
+		Help:      "Membership change events a webhook url should have got that were no longer kept.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		CertificateExpiry,
+		CertificateReloads,
+		RateLimited,
+		RateLimitConfig,
+		AuthAttempts,
+		LdapInFlight,
+		LdapQueued,
+		LdapQueueTimeouts,
+		LdapMaxConcurrent,
+		BreakerState,
+		BreakerTransitions,
+		LdapAnswers,
+		WebhookEvents,
+		WebhookDeliveries,
+		WebhookMissed,
+	)
+}
+
+// Register add collectors to the service registry
+func Register(cs ...prometheus.Collector) {
+	registry.MustRegister(cs...)
+}
+
+// Handler gin handler serving the prometheus metrics
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"fmt"
+)
+
+// Authenticate is the body of a credential verification
+type Authenticate struct {
+	Isid     string `json:"isid" example:"bordeanu"`
+	Password string `json:"password" example:"secret"`
+}
+
+func (r *Authenticate) Validate() error {
+	if r.Isid == "" {
+		return fmt.Errorf("user name is a required parameter")
+	}
+	if r.Password == "" {
+		return fmt.Errorf("password is a required parameter")
+	}
+	return nil
+}
+
+// AuthenticateResult is the decision of a credential verification: whether the password is right and,
+// when it is, whether the user is a member of the group
+type AuthenticateResult struct {
+	Authenticated  bool     `json:"authenticated" example:"true"`
+	Authorized     bool     `json:"authorized" example:"true"`
+	Directory      string   `json:"directory,omitempty" example:"default"`
+	AccountState   string   `json:"account_state,omitempty" example:"active"`
+	AccountReasons []string `json:"account_reasons,omitempty"`
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"strconv"
+)
+
+// UserCheckResult is the detailed answer of a user check, returned for verbose and fanned out requests
+type UserCheckResult struct {
+	Status    string `json:"status" example:"true"`
+	Directory string `json:"directory,omitempty" example:"contractors"`
+	// Isid is the login of the user when it was identified by another identifier type
+	Isid           string   `json:"isid,omitempty" example:"bordeanu"`
+	AccountState   string   `json:"account_state,omitempty" example:"active"`
+	AccountReasons []string `json:"account_reasons,omitempty"`
+}
+
+// String is the text form of the answer, true or false
+func (r UserCheckResult) String() string {
+	return r.Status
+}
+
+// MemberCount is the member count of a group broken down by account state.
+// Members that are not user accounts, e.g. nested groups, are only in the total.
+type MemberCount struct {
+	Total    int `json:"total" example:"42"`
+	Active   int `json:"active" example:"40"`
+	Inactive int `json:"inactive" example:"2"`
+}
+
+// String is the text form of the count, the total
+func (m MemberCount) String() string {
+	return strconv.Itoa(m.Total)
+}
+
+// DirectoryCount is the answer of a user count fanned out to every directory
+type DirectoryCount struct {
+	Total       int                    `json:"total" example:"42"`
+	Directories map[string]int         `json:"directories"`
+	Breakdown   map[string]MemberCount `json:"breakdown,omitempty"`
+	// Errors are the directories that could not be counted, they are left out of the total
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// String is the text form of the count, the total
+func (d DirectoryCount) String() string {
+	return strconv.Itoa(d.Total)
+}
@@ -0,0 +1,13 @@
+package model
+
+// Member is a user member of the group of a directory
+type Member struct {
+	Isid         string `json:"isid" example:"bordeanu"`
+	Directory    string `json:"directory" example:"default"`
+	AccountState string `json:"account_state" example:"active"`
+}
+
+// String is the text form of a member, its isid
+func (m Member) String() string {
+	return m.Isid
+}
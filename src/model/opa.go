@@ -0,0 +1,11 @@
+package model
+
+// OpaUserGroups is the group list of a user, shaped for OPA http.send: unknown users are found:false with no groups
+type OpaUserGroups struct {
+	User         string   `json:"user" example:"bordeanu"`
+	Found        bool     `json:"found" example:"true"`
+	Directory    string   `json:"directory,omitempty" example:"default"`
+	Active       bool     `json:"active" example:"true"`
+	AccountState string   `json:"account_state,omitempty" example:"active"`
+	Groups       []string `json:"groups"`
+}
@@ -0,0 +1,16 @@
+package model
+
+// ProblemContentType is the media type of problem details
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object, the failure answer of the v2 api
+type Problem struct {
+	Type   string `json:"type" example:"about:blank"`
+	Title  string `json:"title" example:"Not Found"`
+	Status int    `json:"status" example:"404"`
+	// Detail is only sent in development mode
+	Detail        string      `json:"detail,omitempty" example:"user bordeanu not found"`
+	Instance      string      `json:"instance,omitempty" example:"/api/v2/users/bordeanu"`
+	CorrelationId string      `json:"correlation_id" example:"705e4dcb-3ecd-24f3-3a35-3e926e4bded5"`
+	Data          interface{} `json:"data,omitempty"`
+}
@@ -0,0 +1,26 @@
+package model
+
+// UserProfile is the normalised profile of a user. Only the attributes of the profile allow-list are filled in.
+type UserProfile struct {
+	Isid           string              `json:"isid" example:"bordeanu"`
+	Directory      string              `json:"directory" example:"default"`
+	DN             string              `json:"dn" example:"CN=Dan Bordeanu,OU=People,DC=example,DC=com"`
+	DisplayName    string              `json:"display_name,omitempty" example:"Dan Bordeanu"`
+	Mail           string              `json:"mail,omitempty" example:"dan.bordeanu@example.com"`
+	GivenName      string              `json:"given_name,omitempty" example:"Dan"`
+	Surname        string              `json:"surname,omitempty" example:"Bordeanu"`
+	Department     string              `json:"department,omitempty" example:"Engineering"`
+	Title          string              `json:"title,omitempty" example:"Developer"`
+	Manager        string              `json:"manager,omitempty" example:"CN=Jane Doe,OU=People,DC=example,DC=com"`
+	AccountState   string              `json:"account_state" example:"active"`
+	AccountReasons []string            `json:"account_reasons,omitempty"`
+	Groups         []GroupRef          `json:"groups,omitempty"`
+	NestedGroups   []GroupRef          `json:"nested_groups,omitempty"`
+	Attributes     map[string][]string `json:"attributes,omitempty"`
+}
+
+// GroupRef names a group of a user profile
+type GroupRef struct {
+	Name string `json:"name" example:"app-users"`
+	DN   string `json:"dn" example:"CN=app-users,OU=Groups,DC=example,DC=com"`
+}
@@ -0,0 +1,25 @@
+package model
+
+// Reverse lookup outcomes
+const (
+	ResolveFound     = "found"
+	ResolveAmbiguous = "ambiguous"
+	ResolveNotFound  = "not_found"
+)
+
+// ResolveResult is the answer of a reverse lookup: the isid of the single match, every match when ambiguous
+type ResolveResult struct {
+	Status    string         `json:"status" example:"found"`
+	Isid      string         `json:"isid,omitempty" example:"bordeanu"`
+	Directory string         `json:"directory,omitempty" example:"default"`
+	Matches   []ResolvedUser `json:"matches"`
+}
+
+// ResolvedUser is an account matching a reverse lookup
+type ResolvedUser struct {
+	Isid              string `json:"isid" example:"bordeanu"`
+	Directory         string `json:"directory" example:"default"`
+	DN                string `json:"dn" example:"CN=Dan Bordeanu,OU=People,DC=example,DC=com"`
+	Mail              string `json:"mail,omitempty" example:"dan.bordeanu@example.com"`
+	UserPrincipalName string `json:"user_principal_name,omitempty" example:"bordeanu@example.com"`
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"fmt"
+)
+
+// SubjectAccessReview identifiers of the authorization.k8s.io/v1 webhook api
+const (
+	SubjectAccessReviewApiVersion = "authorization.k8s.io/v1"
+	SubjectAccessReviewKind       = "SubjectAccessReview"
+)
+
+// SubjectAccessReview is the authorization.k8s.io/v1 object the kubernetes apiserver posts to its authorization
+// webhook, answered with its status set. Only the fields the webhook reads are declared.
+type SubjectAccessReview struct {
+	ApiVersion string                    `json:"apiVersion" example:"authorization.k8s.io/v1"`
+	Kind       string                    `json:"kind" example:"SubjectAccessReview"`
+	Spec       SubjectAccessReviewSpec   `json:"spec"`
+	Status     SubjectAccessReviewStatus `json:"status"`
+}
+
+// SubjectAccessReviewSpec is the request: who asks, and for a resource or a non-resource url
+type SubjectAccessReviewSpec struct {
+	User                  string                 `json:"user,omitempty" example:"oidc:bordeanu"`
+	Groups                []string               `json:"groups,omitempty"`
+	UID                   string                 `json:"uid,omitempty"`
+	ResourceAttributes    *ResourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *NonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+}
+
+// ResourceAttributes describe a request on a kubernetes resource
+type ResourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty" example:"team-a"`
+	Verb        string `json:"verb,omitempty" example:"get"`
+	Group       string `json:"group,omitempty" example:"apps"`
+	Version     string `json:"version,omitempty" example:"v1"`
+	Resource    string `json:"resource,omitempty" example:"deployments"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// NonResourceAttributes describe a request on an url that is not a resource, e.g. /healthz
+type NonResourceAttributes struct {
+	Path string `json:"path,omitempty" example:"/healthz"`
+	Verb string `json:"verb,omitempty" example:"get"`
+}
+
+// SubjectAccessReviewStatus is the decision. Neither allowed nor denied leaves it to the other authorizers.
+type SubjectAccessReviewStatus struct {
+	Allowed         bool   `json:"allowed"`
+	Denied          bool   `json:"denied,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	EvaluationError string `json:"evaluationError,omitempty"`
+}
+
+func (r *SubjectAccessReview) Validate() error {
+	if r.ApiVersion != SubjectAccessReviewApiVersion || r.Kind != SubjectAccessReviewKind {
+		return fmt.Errorf("expected a %s %s, got %s %s", SubjectAccessReviewApiVersion, SubjectAccessReviewKind, r.ApiVersion, r.Kind)
+	}
+	if r.Spec.ResourceAttributes == nil && r.Spec.NonResourceAttributes == nil {
+		return fmt.Errorf("resourceAttributes or nonResourceAttributes are required")
+	}
+	return nil
+}
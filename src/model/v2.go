@@ -0,0 +1,83 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// Membership is the v2 answer of a user check
+type Membership struct {
+	// User is the identifier asked for, Isid the login of the user found
+	User   string `json:"user" example:"bordeanu"`
+	Isid   string `json:"isid,omitempty" example:"bordeanu"`
+	Found  bool   `json:"found" example:"true"`
+	Member bool   `json:"member" example:"true"`
+	// MatchedGroups are the checked groups the user is a member of, none when not a member
+	MatchedGroups  []string `json:"matched_groups" example:"group.users"`
+	Directory      string   `json:"directory,omitempty" example:"default"`
+	AccountState   string   `json:"account_state,omitempty" example:"active"`
+	AccountReasons []string `json:"account_reasons,omitempty"`
+	// Source is live, cache or snapshot, AnsweredAt when the directory gave the answer
+	Source     string     `json:"source,omitempty" example:"live"`
+	AnsweredAt *time.Time `json:"answered_at,omitempty"`
+	CheckedAt  time.Time  `json:"checked_at"`
+}
+
+// Unstamped is the membership without when and where it was answered from
+func (m Membership) Unstamped() interface{} {
+	m.Source, m.AnsweredAt, m.CheckedAt = "", nil, time.Time{}
+	return m
+}
+
+// String is the text form of the membership, true or false
+func (m Membership) String() string {
+	return strconv.FormatBool(m.Member)
+}
+
+// GroupCount is the v2 answer of a user count
+type GroupCount struct {
+	Total       int                            `json:"total" example:"42"`
+	Directories map[string]DirectoryGroupCount `json:"directories"`
+	// Errors are the directories that could not be counted, they are left out of the total
+	Errors    map[string]string `json:"errors,omitempty"`
+	CountedAt time.Time         `json:"counted_at"`
+}
+
+// Unstamped is the count without when it was counted
+func (g GroupCount) Unstamped() interface{} {
+	g.CountedAt = time.Time{}
+	return g
+}
+
+// String is the text form of the count, the total
+func (g GroupCount) String() string {
+	return strconv.Itoa(g.Total)
+}
+
+// DirectoryGroupCount is the member count of the group of a directory. Active and inactive members are only
+// counted with breakdown.
+type DirectoryGroupCount struct {
+	Group    string `json:"group" example:"group.users"`
+	Total    int    `json:"total" example:"42"`
+	Active   *int   `json:"active,omitempty" example:"40"`
+	Inactive *int   `json:"inactive,omitempty" example:"2"`
+}
+
+// ServiceStatus is the v2 health check answer
+type ServiceStatus struct {
+	Pid int `json:"pid" example:"4242"`
+	// Ready is false when every ldap server circuit breaker is open
+	Ready bool `json:"ready" example:"true"`
+	// Directories are up or down, by name, the default one included
+	Directories       map[string]string    `json:"directories"`
+	Breakers          map[string]string    `json:"breakers"`
+	CertificateExpiry map[string]time.Time `json:"certificate_expiry"`
+	CheckedAt         time.Time            `json:"checked_at"`
+}
+
+// Readiness is the v2 readiness answer
+type Readiness struct {
+	Ready     bool              `json:"ready" example:"true"`
+	Breakers  map[string]string `json:"breakers"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// Membership change events
+const (
+	EventJoin  = "join"
+	EventLeave = "leave"
+)
+
+// MembershipEvent is a user joining or leaving a watched group, as posted to the webhooks
+type MembershipEvent struct {
+	// Id is unique to the event, a redelivery or a replay carries the same id
+	Id string `json:"id" example:"5f0c6e2a9b1d4c7e8a3f2b6d1e9c4a70"`
+	// Sequence orders the events, replays start after a sequence
+	Sequence  int64     `json:"sequence" example:"42"`
+	Type      string    `json:"type" example:"join" enums:"join,leave"`
+	Directory string    `json:"directory" example:"default"`
+	Group     string    `json:"group" example:"group.users"`
+	Isid      string    `json:"isid" example:"bordeanu"`
+	At        time.Time `json:"at"`
+}
+
+// WebhookReplay is the answer of a replay: the events after Since are posted again to the urls
+type WebhookReplay struct {
+	Since int64 `json:"since" example:"40"`
+	// Events is the number of events posted again, summed over the urls
+	Events int                `json:"events" example:"2"`
+	Urls   []WebhookUrlReplay `json:"urls"`
+}
+
+// WebhookUrlReplay is the replay of one url, it starts at the oldest kept event when older ones are gone
+type WebhookUrlReplay struct {
+	Url    string `json:"url" example:"https://provisioning.example.com/hooks"`
+	Since  int64  `json:"since" example:"40"`
+	Events int    `json:"events" example:"2"`
+}
@@ -0,0 +1,182 @@
+package opa
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+	"user-check/configuration"
+	"user-check/ldapcheck"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// Root is the bundle root, policies read the snapshot at data.usercheck
+const Root = "usercheck"
+
+// Snapshot is the group membership published in the bundle, per directory
+type Snapshot struct {
+	Directories map[string]DirectoryGroups `json:"directories"`
+}
+
+// DirectoryGroups list the direct user members of each group and, the other way round, the groups of each user
+type DirectoryGroups struct {
+	Groups map[string][]string `json:"groups"`
+	Users  map[string][]string `json:"users"`
+}
+
+// Bundle is a built bundle tarball with its data.json and .manifest
+type Bundle struct {
+	Archive []byte
+	// Revision is the hash of data.json, the ETag is the quoted revision
+	Revision string
+	ETag     string
+	BuiltAt  time.Time
+	// Directories are the directories whose members are in the bundle, sorted
+	Directories []string
+}
+
+var current atomic.Value
+
+// Current return the last bundle built, nil before the first one
+func Current() *Bundle {
+	b, _ := current.Load().(*Bundle)
+	return b
+}
+
+// NewBundle build the tarball of the snapshot. The same snapshot always gives the same revision,
+// so OPA polling an unchanged bundle gets a 304.
+func NewBundle(snapshot Snapshot, builtAt time.Time) (*Bundle, error) {
+	data, err := json.Marshal(map[string]Snapshot{Root: snapshot})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	revision := hex.EncodeToString(sum[:16])
+	manifest, err := json.Marshal(map[string]interface{}{"revision": revision, "roots": []string{Root}})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"/data.json", data}, {"/.manifest", manifest}} {
+		if err = tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}); err != nil {
+			return nil, err
+		}
+		if _, err = tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+	directories := make([]string, 0, len(snapshot.Directories))
+	for name := range snapshot.Directories {
+		directories = append(directories, name)
+	}
+	sort.Strings(directories)
+	return &Bundle{Archive: buf.Bytes(), Revision: revision, ETag: `"` + revision + `"`, BuiltAt: builtAt,
+		Directories: directories}, nil
+}
+
+// Publish make b the bundle served
+func Publish(b *Bundle) {
+	current.Store(b)
+}
+
+// BuildSnapshot read the members of the groups of every directory: its configured group and the
+// OPA_BUNDLE_GROUPS. With INACTIVE_NOT_MEMBER enabled, disabled, locked and expired accounts are left out.
+func BuildSnapshot(ctx context.Context, conf *configuration.Configuration) (Snapshot, error) {
+	return ReadSnapshot(ctx, conf, conf.OpaBundleGroupList())
+}
+
+// ReadSnapshot read the members of the configured group of every directory and of groups, like BuildSnapshot
+func ReadSnapshot(ctx context.Context, conf *configuration.Configuration, groups []string) (Snapshot, error) {
+	snapshot := Snapshot{Directories: map[string]DirectoryGroups{}}
+	for _, name := range conf.DirectoryNames() {
+		p, err := ldapcheck.NewForDirectory(ctx, name)
+		if err != nil {
+			return snapshot, err
+		}
+		directory, _ := conf.Directory(name)
+		members := DirectoryGroups{Groups: map[string][]string{}, Users: map[string][]string{}}
+		for _, group := range append([]string{directory.OncoGroup}, groups...) {
+			if _, done := members.Groups[group]; done {
+				continue
+			}
+			g := p.ForGroup(group)
+			entries, err := g.QueryUserGroupLdap(ctx)
+			if err != nil {
+				return snapshot, err
+			}
+			logins, err := g.MemberLogins(ctx, entries, conf.InactiveNotMember)
+			if err != nil {
+				return snapshot, err
+			}
+			members.Groups[group] = logins
+			for _, login := range logins {
+				members.Users[login] = append(members.Users[login], group)
+			}
+		}
+		for _, memberOf := range members.Users {
+			sort.Strings(memberOf)
+		}
+		snapshot.Directories[name] = members
+	}
+	return snapshot, nil
+}
+
+// Run build the bundle now and then every interval until ctx is done. A failed build is logged
+// and the previous bundle is kept.
+func Run(ctx context.Context, interval time.Duration) {
+	log := logger.SugaredLogger().With("package", "opa", "action", "build bundle")
+
+	build := func() {
+		snapshot, err := BuildSnapshot(ctx, configuration.AppConfig())
+		if err != nil {
+			log.Errorf("keeping previous bundle: %v", err)
+			return
+		}
+		b, err := NewBundle(snapshot, time.Now())
+		if err != nil {
+			log.Errorf("keeping previous bundle: %v", err)
+			return
+		}
+		if previous := Current(); previous != nil && previous.Revision == b.Revision {
+			log.Debugf("bundle unchanged, revision %s", b.Revision)
+			return
+		}
+		Publish(b)
+		log.Infof("built bundle revision %s", b.Revision)
+	}
+
+	concurrency.GlobalWaitGroup.Add(1)
+	go func() {
+		defer concurrency.GlobalWaitGroup.Done()
+		build()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				build()
+			}
+		}
+	}()
+}
@@ -0,0 +1,80 @@
+package opa
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+	"user-check/utils"
+)
+
+// untar read the files of a bundle tarball
+func untar(archive []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if files[h.Name], err = ioutil.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestBundle(t *testing.T) {
+	Convey(`Feature: group snapshots are published as OPA bundles`, t, func() {
+		snapshot := Snapshot{Directories: map[string]DirectoryGroups{"default": {
+			Groups: map[string][]string{"group.users": {"bordeanu", "smith"}},
+			Users:  map[string][]string{"bordeanu": {"group.users"}, "smith": {"group.users"}},
+		}}}
+		b, err := NewBundle(snapshot, time.Now())
+		So(err, ShouldBeNil)
+
+		Convey("The tarball holds data.json under the root and a manifest", func() {
+			files, err := untar(b.Archive)
+			So(err, ShouldBeNil)
+			var data map[string]Snapshot
+			So(json.Unmarshal(files["/data.json"], &data), ShouldBeNil)
+			So(data[Root].Directories["default"].Groups["group.users"], ShouldResemble, []string{"bordeanu", "smith"})
+			var manifest struct {
+				Revision string   `json:"revision"`
+				Roots    []string `json:"roots"`
+			}
+			So(json.Unmarshal(files["/.manifest"], &manifest), ShouldBeNil)
+			So(manifest.Revision, ShouldEqual, b.Revision)
+			So(manifest.Roots, ShouldResemble, []string{Root})
+		})
+		Convey("The same snapshot has the same revision, a changed one another", func() {
+			again, err := NewBundle(snapshot, time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+			So(again.ETag, ShouldEqual, b.ETag)
+			So(again.Archive, ShouldResemble, b.Archive)
+
+			snapshot.Directories["default"].Groups["group.users"] = []string{"bordeanu"}
+			changed, err := NewBundle(snapshot, time.Now())
+			So(err, ShouldBeNil)
+			So(changed.ETag, ShouldNotEqual, b.ETag)
+		})
+		Convey("If-None-Match is matched against the ETag", func() {
+			So(utils.ETagMatch(b.ETag, b.ETag), ShouldBeTrue)
+			So(utils.ETagMatch(`"other", W/`+b.ETag, b.ETag), ShouldBeTrue)
+			So(utils.ETagMatch("*", b.ETag), ShouldBeTrue)
+			So(utils.ETagMatch(`"other"`, b.ETag), ShouldBeFalse)
+			So(utils.ETagMatch("", b.ETag), ShouldBeFalse)
+		})
+	})
+}
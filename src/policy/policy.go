@@ -0,0 +1,216 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// Endpoints a caller can be granted
+const (
+	EndpointUserCheck = "usercheck"
+	EndpointUserCount = "usercount"
+	EndpointMembers   = "members"
+	// EndpointUsers returns user profiles, it is granted by endpoint and directory only
+	EndpointUsers = "users"
+	// EndpointResolve resolves mails, UPNs and employee IDs to isids, it is granted by endpoint and directory only
+	EndpointResolve = "resolve"
+	// EndpointAuthenticate verifies passwords then checks the group
+	EndpointAuthenticate = "authenticate"
+	// EndpointForward answers reverse proxy forward auth subrequests, for the groups they require
+	EndpointForward = "forward"
+	// EndpointExtAuthz answers Envoy ext_authz checks, for the groups the routes require
+	EndpointExtAuthz = "ext_authz"
+	// EndpointK8s is the kubernetes authorization webhook, its groups come from the kubernetes rules
+	EndpointK8s = "k8s"
+	// EndpointOpa serves OPA the group list of a user and the group bundle, it is granted by endpoint and directory only
+	EndpointOpa = "opa"
+	// EndpointHealth is the gRPC Health call binding to every directory, it is granted by endpoint and directory only
+	EndpointHealth = "health"
+	// EndpointAdmin covers the admin endpoints, it is never granted without a policy
+	EndpointAdmin = "admin"
+	// AnyCaller is the policy entry applied to callers without an entry of their own
+	AnyCaller = "*"
+)
+
+// Policy maps caller identities to the groups and endpoints they may query
+type Policy struct {
+	DryRun  bool              `yaml:"dry_run"`
+	Callers map[string]Caller `yaml:"callers"`
+}
+
+// Caller holds the grants of a single caller identity.
+// Groups, endpoints and directories are shell patterns, "*" grants everything.
+// Without directories every directory may be queried.
+type Caller struct {
+	Groups      []string `yaml:"groups"`
+	Endpoints   []string `yaml:"endpoints"`
+	Directories []string `yaml:"directories"`
+}
+
+// DeniedError is returned when the policy refuses a request
+type DeniedError struct {
+	Caller    string
+	Endpoint  string
+	Directory string
+	Group     string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Directory != "" {
+		return fmt.Sprintf("caller %s is not allowed to query directory %s", e.Caller, e.Directory)
+	}
+	if e.Group != "" {
+		return fmt.Sprintf("caller %s is not allowed to query group %s", e.Caller, e.Group)
+	}
+	return fmt.Sprintf("caller %s is not allowed to use endpoint %s", e.Caller, e.Endpoint)
+}
+
+var current atomic.Value
+
+// Load read and validate a policy file
+func Load(file string) (*Policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening policy file %s: %v", file, err)
+	}
+	p := &Policy{}
+	if err = yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("error parsing policy file %s: %v", file, err)
+	}
+	if err = p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", file, err)
+	}
+	return p, nil
+}
+
+// Validate check all the patterns in the policy are well-formed
+func (p *Policy) Validate() error {
+	for name, caller := range p.Callers {
+		patterns := append(append([]string{}, caller.Groups...), caller.Endpoints...)
+		for _, pattern := range append(patterns, caller.Directories...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("caller %s: bad pattern %q: %v", name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Authorize check whether caller may use endpoint for all the given groups
+func (p *Policy) Authorize(caller, endpoint string, groups []string) error {
+	return p.AuthorizeIn(caller, endpoint, "", groups)
+}
+
+// AuthorizeIn check whether caller may use endpoint for all the given groups of directory.
+// An empty directory is not checked.
+func (p *Policy) AuthorizeIn(caller, endpoint, directory string, groups []string) error {
+	grants, ok := p.Callers[caller]
+	if !ok {
+		if grants, ok = p.Callers[AnyCaller]; !ok {
+			return &DeniedError{Caller: caller, Endpoint: endpoint}
+		}
+	}
+	if !matchAny(grants.Endpoints, endpoint) {
+		return &DeniedError{Caller: caller, Endpoint: endpoint}
+	}
+	if directory != "" && len(grants.Directories) > 0 && !matchAny(grants.Directories, directory) {
+		return &DeniedError{Caller: caller, Endpoint: endpoint, Directory: directory}
+	}
+	for _, group := range groups {
+		if !matchAny(grants.Groups, group) {
+			return &DeniedError{Caller: caller, Endpoint: endpoint, Group: group}
+		}
+	}
+	return nil
+}
+
+// GroupScoped tell whether endpoint queries the configured groups, the others are not checked against group grants
+func GroupScoped(endpoint string) bool {
+	switch endpoint {
+	case EndpointAdmin, EndpointUsers, EndpointResolve, EndpointK8s, EndpointOpa, EndpointHealth:
+		return false
+	}
+	return true
+}
+
+// matchAny check value against a list of shell patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Current return the active policy, nil when no policy is configured
+func Current() *Policy {
+	p, _ := current.Load().(*Policy)
+	return p
+}
+
+// Set replace the active policy
+func Set(p *Policy) {
+	current.Store(p)
+}
+
+// Watch load the policy file and reload it every time it changes on disk.
+// An invalid file is logged and the previous policy is kept.
+func Watch(ctx context.Context, file string, interval time.Duration) error {
+	log := logger.SugaredLogger().With("package", "policy", "action", "watch policy file")
+
+	p, err := Load(file)
+	if err != nil {
+		return err
+	}
+	Set(p)
+	log.Infof("loaded caller policy from %s with %d callers, dry run:%t", file, len(p.Callers), p.DryRun)
+	if interval <= 0 {
+		return nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	modTime := info.ModTime()
+
+	concurrency.GlobalWaitGroup.Add(1)
+	go func() {
+		defer concurrency.GlobalWaitGroup.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				log.Errorf("unable to stat policy file %s: %v", file, err)
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			p, err := Load(file)
+			if err != nil {
+				log.Errorf("keeping previous policy: %v", err)
+				continue
+			}
+			Set(p)
+			log.Infof("reloaded caller policy from %s with %d callers, dry run:%t", file, len(p.Callers), p.DryRun)
+		}
+	}()
+	return nil
+}
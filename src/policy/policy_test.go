@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"user-check/utils/logger"
+)
+
+const testPolicy = `
+callers:
+  hr-portal:
+    groups: ["hr.*"]
+    endpoints: ["usercount"]
+  admin-tool:
+    groups: ["*"]
+    endpoints: ["*"]
+  contractor-portal:
+    groups: ["*"]
+    endpoints: ["usercheck"]
+    directories: ["contractors"]
+`
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+func writePolicy(t *testing.T, file, content string) {
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	Convey(`Feature: caller policy decisions`, t, func() {
+		file := filepath.Join(t.TempDir(), "policy.yaml")
+		writePolicy(t, file, testPolicy)
+		p, err := Load(file)
+		So(err, ShouldBeNil)
+
+		Convey("Caller may query its own groups", func() {
+			So(p.Authorize("hr-portal", EndpointUserCount, []string{"hr.payroll"}), ShouldBeNil)
+		})
+		Convey("Caller may not query other groups", func() {
+			err := p.Authorize("hr-portal", EndpointUserCount, []string{"admin.domain"})
+			So(err, ShouldHaveSameTypeAs, &DeniedError{})
+			So(err.(*DeniedError).Group, ShouldEqual, "admin.domain")
+		})
+		Convey("Caller may not use other endpoints", func() {
+			So(p.Authorize("hr-portal", EndpointMembers, []string{"hr.payroll"}), ShouldNotBeNil)
+		})
+		Convey("Unknown callers are denied without a wildcard entry", func() {
+			So(p.Authorize("anonymous", EndpointUserCount, []string{"hr.payroll"}), ShouldNotBeNil)
+		})
+		Convey("Wildcard grants everything", func() {
+			So(p.Authorize("admin-tool", EndpointMembers, []string{"admin.domain"}), ShouldBeNil)
+		})
+		Convey("Caller may only query its own directories", func() {
+			So(p.AuthorizeIn("contractor-portal", EndpointUserCheck, "contractors", []string{"ext.users"}), ShouldBeNil)
+			err := p.AuthorizeIn("contractor-portal", EndpointUserCheck, "default", []string{"ext.users"})
+			So(err, ShouldHaveSameTypeAs, &DeniedError{})
+			So(err.(*DeniedError).Directory, ShouldEqual, "default")
+		})
+		Convey("Callers without directories may query every directory", func() {
+			So(p.AuthorizeIn("admin-tool", EndpointUserCheck, "contractors", []string{"ext.users"}), ShouldBeNil)
+		})
+	})
+}
+
+func TestLoadInvalid(t *testing.T) {
+	Convey(`Feature: invalid policy files are rejected`, t, func() {
+		file := filepath.Join(t.TempDir(), "policy.yaml")
+		Convey("Unknown keys", func() {
+			writePolicy(t, file, "callerz: {}\n")
+			_, err := Load(file)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Malformed patterns", func() {
+			writePolicy(t, file, "callers:\n  x:\n    groups: [\"[\"]\n")
+			_, err := Load(file)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestWatch(t *testing.T) {
+	Convey(`Feature: policy file hot reload`, t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		file := filepath.Join(t.TempDir(), "policy.yaml")
+		writePolicy(t, file, testPolicy)
+
+		So(Watch(ctx, file, 10*time.Millisecond), ShouldBeNil)
+		So(Current().Authorize("anonymous", EndpointUserCount, nil), ShouldNotBeNil)
+
+		Convey("Invalid changes keep the previous policy", func() {
+			writePolicy(t, file, "callers: [")
+			os.Chtimes(file, time.Now(), time.Now().Add(time.Second))
+			time.Sleep(50 * time.Millisecond)
+			So(Current().Callers, ShouldContainKey, "hr-portal")
+		})
+		Convey("Valid changes are picked up", func() {
+			writePolicy(t, file, testPolicy+"  \"*\":\n    groups: [\"*\"]\n    endpoints: [\"usercount\"]\n")
+			os.Chtimes(file, time.Now(), time.Now().Add(2*time.Second))
+			time.Sleep(50 * time.Millisecond)
+			So(Current().Authorize("anonymous", EndpointUserCount, nil), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,4 @@
+// Package usercheckv1 is the gRPC api, generated from usercheck.proto
+package usercheckv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative usercheck/v1/usercheck.proto
@@ -0,0 +1,297 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: usercheck/v1/usercheck.proto
+
+package usercheckv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// UserCheckServiceClient is the client API for UserCheckService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type UserCheckServiceClient interface {
+	// CheckMembership tells whether the user is a member of the configured group
+	CheckMembership(ctx context.Context, in *CheckMembershipRequest, opts ...grpc.CallOption) (*CheckMembershipResponse, error)
+	// BatchCheck checks up to 100 users at once, a failed user does not fail the others
+	BatchCheck(ctx context.Context, in *BatchCheckRequest, opts ...grpc.CallOption) (*BatchCheckResponse, error)
+	// CountMembers counts the members of the configured group
+	CountMembers(ctx context.Context, in *CountMembersRequest, opts ...grpc.CallOption) (*CountMembersResponse, error)
+	// ListMembers lists the user members of the configured group
+	ListMembers(ctx context.Context, in *ListMembersRequest, opts ...grpc.CallOption) (*ListMembersResponse, error)
+	// GetUser returns the profile of the user, limited to the PROFILE_ATTRIBUTES allow-list
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*GetUserResponse, error)
+	// Health reports the directories and the ldap server circuit breakers
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
+}
+
+type userCheckServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUserCheckServiceClient(cc grpc.ClientConnInterface) UserCheckServiceClient {
+	return &userCheckServiceClient{cc}
+}
+
+func (c *userCheckServiceClient) CheckMembership(ctx context.Context, in *CheckMembershipRequest, opts ...grpc.CallOption) (*CheckMembershipResponse, error) {
+	out := new(CheckMembershipResponse)
+	err := c.cc.Invoke(ctx, "/usercheck.v1.UserCheckService/CheckMembership", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userCheckServiceClient) BatchCheck(ctx context.Context, in *BatchCheckRequest, opts ...grpc.CallOption) (*BatchCheckResponse, error) {
+	out := new(BatchCheckResponse)
+	err := c.cc.Invoke(ctx, "/usercheck.v1.UserCheckService/BatchCheck", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userCheckServiceClient) CountMembers(ctx context.Context, in *CountMembersRequest, opts ...grpc.CallOption) (*CountMembersResponse, error) {
+	out := new(CountMembersResponse)
+	err := c.cc.Invoke(ctx, "/usercheck.v1.UserCheckService/CountMembers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userCheckServiceClient) ListMembers(ctx context.Context, in *ListMembersRequest, opts ...grpc.CallOption) (*ListMembersResponse, error) {
+	out := new(ListMembersResponse)
+	err := c.cc.Invoke(ctx, "/usercheck.v1.UserCheckService/ListMembers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userCheckServiceClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*GetUserResponse, error) {
+	out := new(GetUserResponse)
+	err := c.cc.Invoke(ctx, "/usercheck.v1.UserCheckService/GetUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userCheckServiceClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, "/usercheck.v1.UserCheckService/Health", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UserCheckServiceServer is the server API for UserCheckService service.
+// All implementations must embed UnimplementedUserCheckServiceServer
+// for forward compatibility
+type UserCheckServiceServer interface {
+	// CheckMembership tells whether the user is a member of the configured group
+	CheckMembership(context.Context, *CheckMembershipRequest) (*CheckMembershipResponse, error)
+	// BatchCheck checks up to 100 users at once, a failed user does not fail the others
+	BatchCheck(context.Context, *BatchCheckRequest) (*BatchCheckResponse, error)
+	// CountMembers counts the members of the configured group
+	CountMembers(context.Context, *CountMembersRequest) (*CountMembersResponse, error)
+	// ListMembers lists the user members of the configured group
+	ListMembers(context.Context, *ListMembersRequest) (*ListMembersResponse, error)
+	// GetUser returns the profile of the user, limited to the PROFILE_ATTRIBUTES allow-list
+	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
+	// Health reports the directories and the ldap server circuit breakers
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	mustEmbedUnimplementedUserCheckServiceServer()
+}
+
+// UnimplementedUserCheckServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedUserCheckServiceServer struct {
+}
+
+func (UnimplementedUserCheckServiceServer) CheckMembership(context.Context, *CheckMembershipRequest) (*CheckMembershipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckMembership not implemented")
+}
+func (UnimplementedUserCheckServiceServer) BatchCheck(context.Context, *BatchCheckRequest) (*BatchCheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchCheck not implemented")
+}
+func (UnimplementedUserCheckServiceServer) CountMembers(context.Context, *CountMembersRequest) (*CountMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountMembers not implemented")
+}
+func (UnimplementedUserCheckServiceServer) ListMembers(context.Context, *ListMembersRequest) (*ListMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMembers not implemented")
+}
+func (UnimplementedUserCheckServiceServer) GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedUserCheckServiceServer) Health(context.Context, *HealthRequest) (*HealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Health not implemented")
+}
+func (UnimplementedUserCheckServiceServer) mustEmbedUnimplementedUserCheckServiceServer() {}
+
+// UnsafeUserCheckServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UserCheckServiceServer will
+// result in compilation errors.
+type UnsafeUserCheckServiceServer interface {
+	mustEmbedUnimplementedUserCheckServiceServer()
+}
+
+func RegisterUserCheckServiceServer(s grpc.ServiceRegistrar, srv UserCheckServiceServer) {
+	s.RegisterService(&UserCheckService_ServiceDesc, srv)
+}
+
+func _UserCheckService_CheckMembership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckMembershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserCheckServiceServer).CheckMembership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usercheck.v1.UserCheckService/CheckMembership",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserCheckServiceServer).CheckMembership(ctx, req.(*CheckMembershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserCheckService_BatchCheck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserCheckServiceServer).BatchCheck(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usercheck.v1.UserCheckService/BatchCheck",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserCheckServiceServer).BatchCheck(ctx, req.(*BatchCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserCheckService_CountMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserCheckServiceServer).CountMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usercheck.v1.UserCheckService/CountMembers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserCheckServiceServer).CountMembers(ctx, req.(*CountMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserCheckService_ListMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserCheckServiceServer).ListMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usercheck.v1.UserCheckService/ListMembers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserCheckServiceServer).ListMembers(ctx, req.(*ListMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserCheckService_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserCheckServiceServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usercheck.v1.UserCheckService/GetUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserCheckServiceServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserCheckService_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserCheckServiceServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/usercheck.v1.UserCheckService/Health",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserCheckServiceServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// UserCheckService_ServiceDesc is the grpc.ServiceDesc for UserCheckService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UserCheckService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "usercheck.v1.UserCheckService",
+	HandlerType: (*UserCheckServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CheckMembership",
+			Handler:    _UserCheckService_CheckMembership_Handler,
+		},
+		{
+			MethodName: "BatchCheck",
+			Handler:    _UserCheckService_BatchCheck_Handler,
+		},
+		{
+			MethodName: "CountMembers",
+			Handler:    _UserCheckService_CountMembers_Handler,
+		},
+		{
+			MethodName: "ListMembers",
+			Handler:    _UserCheckService_ListMembers_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _UserCheckService_GetUser_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _UserCheckService_Health_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "usercheck/v1/usercheck.proto",
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff locks keys out after repeated failures. Past the free failures every further one locks the
+// key out for twice as long as the previous, up to a maximum. Keys quiet for long enough start over.
+type Backoff struct {
+	free int
+	base time.Duration
+	max  time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*failures
+	lastSweep time.Time
+}
+
+type failures struct {
+	count       int
+	pending     int
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+// NewBackoff create a backoff allowing free failures per key before locking it out for base, doubling up to max
+func NewBackoff(free int, base, max time.Duration) *Backoff {
+	if max < base {
+		max = base
+	}
+	return &Backoff{
+		free:      free,
+		base:      base,
+		max:       max,
+		keys:      map[string]*failures{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow tell whether key may try again and if so reserve the attempt, which must be settled with Failure,
+// Success or Release. Attempts in flight count as failures until settled, so concurrent ones can't get past
+// the free failures; once they are used up a single attempt is let through at a time. When key may not try
+// it returns how long to wait.
+func (b *Backoff) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep(now)
+
+	f, ok := b.keys[key]
+	if !ok {
+		f = &failures{}
+		b.keys[key] = f
+	}
+	if now.Before(f.lockedUntil) {
+		return false, f.lockedUntil.Sub(now)
+	}
+	if f.pending > 0 && f.count+f.pending > b.free {
+		// the attempts in flight may lock the key out, theirs is the wait
+		return false, b.base
+	}
+	f.pending++
+	f.lastSeen = now
+	return true, 0
+}
+
+// Failure record a failed attempt of key, returning the lockout it earned, 0 while failures are free
+func (b *Backoff) Failure(key string) time.Duration {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.keys[key]
+	if !ok {
+		f = &failures{}
+		b.keys[key] = f
+	}
+	f.release()
+	f.count++
+	f.lastSeen = now
+	if f.count <= b.free {
+		return 0
+	}
+	lockout := b.max
+	if shift := f.count - b.free - 1; shift < 32 && b.base<<shift < b.max {
+		lockout = b.base << shift
+	}
+	f.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// Success forget the failures of key, the other attempts in flight stay reserved
+func (b *Backoff) Success(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.keys[key]; ok {
+		f.release()
+		*f = failures{pending: f.pending, lastSeen: f.lastSeen}
+	}
+	b.drop(key)
+}
+
+// Release give back an attempt of key that neither failed nor succeeded, leaving its failures as they are
+func (b *Backoff) Release(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.keys[key]; ok {
+		f.release()
+	}
+	b.drop(key)
+}
+
+// release settle an attempt in flight
+func (f *failures) release() {
+	if f.pending > 0 {
+		f.pending--
+	}
+}
+
+// drop forget key once nothing is left to remember about it, b.mu must be held
+func (b *Backoff) drop(key string) {
+	if f, ok := b.keys[key]; ok && f.count == 0 && f.pending == 0 {
+		delete(b.keys, key)
+	}
+}
+
+// sweep drop keys without failures for idleTimeout past the longest lockout, b.mu must be held
+func (b *Backoff) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < idleTimeout {
+		return
+	}
+	for key, f := range b.keys {
+		if f.pending == 0 && now.Sub(f.lastSeen) > b.max+idleTimeout {
+			delete(b.keys, key)
+		}
+	}
+	b.lastSweep = now
+}
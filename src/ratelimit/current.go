@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"sync/atomic"
+	"user-check/configuration"
+	"user-check/metrics"
+)
+
+// current holds the *Limiter in force, nil when rate limiting is disabled
+var current atomic.Value
+
+func init() {
+	configuration.OnReload(func(old, next *configuration.Configuration) (func(), error) {
+		if !configuration.Changed(old, next, "RateLimitRps", "RateLimitBurst") {
+			return nil, nil
+		}
+		return func() {
+			Configure(next)
+		}, nil
+	})
+}
+
+// Configure put in force the limiter of the configuration, buckets start full again
+func Configure(conf *configuration.Configuration) {
+	metrics.RateLimitConfig.WithLabelValues("rps").Set(float64(conf.RateLimitRps))
+	metrics.RateLimitConfig.WithLabelValues("burst").Set(float64(conf.RateLimitBurst))
+	var l *Limiter
+	if conf.RateLimitRps > 0 {
+		l = New(float64(conf.RateLimitRps), int(conf.RateLimitBurst))
+	}
+	current.Store(l)
+}
+
+// Current return the limiter shared by the http and gRPC servers, nil when rate limiting is disabled.
+// The first call puts the limiter of the configuration in force when nothing did yet.
+func Current() *Limiter {
+	v := current.Load()
+	if v == nil {
+		Configure(configuration.AppConfig())
+		v = current.Load()
+	}
+	l, _ := v.(*Limiter)
+	return l
+}
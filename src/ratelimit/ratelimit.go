@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"sync"
+	"time"
+)
+
+// idleTimeout buckets not used for this long are dropped
+const idleTimeout = 10 * time.Minute
+
+// Limiter is a set of token buckets, one per key
+type Limiter struct {
+	rps   rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// New create a limiter allowing rps requests per second per key, with bursts up to burst
+func New(rps float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rps)))
+	}
+	return &Limiter{
+		rps:       rate.Limit(rps),
+		burst:     burst,
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow take a token for key. When none is left it returns how long to wait before retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN take n tokens for key at once, like Allow. More than the burst is never allowed.
+func (l *Limiter) AllowN(key string, n int) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.sweep(now)
+	l.mu.Unlock()
+
+	r := b.limiter.ReserveN(now, n)
+	if !r.OK() {
+		return false, time.Second
+	}
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+	// don't consume the token, the request is refused
+	r.CancelAt(now)
+	return false, delay
+}
+
+// sweep drop idle buckets, l.mu must be held
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTimeout {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
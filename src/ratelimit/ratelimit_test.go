@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	Convey(`Feature: per key token buckets`, t, func() {
+		l := New(1, 2)
+
+		ok, _ := l.Allow("caller:hr-portal")
+		So(ok, ShouldBeTrue)
+		ok, _ = l.Allow("caller:hr-portal")
+		So(ok, ShouldBeTrue)
+
+		Convey("An empty bucket refuses with a retry delay", func() {
+			ok, wait := l.Allow("caller:hr-portal")
+			So(ok, ShouldBeFalse)
+			So(wait, ShouldBeGreaterThan, 0)
+		})
+		Convey("Other keys have their own bucket", func() {
+			ok, _ := l.Allow("ip:10.0.0.1")
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	Convey(`Feature: lockout doubling with every failure past the free ones`, t, func() {
+		b := NewBackoff(2, time.Second, 4*time.Second)
+
+		So(b.Failure("user:bordeanu"), ShouldEqual, 0)
+		So(b.Failure("user:bordeanu"), ShouldEqual, 0)
+		ok, _ := b.Allow("user:bordeanu")
+		So(ok, ShouldBeTrue)
+
+		So(b.Failure("user:bordeanu"), ShouldEqual, time.Second)
+		So(b.Failure("user:bordeanu"), ShouldEqual, 2*time.Second)
+		So(b.Failure("user:bordeanu"), ShouldEqual, 4*time.Second)
+		So(b.Failure("user:bordeanu"), ShouldEqual, 4*time.Second)
+
+		Convey("A locked out key waits", func() {
+			ok, wait := b.Allow("user:bordeanu")
+			So(ok, ShouldBeFalse)
+			So(wait, ShouldBeGreaterThan, 3*time.Second)
+		})
+		Convey("Other keys are not locked out", func() {
+			ok, _ := b.Allow("ip:10.0.0.1")
+			So(ok, ShouldBeTrue)
+		})
+		Convey("A success starts over", func() {
+			b.Success("user:bordeanu")
+			ok, _ := b.Allow("user:bordeanu")
+			So(ok, ShouldBeTrue)
+			So(b.Failure("user:bordeanu"), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestBackoffConcurrent(t *testing.T) {
+	Convey(`Feature: concurrent attempts can't get past the free failures`, t, func() {
+		b := NewBackoff(2, time.Minute, time.Hour)
+
+		var wg sync.WaitGroup
+		var allowed int32
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if ok, _ := b.Allow("user:bordeanu"); ok {
+					atomic.AddInt32(&allowed, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		// the free failures and the one locking the key out
+		So(allowed, ShouldEqual, 3)
+		for i := 0; i < 3; i++ {
+			b.Failure("user:bordeanu")
+		}
+		ok, wait := b.Allow("user:bordeanu")
+		So(ok, ShouldBeFalse)
+		So(wait, ShouldBeGreaterThan, 59*time.Second)
+
+		Convey("Released attempts free their reservation", func() {
+			ok, _ := b.Allow("ip:10.0.0.1")
+			So(ok, ShouldBeTrue)
+			b.Release("ip:10.0.0.1")
+			So(b.keys, ShouldNotContainKey, "ip:10.0.0.1")
+		})
+		Convey("A success keeps the other attempts reserved", func() {
+			for i := 0; i < 3; i++ {
+				ok, _ := b.Allow("user:martih")
+				So(ok, ShouldBeTrue)
+			}
+			b.Success("user:martih")
+			ok, _ := b.Allow("user:martih")
+			So(ok, ShouldBeTrue)
+			ok, _ = b.Allow("user:martih")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
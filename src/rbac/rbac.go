@@ -0,0 +1,149 @@
+package rbac
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+)
+
+// Rules map Kubernetes verbs, resources and namespaces to directory groups
+type Rules struct {
+	// Nested counts the groups users are members of through other groups
+	Nested bool `yaml:"nested"`
+	// DenyUnmatched denies requests no rule allows, instead of leaving the decision to the other authorizers
+	DenyUnmatched bool   `yaml:"deny_unmatched"`
+	Rules         []Rule `yaml:"rules"`
+}
+
+// Rule allows members of any of its groups the verbs on the resources in the namespaces, or on the
+// non-resource paths. Values are exact, "*" matches everything and a trailing "*" matches a prefix.
+// Resources are written resource[.apigroup][/subresource], e.g. pods/log or deployments.apps.
+// Without namespaces every namespace and cluster scoped resources match, "" is the cluster scope.
+type Rule struct {
+	Groups           []string `yaml:"groups"`
+	Verbs            []string `yaml:"verbs"`
+	Resources        []string `yaml:"resources"`
+	Namespaces       []string `yaml:"namespaces"`
+	NonResourcePaths []string `yaml:"non_resource_paths"`
+}
+
+// Request is what a SubjectAccessReview asks about: a resource or, when Path is set, a non-resource url
+type Request struct {
+	Verb        string
+	Namespace   string
+	ApiGroup    string
+	Resource    string
+	Subresource string
+	Path        string
+}
+
+// Decision is the outcome of the rules for a request
+type Decision struct {
+	Allowed bool
+	Denied  bool
+	Reason  string
+	// Group is the group that allowed the request
+	Group string
+}
+
+var current atomic.Value
+
+// Load read and validate a rules file
+func Load(file string) (*Rules, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening kubernetes rules file %s: %v", file, err)
+	}
+	r := &Rules{}
+	if err = yaml.UnmarshalStrict(data, r); err != nil {
+		return nil, fmt.Errorf("error parsing kubernetes rules file %s: %v", file, err)
+	}
+	if err = r.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid kubernetes rules file %s: %v", file, err)
+	}
+	return r, nil
+}
+
+// Validate check every rule names groups, verbs and what they apply to
+func (r *Rules) Validate() error {
+	for i, rule := range r.Rules {
+		switch {
+		case len(rule.Groups) == 0:
+			return fmt.Errorf("rule %d: groups are required", i+1)
+		case len(rule.Verbs) == 0:
+			return fmt.Errorf("rule %d: verbs are required", i+1)
+		case len(rule.Resources) == 0 && len(rule.NonResourcePaths) == 0:
+			return fmt.Errorf("rule %d: resources or non_resource_paths are required", i+1)
+		case len(rule.Resources) > 0 && len(rule.NonResourcePaths) > 0:
+			return fmt.Errorf("rule %d: resources and non_resource_paths can't be mixed", i+1)
+		}
+	}
+	return nil
+}
+
+// Current return the active rules, nil when none are configured
+func Current() *Rules {
+	r, _ := current.Load().(*Rules)
+	return r
+}
+
+// Set replace the active rules
+func Set(r *Rules) {
+	current.Store(r)
+}
+
+// Decide apply the rules, in order, to the request of a member of groups
+func (r *Rules) Decide(req Request, groups []string) Decision {
+	for i, rule := range r.Rules {
+		if !rule.matches(req) {
+			continue
+		}
+		for _, group := range rule.Groups {
+			for _, g := range groups {
+				if strings.EqualFold(group, g) {
+					return Decision{Allowed: true, Reason: fmt.Sprintf("allowed by rule %d through group %s", i+1, g), Group: g}
+				}
+			}
+		}
+	}
+	if r.DenyUnmatched {
+		return Decision{Denied: true, Reason: "no rule allows the request"}
+	}
+	return Decision{Reason: "no rule allows the request, no opinion"}
+}
+
+// matches tell whether the rule covers the request, whoever makes it
+func (rule Rule) matches(req Request) bool {
+	if !matchAny(rule.Verbs, req.Verb) {
+		return false
+	}
+	if req.Path != "" {
+		return matchAny(rule.NonResourcePaths, req.Path)
+	}
+	resource := req.Resource
+	if req.ApiGroup != "" {
+		resource += "." + req.ApiGroup
+	}
+	if req.Subresource != "" {
+		resource += "/" + req.Subresource
+	}
+	if !matchAny(rule.Resources, resource) {
+		return false
+	}
+	return len(rule.Namespaces) == 0 || matchAny(rule.Namespaces, req.Namespace)
+}
+
+// matchAny check value against the patterns: exact, "*" for everything or a prefix ending with "*"
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*", pattern == value:
+			return true
+		case strings.HasSuffix(pattern, "*") && strings.HasPrefix(value, strings.TrimSuffix(pattern, "*")):
+			return true
+		}
+	}
+	return false
+}
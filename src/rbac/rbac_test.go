@@ -0,0 +1,63 @@
+package rbac
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/yaml.v2"
+	"testing"
+)
+
+const testRules = `
+rules:
+  - groups: ["k8s-admins"]
+    verbs: ["*"]
+    resources: ["*"]
+  - groups: ["team-a-devs"]
+    verbs: ["get", "list", "watch"]
+    resources: ["pods", "pods/log", "deployments.apps"]
+    namespaces: ["team-a", "team-a-*"]
+  - groups: ["monitoring"]
+    verbs: ["get"]
+    non_resource_paths: ["/healthz", "/metrics*"]
+`
+
+func TestDecide(t *testing.T) {
+	Convey(`Feature: kubernetes requests decided by directory groups`, t, func() {
+		r := &Rules{}
+		So(yaml.UnmarshalStrict([]byte(testRules), r), ShouldBeNil)
+		So(r.Validate(), ShouldBeNil)
+
+		Convey("Admins may do anything", func() {
+			d := r.Decide(Request{Verb: "delete", Resource: "nodes"}, []string{"K8S-Admins"})
+			So(d.Allowed, ShouldBeTrue)
+			So(d.Reason, ShouldContainSubstring, "rule 1")
+		})
+		Convey("Developers read their namespaces", func() {
+			So(r.Decide(Request{Verb: "get", Namespace: "team-a", Resource: "pods", Subresource: "log"}, []string{"team-a-devs"}).Allowed, ShouldBeTrue)
+			So(r.Decide(Request{Verb: "list", Namespace: "team-a-staging", ApiGroup: "apps", Resource: "deployments"}, []string{"team-a-devs"}).Allowed, ShouldBeTrue)
+		})
+		Convey("Nothing else is allowed, without an opinion", func() {
+			for _, req := range []Request{
+				{Verb: "delete", Namespace: "team-a", Resource: "pods"},
+				{Verb: "get", Namespace: "team-b", Resource: "pods"},
+				{Verb: "get", Namespace: "team-a", Resource: "secrets"},
+				{Verb: "get", Namespace: "team-a", Resource: "pods", Subresource: "exec"},
+			} {
+				d := r.Decide(req, []string{"team-a-devs"})
+				So(d.Allowed, ShouldBeFalse)
+				So(d.Denied, ShouldBeFalse)
+			}
+		})
+		Convey("Non-resource paths", func() {
+			So(r.Decide(Request{Verb: "get", Path: "/metrics/cadvisor"}, []string{"monitoring"}).Allowed, ShouldBeTrue)
+			So(r.Decide(Request{Verb: "get", Path: "/api"}, []string{"monitoring"}).Allowed, ShouldBeFalse)
+		})
+		Convey("Unmatched requests can be denied", func() {
+			r.DenyUnmatched = true
+			So(r.Decide(Request{Verb: "get", Resource: "pods"}, nil).Denied, ShouldBeTrue)
+		})
+	})
+	Convey(`Feature: rules are validated`, t, func() {
+		So((&Rules{Rules: []Rule{{Groups: []string{"a"}, Verbs: []string{"get"}}}}).Validate(), ShouldNotBeNil)
+		So((&Rules{Rules: []Rule{{Verbs: []string{"get"}, Resources: []string{"pods"}}}}).Validate(), ShouldNotBeNil)
+	})
+}
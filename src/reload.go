@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"time"
+	"user-check/audit"
+	"user-check/configuration"
+	"user-check/identity"
+	"user-check/policy"
+	"user-check/rbac"
+	"user-check/utils/logger"
+)
+
+// stopPolicyWatch stop the watcher of the policy file in use
+var stopPolicyWatch context.CancelFunc = func() {}
+
+// watchPolicy load the policy file and keep watching it until the file changes on reload
+func watchPolicy(ctx context.Context, conf *configuration.Configuration) error {
+	stopPolicyWatch()
+	if conf.PolicyFile == "" {
+		policy.Set(nil)
+		stopPolicyWatch = func() {}
+		return nil
+	}
+	watchCtx, cancel := context.WithCancel(ctx)
+	if err := policy.Watch(watchCtx, conf.PolicyFile, time.Second*time.Duration(conf.PolicyReloadSec)); err != nil {
+		cancel()
+		return err
+	}
+	stopPolicyWatch = cancel
+	return nil
+}
+
+// auditOptions return the audit log settings of conf
+func auditOptions(conf *configuration.Configuration) audit.Options {
+	return audit.Options{
+		Output:     conf.AuditLog,
+		MaxSizeMb:  int(conf.AuditMaxSizeMb),
+		MaxBackups: int(conf.AuditMaxBackups),
+		MaxAgeDays: int(conf.AuditMaxAgeDays),
+		IsidMode:   conf.AuditIsidMode,
+		HashSalt:   conf.AuditHashSalt,
+	}
+}
+
+// registerReloadHooks swap the policy, client identities, kubernetes rules and audit log when the configuration is reloaded.
+// Files are read before anything is swapped so a broken one keeps the whole old configuration.
+func registerReloadHooks(ctx context.Context) {
+	log := logger.SugaredLogger().With("package", "main", "action", "reload configuration")
+
+	configuration.OnReload(func(old, next *configuration.Configuration) (func(), error) {
+		if !configuration.Changed(old, next, "PolicyFile", "PolicyReloadSec") {
+			return nil, nil
+		}
+		if next.PolicyFile != "" {
+			if _, err := policy.Load(next.PolicyFile); err != nil {
+				return nil, err
+			}
+		}
+		return func() {
+			if err := watchPolicy(ctx, next); err != nil {
+				log.Errorf("Unable to watch caller policy: %s", err)
+			}
+			if next.PolicyFile == "" {
+				log.Warnf("No caller policy configured, every caller may query every group")
+			}
+		}, nil
+	})
+
+	configuration.OnReload(func(old, next *configuration.Configuration) (func(), error) {
+		if !configuration.Changed(old, next, "ClientIdentityFile") {
+			return nil, nil
+		}
+		var mapper *identity.Mapper
+		if next.ClientIdentityFile != "" {
+			var err error
+			if mapper, err = identity.Load(next.ClientIdentityFile); err != nil {
+				return nil, err
+			}
+		}
+		return func() {
+			identity.Set(mapper)
+		}, nil
+	})
+
+	configuration.OnReload(func(old, next *configuration.Configuration) (func(), error) {
+		if !configuration.Changed(old, next, "K8sAuthzFile") {
+			return nil, nil
+		}
+		var rules *rbac.Rules
+		if next.K8sAuthzFile != "" {
+			var err error
+			if rules, err = rbac.Load(next.K8sAuthzFile); err != nil {
+				return nil, err
+			}
+		}
+		return func() {
+			rbac.Set(rules)
+		}, nil
+	})
+
+	configuration.OnReload(func(old, next *configuration.Configuration) (func(), error) {
+		if auditOptions(old) == auditOptions(next) {
+			return nil, nil
+		}
+		return func() {
+			if err := audit.Init(auditOptions(next)); err != nil {
+				log.Errorf("Unable to reopen audit log: %s", err)
+			}
+		}, nil
+	})
+}
@@ -0,0 +1,170 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Built-in presets
+const (
+	ActiveDirectory  = "ad"
+	OpenLdap         = "openldap"
+	OpenLdapMemberOf = "openldap-memberof"
+	OpenLdapPosix    = "openldap-posix"
+	FreeIpa          = "freeipa"
+)
+
+// None disables an optional attribute, e.g. memberOf on a server without the memberof overlay
+const None = "none"
+
+// Member attributes understood when reading groups
+const (
+	Member       = "member"
+	UniqueMember = "uniqueMember"
+	MemberUid    = "memberUid"
+)
+
+// Schema names the object classes and attributes users and groups are stored with
+type Schema struct {
+	UserObjectClass    string
+	LoginAttribute     string
+	GroupObjectClass   string
+	GroupNameAttribute string
+	// MemberAttribute lists the members of a group: member and uniqueMember hold DNs, memberUid holds logins
+	MemberAttribute string
+	// MemberOfAttribute lists the groups of a user, empty when the server does not maintain it
+	MemberOfAttribute string
+	// InChain the server resolves nested membership with the LDAP_MATCHING_RULE_IN_CHAIN filter
+	InChain bool
+}
+
+// RuleInChain is the Active Directory matching rule walking nested group membership
+const RuleInChain = "1.2.840.113556.1.4.1941"
+
+var presets = map[string]Schema{
+	ActiveDirectory: {
+		UserObjectClass:    "user",
+		LoginAttribute:     "sAMAccountName",
+		GroupObjectClass:   "group",
+		GroupNameAttribute: "cn",
+		MemberAttribute:    Member,
+		MemberOfAttribute:  "memberOf",
+		InChain:            true,
+	},
+	OpenLdap: {
+		UserObjectClass:    "inetOrgPerson",
+		LoginAttribute:     "uid",
+		GroupObjectClass:   "groupOfNames",
+		GroupNameAttribute: "cn",
+		MemberAttribute:    Member,
+	},
+	OpenLdapMemberOf: {
+		UserObjectClass:    "inetOrgPerson",
+		LoginAttribute:     "uid",
+		GroupObjectClass:   "groupOfNames",
+		GroupNameAttribute: "cn",
+		MemberAttribute:    Member,
+		MemberOfAttribute:  "memberOf",
+	},
+	OpenLdapPosix: {
+		UserObjectClass:    "posixAccount",
+		LoginAttribute:     "uid",
+		GroupObjectClass:   "posixGroup",
+		GroupNameAttribute: "cn",
+		MemberAttribute:    MemberUid,
+	},
+	FreeIpa: {
+		UserObjectClass:    "person",
+		LoginAttribute:     "uid",
+		GroupObjectClass:   "groupOfNames",
+		GroupNameAttribute: "cn",
+		MemberAttribute:    Member,
+		MemberOfAttribute:  "memberOf",
+	},
+}
+
+// Presets list the names of the built-in presets
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Preset return the built-in schema called name
+func Preset(name string) (Schema, error) {
+	s, ok := presets[name]
+	if !ok {
+		return Schema{}, fmt.Errorf("unknown schema %q, expected one of %s", name, strings.Join(Presets(), ", "))
+	}
+	return s, nil
+}
+
+// Resolve return the preset called name with the overrides applied, None clears the memberOf attribute
+func Resolve(name string, overrides Schema) (Schema, error) {
+	s, err := Preset(name)
+	if err != nil {
+		return s, err
+	}
+	s = s.Override(overrides)
+	if s.MemberOfAttribute == None {
+		s.MemberOfAttribute = ""
+	}
+	return s, s.Validate()
+}
+
+// Override replace the settings of s that are set in o
+func (s Schema) Override(o Schema) Schema {
+	set := func(dst *string, value string) {
+		if value != "" {
+			*dst = value
+		}
+	}
+	set(&s.UserObjectClass, o.UserObjectClass)
+	set(&s.LoginAttribute, o.LoginAttribute)
+	set(&s.GroupObjectClass, o.GroupObjectClass)
+	set(&s.GroupNameAttribute, o.GroupNameAttribute)
+	set(&s.MemberAttribute, o.MemberAttribute)
+	set(&s.MemberOfAttribute, o.MemberOfAttribute)
+	return s
+}
+
+// Validate check every setting is there and the member attribute is understood
+func (s Schema) Validate() error {
+	if s.UserObjectClass == "" || s.LoginAttribute == "" || s.GroupObjectClass == "" || s.GroupNameAttribute == "" {
+		return fmt.Errorf("user object class, login attribute, group object class and group name attribute are required")
+	}
+	switch s.MemberAttribute {
+	case Member, UniqueMember, MemberUid:
+		return nil
+	}
+	return fmt.Errorf("member attribute %q is not one of %s, %s or %s", s.MemberAttribute, Member, UniqueMember, MemberUid)
+}
+
+// MembersAreDns tell whether the member attribute holds DNs, as opposed to logins
+func (s Schema) MembersAreDns() bool {
+	return s.MemberAttribute != MemberUid
+}
+
+// HasMemberOf tell whether users list their groups
+func (s Schema) HasMemberOf() bool {
+	return s.MemberOfAttribute != ""
+}
+
+// UserFilter return the filter finding the user with the given, already escaped, login
+func (s Schema) UserFilter(login string) string {
+	return "(&(objectClass=" + s.UserObjectClass + ")(" + s.LoginAttribute + "=" + login + "))"
+}
+
+// MemberFilter return the filter finding the groups listing member, an already escaped DN or login
+func (s Schema) MemberFilter(member string) string {
+	return "(&(objectClass=" + s.GroupObjectClass + ")(" + s.MemberAttribute + "=" + member + "))"
+}
+
+// GroupFilter return the filter finding the group with the given, already escaped, name
+func (s Schema) GroupFilter(name string) string {
+	return "(&(objectClass=" + s.GroupObjectClass + ")(" + s.GroupNameAttribute + "=" + name + "))"
+}
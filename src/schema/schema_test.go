@@ -0,0 +1,52 @@
+package schema
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	Convey(`Feature: schema presets and overrides`, t, func() {
+		Convey("Active Directory keeps the historical filters", func() {
+			s, err := Resolve(ActiveDirectory, Schema{})
+			So(err, ShouldBeNil)
+			So(s.UserFilter("bordeanu"), ShouldEqual, "(&(objectClass=user)(sAMAccountName=bordeanu))")
+			So(s.GroupFilter("group.users"), ShouldEqual, "(&(objectClass=group)(cn=group.users))")
+			So(s.HasMemberOf(), ShouldBeTrue)
+			So(s.MembersAreDns(), ShouldBeTrue)
+			So(s.InChain, ShouldBeTrue)
+		})
+		Convey("OpenLDAP without the memberof overlay reads the group", func() {
+			s, err := Resolve(OpenLdap, Schema{})
+			So(err, ShouldBeNil)
+			So(s.HasMemberOf(), ShouldBeFalse)
+			So(s.UserFilter("jdoe"), ShouldEqual, "(&(objectClass=inetOrgPerson)(uid=jdoe))")
+		})
+		Convey("posixGroup lists logins", func() {
+			s, err := Resolve(OpenLdapPosix, Schema{})
+			So(err, ShouldBeNil)
+			So(s.MemberAttribute, ShouldEqual, MemberUid)
+			So(s.MembersAreDns(), ShouldBeFalse)
+			So(s.MemberFilter("jdoe"), ShouldEqual, "(&(objectClass=posixGroup)(memberUid=jdoe))")
+			So(s.InChain, ShouldBeFalse)
+		})
+		Convey("Single attributes can be overridden", func() {
+			s, err := Resolve(OpenLdap, Schema{GroupObjectClass: "groupOfUniqueNames", MemberAttribute: UniqueMember})
+			So(err, ShouldBeNil)
+			So(s.GroupFilter("staff"), ShouldEqual, "(&(objectClass=groupOfUniqueNames)(cn=staff))")
+			So(s.MemberAttribute, ShouldEqual, UniqueMember)
+			So(s.LoginAttribute, ShouldEqual, "uid")
+		})
+		Convey("memberOf can be turned off", func() {
+			s, err := Resolve(FreeIpa, Schema{MemberOfAttribute: None})
+			So(err, ShouldBeNil)
+			So(s.HasMemberOf(), ShouldBeFalse)
+		})
+		Convey("Unknown presets and member attributes are refused", func() {
+			_, err := Resolve("novell", Schema{})
+			So(err, ShouldNotBeNil)
+			_, err = Resolve(OpenLdap, Schema{MemberAttribute: "members"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
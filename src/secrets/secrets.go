@@ -0,0 +1,167 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider hands out a secret, re-reading it from its source when needed
+type Provider interface {
+	Secret(ctx context.Context) (string, error)
+}
+
+// Static is a secret given in the configuration
+type Static string
+
+func (s Static) Secret(context.Context) (string, error) {
+	return string(s), nil
+}
+
+// Env reads the secret from an env variable on every call
+type Env struct {
+	Name string
+}
+
+func (e *Env) Secret(context.Context) (string, error) {
+	v, ok := os.LookupEnv(e.Name)
+	if !ok || v == "" {
+		return "", fmt.Errorf("secret env variable %s is not set", e.Name)
+	}
+	return v, nil
+}
+
+// File reads the secret from a file, such as a Kubernetes or Docker secret, re-reading it when it changes
+type File struct {
+	Path string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+func (f *File) Secret(context.Context) (string, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file %s: %v", f.Path, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.value != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file %s: %v", f.Path, err)
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("secret file %s is empty", f.Path)
+	}
+	f.value, f.modTime, f.size = value, info.ModTime(), info.Size()
+	return f.value, nil
+}
+
+// Exec runs a command and uses its standard output as secret, caching it for Ttl.
+// The command is split on whitespace and run without a shell.
+type Exec struct {
+	Command string
+	Ttl     time.Duration
+
+	mu        sync.Mutex
+	value     string
+	fetchedAt time.Time
+}
+
+func (e *Exec) Secret(ctx context.Context) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.value != "" && time.Since(e.fetchedAt) < e.Ttl {
+		return e.value, nil
+	}
+
+	args := strings.Fields(e.Command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("secret command is empty")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		// never echo stdout, it may hold part of the secret
+		return "", fmt.Errorf("secret command %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	value := strings.TrimRight(stdout.String(), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("secret command %s printed nothing", args[0])
+	}
+	e.value, e.fetchedAt = value, time.Now()
+	return e.value, nil
+}
+
+// Spec describes where a secret comes from, the first non-empty source wins: file, command, env, value
+type Spec struct {
+	File       string
+	Command    string
+	CommandTtl time.Duration
+	Env        string
+	Value      string
+}
+
+// source is where a shared provider reads its secret from, never the secret itself
+type source struct {
+	file, command, env string
+}
+
+var (
+	providersMu sync.Mutex
+	providers   = map[source]Provider{}
+)
+
+// For return the provider described by spec. Providers are shared by source so file and command results
+// stay cached, plain values are not kept.
+func For(spec Spec) Provider {
+	var key source
+	switch {
+	case spec.File != "":
+		key.file = spec.File
+	case spec.Command != "":
+		key.command = spec.Command
+	case spec.Env != "":
+		key.env = spec.Env
+	default:
+		return Static(spec.Value)
+	}
+
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if p, ok := providers[key]; ok {
+		return p
+	}
+	var p Provider
+	switch {
+	case key.file != "":
+		p = &File{Path: key.file}
+	case key.command != "":
+		p = &Exec{Command: key.command, Ttl: spec.CommandTtl}
+	default:
+		p = &Env{Name: key.env}
+	}
+	providers[key] = p
+	return p
+}
+
+// Forget drop the shared providers and what they cached, For builds them again
+func Forget() {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers = map[source]Provider{}
+}
@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProviders(t *testing.T) {
+	ctx := context.Background()
+
+	Convey(`Feature: file secrets are re-read when they change`, t, func() {
+		file := filepath.Join(t.TempDir(), "npa_password")
+		So(ioutil.WriteFile(file, []byte("first\n"), 0600), ShouldBeNil)
+		p := For(Spec{File: file})
+
+		secret, err := p.Secret(ctx)
+		So(err, ShouldBeNil)
+		So(secret, ShouldEqual, "first")
+
+		So(ioutil.WriteFile(file, []byte("rotated\n"), 0600), ShouldBeNil)
+		os.Chtimes(file, time.Now(), time.Now().Add(time.Minute))
+		secret, err = p.Secret(ctx)
+		So(err, ShouldBeNil)
+		So(secret, ShouldEqual, "rotated")
+
+		So(os.Remove(file), ShouldBeNil)
+		_, err = p.Secret(ctx)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey(`Feature: env secrets`, t, func() {
+		p := For(Spec{Env: "SECRETS_TEST_PASSWORD"})
+		_, err := p.Secret(ctx)
+		So(err, ShouldNotBeNil)
+
+		os.Setenv("SECRETS_TEST_PASSWORD", "from-env")
+		defer os.Unsetenv("SECRETS_TEST_PASSWORD")
+		secret, err := p.Secret(ctx)
+		So(err, ShouldBeNil)
+		So(secret, ShouldEqual, "from-env")
+	})
+
+	Convey(`Feature: command secrets are cached`, t, func() {
+		dir := t.TempDir()
+		counter, script := filepath.Join(dir, "calls"), filepath.Join(dir, "vault-stand-in.sh")
+		So(ioutil.WriteFile(script, []byte("#!/bin/sh\necho x >> "+counter+"\necho from-command\n"), 0700), ShouldBeNil)
+		p := For(Spec{Command: script + " read npa", CommandTtl: time.Hour})
+
+		for i := 0; i < 3; i++ {
+			secret, err := p.Secret(ctx)
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, "from-command")
+		}
+		calls, _ := ioutil.ReadFile(counter)
+		So(string(calls), ShouldEqual, "x\n")
+
+		Convey("Failing commands are reported without their output", func() {
+			_, err := For(Spec{Command: "false"}).Secret(ctx)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey(`Feature: shared providers are keyed by source, never by the secret`, t, func() {
+		first, _ := For(Spec{Value: "first"}).Secret(ctx)
+		second, _ := For(Spec{Value: "second"}).Secret(ctx)
+		So(first, ShouldEqual, "first")
+		So(second, ShouldEqual, "second")
+		p := For(Spec{Env: "SECRETS_TEST_PASSWORD", Value: "ignored"})
+		So(For(Spec{Env: "SECRETS_TEST_PASSWORD", Value: "other"}), ShouldEqual, p)
+		Convey("Forget drops them", func() {
+			Forget()
+			So(For(Spec{Env: "SECRETS_TEST_PASSWORD"}), ShouldNotEqual, p)
+		})
+	})
+	Convey(`Feature: the first configured source wins`, t, func() {
+		So(For(Spec{File: "/run/secrets/npa", Command: "vault read", Value: "passwd"}), ShouldHaveSameTypeAs, &File{})
+		So(For(Spec{Command: "vault read", Value: "passwd"}), ShouldHaveSameTypeAs, &Exec{})
+		secret, _ := For(Spec{Value: "passwd"}).Secret(ctx)
+		So(secret, ShouldEqual, "passwd")
+	})
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"github.com/google/uuid"
+)
+
+// CorrelationIdHeader carries the correlation id of a request from and back to the caller
+const CorrelationIdHeader = "X-Correlation-Id"
+
+// maxCorrelationIdLength longer incoming correlation ids are replaced
+const maxCorrelationIdLength = 128
+
+// CorrelationId return the correlation id the caller sent when it is safe to log, a new one otherwise
+func CorrelationId(incoming string) string {
+	if incoming == "" || len(incoming) > maxCorrelationIdLength {
+		return uuid.New().String()
+	}
+	for _, r := range incoming {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == ':') {
+			return uuid.New().String()
+		}
+	}
+	return incoming
+}
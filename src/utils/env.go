@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 )
@@ -12,13 +13,28 @@ func EnvOrDefault(name, def string) string {
 	return def
 }
 
-func EnvOrDefaultInt32(name string, def int32) int32 {
+// EnvOrDefaultInt32 return the integer in the env variable name, def when it is not set or when it is not an
+// integer, along with an error then
+func EnvOrDefaultInt32(name string, def int32) (int32, error) {
 	if v, ok := os.LookupEnv(name); ok && v != "" {
-		vc, _ := strconv.ParseInt(v, 10, 32)
-		return int32(vc)
+		vc, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return def, fmt.Errorf("%s: %q is not a valid integer", name, v)
+		}
+		return int32(vc), nil
 	}
-	return def
+	return def, nil
 }
 
-
-
+// EnvOrDefaultBool return the boolean in the env variable name, def when it is not set or when it is not a
+// boolean, along with an error then
+func EnvOrDefaultBool(name string, def bool) (bool, error) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		vb, err := strconv.ParseBool(v)
+		if err != nil {
+			return def, fmt.Errorf("%s: %q is not a valid boolean", name, v)
+		}
+		return vb, nil
+	}
+	return def, nil
+}
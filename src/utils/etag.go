@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"strings"
+)
+
+// ETagMatch tell whether an If-None-Match header matches the etag, comparing weakly as RFC 7232 asks for
+func ETagMatch(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
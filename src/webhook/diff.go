@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"sort"
+	"time"
+	"user-check/model"
+	"user-check/opa"
+)
+
+// Diff list the members who joined or left a group between two snapshots, by directory, group and isid, leaves
+// first. Groups missing from either snapshot are left out: starting or stopping to watch a group is no change.
+func Diff(previous, current opa.Snapshot, at time.Time) []model.MembershipEvent {
+	var events []model.MembershipEvent
+	directories := make([]string, 0, len(current.Directories))
+	for directory := range current.Directories {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+	for _, directory := range directories {
+		before, ok := previous.Directories[directory]
+		if !ok {
+			continue
+		}
+		after := current.Directories[directory]
+		groups := make([]string, 0, len(after.Groups))
+		for group := range after.Groups {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+		for _, group := range groups {
+			was, ok := before.Groups[group]
+			if !ok {
+				continue
+			}
+			is := after.Groups[group]
+			event := model.MembershipEvent{Directory: directory, Group: group, At: at}
+			for _, isid := range missing(was, is) {
+				event.Type, event.Isid = model.EventLeave, isid
+				events = append(events, event)
+			}
+			for _, isid := range missing(is, was) {
+				event.Type, event.Isid = model.EventJoin, isid
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+// missing return the isids of from that are not in of, sorted
+func missing(from, of []string) []string {
+	in := make(map[string]bool, len(of))
+	for _, isid := range of {
+		in[isid] = true
+	}
+	var out []string
+	for _, isid := range from {
+		if !in[isid] {
+			out = append(out, isid)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
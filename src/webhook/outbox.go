@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"user-check/model"
+	"user-check/opa"
+)
+
+// Files of the outbox directory
+const (
+	stateFile   = "outbox.json"
+	cursorsFile = "cursors.json"
+)
+
+// Outbox keeps the last snapshot, the recent events and how far each url got in a directory, so that changes
+// made while the service was down are found and events not delivered yet are posted after a restart
+type Outbox struct {
+	dir string
+
+	mu      sync.Mutex
+	state   state
+	cursors map[string]int64
+}
+
+// state is the snapshot and the events, saved together so an event is recorded exactly once
+type state struct {
+	Sequence int64                   `json:"sequence"`
+	Snapshot *opa.Snapshot           `json:"snapshot,omitempty"`
+	Events   []model.MembershipEvent `json:"events"`
+}
+
+// OpenOutbox open the outbox in dir, creating it when missing
+func OpenOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	o := &Outbox{dir: dir, cursors: map[string]int64{}}
+	if err := readJson(filepath.Join(dir, stateFile), &o.state); err != nil {
+		return nil, err
+	}
+	if err := readJson(filepath.Join(dir, cursorsFile), &o.cursors); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Snapshot return the last snapshot recorded, nil before the first one
+func (o *Outbox) Snapshot() *opa.Snapshot {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.state.Snapshot
+}
+
+// Sequence return the sequence of the last event recorded
+func (o *Outbox) Sequence() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.state.Sequence
+}
+
+// Record save snapshot along with its events, numbered and given an id. The last retention events are kept, and
+// every event one of urls has not been sent yet, so a url backing off misses nothing.
+// Nothing changes when the outbox can't be written.
+func (o *Outbox) Record(snapshot opa.Snapshot, events []model.MembershipEvent, retention int, urls []string) ([]model.MembershipEvent, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	next := state{Sequence: o.state.Sequence, Snapshot: &snapshot}
+	recorded := make([]model.MembershipEvent, 0, len(events))
+	for _, e := range events {
+		id, err := newId()
+		if err != nil {
+			return nil, err
+		}
+		next.Sequence++
+		e.Id, e.Sequence = id, next.Sequence
+		recorded = append(recorded, e)
+	}
+	next.Events = append(append([]model.MembershipEvent{}, o.state.Events...), recorded...)
+	keep := len(next.Events) - retention
+	for _, url := range urls {
+		if cursor, ok := o.cursors[url]; ok {
+			for keep > 0 && next.Events[keep-1].Sequence > cursor {
+				keep--
+			}
+		}
+	}
+	if keep > 0 {
+		next.Events = next.Events[keep:]
+	}
+	if err := writeJson(filepath.Join(o.dir, stateFile), next); err != nil {
+		return nil, err
+	}
+	o.state = next
+	return recorded, nil
+}
+
+// Pending return the events url has not been sent yet, in order. A url seen for the first time starts with the
+// events recorded from now on. missed is the number of events url should have got that are no longer kept,
+// e.g. when the url was not configured while they were recorded.
+func (o *Outbox) Pending(url string) (events []model.MembershipEvent, missed int64, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	cursor, ok := o.cursors[url]
+	if !ok {
+		if err := o.setCursor(url, o.state.Sequence); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, nil
+	}
+	events = o.after(cursor)
+	if len(events) > 0 && events[0].Sequence > cursor+1 {
+		missed = events[0].Sequence - cursor - 1
+	} else if len(events) == 0 && o.state.Sequence > cursor {
+		missed = o.state.Sequence - cursor
+	}
+	return events, missed, nil
+}
+
+// Delivered record that url got the events up to sequence
+func (o *Outbox) Delivered(url string, sequence int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.setCursor(url, sequence)
+}
+
+// Rewind make url get the events after since again. Events older than the retained ones are gone, the replay
+// then starts at the oldest one. Returns where the replay starts and how many events it posts.
+func (o *Outbox) Rewind(url string, since int64) (int64, int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if since < 0 || since > o.state.Sequence {
+		return 0, 0, fmt.Errorf("since %d, the last event is %d: %w", since, o.state.Sequence, ErrSince)
+	}
+	if len(o.state.Events) > 0 && since < o.state.Events[0].Sequence-1 {
+		since = o.state.Events[0].Sequence - 1
+	}
+	if err := o.setCursor(url, since); err != nil {
+		return 0, 0, err
+	}
+	return since, len(o.after(since)), nil
+}
+
+// after return the retained events past sequence
+func (o *Outbox) after(sequence int64) []model.MembershipEvent {
+	for i, e := range o.state.Events {
+		if e.Sequence > sequence {
+			return append([]model.MembershipEvent{}, o.state.Events[i:]...)
+		}
+	}
+	return nil
+}
+
+// setCursor save how far url got, o.mu must be held
+func (o *Outbox) setCursor(url string, sequence int64) error {
+	cursors := make(map[string]int64, len(o.cursors)+1)
+	for k, v := range o.cursors {
+		cursors[k] = v
+	}
+	cursors[url] = sequence
+	if err := writeJson(filepath.Join(o.dir, cursorsFile), cursors); err != nil {
+		return err
+	}
+	o.cursors = cursors
+	return nil
+}
+
+// newId return a random event id
+func newId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// readJson decode the file into v, a missing file leaves v as it is
+func readJson(file string, v interface{}) error {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// writeJson replace the file with v atomically: a crash leaves either the old or the new content
+func writeJson(file string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
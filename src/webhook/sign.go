@@ -0,0 +1,34 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Headers of the event posts
+const (
+	EventHeader     = "X-Usercheck-Event"
+	DeliveryHeader  = "X-Usercheck-Delivery"
+	TimestampHeader = "X-Usercheck-Timestamp"
+	SignatureHeader = "X-Usercheck-Signature"
+)
+
+// signaturePrefix names the algorithm in the signature header
+const signaturePrefix = "sha256="
+
+// Sign return the signature of a post sent at timestamp (unix seconds): sha256= followed by the hex HMAC-SHA256,
+// keyed with secret, of the timestamp, a dot and the body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify tell whether signature is the one of the body sent at timestamp, for receivers. Receivers should also
+// refuse timestamps too far from their clock, so captured posts can't be sent again.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,290 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"user-check/configuration"
+	"user-check/metrics"
+	"user-check/model"
+	"user-check/opa"
+	"user-check/utils/go-stats/concurrency"
+	"user-check/utils/logger"
+)
+
+// postTimeout bounds a single post, a receiver that hangs is retried like one that fails
+const postTimeout = 10 * time.Second
+
+// Replay errors
+var (
+	// ErrUnknownUrl is returned when replaying to a url the events are not posted to
+	ErrUnknownUrl = errors.New("not a webhook url")
+	// ErrSince is returned when replaying after an event that was not recorded yet
+	ErrSince = errors.New("no such event")
+)
+
+// Notifier finds the membership changes between snapshots, records them in the outbox and posts them to every
+// webhook url, in order. A url that fails is retried with a backoff doubling from WEBHOOK_BACKOFF_SEC up to
+// WEBHOOK_BACKOFF_MAX_SEC, without holding back the other urls.
+type Notifier struct {
+	outbox *Outbox
+	client *http.Client
+	wake   chan struct{}
+
+	// deliverMu serializes deliveries so each url gets its events once and in order
+	deliverMu sync.Mutex
+	retries   map[string]retry
+}
+
+// retry is the backoff of a failing url
+type retry struct {
+	attempts int
+	next     time.Time
+}
+
+var current atomic.Value
+
+// Current return the running notifier, nil when webhooks are disabled
+func Current() *Notifier {
+	n, _ := current.Load().(*Notifier)
+	return n
+}
+
+// New create a notifier recording its events in outbox
+func New(outbox *Outbox) *Notifier {
+	return &Notifier{
+		outbox:  outbox,
+		client:  &http.Client{Timeout: postTimeout},
+		wake:    make(chan struct{}, 1),
+		retries: map[string]retry{},
+	}
+}
+
+// Observe record the changes from the previous snapshot to snapshot and return them. The first snapshot only
+// sets the baseline, it has no changes.
+func (n *Notifier) Observe(snapshot opa.Snapshot, at time.Time) ([]model.MembershipEvent, error) {
+	previous := n.outbox.Snapshot()
+	var events []model.MembershipEvent
+	if previous != nil {
+		events = Diff(*previous, snapshot, at)
+	}
+	if previous != nil && len(events) == 0 && reflect.DeepEqual(*previous, snapshot) {
+		return nil, nil
+	}
+	conf := configuration.AppConfig()
+	recorded, err := n.outbox.Record(snapshot, events, int(conf.WebhookRetention), conf.WebhookUrlList())
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range recorded {
+		metrics.WebhookEvents.WithLabelValues(e.Type).Inc()
+	}
+	if len(recorded) > 0 {
+		n.notify()
+	}
+	return recorded, nil
+}
+
+// Deliver post the pending events to every url, stopping at the first failure of a url until its backoff is
+// over. Returns when the next url backing off is due, zero when none is.
+func (n *Notifier) Deliver(ctx context.Context) time.Time {
+	n.deliverMu.Lock()
+	defer n.deliverMu.Unlock()
+
+	log := logger.SugaredLogger().With("package", "webhook", "action", "deliver events")
+	conf := configuration.AppConfig()
+	var due time.Time
+	backoff := func(url string, err error) {
+		r := n.retries[url]
+		r.attempts++
+		wait := time.Duration(conf.WebhookBackoffSec) * time.Second << (r.attempts - 1)
+		if max := time.Duration(conf.WebhookBackoffMaxSec) * time.Second; wait > max || wait <= 0 {
+			wait = max
+		}
+		r.next = time.Now().Add(wait)
+		n.retries[url] = r
+		if due.IsZero() || r.next.Before(due) {
+			due = r.next
+		}
+		log.Warnf("%v, attempt %d, retrying in %s", err, r.attempts, wait)
+	}
+
+	secret, err := conf.WebhookSecretProvider().Secret(ctx)
+	if err != nil {
+		log.Errorf("unable to read the webhook secret: %v", err)
+		return time.Now().Add(time.Duration(conf.WebhookBackoffSec) * time.Second)
+	}
+	for _, url := range conf.WebhookUrlList() {
+		if r, ok := n.retries[url]; ok && time.Now().Before(r.next) {
+			if due.IsZero() || r.next.Before(due) {
+				due = r.next
+			}
+			continue
+		}
+		events, missed, err := n.outbox.Pending(url)
+		if err != nil {
+			log.Errorf("unable to read the outbox: %v", err)
+			continue
+		}
+		if missed > 0 {
+			metrics.WebhookMissed.Add(float64(missed))
+			log.Errorf("%d events for %s are no longer kept, the receiver must resync the group members", missed, url)
+		}
+		for _, e := range events {
+			if err = n.post(ctx, url, secret, e); err != nil {
+				metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+				backoff(url, err)
+				break
+			}
+			metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+			delete(n.retries, url)
+			if err = n.outbox.Delivered(url, e.Sequence); err != nil {
+				log.Errorf("event %d delivered to %s, unable to record it: %v", e.Sequence, url, err)
+				break
+			}
+		}
+	}
+	return due
+}
+
+// post send one event to url, signed with secret
+func (n *Notifier) post(ctx context.Context, url, secret string, e model.MembershipEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, e.Type)
+	req.Header.Set(DeliveryHeader, e.Id)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+	res, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting event %d to %s: %w", e.Sequence, url, err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("posting event %d to %s: answered %s", e.Sequence, url, res.Status)
+	}
+	return nil
+}
+
+// Replay post the events after since again to url, to every url when empty. A url backing off is tried at once.
+func (n *Notifier) Replay(url string, since int64) (*model.WebhookReplay, error) {
+	urls := configuration.AppConfig().WebhookUrlList()
+	if url != "" {
+		known := false
+		for _, u := range urls {
+			known = known || u == url
+		}
+		if !known {
+			return nil, fmt.Errorf("%s: %w", url, ErrUnknownUrl)
+		}
+		urls = []string{url}
+	}
+
+	n.deliverMu.Lock()
+	defer n.deliverMu.Unlock()
+	result := &model.WebhookReplay{Since: since}
+	for _, u := range urls {
+		from, count, err := n.outbox.Rewind(u, since)
+		if err != nil {
+			return nil, err
+		}
+		result.Urls = append(result.Urls, model.WebhookUrlReplay{Url: u, Since: from, Events: count})
+		result.Events += count
+		delete(n.retries, u)
+	}
+	n.notify()
+	return result, nil
+}
+
+// notify wake the delivery loop up
+func (n *Notifier) notify() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run open the outbox, then read the watched groups now and every interval and deliver their changes until
+// ctx is done. A failed read is logged and retried at the next interval.
+func Run(ctx context.Context, interval time.Duration) error {
+	conf := configuration.AppConfig()
+	outbox, err := OpenOutbox(conf.WebhookOutbox)
+	if err != nil {
+		return err
+	}
+	n := New(outbox)
+	current.Store(n)
+	log := logger.SugaredLogger().With("package", "webhook", "action", "find membership changes")
+
+	observe := func() {
+		conf := configuration.AppConfig()
+		snapshot, err := opa.ReadSnapshot(ctx, conf, conf.WebhookGroupList())
+		if err != nil {
+			log.Errorf("unable to read the watched groups: %v", err)
+			return
+		}
+		events, err := n.Observe(snapshot, time.Now())
+		if err != nil {
+			log.Errorf("unable to record membership changes: %v", err)
+			return
+		}
+		if len(events) > 0 {
+			log.Infof("found %d membership changes, up to event %d", len(events), events[len(events)-1].Sequence)
+		}
+	}
+
+	concurrency.GlobalWaitGroup.Add(2)
+	go func() {
+		defer concurrency.GlobalWaitGroup.Done()
+		observe()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				observe()
+			}
+		}
+	}()
+	go func() {
+		defer concurrency.GlobalWaitGroup.Done()
+		for {
+			// events left from before a restart are delivered right away
+			wait := time.Hour
+			if due := n.Deliver(ctx); !due.IsZero() {
+				wait = time.Until(due)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-n.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}()
+	return nil
+}
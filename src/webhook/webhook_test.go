@@ -0,0 +1,246 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"user-check/configuration"
+	"user-check/model"
+	"user-check/opa"
+	"user-check/utils/logger"
+)
+
+func init() {
+	logger.Init(context.Background(), true)
+}
+
+// snapshot of the members of group.users in the default directory
+func snapshot(members ...string) opa.Snapshot {
+	return opa.Snapshot{Directories: map[string]opa.DirectoryGroups{"default": {
+		Groups: map[string][]string{"group.users": members},
+	}}}
+}
+
+// receiver record the events posted to it, failing while fail is set
+type receiver struct {
+	mu     sync.Mutex
+	fail   bool
+	events []model.MembershipEvent
+	valid  []bool
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	timestamp, _ := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+	var e model.MembershipEvent
+	_ = json.Unmarshal(body, &e)
+	r.events = append(r.events, e)
+	r.valid = append(r.valid, Verify("s3cret", timestamp, body, req.Header.Get(SignatureHeader)) &&
+		req.Header.Get(EventHeader) == e.Type && req.Header.Get(DeliveryHeader) == e.Id)
+}
+
+// received return the isids of the events received, prefixed with + for joins and - for leaves
+func (r *receiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for _, e := range r.events {
+		sign := "+"
+		if e.Type == model.EventLeave {
+			sign = "-"
+		}
+		out = append(out, sign+e.Isid)
+	}
+	return out
+}
+
+func TestDiff(t *testing.T) {
+	Convey(`Feature: membership changes between snapshots`, t, func() {
+		at := time.Now()
+
+		Convey("Joins and leaves are listed by group, leaves first", func() {
+			events := Diff(snapshot("bordeanu", "martih"), snapshot("martih", "smith", "adams"), at)
+			So(events, ShouldHaveLength, 3)
+			So(events[0], ShouldResemble, model.MembershipEvent{Type: model.EventLeave, Directory: "default",
+				Group: "group.users", Isid: "bordeanu", At: at})
+			So(events[1].Type, ShouldEqual, model.EventJoin)
+			So(events[1].Isid, ShouldEqual, "adams")
+			So(events[2].Isid, ShouldEqual, "smith")
+		})
+		Convey("Groups only one snapshot has are no change", func() {
+			current := snapshot("bordeanu")
+			current.Directories["default"].Groups["licensed.app"] = []string{"martih"}
+			So(Diff(snapshot("bordeanu"), current, at), ShouldBeEmpty)
+			So(Diff(current, snapshot("bordeanu"), at), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestSign(t *testing.T) {
+	Convey(`Feature: signed posts`, t, func() {
+		body := []byte(`{"type":"join"}`)
+		signature := Sign("s3cret", 1700000000, body)
+		So(signature, ShouldStartWith, "sha256=")
+		So(Verify("s3cret", 1700000000, body, signature), ShouldBeTrue)
+		So(Verify("other", 1700000000, body, signature), ShouldBeFalse)
+		So(Verify("s3cret", 1700000001, body, signature), ShouldBeFalse)
+		So(Verify("s3cret", 1700000000, []byte(`{"type":"leave"}`), signature), ShouldBeFalse)
+	})
+}
+
+func TestNotifier(t *testing.T) {
+	Convey(`Feature: membership changes are posted to the webhooks`, t, func() {
+		rec := &receiver{}
+		srv := httptest.NewServer(rec)
+		defer srv.Close()
+		os.Setenv("WEBHOOK_URLS", srv.URL)
+		os.Setenv("WEBHOOK_SECRET", "s3cret")
+		os.Setenv("WEBHOOK_RETENTION", "3")
+		defer os.Unsetenv("WEBHOOK_URLS")
+		defer os.Unsetenv("WEBHOOK_SECRET")
+		defer os.Unsetenv("WEBHOOK_RETENTION")
+		_, err := configuration.Load(nil)
+		So(err, ShouldBeNil)
+
+		dir := t.TempDir()
+		outbox, err := OpenOutbox(dir)
+		So(err, ShouldBeNil)
+		n := New(outbox)
+		ctx := context.Background()
+
+		// the first snapshot is the baseline
+		events, err := n.Observe(snapshot("bordeanu", "martih"), time.Now())
+		So(err, ShouldBeNil)
+		So(events, ShouldBeEmpty)
+		So(n.Deliver(ctx).IsZero(), ShouldBeTrue)
+
+		Convey("Events are signed and posted in order", func() {
+			events, err := n.Observe(snapshot("martih", "smith"), time.Now())
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 2)
+			So(events[0].Sequence, ShouldEqual, 1)
+			So(events[0].Id, ShouldNotBeEmpty)
+			So(n.Deliver(ctx).IsZero(), ShouldBeTrue)
+			So(rec.received(), ShouldResemble, []string{"-bordeanu", "+smith"})
+			So(rec.valid, ShouldResemble, []bool{true, true})
+
+			// an unchanged snapshot has no events
+			events, err = n.Observe(snapshot("martih", "smith"), time.Now())
+			So(err, ShouldBeNil)
+			So(events, ShouldBeEmpty)
+		})
+		Convey("A failing receiver is retried after a backoff, without losing events", func() {
+			rec.fail = true
+			_, err := n.Observe(snapshot("martih"), time.Now())
+			So(err, ShouldBeNil)
+			due := n.Deliver(ctx)
+			So(due.IsZero(), ShouldBeFalse)
+			So(time.Until(due), ShouldBeBetween, 0, time.Second)
+
+			rec.fail = false
+			// still backing off
+			So(n.Deliver(ctx), ShouldEqual, due)
+			So(rec.received(), ShouldBeEmpty)
+			n.retries = map[string]retry{}
+			So(n.Deliver(ctx).IsZero(), ShouldBeTrue)
+			So(rec.received(), ShouldResemble, []string{"-bordeanu"})
+		})
+		Convey("A url backing off past the retention still gets every event", func() {
+			rec.fail = true
+			_, _ = n.Observe(snapshot("martih", "smith"), time.Now())
+			_, _ = n.Observe(snapshot("smith", "adams"), time.Now())
+			_, _ = n.Observe(snapshot("adams"), time.Now())
+			n.Deliver(ctx)
+			// five events past the cursor of the url, three are retained
+			events, missed, err := outbox.Pending(srv.URL)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 5)
+			So(missed, ShouldEqual, 0)
+
+			rec.fail = false
+			n.retries = map[string]retry{}
+			So(n.Deliver(ctx).IsZero(), ShouldBeTrue)
+			So(rec.received(), ShouldResemble, []string{"-bordeanu", "+smith", "-martih", "+adams", "-smith"})
+			// once delivered, the retention applies again
+			_, _ = n.Observe(snapshot("adams", "jones"), time.Now())
+			n.Deliver(ctx)
+			So(outbox.state.Events, ShouldHaveLength, 3)
+
+			Convey("Events dropped before a url got them are reported as missed", func() {
+				So(outbox.setCursor("http://new.example.com", 1), ShouldBeNil)
+				events, missed, err := outbox.Pending("http://new.example.com")
+				So(err, ShouldBeNil)
+				So(events, ShouldHaveLength, 3)
+				So(missed, ShouldEqual, 2)
+			})
+		})
+		Convey("Events not delivered yet survive a restart", func() {
+			rec.fail = true
+			_, err := n.Observe(snapshot("bordeanu"), time.Now())
+			So(err, ShouldBeNil)
+			n.Deliver(ctx)
+
+			outbox, err := OpenOutbox(dir)
+			So(err, ShouldBeNil)
+			restarted := New(outbox)
+			So(outbox.Snapshot(), ShouldResemble, func() *opa.Snapshot { s := snapshot("bordeanu"); return &s }())
+			rec.fail = false
+			So(restarted.Deliver(ctx).IsZero(), ShouldBeTrue)
+			So(rec.received(), ShouldResemble, []string{"-martih"})
+		})
+		Convey("Replays post the kept events again", func() {
+			_, _ = n.Observe(snapshot("martih"), time.Now())
+			_, _ = n.Observe(snapshot("martih", "smith", "adams"), time.Now())
+			n.Deliver(ctx)
+			So(rec.received(), ShouldResemble, []string{"-bordeanu", "+adams", "+smith"})
+
+			result, err := n.Replay("", 2)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, &model.WebhookReplay{Since: 2, Events: 1, Urls: []model.WebhookUrlReplay{{Url: srv.URL, Since: 2, Events: 1}}})
+			n.Deliver(ctx)
+			So(rec.received(), ShouldResemble, []string{"-bordeanu", "+adams", "+smith", "+smith"})
+			So(rec.events[3].Id, ShouldEqual, rec.events[2].Id)
+
+			// a fourth event leaves the first one out of the three kept
+			_, _ = n.Observe(snapshot("smith", "adams"), time.Now())
+			n.Deliver(ctx)
+			result, err = n.Replay(srv.URL, 0)
+			So(err, ShouldBeNil)
+			So(result.Since, ShouldEqual, 0)
+			So(result.Events, ShouldEqual, 3)
+			So(result.Urls, ShouldResemble, []model.WebhookUrlReplay{{Url: srv.URL, Since: 1, Events: 3}})
+
+			// every url gets its own entry, the events are summed
+			other := httptest.NewServer(&receiver{})
+			defer other.Close()
+			os.Setenv("WEBHOOK_URLS", srv.URL+","+other.URL)
+			_, err = configuration.Load(nil)
+			So(err, ShouldBeNil)
+			result, err = n.Replay("", 2)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, &model.WebhookReplay{Since: 2, Events: 4, Urls: []model.WebhookUrlReplay{
+				{Url: srv.URL, Since: 2, Events: 2},
+				{Url: other.URL, Since: 2, Events: 2},
+			}})
+
+			_, err = n.Replay("http://elsewhere.example.com", 0)
+			So(err, ShouldWrap, ErrUnknownUrl)
+			_, err = n.Replay("", 5)
+			So(err, ShouldWrap, ErrSince)
+		})
+	})
+}